@@ -0,0 +1,156 @@
+// bing.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// -------- Bing News Search provider --------
+const bingProviderName = "bing"
+
+// bingMaxPageSize is the largest count the news search endpoint accepts.
+const bingMaxPageSize = 100
+
+// BingProvider queries the Bing News Search v7 API with the Azure key in
+// the BING_SEARCH_KEY environment variable.
+type BingProvider struct{}
+
+func (BingProvider) Name() string { return bingProviderName }
+
+func (BingProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	return fetchBing(ctx, q)
+}
+
+type BingResponse struct {
+	TotalEstimatedMatches int `json:"totalEstimatedMatches"`
+	Value                 []struct {
+		Name          string `json:"name"`
+		URL           string `json:"url"`
+		Description   string `json:"description"`
+		DatePublished string `json:"datePublished"`
+		Provider      []struct {
+			Name string `json:"name"`
+		} `json:"provider"`
+	} `json:"value"`
+	// Bing reports failures in "errors", the Azure gateway in "error".
+	Errors []bingError `json:"errors"`
+	Error  *bingError  `json:"error"`
+}
+
+type bingError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// bingFreshness maps a days window onto Bing's coarse freshness buckets.
+// Windows longer than a month are left unbounded and filtered afterwards.
+func bingFreshness(days int) string {
+	switch {
+	case days <= 1:
+		return "Day"
+	case days <= 7:
+		return "Week"
+	case days <= 31:
+		return "Month"
+	}
+	return ""
+}
+
+func fetchBing(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	apiKey := os.Getenv("BING_SEARCH_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("BING_SEARCH_KEY not set")
+	}
+	params := url.Values{}
+	params.Set("q", q.Query)
+	var since time.Time
+	if q.endpoint() == EndpointTopHeadlines {
+		if q.Category != "" {
+			params.Set("category", q.Category)
+		}
+	} else {
+		since = q.windowMidnight()
+		if f := bingFreshness(q.Days); f != "" {
+			params.Set("freshness", f)
+		}
+		if q.SortBy == "publishedAt" {
+			params.Set("sortBy", "Date")
+		}
+	}
+	if q.Country != "" && q.Language != "" {
+		params.Set("mkt", q.Language+"-"+q.Country)
+	} else if q.Language != "" {
+		params.Set("setLang", q.Language)
+	}
+	pageSize := min(q.MaxItems, bingMaxPageSize)
+	params.Set("count", strconv.Itoa(pageSize))
+
+	news := []NewsResult{}
+	for offset := 0; len(news) < q.MaxItems; offset += pageSize {
+		params.Set("offset", strconv.Itoa(offset))
+		result, err := fetchBingPage(ctx, "https://api.bing.microsoft.com/v7.0/news/search?"+params.Encode(), apiKey)
+		if err != nil {
+			return news, err
+		}
+		for _, v := range result.Value {
+			r := NewsResult{
+				Title:       v.Name,
+				URL:         v.URL,
+				Source:      bingProviderName,
+				PublishedAt: parsePublishedAt(v.DatePublished),
+				Description: v.Description,
+			}
+			if len(v.Provider) > 0 {
+				r.SourceName = v.Provider[0].Name
+			}
+			if !r.PublishedAt.IsZero() && r.PublishedAt.Before(since) {
+				continue
+			}
+			news = append(news, r)
+			if len(news) >= q.MaxItems {
+				break
+			}
+		}
+		if len(result.Value) < pageSize || offset+pageSize >= result.TotalEstimatedMatches {
+			break
+		}
+	}
+	return news, nil
+}
+
+func fetchBingPage(ctx context.Context, reqURL, apiKey string) (BingResponse, error) {
+	var result BingResponse
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Ocp-Apim-Subscription-Key", apiKey)
+	resp, err := doWithRetry(ctx, req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK || len(result.Errors) > 0 || result.Error != nil {
+		msg := http.StatusText(resp.StatusCode)
+		if len(result.Errors) > 0 {
+			msg = result.Errors[0].Code + ": " + result.Errors[0].Message
+		} else if result.Error != nil {
+			msg = result.Error.Code + ": " + result.Error.Message
+		}
+		return result, &ProviderError{Provider: bingProviderName, StatusCode: resp.StatusCode, Message: msg,
+			RetryAt: rateLimitRetryAt(resp.Header, time.Now())}
+	}
+	if decodeErr != nil {
+		return result, decodeErr
+	}
+	return result, nil
+}
@@ -0,0 +1,116 @@
+// bing_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bingNews is a canned news search response: two articles from the last
+// day and one from a year ago, which a week's window leaves out.
+func bingNews() string {
+	recent := time.Now().Add(-2 * time.Hour).UTC().Format("2006-01-02T15:04:05.0000000Z")
+	return fmt.Sprintf(`{
+  "_type": "News",
+  "totalEstimatedMatches": 3,
+  "value": [
+    {"name": "Go 1.30 released", "url": "https://example.com/go130", "description": "The Go team shipped 1.30.",
+     "datePublished": %[1]q, "provider": [{"_type": "Organization", "name": "Gopher Times"}]},
+    {"name": "Generics, two years on", "url": "https://example.com/generics", "description": "A look back.",
+     "datePublished": %[1]q, "provider": []},
+    {"name": "Go 1.22 released", "url": "https://example.com/go122", "description": "Old news.",
+     "datePublished": "2024-02-06T12:00:00.0000000Z", "provider": [{"name": "Gopher Times"}]}
+  ]
+}`, recent)
+}
+
+func TestBingFetch(t *testing.T) {
+	t.Setenv("BING_SEARCH_KEY", "bing-key")
+	var req *http.Request
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		fmt.Fprint(w, bingNews())
+	})
+	news, err := BingProvider{}.Fetch(context.Background(), NewsQuery{Query: "golang", Days: 7, MaxItems: 10, SortBy: "publishedAt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("Ocp-Apim-Subscription-Key"); got != "bing-key" {
+		t.Errorf("subscription key %q, want bing-key", got)
+	}
+	params := req.URL.Query()
+	for k, want := range map[string]string{"q": "golang", "freshness": "Week", "sortBy": "Date", "count": "10", "offset": "0"} {
+		if got := params.Get(k); got != want {
+			t.Errorf("%s=%q, want %q", k, got, want)
+		}
+	}
+	if len(news) != 2 {
+		t.Fatalf("got %d results, want the 2 in the window", len(news))
+	}
+	first := news[0]
+	if first.Title != "Go 1.30 released" || first.SourceName != "Gopher Times" || first.Source != bingProviderName ||
+		first.Description != "The Go team shipped 1.30." || first.PublishedAt.IsZero() {
+		t.Errorf("first result %+v", first)
+	}
+	if news[1].SourceName != "" {
+		t.Errorf("second result has source %q, want none", news[1].SourceName)
+	}
+}
+
+func TestBingErrors(t *testing.T) {
+	t.Setenv("BING_SEARCH_KEY", "bing-key")
+	for _, tc := range []struct {
+		status int
+		body   string
+		want   string
+	}{
+		{http.StatusUnauthorized, `{"error":{"code":"401","message":"Access denied due to invalid subscription key."}}`,
+			"bing: http 401: 401: Access denied due to invalid subscription key."},
+		{http.StatusBadRequest, `{"_type":"ErrorResponse","errors":[{"code":"InvalidRequest","message":"Parameter has invalid value."}]}`,
+			"bing: http 400: InvalidRequest: Parameter has invalid value."},
+		{http.StatusTooManyRequests, `{"error":{"code":"429","message":"Rate limit is exceeded."}}`,
+			"bing: http 429: 429: Rate limit is exceeded."},
+	} {
+		t.Run(fmt.Sprint(tc.status), func(t *testing.T) {
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			})
+			_, err := BingProvider{}.Fetch(context.Background(), NewsQuery{Query: "golang", Days: 7, MaxItems: 10})
+			var perr *ProviderError
+			if !errors.As(err, &perr) || err.Error() != tc.want {
+				t.Fatalf("got %v, want %q", err, tc.want)
+			}
+			if errors.Is(err, ErrRateLimited) != (tc.status == http.StatusTooManyRequests) {
+				t.Errorf("errors.Is(err, ErrRateLimited) = %v", errors.Is(err, ErrRateLimited))
+			}
+		})
+	}
+}
+
+func TestBingNeedsKey(t *testing.T) {
+	t.Setenv("BING_SEARCH_KEY", "")
+	_, err := BingProvider{}.Fetch(context.Background(), NewsQuery{Query: "golang", Days: 7, MaxItems: 10})
+	if err == nil || !strings.Contains(err.Error(), "BING_SEARCH_KEY") {
+		t.Errorf("got %v, want BING_SEARCH_KEY not set", err)
+	}
+}
+
+func TestBingSelected(t *testing.T) {
+	p, err := newProvider("Bing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.(BingProvider); !ok {
+		t.Errorf("-provider bing selects %T", p)
+	}
+	q, err := parseTopicLine("bing:golang,7,10", NewsQuery{})
+	if err != nil || q.Provider != bingProviderName || q.Query != "golang" {
+		t.Errorf("bing:golang parsed as %+v, %v", q, err)
+	}
+}
@@ -0,0 +1,253 @@
+// cachecmd.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// -------- cache command --------
+
+const cacheUsage = `usage:
+  newscli cache prune [-older-than 30d] [-query topic] [-purge-deleted] [-vacuum] [-dry-run]
+  newscli cache export [-format json|jsonl|csv] [-out file] [-query topic] [-since 7d]
+  newscli cache import [-format json|jsonl|csv] [-dry-run] file
+  newscli cache vacuum [-older-than 7d]
+  newscli cache stats`
+
+// runCacheCommand handles `newscli cache <subcommand> [flags]`.
+// Each takes -db, see StoreFlags.
+func runCacheCommand(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", cacheUsage)
+	}
+	switch args[0] {
+	case "prune":
+		return runCachePrune(args[1:], w)
+	case "export":
+		return runCacheExport(args[1:], w)
+	case "import":
+		return runCacheImport(args[1:], w)
+	case "vacuum":
+		return runCacheVacuum(args[1:], w)
+	case "stats":
+		return runCacheStats(args[1:], w)
+	}
+	return fmt.Errorf("unknown cache command %q\n%s", args[0], cacheUsage)
+}
+
+// runCachePrune hard-deletes old and soft-deleted rows.
+func runCachePrune(args []string, w io.Writer) error {
+	fs, store := cacheFlagSet("cache prune")
+	olderThan := fs.String("older-than", "", "delete rows cached longer ago than this, e.g. 30d or 12h")
+	query := fs.String("query", "", "only prune rows for this topic")
+	purgeDeleted := fs.Bool("purge-deleted", false, "also remove soft-deleted rows")
+	vacuum := fs.Bool("vacuum", false, "compact the database afterwards (VACUUM, or OPTIMIZE TABLE on MySQL)")
+	dryRun := fs.Bool("dry-run", false, "report what would be deleted without deleting it")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *olderThan == "" && !*purgeDeleted {
+		return fmt.Errorf("cache prune: give -older-than, -purge-deleted or both")
+	}
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+	dsn := store.DSN
+	var cutoff time.Time
+	if *olderThan != "" {
+		age, err := parseAge(*olderThan)
+		if err != nil {
+			return fmt.Errorf("invalid -older-than: %w", err)
+		}
+		cutoff = time.Now().Add(-age)
+	}
+
+	// scope picks the rows to prune; Unscoped so the delete is a hard one
+	// and soft-deleted rows can be matched too
+	scope := func(tx *gorm.DB) *gorm.DB {
+		tx = tx.Unscoped().Model(&CachedSearch{})
+		if *query != "" {
+			tx = tx.Where("query = ?", queryKey(*query))
+		}
+		switch {
+		case !cutoff.IsZero() && *purgeDeleted:
+			return tx.Where("created < ? OR deleted_at IS NOT NULL", cutoff)
+		case !cutoff.IsZero():
+			return tx.Where("created < ?", cutoff)
+		}
+		return tx.Where("deleted_at IS NOT NULL")
+	}
+
+	if *dryRun {
+		var counts []struct {
+			Query     string
+			TotalRows int64
+		}
+		scope(db).Select("query, COUNT(*) AS total_rows").Group("query").Order("query").Scan(&counts)
+		var total int64
+		for _, c := range counts {
+			fmt.Fprintf(w, "would delete %d rows for %q\n", c.TotalRows, c.Query)
+			total += c.TotalRows
+		}
+		fmt.Fprintf(w, "would delete %d rows in total (dry run, nothing changed)\n", total)
+		return nil
+	}
+
+	var queries []string
+	if err := scope(db).Distinct("query").Pluck("query", &queries).Error; err != nil {
+		return err
+	}
+	tx := scope(db).Delete(&CachedSearch{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	fmt.Fprintf(w, "deleted %d rows\n", tx.RowsAffected)
+	if err := forgetResults(queries); err != nil {
+		return err
+	}
+	if isMemoryDB(dsn) {
+		return nil // nothing on disk to vacuum or measure
+	}
+	if *vacuum {
+		if err := compactCache(db); err != nil {
+			return fmt.Errorf("vacuum: %w", err)
+		}
+	}
+	if !isSQLite(db) {
+		// the DSN may hold a password, so name the table instead
+		fmt.Fprintf(w, "cached_searches now takes %.1f MB\n", float64(cacheBytes(db))/(1<<20))
+	} else if info, err := os.Stat(dsn); err == nil {
+		fmt.Fprintf(w, "%s is now %.1f MB\n", dsn, float64(info.Size())/(1<<20))
+	}
+	return nil
+}
+
+// forgetResults drops the results Redis holds for the topics cached under
+// queries, when REDIS_ADDR names one, once a cache command has changed
+// their rows. A fetch's memory cache is its own, gone with the process.
+func forgetResults(queries []string) error {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" || len(queries) == 0 {
+		return nil
+	}
+	c, err := newRedisCache(addr, 0)
+	if err != nil {
+		return err
+	}
+	defer c.client.Close()
+	for _, q := range queries {
+		c.Invalidate(context.Background(), q)
+	}
+	return nil
+}
+
+// runCacheVacuum hard-deletes rows soft-deleted longer ago than
+// -older-than, compacts the database and refreshes the planner statistics.
+// On SQLite it runs on a single connection and gives up, changing nothing
+// more, if another process keeps the database locked past the busy timeout.
+func runCacheVacuum(args []string, w io.Writer) error {
+	fs, store := cacheFlagSet("cache vacuum")
+	olderThan := fs.String("older-than", "7d", "only purge rows soft-deleted longer ago than this, e.g. 7d or 12h")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	age, err := parseAge(*olderThan)
+	if err != nil {
+		return fmt.Errorf("invalid -older-than: %w", err)
+	}
+	cutoff := time.Now().Add(-age)
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+	dsn := store.DSN
+
+	return db.Connection(func(conn *gorm.DB) error {
+		conn = conn.Session(&gorm.Session{}) // each statement below starts afresh
+		rowsBefore, sizeBefore := vacuumCounts(conn), storageSize(conn, dsn)
+		inUse := func(step string, err error) error {
+			if isBusy(err) {
+				return fmt.Errorf("cache vacuum: the database is in use by another process, try again when it has finished (%s: %w)", step, err)
+			}
+			return fmt.Errorf("cache vacuum: %s: %w", step, err)
+		}
+		purged, err := purgeSoftDeleted(context.Background(), conn, cutoff)
+		if err != nil {
+			return inUse("purging soft-deleted rows", err)
+		}
+		fmt.Fprintf(w, "purged %d rows soft-deleted before %s\n", purged, cutoff.Local().Format("2006-01-02 15:04"))
+		if !isMemoryDB(dsn) {
+			if err := compactCache(conn); err != nil {
+				return inUse("vacuum", err)
+			}
+			if isSQLite(conn) {
+				// VACUUM goes through the WAL; checkpoint so the file itself shrinks
+				if err := conn.Exec("PRAGMA wal_checkpoint(TRUNCATE)").Error; err != nil {
+					return inUse("checkpoint", err)
+				}
+			}
+		}
+		if err := analyzeDB(conn); err != nil {
+			return inUse("analyze", err)
+		}
+		rowsAfter, sizeAfter := vacuumCounts(conn), storageSize(conn, dsn)
+		fmt.Fprintf(w, "%-14s %10s %10s\n", "", "BEFORE", "AFTER")
+		fmt.Fprintf(w, "%-14s %10d %10d\n", "live rows", rowsBefore.live, rowsAfter.live)
+		fmt.Fprintf(w, "%-14s %10d %10d\n", "deleted rows", rowsBefore.deleted, rowsAfter.deleted)
+		fmt.Fprintf(w, "%-14s %10.1f %10.1f\n", "size (MB)", float64(sizeBefore)/(1<<20), float64(sizeAfter)/(1<<20))
+		return nil
+	})
+}
+
+type rowCounts struct{ live, deleted int64 }
+
+func vacuumCounts(db *gorm.DB) rowCounts {
+	var c rowCounts
+	db.Model(&CachedSearch{}).Count(&c.live)
+	db.Unscoped().Model(&CachedSearch{}).Where("deleted_at IS NOT NULL").Count(&c.deleted)
+	return c
+}
+
+// storageSize is the SQLite file with its WAL, or cacheBytes where there
+// is no file to measure.
+func storageSize(db *gorm.DB, dsn string) int64 {
+	if !isSQLite(db) || isMemoryDB(dsn) {
+		return cacheBytes(db)
+	}
+	var size int64
+	for _, path := range []string{dsn, dsn + "-wal"} {
+		if info, err := os.Stat(path); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// parseAge parses a retention window: a time.ParseDuration string or a
+// whole number of days such as "30d".
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("%q is not a positive number of days", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%q must be positive", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,69 @@
+// cachecmd_test.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// fileSize is the size of the SQLite file at path with its WAL.
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	var size int64
+	for _, p := range []string{path, path + "-wal"} {
+		if info, err := os.Stat(p); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+func TestCacheVacuum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	db, err := openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedTopics(t, db, 200, 50)
+	// three quarters deleted a while ago, a few more just now
+	if err := db.Where("query < ?", "topic 5").Delete(&CachedSearch{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Unscoped().Model(&CachedSearch{}).Where("deleted_at IS NOT NULL").
+		Update("deleted_at", time.Now().AddDate(0, 0, -8)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Where("query = ?", "topic 99").Delete(&CachedSearch{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var deleted, live int64
+	db.Unscoped().Model(&CachedSearch{}).Where("deleted_at IS NOT NULL").Count(&deleted)
+	db.Model(&CachedSearch{}).Count(&live)
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+	before := fileSize(t, path)
+
+	out, err := runCommand(t, "cache", "vacuum", "-db", path)
+	if err != nil {
+		t.Fatalf("cache vacuum: %v\n%s", err, out)
+	}
+	purged := deleted - 50
+	if want := fmt.Sprintf("purged %d rows soft-deleted before", purged); !regexp.MustCompile(want).MatchString(out) {
+		t.Errorf("the output doesn't say %q:\n%s", want, out)
+	}
+	for _, want := range []string{
+		fmt.Sprintf(`live rows +%d +%d\n`, live, live),
+		fmt.Sprintf(`deleted rows +%d +50\n`, deleted),
+	} {
+		if !regexp.MustCompile(want).MatchString(out) {
+			t.Errorf("the output doesn't match %q:\n%s", want, out)
+		}
+	}
+	if after := fileSize(t, path); after >= before*2/3 {
+		t.Errorf("the cache went from %d to %d bytes, want it to shrink by a third at least", before, after)
+	}
+}
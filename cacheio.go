@@ -0,0 +1,568 @@
+// cacheio.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// -------- Cache export --------
+
+// CacheRecord is the exported form of a CachedSearch row. JSON uses these
+// field names and CSV the same names as its header, so exports can be
+// imported again.
+type CacheRecord struct {
+	ID             uint       `json:"id"`
+	Query          string     `json:"query"`
+	Days           int        `json:"days"`
+	MaxItems       int        `json:"max_items"`
+	Endpoint       string     `json:"endpoint"`
+	Country        string     `json:"country"`
+	Category       string     `json:"category"`
+	Language       string     `json:"language"`
+	SortBy         string     `json:"sort_by"`
+	Domains        string     `json:"domains"`
+	ExcludeDomains string     `json:"exclude_domains"`
+	SearchIn       string     `json:"search_in"`
+	Feeds          string     `json:"feeds"`
+	Provider       string     `json:"provider"`
+	Title          string     `json:"title"`
+	URL            string     `json:"url"`
+	Description    string     `json:"description"`
+	Author         string     `json:"author"`
+	SourceName     string     `json:"source_name"`
+	PublishedAt    *time.Time `json:"published_at"`
+	Created        time.Time  `json:"created"`
+	Position       int        `json:"position"`
+	LastAccessed   *time.Time `json:"last_accessed"`
+	RunID          *uint      `json:"run_id"`
+	UserID         string     `json:"user_id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// cacheRecordColumns is the CSV header, in CacheRecord field order.
+var cacheRecordColumns = []string{"id", "query", "days", "max_items", "endpoint", "country", "category",
+	"language", "sort_by", "domains", "exclude_domains", "search_in", "feeds", "provider", "title", "url",
+	"description", "author", "source_name", "published_at", "created", "position", "last_accessed",
+	"run_id", "user_id", "created_at", "updated_at"}
+
+func newCacheRecord(c CachedSearch) CacheRecord {
+	return CacheRecord{
+		ID: c.ID, Query: c.Query, Days: c.Days, MaxItems: c.MaxItems, Endpoint: c.Endpoint,
+		Country: c.Country, Category: c.Category, Language: c.Language, SortBy: c.SortBy,
+		Domains: c.Domains, ExcludeDomains: c.ExcludeDomains, SearchIn: c.SearchIn, Feeds: c.Feeds,
+		Provider: c.Provider, Title: c.Title, URL: c.URL, Description: c.Description, Author: c.Author,
+		SourceName: c.SourceName, PublishedAt: c.PublishedAt, Created: c.Created, Position: c.Position,
+		LastAccessed: c.LastAccessed, RunID: c.RunID, UserID: c.UserID, CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+	}
+}
+
+// csvTime formats a timestamp column; NULL becomes an empty field.
+func csvTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func (r CacheRecord) csvRow() []string {
+	return []string{strconv.FormatUint(uint64(r.ID), 10), r.Query, strconv.Itoa(r.Days), strconv.Itoa(r.MaxItems),
+		r.Endpoint, r.Country, r.Category, r.Language, r.SortBy, r.Domains, r.ExcludeDomains, r.SearchIn,
+		r.Feeds, r.Provider, r.Title, r.URL, r.Description, r.Author, r.SourceName, csvTime(r.PublishedAt),
+		csvTime(&r.Created), strconv.Itoa(r.Position), csvTime(r.LastAccessed), csvRunID(r.RunID),
+		r.UserID, csvTime(&r.CreatedAt), csvTime(&r.UpdatedAt)}
+}
+
+// csvRunID formats the run_id column; NULL becomes an empty field.
+func csvRunID(id *uint) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
+
+// runCacheExport writes cached rows as JSON, JSON Lines or CSV, reading
+// them one at a time so large caches aren't loaded into memory.
+func runCacheExport(args []string, w io.Writer) error {
+	fs, store := cacheFlagSet("cache export")
+	format := fs.String("format", "json", "output format: json (one array), jsonl (one object per line) or csv")
+	out := fs.String("out", "", "file to write; defaults to standard output")
+	query := fs.String("query", "", "only export rows for this topic")
+	since := fs.String("since", "", "only export rows cached in this window (e.g. 7d, 12h) or since a date (2006-01-02)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *format != "json" && *format != "jsonl" && *format != "csv" {
+		return fmt.Errorf("invalid -format %q: want json, jsonl or csv", *format)
+	}
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+
+	scope := db.Model(&CachedSearch{}).Order("id")
+	if *query != "" {
+		scope = scope.Where("query = ?", queryKey(*query))
+	}
+	if *since != "" {
+		start, err := parseSince(*since)
+		if err != nil {
+			return fmt.Errorf("invalid -since: %w", err)
+		}
+		scope = scope.Where(sqlInstant(db, "created")+" >= "+sqlInstant(db, "?"), start)
+	}
+
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	bw := bufio.NewWriter(w)
+	rows, err := scope.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	csvw := csv.NewWriter(bw)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	switch *format {
+	case "csv":
+		csvw.Write(cacheRecordColumns)
+	case "json":
+		bw.WriteString("[\n")
+	}
+	n := 0
+	for rows.Next() {
+		var c CachedSearch
+		if err := db.ScanRows(rows, &c); err != nil {
+			return err
+		}
+		rec := newCacheRecord(c)
+		switch *format {
+		case "csv":
+			err = csvw.Write(rec.csvRow())
+		case "json":
+			if n > 0 {
+				bw.WriteString(",\n")
+			}
+			buf.Reset()
+			err = enc.Encode(rec)
+			bw.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+		default:
+			buf.Reset()
+			err = enc.Encode(rec)
+			bw.Write(buf.Bytes())
+		}
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if *format == "json" {
+		if n > 0 {
+			bw.WriteString("\n")
+		}
+		bw.WriteString("]\n")
+	}
+	csvw.Flush()
+	if err := csvw.Error(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "exported %d rows to %s\n", n, *out)
+	}
+	return nil
+}
+
+// parseSince accepts a window such as 7d or 12h, or a date or RFC 3339
+// timestamp.
+func parseSince(s string) (time.Time, error) {
+	if age, err := parseAge(s); err == nil {
+		return time.Now().Add(-age), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// -------- Cache import --------
+
+// importedRecord is one entry of an import file and the line it starts on.
+type importedRecord struct {
+	Line int
+	Rec  CacheRecord
+	Err  error
+}
+
+// runCacheImport loads an export, or a hand-written file in the same
+// format, into the cache. Entries already cached are updated in place, so
+// importing the same file twice leaves one copy of each row.
+func runCacheImport(args []string, w io.Writer) error {
+	fs, store := cacheFlagSet("cache import")
+	format := fs.String("format", "", "input format: json, jsonl or csv; guessed from the file when empty")
+	dryRun := fs.Bool("dry-run", false, "validate the file without changing the cache")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("cache import: give exactly one file")
+	}
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+	path := fs.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = guessImportFormat(path, data)
+	}
+	var records []importedRecord
+	switch *format {
+	case "json":
+		records, err = readJSONRecords(data)
+	case "jsonl":
+		records, err = readJSONLRecords(data)
+	case "csv":
+		records, err = readCSVRecords(data)
+	default:
+		return fmt.Errorf("invalid -format %q: want json, jsonl or csv", *format)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	var inserted, updated, skipped int
+	changed := map[string]bool{}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		for _, r := range records {
+			err := r.Err
+			var row CachedSearch
+			if err == nil {
+				row, err = r.Rec.cachedSearch(now)
+			}
+			if err != nil {
+				fmt.Fprintf(w, "%s:%d: skipped: %v\n", path, r.Line, err)
+				skipped++
+				continue
+			}
+			var n int64
+			key := map[string]any{"query": row.Query, "endpoint": row.Endpoint, "country": row.Country,
+				"category": row.Category, "language": row.Language, "sort_by": row.SortBy, "domains": row.Domains,
+				"exclude_domains": row.ExcludeDomains, "search_in": row.SearchIn, "feeds": row.Feeds,
+				"provider": row.Provider, "url": row.URL}
+			if err := tx.Unscoped().Model(&CachedSearch{}).Where(key).Count(&n).Error; err != nil {
+				return err
+			}
+			if n > 0 {
+				updated++
+			} else {
+				inserted++
+			}
+			if *dryRun {
+				continue
+			}
+			if err := tx.Clauses(cacheUpsert()).Create(&row).Error; err != nil {
+				return fmt.Errorf("%s:%d: %w", path, r.Line, err)
+			}
+			changed[row.Query] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := forgetResults(slices.Sorted(maps.Keys(changed))); err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("imported %d rows: %d inserted, %d updated, %d skipped", inserted+updated, inserted, updated, skipped)
+	if *dryRun {
+		msg = "would have " + msg + " (dry run, nothing changed)"
+	}
+	fmt.Fprintln(w, msg)
+	return nil
+}
+
+// guessImportFormat goes by the file extension, then by whether the file
+// opens a JSON array.
+func guessImportFormat(path string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return "csv"
+	case ".jsonl", ".ndjson":
+		return "jsonl"
+	}
+	if trimmed := bytes.TrimLeft(data, " \t\r\n\ufeff"); len(trimmed) > 0 && trimmed[0] == '[' {
+		return "json"
+	}
+	return "jsonl"
+}
+
+// decodeCacheRecord decodes one JSON object, rejecting unknown fields so
+// typos in hand-written files aren't silently dropped.
+func decodeCacheRecord(raw []byte) (CacheRecord, error) {
+	var rec CacheRecord
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&rec)
+	return rec, err
+}
+
+// readJSONRecords reads a JSON array of records. A malformed record is
+// reported and skipped; broken JSON syntax ends the import.
+func readJSONRecords(data []byte) ([]importedRecord, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, fmt.Errorf("expected a JSON array of records")
+	}
+	var records []importedRecord
+	for dec.More() {
+		// the next value starts after any whitespace and comma
+		start := int(dec.InputOffset())
+		for start < len(data) && strings.IndexByte(" \t\r\n,", data[start]) >= 0 {
+			start++
+		}
+		line := 1 + bytes.Count(data[:start], []byte("\n"))
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rec, err := decodeCacheRecord(raw)
+		records = append(records, importedRecord{Line: line, Rec: rec, Err: err})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// readJSONLRecords reads one record per line, ignoring blank lines.
+func readJSONLRecords(data []byte) ([]importedRecord, error) {
+	var records []importedRecord
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		rec, err := decodeCacheRecord(line)
+		records = append(records, importedRecord{Line: i + 1, Rec: rec, Err: err})
+	}
+	return records, nil
+}
+
+// readCSVRecords reads CSV with a header row naming cacheRecordColumns.
+// Columns may be left out or reordered; query, title and url are required.
+func readCSVRecords(data []byte) ([]importedRecord, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+	known := make(map[string]bool, len(cacheRecordColumns))
+	for _, c := range cacheRecordColumns {
+		known[c] = true
+	}
+	seen := make(map[string]bool, len(header))
+	for _, h := range header {
+		if !known[h] {
+			return nil, fmt.Errorf("unknown CSV column %q", h)
+		}
+		seen[h] = true
+	}
+	for _, c := range []string{"query", "title", "url"} {
+		if !seen[c] {
+			return nil, fmt.Errorf("CSV header has no %q column", c)
+		}
+	}
+
+	var records []importedRecord
+	for {
+		fields, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := r.FieldPos(0)
+		if err != nil {
+			var perr *csv.ParseError
+			if !errors.As(err, &perr) || perr.Err != csv.ErrFieldCount {
+				return nil, err
+			}
+			records = append(records, importedRecord{Line: perr.StartLine, Err: perr.Err})
+			continue
+		}
+		ir := importedRecord{Line: line}
+		for i, v := range fields {
+			if err := ir.Rec.setCSV(header[i], v); err != nil {
+				ir.Err = err
+				break
+			}
+		}
+		records = append(records, ir)
+	}
+	return records, nil
+}
+
+// setCSV stores one CSV field, the inverse of csvRow.
+func (r *CacheRecord) setCSV(col, v string) error {
+	var err error
+	num := func(dst *int) {
+		if v != "" {
+			*dst, err = strconv.Atoi(v)
+		}
+	}
+	ts := func(dst *time.Time) {
+		if v != "" {
+			*dst, err = time.Parse(time.RFC3339Nano, v)
+		}
+	}
+	optTS := func(dst **time.Time) {
+		if v != "" {
+			var t time.Time
+			if t, err = time.Parse(time.RFC3339Nano, v); err == nil {
+				*dst = &t
+			}
+		}
+	}
+	switch col {
+	case "id", "run_id":
+		// IDs are local to the exporting database
+	case "query":
+		r.Query = v
+	case "days":
+		num(&r.Days)
+	case "max_items":
+		num(&r.MaxItems)
+	case "endpoint":
+		r.Endpoint = v
+	case "country":
+		r.Country = v
+	case "category":
+		r.Category = v
+	case "language":
+		r.Language = v
+	case "sort_by":
+		r.SortBy = v
+	case "domains":
+		r.Domains = v
+	case "exclude_domains":
+		r.ExcludeDomains = v
+	case "search_in":
+		r.SearchIn = v
+	case "feeds":
+		r.Feeds = v
+	case "provider":
+		r.Provider = v
+	case "title":
+		r.Title = v
+	case "url":
+		r.URL = v
+	case "description":
+		r.Description = v
+	case "author":
+		r.Author = v
+	case "source_name":
+		r.SourceName = v
+	case "published_at":
+		optTS(&r.PublishedAt)
+	case "created":
+		ts(&r.Created)
+	case "position":
+		num(&r.Position)
+	case "last_accessed":
+		optTS(&r.LastAccessed)
+	case "user_id":
+		r.UserID = v
+	case "created_at":
+		ts(&r.CreatedAt)
+	case "updated_at":
+		ts(&r.UpdatedAt)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", col, err)
+	}
+	return nil
+}
+
+// cachedSearch validates r and converts it to a row. Endpoint and provider
+// default like the table columns do, and a missing created time becomes
+// now, so hand-written fixtures only need a query, title and URL.
+func (r CacheRecord) cachedSearch(now time.Time) (CachedSearch, error) {
+	c := CachedSearch{
+		Query: queryKey(r.Query), Days: r.Days, MaxItems: r.MaxItems, Endpoint: r.Endpoint,
+		Country: r.Country, Category: r.Category, Language: r.Language, SortBy: r.SortBy,
+		Domains: r.Domains, ExcludeDomains: r.ExcludeDomains, SearchIn: r.SearchIn, Feeds: r.Feeds,
+		Provider: r.Provider, Title: r.Title, URL: r.URL, Description: r.Description, Author: r.Author,
+		SourceName: r.SourceName, PublishedAt: r.PublishedAt, Created: r.Created, Position: r.Position,
+		LastAccessed: r.LastAccessed, UserID: cmp.Or(r.UserID, globalUser), CreatedAt: r.CreatedAt,
+		UpdatedAt: r.UpdatedAt,
+	}
+	switch {
+	case c.Query == "":
+		return c, fmt.Errorf("missing query")
+	case strings.TrimSpace(c.Title) == "":
+		return c, fmt.Errorf("missing title")
+	case c.Days < 0 || c.MaxItems < 0 || c.Position < 0:
+		return c, fmt.Errorf("days, max_items and position can't be negative")
+	}
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return c, fmt.Errorf("url %q is not an absolute http(s) URL", c.URL)
+	}
+	switch c.Endpoint {
+	case "":
+		c.Endpoint = EndpointEverything
+	case EndpointEverything, EndpointTopHeadlines:
+	default:
+		return c, fmt.Errorf("unknown endpoint %q", c.Endpoint)
+	}
+	if c.Provider == "" {
+		c.Provider = newsAPIProviderName
+	}
+	if c.Created.IsZero() {
+		c.Created = now
+	}
+	// a future created time would keep the row fresh indefinitely
+	if c.Created.After(now.Add(time.Minute)) {
+		return c, fmt.Errorf("created %s is in the future", c.Created.Format(time.RFC3339))
+	}
+	if c.PublishedAt != nil && c.PublishedAt.IsZero() {
+		c.PublishedAt = nil
+	}
+	if c.LastAccessed != nil && c.LastAccessed.IsZero() {
+		c.LastAccessed = nil
+	}
+	return c, nil
+}
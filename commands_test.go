@@ -0,0 +1,300 @@
+// commands_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// runCommand runs newscli args as main would, in a directory of the
+// test's own, and returns what the command wrote to stdout. The process
+// state the command sets up is put back when the test ends.
+func runCommand(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	saveGlobals(t)
+	var out bytes.Buffer
+	for _, c := range commands {
+		if c.name == args[0] {
+			err := c.run(args[1:], &out)
+			return out.String(), err
+		}
+	}
+	t.Fatalf("no command %q", args[0])
+	return "", nil
+}
+
+// saveGlobals puts back what commands set up for the process: the
+// caches, clients and limits outside the flags, and the flags' targets,
+// which the next command resets to their defaults anyway.
+func saveGlobals(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	// neither the user's config file nor their keys apply
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	for _, env := range []string{"NEWSAPI_KEY", "NEWSAPI_KEYS", "NEWSAPI_KEYS_FILE", "REDIS_ADDR", "NEWSCLI_CONFIG", "NEWSCLI_DB"} {
+		t.Setenv(env, "")
+	}
+	keep(t, &httpClient)
+	keep(t, &newsAPIKeys)
+	keep(t, &newsAPIQuota)
+	keep(t, &httpCache)
+	keep(t, &memoryCache)
+	keep(t, &redisCache)
+	keep(t, &apiBudget)
+	keep(t, &fetchSlots)
+	keep(t, &strictProviders)
+	keep(t, &activeConfig)
+	keep(t, &searchLog)
+	keep(t, &outputTemplate)
+	keep(t, &retryPolicy)
+	keep(t, &cacheLimits)
+	keep(t, &offlineMode)
+	keep(t, &debugLogging)
+	keep(t, &cacheMaxAge)
+	keep(t, &purgeDeletedAfter)
+	keep(t, &maxItemsCap)
+	keep(t, &maxTaskTimeout)
+	keep(t, &shutdownGrace)
+	keep(t, &priorityAge)
+	keep(t, &feedItems)
+	keep(t, &fakeProvider)
+	t.Cleanup(func() {
+		reporter.mu.Lock()
+		reporter.out, reporter.level, reporter.topics, reporter.color, reporter.live, reporter.noProgress =
+			io.Discard, levelQuiet, false, false, false, false
+		reporter.mu.Unlock()
+	})
+}
+
+// keep puts *p back as it is now when the test ends.
+func keep[T any](t testing.TB, p *T) {
+	saved := *p
+	t.Cleanup(func() { *p = saved })
+}
+
+// readOutput returns the one results file in dir.
+func readOutput(t *testing.T, dir string) string {
+	t.Helper()
+	files, _ := filepath.Glob(filepath.Join(dir, "Outputs_*"))
+	if len(files) != 1 {
+		t.Fatalf("got results files %v, want one", files)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// seedCache creates the SQLite cache at path with articles cached for each
+// topic, as a week's search for that many.
+func seedCache(t *testing.T, path string, topics map[string]int) {
+	t.Helper()
+	db, err := openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}()
+	for topic, n := range topics {
+		q := testQuery(topic)
+		q.MaxItems = n
+		news := stubArticles(n)
+		for i := range news {
+			news[i].Source = newsAPIProviderName
+		}
+		if err := storeFetched(db, q, news); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOfflineAgainstSeededCache(t *testing.T) {
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("offline run sent %s", r.URL)
+	})
+	dir := t.TempDir()
+	seed := filepath.Join(dir, "seed.db")
+	seedCache(t, seed, map[string]int{"golang": 5, "rust": 2})
+	input := filepath.Join(dir, "topics.txt")
+	if err := os.WriteFile(input, []byte("golang,7,5\nrust,7,5\nhaskell,7,5\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := runCommand(t, "fetch", "-db", seed, "-offline", "-input", input, "-once", "-overwrite", "-output-dir", dir)
+	var failed *FailedTopicsError
+	if !errors.As(err, &failed) || failed.Failed != 1 || failed.Topics != 3 {
+		t.Errorf("got error %v, want 1 of 3 topics failed", err)
+	}
+	out := readOutput(t, dir)
+	for _, want := range []string{
+		`Results for "golang" (Fetched from: DB):`,
+		`Results for "rust" (Fetched from: DB, 2/5 items, cache may be stale):`,
+		`Results for "haskell" (error: offline and nothing cached)`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("the results don't have %s:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "\n- "); n != 7 {
+		t.Errorf("%d articles listed, want the 7 cached", n)
+	}
+}
+
+// TestCommandsSmoke runs each subcommand once against a cache filled by a
+// fetch with the fake provider.
+func TestCommandsSmoke(t *testing.T) {
+	dir := t.TempDir()
+	cache := filepath.Join(dir, "cache.db")
+	input := filepath.Join(dir, "topics.txt")
+	if err := os.WriteFile(input, []byte("golang,7,3\nrust,7,3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	export := filepath.Join(dir, "export.json")
+	captureLog(t) // search warns when built without FTS5
+	// and export to a file reports on stderr
+	keep(t, &os.Stderr)
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	os.Stderr = devNull
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"fetch", "-provider", "fake", "-db", cache, "-input", input, "-once", "-overwrite", "-output-dir", dir}, ""},
+		{[]string{"search", "-db", cache, "golang"}, "topic: golang"},
+		{[]string{"runs", "list", "-db", cache}, "topics.txt"},
+		{[]string{"trends", "-db", cache}, "most searched:"},
+		{[]string{"cache", "stats", "-db", cache}, "1 runs, 2 topics"},
+		{[]string{"cache", "export", "-db", cache, "-out", export}, ""},
+		{[]string{"cache", "import", "-db", cache, "-dry-run", export}, "would have imported 6 rows"},
+		{[]string{"cache", "prune", "-db", cache, "-older-than", "30d"}, "deleted 0 rows"},
+		{[]string{"cache", "vacuum", "-db", cache}, "live rows"},
+		{[]string{"db", "backup", "-db", cache, filepath.Join(dir, "backup.db")}, "backed up the cache"},
+		{[]string{"quota", "-db", cache}, "NewsAPI usage for"},
+		{[]string{"config", "show", "-db", cache}, "db                           " + cache + " flag"},
+	} {
+		out, err := runCommand(t, tc.args...)
+		if err != nil {
+			t.Errorf("%s: %v", strings.Join(tc.args, " "), err)
+			continue
+		}
+		if !strings.Contains(out, tc.want) {
+			t.Errorf("%s: the output doesn't say %q:\n%s", strings.Join(tc.args, " "), tc.want, out)
+		}
+	}
+	readOutput(t, dir)
+	if _, err := os.Stat(export); err != nil {
+		t.Errorf("cache export: %v", err)
+	}
+}
+
+func TestHelpListsCommands(t *testing.T) {
+	var out bytes.Buffer
+	printHelp(&out)
+	for _, c := range commands {
+		if !strings.Contains(out.String(), "\n  "+c.name+" ") {
+			t.Errorf("the help doesn't list %s:\n%s", c.name, out.String())
+		}
+	}
+}
+
+func TestServeSmoke(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	captureLog(t)
+	done := make(chan error, 1)
+	go func() {
+		_, err := runCommand(t, "serve", "-provider", "fake", "-db", filepath.Join(t.TempDir(), "cache.db"), "-addr", addr)
+		done <- err
+	}()
+
+	var resp *http.Response
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if resp, err = http.Get("http://" + addr + "/news?topic=golang,7,3"); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("the server never answered: %v", err)
+	}
+	var body ServeResponse
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || len(body.Results) != 3 {
+		t.Errorf("got %s with %+v, %v; want 3 results", resp.Status, body, err)
+	}
+
+	// serve stops on an interrupt
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve didn't stop on SIGINT")
+	}
+}
+
+func TestWorkerCountDoesntChangeResults(t *testing.T) {
+	// a fixed range keeps the fake articles the same from run to run
+	var lines []string
+	for i := range 200 {
+		lines = append(lines, fmt.Sprintf("topic %d,,%d,from=2024-05-01;to=2024-05-08", i, 1+i%5))
+	}
+	input := strings.Join(lines, "\n") + "\n"
+	topics := func(workers, queue string) []JSONTopic {
+		dir := t.TempDir()
+		fetchInto(t, dir, input, "-workers", workers, "-queue-size", queue, "-overwrite", "-format", "json")
+		data, err := os.ReadFile(filepath.Join(dir, "Outputs_user1.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc JSONOutput
+		if err := json.Unmarshal(data, &doc); err != nil {
+			t.Fatal(err)
+		}
+		for i := range doc.Topics {
+			doc.Topics[i].ElapsedMs = 0
+		}
+		return doc.Topics
+	}
+	one, many := topics("1", "0"), topics("16", "1000")
+	if len(one) != len(lines) {
+		t.Fatalf("one worker answered %d topics, want %d", len(one), len(lines))
+	}
+	for i := range one {
+		if one[i].Error != "" || len(one[i].Results) == 0 {
+			t.Errorf("one worker: %s: got %d results, error %q", one[i].Query, len(one[i].Results), one[i].Error)
+		}
+		if !reflect.DeepEqual(one[i], many[i]) {
+			t.Errorf("%s: one worker got\n%+v\n16 got\n%+v", one[i].Query, one[i], many[i])
+		}
+	}
+}
@@ -0,0 +1,368 @@
+// config.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// -------- Config file --------
+
+// configFileName is looked for in the working directory, then in
+// newscli/ under the user config directory ($XDG_CONFIG_HOME or ~/.config).
+const configFileName = "headlines.yaml"
+
+// A configSetting is a config file key that stands in for a flag. The
+// flag's environment variable, if it has one, overrides the file.
+type configSetting struct {
+	key, flag, env string
+}
+
+var configSettings = []configSetting{
+	{"db", "db", "NEWSCLI_DB"},
+	{"provider", "provider", ""},
+	{"fallback_providers", "fallback-provider", ""},
+	{"language", "language", "NEWSAPI_LANGUAGE"},
+	{"workers", "workers", "NEWSCLI_WORKERS"},
+	{"queue_size", "queue-size", "NEWSCLI_QUEUE_SIZE"},
+	{"max_concurrent_fetches", "max-concurrent-fetches", ""},
+	{"priority_age", "priority-age", ""},
+	{"timeout", "timeout", "NEWSCLI_TIMEOUT"},
+	{"max_timeout", "max-timeout", ""},
+	{"input", "input", "NEWSCLI_INPUT"},
+	{"input_format", "input-format", ""},
+	{"strict", "strict", ""},
+	{"max_items_cap", "max-items-cap", ""},
+	{"output.dir", "output-dir", "NEWSCLI_OUTPUT_DIR"},
+	{"output.name", "output-name", ""},
+	{"output.format", "format", ""},
+	{"output.template", "template", ""},
+	{"output.split_by_topic", "split-by-topic", ""},
+	{"output.summary_json", "summary-json", ""},
+	{"fail_fast", "fail-fast", ""},
+	{"shutdown_grace", "shutdown-grace", ""},
+	{"quiet", "quiet", ""},
+	{"verbose", "verbose", ""},
+	{"no_progress", "no-progress", ""},
+	{"output.csv_bom", "csv-bom", ""},
+	{"output.explode", "explode", ""},
+	{"output.feed_items", "feed-items", ""},
+	{"output.overwrite", "overwrite", ""},
+	{"output.keep_days", "keep-days", ""},
+	{"output.stdout", "stdout", ""},
+	{"output.tee", "tee", ""},
+	{"watch_interval", "watch-interval", ""},
+	{"cache.max_age", "cache-max-age", ""},
+	{"cache.max_rows", "cache-max-rows", ""},
+	{"cache.max_mb", "cache-max-mb", ""},
+	{"cache.memory_size", "memory-cache-size", ""},
+	{"cache.redis_ttl", "redis-ttl", ""},
+	{"cache.http_max_age", "http-cache-max-age", ""},
+	{"cache.purge_deleted_after", "purge-deleted-after", ""},
+	{"quota.api_budget", "api-budget", ""},
+	{"quota.max_api_calls", "max-api-calls", ""},
+	{"quota.timezone", "quota-timezone", ""},
+	{"http.timeout", "http-timeout", "NEWSCLI_HTTP_TIMEOUT"},
+	{"http.proxy", "proxy", "NEWSCLI_PROXY"},
+	{"http.user_agent", "user-agent", "NEWSCLI_USER_AGENT"},
+	{"retry.attempts", "retry-attempts", ""},
+	{"retry.base_delay", "retry-base-delay", ""},
+	{"retry.max_delay", "retry-max-delay", ""},
+	{"retry.passes", "retry-passes", ""},
+	{"retry.on", "retry-on", ""},
+	{"serve.addr", "addr", "NEWSCLI_ADDR"},
+}
+
+// configEnv are config file keys that set an environment variable instead
+// of a flag, mostly provider keys. A variable already set wins.
+var configEnv = []struct{ key, env string }{
+	{"providers.newsapi.key", "NEWSAPI_KEY"},
+	{"providers.newsapi.keys", "NEWSAPI_KEYS"},
+	{"providers.guardian.key", "GUARDIAN_KEY"},
+	{"providers.gnews.key", "GNEWS_KEY"},
+	{"providers.bing.key", "BING_SEARCH_KEY"},
+	{"providers.nyt.key", "NYT_KEY"},
+	{"cache.redis_addr", "REDIS_ADDR"},
+}
+
+// Config is a loaded config file. Values holds its settings flattened to
+// dotted keys, lists joined with commas.
+type Config struct {
+	Path   string
+	Values map[string]string
+	Topics []string
+	// fromFile are the flags the file set.
+	fromFile map[string]bool
+}
+
+// activeConfig is the config file applyConfig loaded, nil if there is none.
+var activeConfig *Config
+
+// findConfig returns the config file to use: explicit if given, else the
+// first default location that exists, else "".
+func findConfig(explicit string) string {
+	if explicit != "" {
+		return explicit
+	}
+	for _, path := range configSearchPath() {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+func configSearchPath() []string {
+	paths := []string{configFileName}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "newscli", configFileName))
+	}
+	return paths
+}
+
+// loadConfig reads the config file at path. Keys it doesn't know are
+// reported and otherwise ignored, so a typo doesn't stop a run.
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	c := &Config{Path: path, Values: map[string]string{}, fromFile: map[string]bool{}}
+	if topics, ok := doc["topics"]; ok {
+		list, ok := topics.([]any)
+		if !ok {
+			return nil, fmt.Errorf("config file %s: topics must be a list of input lines", path)
+		}
+		for _, t := range list {
+			c.Topics = append(c.Topics, fmt.Sprint(t))
+		}
+		delete(doc, "topics")
+	}
+	flattenConfig("", doc, c.Values)
+
+	known := map[string]bool{}
+	for _, s := range configSettings {
+		known[s.key] = true
+	}
+	for _, e := range configEnv {
+		known[e.key] = true
+	}
+	var unknown []string
+	for key := range c.Values {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		log.Printf("warning: config file %s: unknown key %q", path, key)
+		delete(c.Values, key)
+	}
+	return c, nil
+}
+
+// flattenConfig stores the scalars and lists under m in out by dotted key.
+func flattenConfig(prefix string, m map[string]any, out map[string]string) {
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch v := v.(type) {
+		case map[string]any:
+			flattenConfig(key, v, out)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			out[key] = strings.Join(items, ",")
+		case nil:
+		default:
+			out[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// parseFlags parses args into fs and then applies the config file.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return applyConfig(fs)
+}
+
+// parseFlagsAnywhere is parseFlags for a command whose flags may also
+// follow its arguments, which it returns.
+func parseFlagsAnywhere(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	return rest, applyConfig(fs)
+}
+
+// applyConfig fills in the flags of fs from the config file: each flag
+// the command line didn't set and whose environment variable is unset, so
+// flags beat the environment, which beats the file, which beats the
+// built-in defaults.
+func applyConfig(fs *flag.FlagSet) error {
+	explicit := ""
+	if f := fs.Lookup("config"); f != nil {
+		explicit = f.Value.String()
+	}
+	path := findConfig(explicit)
+	if path == "" {
+		return nil
+	}
+	c, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	activeConfig = c
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, s := range configSettings {
+		v, ok := c.Values[s.key]
+		if !ok || fs.Lookup(s.flag) == nil || set[s.flag] || (s.env != "" && os.Getenv(s.env) != "") {
+			continue
+		}
+		if err := fs.Set(s.flag, v); err != nil {
+			return fmt.Errorf("config file %s: %s: %w", path, s.key, err)
+		}
+		c.fromFile[s.flag] = true
+	}
+	for _, e := range configEnv {
+		if v, ok := c.Values[e.key]; ok && os.Getenv(e.env) == "" {
+			os.Setenv(e.env, v)
+			envSources[e.env] = sourceFile
+		}
+	}
+	return nil
+}
+
+// Where a setting's effective value came from.
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceFile    = "config file"
+	sourceDefault = "default"
+)
+
+// settingSource reports where the value of flag name of fs came from; for
+// an environment variable, that is the file that set it if any.
+func settingSource(fs *flag.FlagSet, name string) string {
+	if activeConfig != nil && activeConfig.fromFile[name] {
+		return sourceFile
+	}
+	setByFlag := false
+	fs.Visit(func(f *flag.Flag) { setByFlag = setByFlag || f.Name == name })
+	if setByFlag {
+		return sourceFlag
+	}
+	for _, s := range configSettings {
+		if s.flag == name && s.env != "" && os.Getenv(s.env) != "" {
+			return envSource(s.env)
+		}
+	}
+	return sourceDefault
+}
+
+// -------- config command --------
+
+const configUsage = `usage:
+  newscli config show [flags]`
+
+// runConfigCommand handles `newscli config show [flags]`, which takes
+// every flag fetch and serve do and prints the configuration they would
+// run with.
+func runConfigCommand(args []string, w io.Writer) error {
+	if len(args) == 0 || args[0] != "show" {
+		if len(args) > 0 {
+			return fmt.Errorf("unknown config command %q\n%s", args[0], configUsage)
+		}
+		return fmt.Errorf("%s", configUsage)
+	}
+	fs, _ := fetchFlagSet("config show")
+	addRunFlags(fs)
+	addServeFlags(fs)
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+
+	if activeConfig != nil {
+		fmt.Fprintf(w, "config file: %s\n\n", activeConfig.Path)
+	} else {
+		fmt.Fprintf(w, "config file: none (looked for %s)\n\n", strings.Join(configSearchPath(), ", "))
+	}
+	fmt.Fprintf(w, "%-28s %-40s %s\n", "KEY", "VALUE", "SOURCE")
+	for _, s := range configSettings {
+		value := fs.Lookup(s.flag).Value.String()
+		source := settingSource(fs, s.flag)
+		switch {
+		case s.key == "db" || s.key == "http.proxy":
+			value = redactURL(value)
+		case s.key == "input" && source == sourceDefault && activeConfig != nil && len(activeConfig.Topics) > 0:
+			value, source = "(the topics below)", sourceFile
+		}
+		fmt.Fprintf(w, "%-28s %-40s %s\n", s.key, value, source)
+	}
+	for _, e := range configEnv {
+		v := os.Getenv(e.env)
+		if v == "" {
+			continue
+		}
+		source := envSource(e.env)
+		if source == sourceEnv {
+			source += " " + e.env
+		}
+		fmt.Fprintf(w, "%-28s %-40s %s\n", e.key, maskEnvValue(e.env, v), source)
+	}
+	if activeConfig != nil && len(activeConfig.Topics) > 0 {
+		fmt.Fprintf(w, "%-28s %-40s %s\n", "topics", fmt.Sprintf("%d topics", len(activeConfig.Topics)), sourceFile)
+	}
+	return nil
+}
+
+// maskSecret hides all but the last four characters of a key, or all of
+// it when it is short.
+func maskSecret(s string) string {
+	if len(s) <= 8 {
+		return "****"
+	}
+	return "****" + s[len(s)-4:]
+}
+
+// redactURL hides the password in a URL-shaped setting such as a Postgres
+// DSN or a proxy; anything else is returned as it is.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	return u.Redacted()
+}
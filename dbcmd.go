@@ -0,0 +1,228 @@
+// dbcmd.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// -------- db command --------
+
+const dbUsage = `usage:
+  newscli db backup [-db cache] file
+  newscli db restore [-db cache] file`
+
+// runDBCommand handles `newscli db <subcommand> [flags] file`. Both
+// subcommands work on SQLite files only; Postgres and MySQL have pg_dump
+// and mysqldump.
+func runDBCommand(args []string, w io.Writer) error {
+	if len(args) == 0 || (args[0] != "backup" && args[0] != "restore") {
+		if len(args) > 0 {
+			return fmt.Errorf("unknown db command %q\n%s", args[0], dbUsage)
+		}
+		return fmt.Errorf("%s", dbUsage)
+	}
+	fs, store := cacheFlagSet("db " + args[0])
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%s", dbUsage)
+	}
+	if store.Ephemeral {
+		store.DSN = memoryDB
+	}
+	file, err := sqliteFile(store.DSN)
+	if err != nil {
+		return fmt.Errorf("db %s: %w", args[0], err)
+	}
+	if args[0] == "restore" {
+		return runDBRestore(store.DSN, file, fs.Arg(0), w)
+	}
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+	return runDBBackup(db, fs.Arg(0), w)
+}
+
+// sqliteFile is the file an SQLite -db value names: the value without the
+// connection parameters after a ?, and without the file: of a URI. A
+// server URL or an in-memory database is refused, as there is no file to
+// back up or restore.
+func sqliteFile(dsn string) (string, error) {
+	errNoFile := fmt.Errorf("only an SQLite cache file can be backed up or restored, not %s", redactURL(dsn))
+	if isMemoryDB(dsn) || strings.Contains(dsn, "://") {
+		return "", errNoFile
+	}
+	path, query, _ := strings.Cut(dsn, "?")
+	path = strings.TrimPrefix(path, "file:")
+	if values, err := url.ParseQuery(query); err == nil && values.Get("mode") == "memory" {
+		return "", errNoFile
+	}
+	if path == "" || path == memoryDB {
+		return "", errNoFile
+	}
+	return filepath.Clean(path), nil
+}
+
+// runDBBackup writes a consistent copy of the cache to path with VACUUM
+// INTO, which reads one snapshot and so can run while other processes keep
+// writing. The copy is written next to path and renamed into place, so an
+// interrupted backup never leaves a truncated file behind.
+func runDBBackup(db *gorm.DB, path string, w io.Writer) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("db backup: %s already exists", path)
+	}
+	tmp := path + ".tmp"
+	os.Remove(tmp) // left over from an interrupted backup
+	if err := withBusyRetry(func() error { return db.Exec("VACUUM INTO ?", tmp).Error }); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("db backup: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("db backup: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "backed up the cache to %s (%.1f MB)\n", path, float64(info.Size())/(1<<20))
+	return nil
+}
+
+// runDBRestore replaces file, the cache file dsn names, with the backup at
+// path. The backup is copied to a temporary file next to file and checked
+// there, then renamed over it, so a bad or interrupted restore leaves the
+// current cache untouched. The current cache is opened as it is, without
+// migrating what is about to be replaced, and the restore refuses while
+// another process is using it, since that process would go on writing to
+// the replaced file.
+func runDBRestore(dsn, file, path string, w io.Writer) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".restore-*")
+	if err != nil {
+		return fmt.Errorf("db restore: %w", err)
+	}
+	tmp.Close()
+	defer func() {
+		// checkBackup's read-only connection can leave the WAL files of a
+		// backup taken in WAL mode; the backup itself is gone once renamed
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			os.Remove(tmp.Name() + suffix)
+		}
+	}()
+	if err := copyFile(path, tmp.Name()); err != nil {
+		return fmt.Errorf("db restore: %w", err)
+	}
+	version, err := checkBackup(tmp.Name())
+	if err != nil {
+		return fmt.Errorf("db restore: %s: %w", path, err)
+	}
+
+	if _, err := os.Stat(file); err == nil {
+		if err := releaseCache(dsn); err != nil {
+			return fmt.Errorf("db restore: %w", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("db restore: %w", err)
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(file + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("db restore: %w", err)
+		}
+	}
+	fmt.Fprintf(w, "restored %s from %s (schema version %d)\n", file, path, version)
+	return nil
+}
+
+// releaseCache takes the cache file dsn out of WAL mode, which folds the
+// WAL back into the file and needs every other connection to it closed:
+// what a restore needs. It fails if another process has it open.
+func releaseCache(dsn string) error {
+	db, err := gorm.Open(sqlite.Open(sqliteFileDSN(dsn)), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	var mode string
+	if err := db.Raw("PRAGMA journal_mode = DELETE").Scan(&mode).Error; err != nil && !isBusy(err) {
+		sqlDB.Close()
+		return err
+	}
+	if err := sqlDB.Close(); err != nil {
+		return err
+	}
+	if mode != "delete" {
+		return fmt.Errorf("the cache is in use by another process, stop it and try again")
+	}
+	return nil
+}
+
+// checkBackup opens path read-only and reports its schema version if it
+// is an intact newscli cache this build can use.
+func checkBackup(path string) (int, error) {
+	bdb, err := gorm.Open(sqlite.Open("file:"+filepath.ToSlash(path)+"?mode=ro"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if sqlDB, err := bdb.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	var result string
+	if err := bdb.Raw("PRAGMA quick_check").Scan(&result).Error; err != nil {
+		return 0, fmt.Errorf("not a readable SQLite database: %w", err)
+	}
+	if result != "ok" {
+		return 0, fmt.Errorf("database is damaged: %s", result)
+	}
+	if !bdb.Migrator().HasTable(&CachedSearch{}) {
+		return 0, fmt.Errorf("not a newscli cache: it has no cached_searches table")
+	}
+	version, err := cacheSchemaVersion(bdb)
+	if err != nil {
+		return 0, err
+	}
+	if version > schemaVersion {
+		return 0, fmt.Errorf("written by a newer newscli (schema version %d, this build knows up to %d)", version, schemaVersion)
+	}
+	return version, nil
+}
+
+// copyFile copies src to dst and syncs it, so a rename of dst that
+// follows can't expose a partly written file.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,140 @@
+// dbcmd_test.go
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// openCache opens the SQLite cache at path, to be closed by the caller.
+func openCache(t *testing.T, path string) (*gorm.DB, func()) {
+	t.Helper()
+	db, err := openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}
+}
+
+// cachedQueries lists the topics cached in the SQLite cache at path.
+func cachedQueries(t *testing.T, path string) string {
+	t.Helper()
+	db, done := openCache(t, path)
+	defer done()
+	return strings.Join(cachedTopics(t, db), ", ")
+}
+
+func TestDBBackupRestoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	cache, backup := filepath.Join(dir, "cache.db"), filepath.Join(dir, "backup.db")
+	seedCache(t, cache, map[string]int{"golang": 5, "rust": 3})
+
+	db, done := openCache(t, cache)
+	var out bytes.Buffer
+	if err := runDBBackup(db, backup, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "backed up the cache to "+backup) {
+		t.Errorf("got %q", out.String())
+	}
+	if err := runDBBackup(db, backup, &out); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("backing up over the backup: got %v, want it refused", err)
+	}
+	// the cache changes after the backup
+	if err := db.Unscoped().Where("query = ?", "rust").Delete(&CachedSearch{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := storeFetched(db, testQuery("zig"), stubArticles(2)); err != nil {
+		t.Fatal(err)
+	}
+	done()
+
+	out2, err := runCommand(t, "db", "restore", "-db", cache, backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "restored " + cache + " from " + backup; !strings.HasPrefix(out2, want) {
+		t.Errorf("got %q, want %s...", out2, want)
+	}
+	if got := cachedQueries(t, cache); got != "golang, rust" {
+		t.Errorf("restored cache has %s, want golang, rust", got)
+	}
+	db, done = openCache(t, cache)
+	defer done()
+	if n := countRows(t, db, testQuery("golang")); n != 5 {
+		t.Errorf("%d golang rows restored, want 5", n)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, "*.restore-*")); len(leftovers) > 0 {
+		t.Errorf("left %v behind", leftovers)
+	}
+}
+
+func TestDBRestoreRefuses(t *testing.T) {
+	dir := t.TempDir()
+	cache := filepath.Join(dir, "cache.db")
+	seedCache(t, cache, map[string]int{"golang": 5})
+
+	garbage := filepath.Join(dir, "garbage.db")
+	if err := os.WriteFile(garbage, []byte("not a database at all, but long enough to look like a header"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	newer := filepath.Join(dir, "newer.db")
+	db, done := openCache(t, newer)
+	if err := db.Create(&SchemaMigration{Version: schemaVersion + 1, Name: "from the future", AppliedAt: time.Now()}).Error; err != nil {
+		t.Fatal(err)
+	}
+	done()
+	backup := filepath.Join(dir, "backup.db")
+	seedCache(t, backup, map[string]int{"rust": 5})
+
+	for _, tc := range []struct{ name, file, err string }{
+		{"garbage", garbage, "file is not a database"},
+		{"newer schema", newer, "written by a newer newscli"},
+		{"missing", filepath.Join(dir, "missing.db"), "no such file"},
+	} {
+		if _, err := runCommand(t, "db", "restore", "-db", cache, tc.file); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got %v, want an error saying %q", tc.name, err, tc.err)
+		}
+	}
+	// a cache in use by another process isn't replaced under it
+	_, done = openCache(t, cache)
+	if _, err := runCommand(t, "db", "restore", "-db", cache, backup); err == nil || !strings.Contains(err.Error(), "in use by another process") {
+		t.Errorf("restoring over an open cache: got %v, want it refused", err)
+	}
+	done()
+	if got := cachedQueries(t, cache); got != "golang" {
+		t.Errorf("after the refused restores the cache has %s, want golang", got)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, "*.restore-*")); len(leftovers) > 0 {
+		t.Errorf("left %v behind", leftovers)
+	}
+}
+
+func TestSQLiteFile(t *testing.T) {
+	for dsn, want := range map[string]string{
+		"news_cache.db":                      "news_cache.db",
+		"/var/cache/newscli/./cache.db":      "/var/cache/newscli/cache.db",
+		"cache.db?_busy_timeout=100":         "cache.db",
+		"file:cache.db?cache=shared":         "cache.db",
+		memoryDB:                             "",
+		"file:news?mode=memory&cache=shared": "",
+		"postgres://news@localhost/news":     "",
+	} {
+		got, err := sqliteFile(dsn)
+		if want == "" && err == nil {
+			t.Errorf("%s: got %s, want it refused", dsn, got)
+		} else if want != "" && got != want {
+			t.Errorf("%s: got %s, %v; want %s", dsn, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// dispatch.go
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// -------- Task priorities --------
+
+// Priority orders the topics waiting for a worker, from the priority=
+// option of an input line. The zero value is PriorityNormal.
+type Priority int
+
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityLow:
+		return "low"
+	}
+	return "normal"
+}
+
+func parsePriority(s string) (Priority, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "high":
+		return PriorityHigh, nil
+	case "normal", "":
+		return PriorityNormal, nil
+	case "low":
+		return PriorityLow, nil
+	}
+	return PriorityNormal, fmt.Errorf("priority %q: want high, normal or low", s)
+}
+
+// taskSeq numbers tasks in the order they are submitted, which is the
+// order dispatch keeps within a priority.
+var taskSeq atomic.Uint64
+
+// nextTaskSeq is the number of the next task submitted. Callers take it in
+// input order, before the goroutines submitting the tasks race each other.
+func nextTaskSeq() uint64 {
+	return taskSeq.Add(1)
+}
+
+// priorityAge is how long a task waits for a worker before it moves up a
+// priority; set from -priority-age.
+var priorityAge = 30 * time.Second
+
+// waitingTask is a task held by dispatch.
+type waitingTask struct {
+	Task
+	since time.Time
+}
+
+// taskHeap is the tasks of one priority waiting for a worker, by seq.
+type taskHeap []waitingTask
+
+func (h taskHeap) Len() int           { return len(h) }
+func (h taskHeap) Less(i, j int) bool { return h[i].seq < h[j].seq }
+func (h taskHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *taskHeap) Push(x any)        { *h = append(*h, x.(waitingTask)) }
+
+func (h *taskHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
+
+// dispatch passes the tasks sent to in on to the workers reading out,
+// highest priority first and in submission order within a priority. It
+// holds up to size tasks, at least one; further senders wait, as they
+// would on a channel of that size. A task that has waited priorityAge
+// moves up a priority, so high priority topics can hold a low priority one
+// back by about twice that, no more. out is closed once in is, and the
+// tasks held have been passed on.
+func dispatch(in <-chan Task, out chan<- Task, size int) {
+	// levels[0] holds PriorityHigh, levels[2] PriorityLow
+	var levels [3]taskHeap
+	held := 0
+	for in != nil || held > 0 {
+		var send chan<- Task
+		var next Task
+		top := -1
+		if held > 0 {
+			promoteWaiting(&levels, time.Now())
+			for l := range levels {
+				if len(levels[l]) > 0 {
+					top = l
+					break
+				}
+			}
+			send, next = out, levels[top][0].Task
+		}
+		recv := in
+		if held >= max(size, 1) {
+			recv = nil
+		}
+		select {
+		case t, ok := <-recv:
+			if !ok {
+				in = nil
+				continue
+			}
+			l := int(PriorityHigh - min(max(t.Priority, PriorityLow), PriorityHigh))
+			heap.Push(&levels[l], waitingTask{Task: t, since: time.Now()})
+			held++
+		case send <- next:
+			heap.Pop(&levels[top])
+			held--
+		}
+	}
+	close(out)
+}
+
+// promoteWaiting moves the tasks that have waited priorityAge at their
+// priority up one. Only the first task of each priority is looked at: the
+// rest were submitted after it, and so have waited no longer, give or take
+// the goroutines submitting them.
+func promoteWaiting(levels *[3]taskHeap, now time.Time) {
+	for l := 1; l < len(levels); l++ {
+		for len(levels[l]) > 0 && now.Sub(levels[l][0].since) >= priorityAge {
+			t := heap.Pop(&levels[l]).(waitingTask)
+			verbosef("%s: waited %s for a worker, moving it up from %s priority", topicLabel(t.NewsQuery),
+				roundDuration(now.Sub(t.since)), PriorityHigh-Priority(l))
+			t.since = now
+			heap.Push(&levels[l-1], t)
+		}
+	}
+}
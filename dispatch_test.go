@@ -0,0 +1,145 @@
+// dispatch_test.go
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// priorityTopics are submitted in this order; the names say which
+// priority each has.
+var priorityTopics = []NewsQuery{
+	{Query: "low 1", Priority: PriorityLow}, {Query: "normal 1"}, {Query: "high 1", Priority: PriorityHigh},
+	{Query: "low 2", Priority: PriorityLow}, {Query: "high 2", Priority: PriorityHigh}, {Query: "normal 2"},
+	{Query: "high 3", Priority: PriorityHigh}, {Query: "normal 3"},
+}
+
+// byPriority is the order priorityTopics are taken in when they all wait.
+var byPriority = []string{"high 1", "high 2", "high 3", "normal 1", "normal 2", "normal 3", "low 1", "low 2"}
+
+func TestParsePriority(t *testing.T) {
+	for s, want := range map[string]Priority{"high": PriorityHigh, " HIGH ": PriorityHigh, "": PriorityNormal,
+		"normal": PriorityNormal, "low": PriorityLow} {
+		if got, err := parsePriority(s); got != want || err != nil {
+			t.Errorf("parsePriority(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	if _, err := parsePriority("urgent"); err == nil {
+		t.Error("parsePriority(urgent) succeeded")
+	}
+}
+
+func TestDispatchOrder(t *testing.T) {
+	in, out := make(chan Task), make(chan Task)
+	go dispatch(in, out, len(priorityTopics))
+	for _, q := range priorityTopics {
+		in <- Task{NewsQuery: q, seq: nextTaskSeq()}
+	}
+	close(in)
+	var got []string
+	for t := range out {
+		got = append(got, t.Query)
+	}
+	if !slices.Equal(got, byPriority) {
+		t.Errorf("got %q, want %q", got, byPriority)
+	}
+}
+
+// busyProvider is a recordingProvider whose fetch of "busy" lasts until
+// release is closed, keeping its worker from the tasks queued behind it.
+// started is closed when that fetch starts.
+type busyProvider struct {
+	*recordingProvider
+	started, release chan struct{}
+}
+
+func (p *busyProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	if q.Query == "busy" {
+		close(p.started)
+		select {
+		case <-p.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return p.recordingProvider.Fetch(ctx, q)
+}
+
+func TestPriorityCompletionOrder(t *testing.T) {
+	db := openTestDB(t)
+	p := &busyProvider{&recordingProvider{stubProvider: &stubProvider{news: stubArticles(5)}}, make(chan struct{}), make(chan struct{})}
+	tasks := startTestPool(t, db, p, 1)
+	submit := func(q NewsQuery) chan TaskResult {
+		resp := make(chan TaskResult, 1)
+		q.Days, q.MaxItems = 7, 5
+		// the send returns once the dispatcher holds the task
+		tasks <- Task{NewsQuery: q, Resp: resp, Ctx: context.Background(), seq: nextTaskSeq(), queued: time.Now()}
+		return resp
+	}
+	busy := submit(NewsQuery{Query: "busy"})
+	<-p.started
+	var resps []chan TaskResult
+	for _, q := range priorityTopics {
+		resps = append(resps, submit(q))
+	}
+	close(p.release)
+	for _, resp := range append(resps, busy) {
+		if r := <-resp; r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	}
+	var got []string
+	for _, q := range p.queries[1:] {
+		got = append(got, q.Query)
+	}
+	if p.queries[0].Query != "busy" || !slices.Equal(got, byPriority) {
+		t.Errorf("fetched busy, then %q; want %q", got, byPriority)
+	}
+}
+
+func TestPromoteWaiting(t *testing.T) {
+	keep(t, &priorityAge)
+	priorityAge = time.Minute
+	now := time.Now()
+	var levels [3]taskHeap
+	levels[1] = taskHeap{{Task{NewsQuery: NewsQuery{Query: "normal"}, seq: 2}, now.Add(-2 * time.Minute)}}
+	levels[2] = taskHeap{
+		{Task{NewsQuery: NewsQuery{Query: "old low"}, seq: 1}, now.Add(-time.Minute)},
+		{Task{NewsQuery: NewsQuery{Query: "new low"}, seq: 3}, now},
+	}
+	promoteWaiting(&levels, now)
+	// each task moves up one level, however long it has waited
+	if len(levels[0]) != 1 || levels[0][0].Query != "normal" {
+		t.Errorf("high holds %+v, want the normal task", levels[0])
+	}
+	if len(levels[1]) != 1 || levels[1][0].Query != "old low" || !levels[1][0].since.Equal(now) {
+		t.Errorf("normal holds %+v, want the old low task, waiting from now", levels[1])
+	}
+	if len(levels[2]) != 1 || levels[2][0].Query != "new low" {
+		t.Errorf("low holds %+v, want the new low task", levels[2])
+	}
+}
+
+func TestDispatchPromotesStarvedTasks(t *testing.T) {
+	keep(t, &priorityAge)
+	priorityAge = 20 * time.Millisecond
+	in, out := make(chan Task), make(chan Task)
+	go dispatch(in, out, 10)
+	// a task moves up a level each time it has waited priorityAge at one,
+	// checked when the dispatcher next has something to do
+	in <- Task{NewsQuery: NewsQuery{Query: "low", Priority: PriorityLow}, seq: nextTaskSeq()}
+	time.Sleep(3 * priorityAge)
+	in <- Task{NewsQuery: NewsQuery{Query: "normal"}, seq: nextTaskSeq()}
+	time.Sleep(3 * priorityAge)
+	in <- Task{NewsQuery: NewsQuery{Query: "high", Priority: PriorityHigh}, seq: nextTaskSeq()}
+	close(in)
+	var got []string
+	for t := range out {
+		got = append(got, t.Query)
+	}
+	if want := []string{"low", "normal", "high"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
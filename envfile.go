@@ -0,0 +1,193 @@
+// envfile.go
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// -------- .env files --------
+
+// defaultEnvFile is read from the working directory when it exists.
+const defaultEnvFile = ".env"
+
+// envSources records which file set each variable loadEnvFile or the
+// config file put into the environment. Variables missing from it came
+// from the real environment.
+var envSources = map[string]string{}
+
+// loadEnvFiles reads the file named by -env-file, if args have one, and
+// then .env, before any flag is registered, since flag defaults are taken
+// from the environment. Neither overrides a variable that is already set,
+// so the real environment wins, then -env-file, then .env.
+func loadEnvFiles(args []string) error {
+	if path := envFileArg(args); path != "" {
+		if err := loadEnvFile(path); err != nil {
+			return err
+		}
+	}
+	err := loadEnvFile(defaultEnvFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// envFileArg finds -env-file in args ahead of flag parsing, which only
+// comes later and per command.
+func envFileArg(args []string) string {
+	for i, a := range args {
+		if a == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !strings.HasPrefix(a, "-") || name != "env-file" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// loadEnvFile sets the variables of the .env-style file at path that
+// aren't set yet. Malformed lines are reported with their line number and
+// skipped.
+func loadEnvFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	vars, err := parseEnvFile(file, func(line int, msg string) {
+		log.Printf("warning: %s:%d: %s", path, line, msg)
+	})
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	for _, v := range vars {
+		if _, set := os.LookupEnv(v[0]); set {
+			continue
+		}
+		os.Setenv(v[0], v[1])
+		envSources[v[0]] = path
+	}
+	return nil
+}
+
+// parseEnvFile reads KEY=VALUE lines in file order. Blank lines and lines
+// starting with # are skipped, as is an "export " prefix. A value may be
+// single-quoted (taken as it is) or double-quoted (with \n, \t, \" and \\
+// escapes); an unquoted value ends at " #", which starts a comment.
+func parseEnvFile(r io.Reader, malformed func(line int, msg string)) ([][2]string, error) {
+	var vars [][2]string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !isEnvName(key) {
+			malformed(n, "want KEY=VALUE")
+			continue
+		}
+		value, err := envValue(strings.TrimSpace(value))
+		if err != nil {
+			malformed(n, err.Error())
+			continue
+		}
+		vars = append(vars, [2]string{key, value})
+	}
+	return vars, scanner.Err()
+}
+
+func isEnvName(s string) bool {
+	for i, r := range s {
+		if !(r == '_' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// envValue unquotes the value part of a .env line.
+func envValue(v string) (string, error) {
+	if v == "" || (v[0] != '"' && v[0] != '\'') {
+		if i := strings.Index(v, " #"); i >= 0 {
+			v = strings.TrimSpace(v[:i])
+		}
+		return v, nil
+	}
+	quote := v[0]
+	var b strings.Builder
+	for i := 1; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == quote:
+			if rest := strings.TrimSpace(v[i+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+				return "", fmt.Errorf("unexpected %q after the closing quote", rest)
+			}
+			return b.String(), nil
+		case c == '\\' && quote == '"' && i+1 < len(v):
+			i++
+			switch v[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(v[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("missing closing %c", quote)
+}
+
+// envSource says where the variable name got its value: "env" for the
+// real environment, or the file that set it.
+func envSource(name string) string {
+	if src, ok := envSources[name]; ok {
+		return src
+	}
+	return sourceEnv
+}
+
+// reportSecrets logs, masked, each provider key and connection secret in
+// the environment and which source supplied it, so a key picked up from
+// the wrong place is easy to spot.
+func reportSecrets() {
+	var parts []string
+	for _, e := range configEnv {
+		v := os.Getenv(e.env)
+		if v == "" {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s=%s (%s)", e.env, maskEnvValue(e.env, v), envSource(e.env)))
+	}
+	if len(parts) > 0 {
+		log.Printf("secrets: %s", strings.Join(parts, ", "))
+	}
+}
+
+// maskEnvValue masks a key; REDIS_ADDR is shown with its password
+// redacted instead, since the address itself isn't secret.
+func maskEnvValue(name, v string) string {
+	if name == "REDIS_ADDR" {
+		return redactURL(v)
+	}
+	return maskSecret(v)
+}
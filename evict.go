@@ -0,0 +1,130 @@
+// evict.go
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// -------- Cache size limits --------
+
+// CacheLimits caps the cache; zero fields are unlimited. Set from
+// -cache-max-rows and -cache-max-mb.
+type CacheLimits struct {
+	MaxRows  int64
+	MaxBytes int64
+}
+
+var cacheLimits CacheLimits
+
+var (
+	// evictMu keeps workers from evicting concurrently.
+	evictMu sync.Mutex
+	// evictedQueries counts the topics evicted in the current pass.
+	evictedQueries atomic.Int64
+	// passStart is when the current pass of the input files started, nil
+	// before the first; startRun sets it along with evictedQueries, while
+	// workers may still be evicting for the pass before.
+	passStart    atomic.Pointer[time.Time]
+	processStart = time.Now()
+)
+
+// passStarted is the time topics touched since are kept from eviction:
+// the start of the current pass, or of the process before any.
+func passStarted() time.Time {
+	if t := passStart.Load(); t != nil {
+		return *t
+	}
+	return processStart
+}
+
+// touchQuery marks q's rows as just used so eviction keeps them.
+func touchQuery(db *gorm.DB, q NewsQuery) {
+	db.Model(&CachedSearch{}).Where("query = ?", q.cacheQuery()).Update("last_accessed", time.Now())
+}
+
+func (l CacheLimits) exceeded(db *gorm.DB) bool {
+	if l.MaxRows > 0 {
+		var rows int64
+		db.Unscoped().Model(&CachedSearch{}).Count(&rows)
+		if rows > l.MaxRows {
+			return true
+		}
+	}
+	return l.MaxBytes > 0 && cacheBytes(db) > l.MaxBytes
+}
+
+// evictCache deletes whole topics, least recently used first, until the
+// cache fits cacheLimits. Topics used since passStarted are never evicted,
+// so the cache may stay over its limit until the next pass.
+func evictCache(db *gorm.DB) error {
+	if cacheLimits == (CacheLimits{}) {
+		return nil
+	}
+	evictMu.Lock()
+	defer evictMu.Unlock()
+	lastUsed := sqlInstant(db, "MAX(COALESCE(last_accessed, created))")
+	for cacheLimits.exceeded(db) {
+		var lru []string
+		err := db.Unscoped().Model(&CachedSearch{}).
+			Group("query").
+			Having(lastUsed+" < "+sqlInstant(db, "?"), passStarted()).
+			Order(lastUsed).
+			Limit(1).Pluck("query", &lru).Error
+		if err != nil {
+			return err
+		}
+		if len(lru) == 0 {
+			return nil // everything left is in use this pass
+		}
+		if err := db.Unscoped().Where("query = ?", lru[0]).Delete(&CachedSearch{}).Error; err != nil {
+			return err
+		}
+		invalidateQuery(context.Background(), lru[0])
+		debugf("evicted cached topic %q", lru[0])
+		evictedQueries.Add(1)
+	}
+	return nil
+}
+
+// -------- Soft-deleted rows --------
+
+// purgeBatch is how many soft-deleted rows purgeSoftDeleted removes per
+// statement, so the write lock is held only briefly each time.
+const purgeBatch = 500
+
+// purgeDeletedAfter is how long soft-deleted rows are kept before the
+// end-of-pass cleanup removes them for good; 0 keeps them forever. Set
+// from -purge-deleted-after and -keep-deleted.
+var purgeDeletedAfter = 7 * 24 * time.Hour
+
+// purgeSoftDeleted permanently deletes rows soft-deleted before cutoff,
+// purgeBatch at a time, and returns how many went. It stops between
+// batches once ctx is done.
+func purgeSoftDeleted(ctx context.Context, db *gorm.DB, cutoff time.Time) (int64, error) {
+	// the derived table lets MySQL read the table it deletes from
+	batch := "DELETE FROM cached_searches WHERE id IN (SELECT id FROM (SELECT id FROM cached_searches " +
+		"WHERE deleted_at IS NOT NULL AND " + sqlInstant(db, "deleted_at") + " < " + sqlInstant(db, "?") +
+		" LIMIT ?) AS batch)"
+	var purged int64
+	for ctx.Err() == nil {
+		var n int64
+		err := withBusyRetry(func() error {
+			tx := db.Exec(batch, cutoff, purgeBatch)
+			n = tx.RowsAffected
+			return tx.Error
+		})
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+		if n < purgeBatch {
+			break
+		}
+	}
+	return purged, ctx.Err()
+}
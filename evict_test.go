@@ -0,0 +1,184 @@
+// evict_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// useCacheLimits caps the cache for the rest of the test.
+func useCacheLimits(t testing.TB, l CacheLimits) {
+	keep(t, &cacheLimits)
+	cacheLimits = l
+}
+
+// lastUsed marks the rows of topic as last used at.
+func lastUsed(t testing.TB, db *gorm.DB, topic string, at time.Time) {
+	t.Helper()
+	err := db.Model(&CachedSearch{}).Where("query = ?", queryKey(topic)).Updates(map[string]any{"last_accessed": at, "created": at}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// cachedTopics lists the topics with rows in the cache, deleted or not.
+func cachedTopics(t testing.TB, db *gorm.DB) []string {
+	t.Helper()
+	var topics []string
+	if err := db.Unscoped().Model(&CachedSearch{}).Distinct().Order("query").Pluck("query", &topics).Error; err != nil {
+		t.Fatal(err)
+	}
+	return topics
+}
+
+func TestEvictionDropsLeastRecentlyUsed(t *testing.T) {
+	db := openTestDB(t)
+	seedTopics(t, db, 3, 5)
+	now := time.Now()
+	lastUsed(t, db, "topic 1", now.Add(-2*time.Hour))
+	lastUsed(t, db, "topic 2", now.Add(-3*time.Hour)) // the oldest
+	lastUsed(t, db, "topic 3", now.Add(-time.Hour))
+	useCacheLimits(t, CacheLimits{MaxRows: 15})
+	tasks := startTestPool(t, db, &stubProvider{news: stubArticles(5)}, 1)
+
+	var out bytes.Buffer
+	runPass(context.Background(), db, tasks, "evict.txt", []NewsQuery{testQuery("topic 4")}, 5*time.Second, &out)
+	if got := strings.Join(cachedTopics(t, db), ", "); got != "topic 1, topic 3, topic 4" {
+		t.Errorf("cached topics %s, want topic 2 evicted", got)
+	}
+	if n := evictedQueries.Load(); n != 1 {
+		t.Errorf("%d topics evicted, want 1", n)
+	}
+	var summary bytes.Buffer
+	printPassSummary(&summary, RunStats{})
+	if want := "Evicted 1 least recently used topics"; !strings.Contains(summary.String(), want) {
+		t.Errorf("the summary doesn't say %q:\n%s", want, summary.String())
+	}
+
+	// the next pass evicts the older of what's left, oldest first
+	time.Sleep(10 * time.Millisecond)
+	runPass(context.Background(), db, tasks, "evict.txt", []NewsQuery{testQuery("topic 5"), testQuery("topic 6")}, 5*time.Second, &out)
+	if got := strings.Join(cachedTopics(t, db), ", "); got != "topic 4, topic 5, topic 6" {
+		t.Errorf("cached topics %s, want topic 1 and topic 3 evicted", got)
+	}
+	if n := evictedQueries.Load(); n != 2 {
+		t.Errorf("%d topics evicted in the second pass, want 2", n)
+	}
+}
+
+func TestEvictionKeepsTopicsServedThisPass(t *testing.T) {
+	db := openTestDB(t)
+	keep(t, &memoryCache)
+	memoryCache = newMemoryCache(10)
+	useCacheLimits(t, CacheLimits{MaxRows: 10})
+	tasks := startTestPool(t, db, &stubProvider{news: stubArticles(5)}, 1)
+
+	// topic 1 is fetched in the first pass and served from memory in the
+	// second, which fetches two more topics into a cache too small for all
+	var out bytes.Buffer
+	runPass(context.Background(), db, tasks, "evict.txt", []NewsQuery{testQuery("topic 1")}, 5*time.Second, &out)
+	time.Sleep(10 * time.Millisecond)
+	results := []NewsQuery{testQuery("topic 1"), testQuery("topic 2"), testQuery("topic 3")}
+	_, res := runPass(context.Background(), db, tasks, "evict.txt", results, 5*time.Second, &out)
+	if res[0].Err != nil || res[0].Source != "DB" {
+		t.Fatalf("topic 1: got %+v, want it served from memory", res[0])
+	}
+	for _, topic := range cachedTopics(t, db) {
+		if topic == "topic 1" {
+			return
+		}
+	}
+	t.Errorf("topic 1, served this pass, was evicted: %v are cached", cachedTopics(t, db))
+}
+
+// softDelete soft-deletes the rows of the given topics, as deleted age ago.
+func softDelete(t testing.TB, db *gorm.DB, age time.Duration, topics ...string) {
+	t.Helper()
+	if err := db.Where("query IN ?", topics).Delete(&CachedSearch{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	err := db.Unscoped().Model(&CachedSearch{}).Where("query IN ?", topics).Update("deleted_at", time.Now().Add(-age)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPurgeSoftDeletedInBatches(t *testing.T) {
+	db := openTestDB(t)
+	seedTopics(t, db, 50, 50)
+	var old, recent []string
+	for i := 1; i <= 50; i++ {
+		if i <= 45 {
+			old = append(old, fmt.Sprintf("topic %d", i))
+		} else if i <= 48 {
+			recent = append(recent, fmt.Sprintf("topic %d", i))
+		}
+	}
+	softDelete(t, db, 8*24*time.Hour, old...)
+	softDelete(t, db, time.Hour, recent...)
+
+	var deletes atomic.Int64
+	err := db.Callback().Raw().After("gorm:raw").Register("test:count", func(tx *gorm.DB) {
+		if strings.HasPrefix(tx.Statement.SQL.String(), "DELETE") {
+			deletes.Add(1)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	purged, err := purgeSoftDeleted(context.Background(), db, time.Now().Add(-7*24*time.Hour))
+	if err != nil || purged != 45*50 {
+		t.Fatalf("purged %d rows, %v; want %d", purged, err, 45*50)
+	}
+	// 2250 rows take four full batches and a fifth of 250
+	if n := deletes.Load(); n != 5 {
+		t.Errorf("%d DELETE statements, want 5 of up to %d rows", n, purgeBatch)
+	}
+	var left, live int64
+	db.Unscoped().Model(&CachedSearch{}).Where("deleted_at IS NOT NULL").Count(&left)
+	db.Model(&CachedSearch{}).Count(&live)
+	if left != 3*50 || live != 2*50 {
+		t.Errorf("%d soft-deleted and %d live rows left, want 150 deleted recently and 100 live", left, live)
+	}
+}
+
+func TestPurgeSoftDeletedStopsWhenCanceled(t *testing.T) {
+	db := openTestDB(t)
+	seedTopics(t, db, 2, 50)
+	softDelete(t, db, 8*24*time.Hour, "topic 1", "topic 2")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if purged, err := purgeSoftDeleted(ctx, db, time.Now()); purged != 0 || err != context.Canceled {
+		t.Errorf("purged %d rows, %v; want none and the context's error", purged, err)
+	}
+}
+
+func TestPurgeAfterPass(t *testing.T) {
+	for _, tc := range []struct {
+		after time.Duration
+		left  int64
+	}{
+		{7 * 24 * time.Hour, 0},
+		{0, 50}, // -purge-deleted-after 0 keeps them all
+	} {
+		db := openTestDB(t)
+		seedTopics(t, db, 1, 50)
+		softDelete(t, db, 8*24*time.Hour, "topic 1")
+		keep(t, &purgeDeletedAfter)
+		purgeDeletedAfter = tc.after
+		captureLog(t)
+		purgeAfterPass(db)
+		var left int64
+		db.Unscoped().Model(&CachedSearch{}).Count(&left)
+		if left != tc.left {
+			t.Errorf("-purge-deleted-after %s: %d rows left, want %d", tc.after, left, tc.left)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// fake.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// -------- Fake provider --------
+const fakeProviderName = "fake"
+
+// FakeProvider makes up articles instead of calling an API, so the worker
+// pool, cache and output can be exercised without keys or a network. The
+// same query always yields the same articles within an hour.
+type FakeProvider struct {
+	// FailAfter makes every call after the first FailAfter fail with a 503
+	// (0 never fails). Calls are counted across all FakeProviders.
+	FailAfter int
+	// Latency delays every call, still honouring the task's deadline.
+	Latency time.Duration
+}
+
+// fakeProvider holds the -fake-* flag settings newProvider hands out.
+var fakeProvider FakeProvider
+
+var fakeCalls atomic.Int64
+
+var (
+	fakeAdjectives = []string{"New", "Open", "Surprising", "Quiet", "Major", "Early", "Unusual", "Growing"}
+	fakeNouns      = []string{"release", "study", "debate", "outage", "milestone", "partnership", "report", "trend"}
+	fakeSources    = []string{"Example Times", "Sample Daily", "Placeholder Post", "Demo Wire"}
+)
+
+func (FakeProvider) Name() string { return fakeProviderName }
+
+func (p FakeProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	if p.Latency > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(p.Latency):
+		}
+	}
+	countAPICall(ctx)
+	if n := fakeCalls.Add(1); p.FailAfter > 0 && n > int64(p.FailAfter) {
+		return nil, &ProviderError{Provider: fakeProviderName, StatusCode: http.StatusServiceUnavailable,
+			Message: fmt.Sprintf("injected failure on call %d", n)}
+	}
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%s|%s|%s|%s", q.Query, q.endpoint(), q.Country, q.Category, q.Language)
+	seed := h.Sum64()
+	rng := rand.New(rand.NewPCG(seed, seed>>1))
+
+	// spread articles over the days window, newest first, ending at the
+	// top of the hour so repeated runs agree
+	end := time.Now().Truncate(time.Hour)
+	if until := q.fetchUntil(); !until.IsZero() && until.Before(end) {
+		end = until
+	}
+	start := q.windowStart()
+	if start.IsZero() || !start.Before(end) {
+		start = end.Add(-24 * time.Hour)
+	}
+	debugf("fake: %q from %s to %s", q.Query, start.Format(time.RFC3339), end.Format(time.RFC3339))
+	window := end.Sub(start)
+	slug := strings.Join(strings.Fields(strings.ToLower(q.Query)), "-")
+	if !q.Until.IsZero() {
+		// a delta fetch returns other articles than the cached ones
+		slug += fmt.Sprintf("/before-%d", q.Until.Unix())
+	}
+	news := make([]NewsResult, q.MaxItems)
+	step := window / time.Duration(max(q.MaxItems, 1))
+	for i := range news {
+		news[i] = NewsResult{
+			Title: fmt.Sprintf("%s %s on %s", fakeAdjectives[rng.IntN(len(fakeAdjectives))],
+				fakeNouns[rng.IntN(len(fakeNouns))], q.Query),
+			URL:         fmt.Sprintf("https://news.example.com/%s/%d", slug, i+1),
+			Source:      fakeProviderName,
+			PublishedAt: end.Add(-step*time.Duration(i) - time.Duration(rng.Int64N(int64(step)))),
+			Description: fmt.Sprintf("Synthetic article %d about %s.", i+1, q.Query),
+			Author:      "Fake Provider",
+			SourceName:  fakeSources[rng.IntN(len(fakeSources))],
+		}
+	}
+	return news, nil
+}
@@ -0,0 +1,138 @@
+// fake_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// resetFakeCalls starts the fake providers' call count over, since
+// FailAfter counts across them all.
+func resetFakeCalls(t testing.TB) {
+	fakeCalls.Store(0)
+	t.Cleanup(func() { fakeCalls.Store(0) })
+}
+
+func TestFakeIsDeterministic(t *testing.T) {
+	q := testQuery("golang")
+	a, err := FakeProvider{}.Fetch(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := FakeProvider{}.Fetch(context.Background(), q)
+	if len(a) != q.MaxItems || !reflect.DeepEqual(a, b) {
+		t.Errorf("two fetches of the same topic differ:\n%v\n%v", a, b)
+	}
+	start := q.windowStart()
+	for _, r := range a {
+		if r.PublishedAt.Before(start) || r.PublishedAt.After(time.Now()) || r.Source != fakeProviderName {
+			t.Errorf("%s published %s, outside the window from %s", r.URL, r.PublishedAt, start)
+		}
+	}
+	other, _ := FakeProvider{}.Fetch(context.Background(), testQuery("rust"))
+	if other[0].URL == a[0].URL {
+		t.Error("different topics got the same articles")
+	}
+}
+
+func TestFakeFailAfter(t *testing.T) {
+	resetFakeCalls(t)
+	fake := FakeProvider{FailAfter: 2}
+	for i := 1; i <= 4; i++ {
+		_, err := fake.Fetch(context.Background(), testQuery("golang"))
+		var perr *ProviderError
+		switch {
+		case i <= 2 && err != nil:
+			t.Errorf("call %d: %v", i, err)
+		case i > 2 && (!errors.As(err, &perr) || perr.StatusCode != http.StatusServiceUnavailable):
+			t.Errorf("call %d: got %v, want a 503", i, err)
+		}
+	}
+}
+
+func TestFakeLatencyHonoursDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := FakeProvider{Latency: time.Minute}.Fetch(ctx, testQuery("golang"))
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Errorf("got %v after %s, want the deadline at once", err, time.Since(start))
+	}
+	start = time.Now()
+	if _, err := (FakeProvider{Latency: 100 * time.Millisecond}).Fetch(context.Background(), testQuery("golang")); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took < 100*time.Millisecond {
+		t.Errorf("a fetch with 100ms of latency took %s", took)
+	}
+}
+
+func TestFakeFailuresFallBack(t *testing.T) {
+	resetFakeCalls(t)
+	db := openTestDB(t)
+	tasks := startTestPool(t, db, ProviderChain{Providers: []Provider{FakeProvider{FailAfter: 1}, &stubProvider{news: stubArticles(5)}}}, 1)
+
+	// the first call succeeds and caches the topic
+	q := testQuery("golang")
+	r := submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second)
+	if r.Err != nil || r.Source != "API (fake)" || r.Results[0].Source != fakeProviderName {
+		t.Fatalf("first fetch: got %+v", r)
+	}
+	// the next falls through to the chain's next provider
+	r = submitTask(context.Background(), tasks, testQuery("rust"), nextTaskSeq(), 5*time.Second)
+	if r.Err != nil || len(r.Results) == 0 || r.Results[0].Source != "stub" {
+		t.Fatalf("after the injected failure: got %+v, want the stub's results", r)
+	}
+}
+
+func TestFakeFailuresServedFromCache(t *testing.T) {
+	resetFakeCalls(t)
+	db := openTestDB(t)
+	tasks := startTestPool(t, db, FakeProvider{FailAfter: 1}, 1)
+	q := testQuery("golang")
+	if r := submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	// a refresh fails, and falls back to the rows the first call cached
+	q.Refresh = true
+	r := submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second)
+	if r.Err != nil || r.Source != "DB" || len(r.Results) != q.MaxItems {
+		t.Fatalf("refresh after the injected failure: got %d results from %q, error %v; want the cached %d",
+			len(r.Results), r.Source, r.Err, q.MaxItems)
+	}
+}
+
+func TestFakeDeltaFetch(t *testing.T) {
+	db := openTestDB(t)
+	tasks := startTestPool(t, db, FakeProvider{}, 1)
+	days5, week := testQuery("golang"), testQuery("golang")
+	days5.Days = 5
+	first := submitTask(context.Background(), tasks, days5, nextTaskSeq(), 5*time.Second)
+	if first.Err != nil {
+		t.Fatal(first.Err)
+	}
+	oldest := first.Results[len(first.Results)-1].PublishedAt
+
+	// the week's fetch asks only for the days before the cached articles
+	if r := submitTask(context.Background(), tasks, week, nextTaskSeq(), 5*time.Second); r.Err != nil || r.Source != "API" {
+		t.Fatalf("got %q, %v; want a fetch", r.Source, r.Err)
+	}
+	var delta []CachedSearch
+	db.Where("url LIKE ?", "%/before-%").Find(&delta)
+	if len(delta) != week.MaxItems {
+		t.Fatalf("%d articles from the delta fetch, want %d", len(delta), week.MaxItems)
+	}
+	start := week.windowStart()
+	for _, c := range delta {
+		if c.PublishedAt.Before(start) || !c.PublishedAt.Before(oldest) {
+			t.Errorf("%s published %s, outside %s to %s", c.URL, c.PublishedAt, start, oldest)
+		}
+	}
+	if n := countRows(t, db, week); n != 2*int64(week.MaxItems) {
+		t.Errorf("%d rows cached for the week, want %d", n, 2*week.MaxItems)
+	}
+}
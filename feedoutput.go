@@ -0,0 +1,259 @@
+// feedoutput.go
+package main
+
+import (
+	"cmp"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// -------- RSS/Atom feed output --------
+
+// feedExt is the extension of feed files in both formats.
+const feedExt = "xml"
+
+// feedItems is how many items a feed file keeps, newest first, as results
+// of later runs are merged into it; set from -feed-items.
+var feedItems = 50
+
+// feedItem is an entry of a feed file in either format.
+type feedItem struct {
+	GUID        string
+	Title       string
+	Link        string
+	Description string
+	Published   time.Time
+}
+
+// feedGUID is the stable id of the item for link, so a reader recognises
+// an article it has seen in an earlier run.
+func feedGUID(link string) string {
+	sum := sha1.Sum([]byte(link))
+	return "urn:sha1:" + hex.EncodeToString(sum[:])
+}
+
+// feedFileName is the file name of q's feed, named after topicSlug so the
+// same topic finds its feed again on the next run.
+func feedFileName(q NewsQuery, ext string) string {
+	return topicSlug(q) + "." + ext
+}
+
+// topicSlug is q's user, topic and scope folded into lower-case words
+// joined by hyphens, safe as a file or directory name anywhere: "c/c++"
+// is "c-c" and "what's new?" is "what-s-new". Letters of any script are
+// kept.
+func topicSlug(q NewsQuery) string {
+	label := topicLabel(q)
+	if q.UserID != "" {
+		label = q.UserID + " " + label
+	}
+	words := strings.FieldsFunc(strings.ToLower(label), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	name := strings.Join(words, "-")
+	if len(name) > 100 {
+		cut := 100
+		for !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = strings.TrimRight(name[:cut], "-")
+	}
+	return cmp.Or(name, "topic")
+}
+
+// writeFeedFiles writes a feed per topic of out, format "rss" or "atom",
+// into the output directory, merging each with the topic's feed from
+// earlier runs. Topics that failed leave their feed as it is. It returns
+// the paths written.
+func writeFeedFiles(cfg RunConfig, out fileOutput) ([]string, error) {
+	dir, _ := expandTemplate(cfg.OutputDir, templateVars{out.input.path, out.started, feedExt})
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("creating the output directory: %w", err)
+	}
+	var paths []string
+	var errs []error
+	for i, q := range out.input.topics {
+		r := out.results[i]
+		if r.Err != nil {
+			continue
+		}
+		path := filepath.Join(dir, feedFileName(q, feedExt))
+		items, err := readFeedFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: keeping the old feed: %w", path, err))
+			continue
+		}
+		items = mergeFeedItems(items, r.Results, out.started)
+		title := "News: " + topicLabel(q)
+		err = writeFileAtomic(path, func(w io.Writer) error {
+			if cfg.Format == "atom" {
+				return writeAtomFeed(w, title, feedGUID(filepath.Base(path)), items, out.started)
+			}
+			return writeRSSFeed(w, title, items, out.started)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("writing %s: %w", path, err))
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths, errors.Join(errs...)
+}
+
+// mergeFeedItems adds results to the items of the feed's earlier runs,
+// replacing items with the same GUID, and keeps the newest feedItems.
+// Results without a date are dated now.
+func mergeFeedItems(items []feedItem, results []NewsResult, now time.Time) []feedItem {
+	byGUID := map[string]int{}
+	for i, it := range items {
+		byGUID[it.GUID] = i
+	}
+	for _, a := range results {
+		source, description := cmp.Or(a.SourceName, a.Source), a.Description
+		switch {
+		case source != "" && description != "":
+			description = source + " — " + description
+		case source != "":
+			description = source
+		}
+		it := feedItem{GUID: feedGUID(a.URL), Title: a.Title, Link: a.URL, Description: description,
+			Published: cmp.Or(a.PublishedAt, now)}
+		if i, ok := byGUID[it.GUID]; ok {
+			items[i] = it
+			continue
+		}
+		byGUID[it.GUID] = len(items)
+		items = append(items, it)
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Published.After(items[j].Published) })
+	return items[:min(len(items), feedItems)]
+}
+
+type rssOutput struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel struct {
+		Title         string          `xml:"title"`
+		Link          string          `xml:"link"`
+		Description   string          `xml:"description"`
+		LastBuildDate string          `xml:"lastBuildDate"`
+		Items         []rssOutputItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type rssOutputItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description,omitempty"`
+	PubDate     string `xml:"pubDate"`
+	GUID        struct {
+		IsPermaLink string `xml:"isPermaLink,attr"`
+		Value       string `xml:",chardata"`
+	} `xml:"guid"`
+}
+
+type atomOutput struct {
+	XMLName xml.Name          `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string            `xml:"title"`
+	ID      string            `xml:"id"`
+	Updated string            `xml:"updated"`
+	Entries []atomOutputEntry `xml:"entry"`
+}
+
+type atomOutputEntry struct {
+	Title string `xml:"title"`
+	ID    string `xml:"id"`
+	Link  struct {
+		Href string `xml:"href,attr"`
+	} `xml:"link"`
+	Updated   string `xml:"updated"`
+	Published string `xml:"published"`
+	Summary   string `xml:"summary,omitempty"`
+}
+
+// writeRSSFeed writes items as an RSS 2.0 channel. The channel links to
+// its newest article, there being no page for the topic itself.
+func writeRSSFeed(w io.Writer, title string, items []feedItem, now time.Time) error {
+	var doc rssOutput
+	doc.Version = "2.0"
+	doc.Channel.Title, doc.Channel.Description = title, title
+	doc.Channel.LastBuildDate = now.Format(time.RFC1123Z)
+	for _, it := range items {
+		var item rssOutputItem
+		item.Title, item.Link, item.Description = it.Title, it.Link, it.Description
+		item.PubDate = it.Published.Format(time.RFC1123Z)
+		item.GUID.IsPermaLink, item.GUID.Value = "false", it.GUID
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+	if len(items) > 0 {
+		doc.Channel.Link = items[0].Link
+	}
+	return writeXML(w, doc)
+}
+
+// writeAtomFeed writes items as an Atom feed with the given id.
+func writeAtomFeed(w io.Writer, title, id string, items []feedItem, now time.Time) error {
+	doc := atomOutput{Title: title, ID: id, Updated: now.Format(time.RFC3339)}
+	for _, it := range items {
+		e := atomOutputEntry{Title: it.Title, ID: it.GUID, Summary: it.Description,
+			Published: it.Published.Format(time.RFC3339), Updated: it.Published.Format(time.RFC3339)}
+		e.Link.Href = it.Link
+		doc.Entries = append(doc.Entries, e)
+	}
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, doc any) error {
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// readFeedFile reads the items of a feed file written by an earlier run,
+// in either format; a missing file has none.
+func readFeedFile(path string) ([]feedItem, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Channel struct {
+			Items []rssOutputItem `xml:"item"`
+		} `xml:"channel"`
+		Entries []atomOutputEntry `xml:"entry"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("malformed feed: %w", err)
+	}
+	var items []feedItem
+	for _, it := range doc.Channel.Items {
+		published, _ := time.Parse(time.RFC1123Z, it.PubDate)
+		items = append(items, feedItem{GUID: cmp.Or(it.GUID.Value, feedGUID(it.Link)), Title: it.Title, Link: it.Link,
+			Description: it.Description, Published: published})
+	}
+	for _, e := range doc.Entries {
+		published, _ := time.Parse(time.RFC3339, cmp.Or(e.Published, e.Updated))
+		items = append(items, feedItem{GUID: cmp.Or(e.ID, feedGUID(e.Link.Href)), Title: e.Title, Link: e.Link.Href,
+			Description: e.Summary, Published: published})
+	}
+	return items, nil
+}
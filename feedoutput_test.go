@@ -0,0 +1,184 @@
+// feedoutput_test.go
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// rssDoc is the RSS 2.0 structure a feed reader expects, declared apart
+// from rssOutput so the test doesn't just read back what was written.
+type rssDoc struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel struct {
+		Title         string `xml:"title"`
+		Link          string `xml:"link"`
+		Description   string `xml:"description"`
+		LastBuildDate string `xml:"lastBuildDate"`
+		Items         []struct {
+			Title       string `xml:"title"`
+			Link        string `xml:"link"`
+			Description string `xml:"description"`
+			PubDate     string `xml:"pubDate"`
+			GUID        struct {
+				IsPermaLink string `xml:"isPermaLink,attr"`
+				Value       string `xml:",chardata"`
+			} `xml:"guid"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+// writeFeeds writes out's feeds in format to dir and returns their paths.
+func writeFeeds(t *testing.T, dir, format string, out fileOutput) []string {
+	t.Helper()
+	paths, err := writeFeedFiles(RunConfig{OutputDir: dir, Format: format}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func readRSS(t *testing.T, path string) rssDoc {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc rssDoc
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("%s isn't well-formed: %v", path, err)
+	}
+	return doc
+}
+
+// rssLinks lists the links of doc's items, checking each is a valid item.
+func rssLinks(t *testing.T, doc rssDoc) []string {
+	t.Helper()
+	var links []string
+	for _, it := range doc.Channel.Items {
+		if it.Title == "" || it.Link == "" {
+			t.Errorf("item %+v lacks a title or link", it)
+		}
+		if _, err := time.Parse(time.RFC1123Z, it.PubDate); err != nil {
+			t.Errorf("item %s: bad pubDate: %v", it.Link, err)
+		}
+		if it.GUID.Value != feedGUID(it.Link) || it.GUID.IsPermaLink != "false" {
+			t.Errorf("item %s: got guid %+v, want %s", it.Link, it.GUID, feedGUID(it.Link))
+		}
+		links = append(links, it.Link)
+	}
+	return links
+}
+
+func TestRSSFeed(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeFeeds(t, dir, "rss", sampleOutput())
+	// zig failed, so it has no feed
+	want := []string{filepath.Join(dir, "golang.xml"), filepath.Join(dir, "rust.xml")}
+	if !slices.Equal(paths, want) {
+		t.Fatalf("wrote %v, want %v", paths, want)
+	}
+
+	doc := readRSS(t, paths[0])
+	if doc.Version != "2.0" || doc.Channel.Title != `News: "golang"` || doc.Channel.Description == "" {
+		t.Errorf("got channel %q version %q", doc.Channel.Title, doc.Version)
+	}
+	if got, err := time.Parse(time.RFC1123Z, doc.Channel.LastBuildDate); err != nil || !got.Equal(sampleOutput().started) {
+		t.Errorf("got lastBuildDate %q, error %v", doc.Channel.LastBuildDate, err)
+	}
+	links := rssLinks(t, doc)
+	if want := []string{"https://go.dev/blog/go1.23", "https://example.com/range-func"}; !slices.Equal(links, want) {
+		t.Errorf("got items %v, want %v", links, want)
+	}
+	if doc.Channel.Link != links[0] {
+		t.Errorf("channel links to %q, want its newest item", doc.Channel.Link)
+	}
+	for i, want := range []string{"The Go Blog", "newsapi — Iterators, at last."} {
+		if got := doc.Channel.Items[i].Description; got != want {
+			t.Errorf("item %d: got description %q, want %q", i, got, want)
+		}
+	}
+	if n := len(readRSS(t, paths[1]).Channel.Items); n != 0 {
+		t.Errorf("rust's feed has %d items, want none", n)
+	}
+}
+
+func TestFeedMergesEarlierRuns(t *testing.T) {
+	keep(t, &feedItems)
+	feedItems = 3
+	dir := t.TempDir()
+	writeFeeds(t, dir, "rss", sampleOutput())
+
+	// the next run finds one article again, retitled, and two new ones
+	out := sampleOutput()
+	out.started = out.started.Add(time.Hour)
+	out.results[0].Results = []NewsResult{
+		{Title: "Go 1.23 is out", URL: "https://go.dev/blog/go1.23", PublishedAt: out.started.Add(-3 * time.Hour)},
+		{Title: "Newest", URL: "https://example.com/newest", PublishedAt: out.started.Add(-time.Minute)},
+		{Title: "Undated", URL: "https://example.com/undated"},
+	}
+	path := writeFeeds(t, dir, "rss", out)[0]
+	doc := readRSS(t, path)
+	links := rssLinks(t, doc)
+	// newest first: the undated one counts as published now, and the
+	// oldest no longer fits
+	want := []string{"https://example.com/undated", "https://example.com/newest", "https://go.dev/blog/go1.23"}
+	if !slices.Equal(links, want) {
+		t.Errorf("got items %v, want %v", links, want)
+	}
+	if got := doc.Channel.Items[2].Title; got != "Go 1.23 is out" {
+		t.Errorf("the repeated article is titled %q, want the newer title", got)
+	}
+}
+
+func TestAtomFeed(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFeeds(t, dir, "atom", sampleOutput())[0]
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+		Title   string   `xml:"title"`
+		ID      string   `xml:"id"`
+		Updated string   `xml:"updated"`
+		Entries []struct {
+			Title string `xml:"title"`
+			ID    string `xml:"id"`
+			Link  struct {
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+			Updated string `xml:"updated"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("%s isn't an Atom feed: %v", path, err)
+	}
+	if doc.Title != `News: "golang"` || doc.ID != feedGUID("golang.xml") || len(doc.Entries) != 2 {
+		t.Fatalf("got feed %q, id %q, %d entries", doc.Title, doc.ID, len(doc.Entries))
+	}
+	for _, e := range doc.Entries {
+		if _, err := time.Parse(time.RFC3339, e.Updated); err != nil || e.ID != feedGUID(e.Link.Href) || e.Title == "" {
+			t.Errorf("bad entry %+v", e)
+		}
+	}
+
+	// and it is read back to merge the next run into
+	items, err := readFeedFile(path)
+	if err != nil || len(items) != 2 || items[0].Link != "https://go.dev/blog/go1.23" {
+		t.Errorf("read back %+v, error %v", items, err)
+	}
+}
+
+func TestFeedGUIDIsStable(t *testing.T) {
+	a, b := feedGUID("https://example.com/a"), feedGUID("https://example.com/a")
+	if a != b || a == feedGUID("https://example.com/b") {
+		t.Errorf("got GUIDs %s and %s", a, b)
+	}
+}
@@ -0,0 +1,99 @@
+// fixtures_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// topicNews answers NewsAPI requests with three articles about the topic
+// asked for, published in the hours before published.
+func topicNews(published time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		var arts []string
+		for i := 1; i <= 3; i++ {
+			arts = append(arts, fmt.Sprintf(`{"source":{"name":"Wire"},"title":"%s story %d","url":"https://example.com/%s/%d","publishedAt":%q}`,
+				q, i, q, i, published.Add(-time.Duration(i)*time.Hour).UTC().Format(time.RFC3339)))
+		}
+		fmt.Fprintf(w, `{"status":"ok","totalResults":3,"articles":[%s]}`, strings.Join(arts, ","))
+	}
+}
+
+// runTestPass runs a pass over topics with NewsAPI on a cache of its own
+// and returns its results file.
+func runTestPass(t *testing.T, topics ...string) string {
+	t.Helper()
+	db := openTestDB(t)
+	tasks, stop := (&FetchFlags{Workers: 2, QueueSize: 10}).startPool(db, NewsAPIProvider{})
+	defer stop()
+	var queries []NewsQuery
+	for _, topic := range topics {
+		queries = append(queries, testQuery(topic))
+	}
+	var out bytes.Buffer
+	runPass(context.Background(), db, tasks, "fixtures.txt", queries, 5*time.Second, &out)
+	return out.String()
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	useNewsAPIKeys(t, "test-key")
+	srv := stubAPI(t, topicNews(time.Now().Truncate(time.Hour)))
+	// record what the stub server answers
+	httpClient.Transport = recordTransport{base: httpClient.Transport, dir: dir}
+	recorded := runTestPass(t, "golang", "rust")
+	if !strings.Contains(recorded, "golang story 1") || !strings.Contains(recorded, "rust story 3") {
+		t.Fatalf("recording run wrote:\n%s", recorded)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 2 {
+		t.Fatalf("recorded %d fixtures, want 2", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	if strings.Contains(string(data), "test-key") {
+		t.Error("a fixture holds the API key")
+	}
+
+	// a replay doesn't touch the network, and writes the same results
+	srv.Close()
+	replay, err := newHTTPClient(HTTPConfig{Timeout: 5 * time.Second, ReplayDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpClient = replay
+	for i := range 2 {
+		if got := runTestPass(t, "golang", "rust"); got != recorded {
+			t.Errorf("replay %d wrote:\n%s\nwant:\n%s", i+1, got, recorded)
+		}
+	}
+
+	_, err = fetchNewsAPI(context.Background(), testQuery("haskell"))
+	var missing *FixtureMissingError
+	if !errors.As(err, &missing) || missing.Query != "haskell" {
+		t.Errorf("unrecorded topic: got %v, want a FixtureMissingError for it", err)
+	}
+}
+
+func TestFixtureKeyIgnoresDates(t *testing.T) {
+	a, _ := http.NewRequest(http.MethodGet, "https://newsapi.org/v2/everything?q=go&from=2024-05-01&pageSize=5&apiKey=one", nil)
+	b, _ := http.NewRequest(http.MethodGet, "https://newsapi.org/v2/everything?pageSize=5&from=2024-05-09&q=go&apiKey=two", nil)
+	c, _ := http.NewRequest(http.MethodGet, "https://newsapi.org/v2/everything?q=rust&from=2024-05-01&pageSize=5", nil)
+	ka, _ := fixtureKey(a)
+	kb, _ := fixtureKey(b)
+	kc, _ := fixtureKey(c)
+	if ka != kb {
+		t.Error("requests differing only in dates and keys have different fixtures")
+	}
+	if ka == kc {
+		t.Error("requests for different topics share a fixture")
+	}
+}
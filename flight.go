@@ -0,0 +1,90 @@
+// flight.go
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// -------- Shared in-flight fetches --------
+
+// flightGroup lets tasks for the same topic that are processed at the same
+// time share one call: the first starts it, the others wait for its
+// result. A topic fifty users subscribe to, all missing the cache at once,
+// makes one provider request and one cache write rather than fifty.
+//
+// The call runs on a context of its own, with the values of the first
+// task's, so its API calls are counted to that task. It is canceled only
+// once every task waiting for it has given up; a task canceled earlier
+// returns its own error and leaves the call to the rest.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done    chan struct{}
+	result  TaskResult
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// inFlight is the group the workers fetch through.
+var inFlight = &flightGroup{calls: make(map[string]*flight)}
+
+// flightKey is what tasks sharing a call have in common: every parameter
+// that selects the results, but not the user.
+func flightKey(q NewsQuery) string {
+	key := q.resultsKey()
+	if q.Refresh {
+		key += "|refresh"
+	}
+	return key
+}
+
+// do returns the result of fn for q, calling it unless a call for the same
+// topic is in flight already. shared reports whether the result is that
+// of a call another task started.
+func (g *flightGroup) do(ctx context.Context, q NewsQuery, fn func(ctx context.Context) TaskResult) (result TaskResult, shared bool) {
+	key := flightKey(q)
+	g.mu.Lock()
+	f, shared := g.calls[key]
+	if !shared {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = f
+		go func() {
+			defer func() {
+				g.mu.Lock()
+				if g.calls[key] == f {
+					delete(g.calls, key)
+				}
+				g.mu.Unlock()
+				cancel()
+				close(f.done)
+			}()
+			// a panic in fn fails the tasks waiting for it, not the process
+			defer recoverTask(q, &f.result)
+			f.result = fn(callCtx)
+		}()
+	}
+	f.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.result, shared
+	case <-ctx.Done():
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f.waiters--; f.waiters == 0 {
+		// nobody wants the result any more; a task arriving now starts
+		// a call of its own
+		f.cancel()
+		if g.calls[key] == f {
+			delete(g.calls, key)
+		}
+	}
+	return TaskResult{Err: ctx.Err()}, shared
+}
@@ -0,0 +1,118 @@
+// gnews.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -------- GNews provider --------
+const gnewsProviderName = "gnews"
+
+// gnewsMaxPageSize is the largest max value the search endpoint accepts.
+const gnewsMaxPageSize = 100
+
+// GNewsProvider searches gnews.io using the GNEWS_KEY environment variable.
+type GNewsProvider struct{}
+
+func (GNewsProvider) Name() string { return gnewsProviderName }
+
+func (GNewsProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	return fetchGNews(ctx, q)
+}
+
+type GNewsResponse struct {
+	TotalArticles int `json:"totalArticles"`
+	Articles      []struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		URL         string `json:"url"`
+		PublishedAt string `json:"publishedAt"`
+		Source      struct {
+			Name string `json:"name"`
+		} `json:"source"`
+	} `json:"articles"`
+	// Errors is a list of messages on failure.
+	Errors []string `json:"errors"`
+}
+
+func fetchGNews(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	apiKey := os.Getenv("GNEWS_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("GNEWS_KEY not set")
+	}
+	params := url.Values{}
+	params.Set("q", q.Query)
+	endpoint := "search"
+	if q.endpoint() == EndpointTopHeadlines {
+		endpoint = "top-headlines"
+		if q.Country != "" {
+			params.Set("country", q.Country)
+		}
+		if q.Category != "" {
+			params.Set("category", q.Category)
+		}
+	} else {
+		params.Set("from", q.windowMidnight().UTC().Format(time.RFC3339))
+		if until := q.fetchUntil(); !until.IsZero() {
+			params.Set("to", until.UTC().Format(time.RFC3339))
+		}
+		if q.SortBy == "publishedAt" {
+			params.Set("sortby", "publishedAt")
+		} else {
+			params.Set("sortby", "relevance")
+		}
+	}
+	if q.Language != "" {
+		params.Set("lang", q.Language)
+	}
+	params.Set("max", strconv.Itoa(min(q.MaxItems, gnewsMaxPageSize)))
+	params.Set("apikey", apiKey)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://gnews.io/api/v4/"+endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doWithRetry(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result GNewsResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK || len(result.Errors) > 0 {
+		msg := strings.Join(result.Errors, "; ")
+		if decodeErr != nil || msg == "" {
+			msg = http.StatusText(resp.StatusCode)
+		}
+		return nil, &ProviderError{Provider: gnewsProviderName, StatusCode: resp.StatusCode, Message: msg,
+			RetryAt: rateLimitRetryAt(resp.Header, time.Now())}
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+
+	news := []NewsResult{}
+	for _, a := range result.Articles {
+		news = append(news, NewsResult{
+			Title:       a.Title,
+			URL:         a.URL,
+			Source:      gnewsProviderName,
+			PublishedAt: parsePublishedAt(a.PublishedAt),
+			Description: a.Description,
+			SourceName:  a.Source.Name,
+		})
+		if len(news) >= q.MaxItems {
+			break
+		}
+	}
+	return news, nil
+}
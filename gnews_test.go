@@ -0,0 +1,41 @@
+// gnews_test.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGNewsFromIsLocalMidnight(t *testing.T) {
+	// east of UTC, the local midnight is the evening before in UTC
+	keep(t, &time.Local)
+	time.Local = time.FixedZone("UTC+9", 9*60*60)
+	t.Setenv("GNEWS_KEY", "test-key")
+	var from string
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		from = r.URL.Query().Get("from")
+		fmt.Fprint(w, `{"totalArticles":0,"articles":[]}`)
+	})
+
+	q := testQuery("go")
+	ranged := testQuery("go")
+	ranged.Days, ranged.From = 0, time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)
+	for _, tc := range []struct {
+		name string
+		q    NewsQuery
+		want string
+	}{
+		{"days", q, q.windowStart().UTC().Format(time.RFC3339)},
+		{"range", ranged, "2024-04-30T15:00:00Z"},
+	} {
+		if _, err := fetchGNews(context.Background(), tc.q); err != nil {
+			t.Fatal(err)
+		}
+		if from != tc.want {
+			t.Errorf("%s: sent from=%s, want %s", tc.name, from, tc.want)
+		}
+	}
+}
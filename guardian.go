@@ -0,0 +1,134 @@
+// guardian.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// -------- Guardian Open Platform provider --------
+const guardianProviderName = "guardian"
+
+// guardianMaxPageSize is the largest page-size the content API accepts.
+const guardianMaxPageSize = 50
+
+// GuardianProvider searches The Guardian's content API using the
+// GUARDIAN_KEY environment variable.
+type GuardianProvider struct{}
+
+func (GuardianProvider) Name() string { return guardianProviderName }
+
+func (GuardianProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	return fetchGuardian(ctx, q)
+}
+
+type GuardianResponse struct {
+	// Message is set instead of Response when the key is missing or
+	// invalid, or the rate limit is hit.
+	Message  string `json:"message"`
+	Response struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Total   int    `json:"total"`
+		Pages   int    `json:"pages"`
+		Results []struct {
+			WebTitle           string `json:"webTitle"`
+			WebURL             string `json:"webUrl"`
+			WebPublicationDate string `json:"webPublicationDate"`
+			Fields             struct {
+				TrailText string `json:"trailText"`
+				Byline    string `json:"byline"`
+			} `json:"fields"`
+		} `json:"results"`
+	} `json:"response"`
+}
+
+func fetchGuardian(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	apiKey := os.Getenv("GUARDIAN_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("GUARDIAN_KEY not set")
+	}
+	if q.endpoint() == EndpointTopHeadlines {
+		return nil, &ProviderError{Provider: guardianProviderName, StatusCode: http.StatusBadRequest,
+			Message: "top-headlines queries are not supported"}
+	}
+	params := url.Values{}
+	params.Set("q", q.Query)
+	params.Set("from-date", q.windowFrom().Format("2006-01-02"))
+	if until := q.fetchUntil(); !until.IsZero() {
+		// whole days only; the overlap is deduplicated when stored
+		params.Set("to-date", until.Format("2006-01-02"))
+	}
+	params.Set("show-fields", "trailText,byline")
+	if q.SortBy == "publishedAt" {
+		params.Set("order-by", "newest")
+	} else {
+		params.Set("order-by", "relevance")
+	}
+	pageSize := min(q.MaxItems, guardianMaxPageSize)
+	params.Set("page-size", strconv.Itoa(pageSize))
+	params.Set("api-key", apiKey)
+
+	news := []NewsResult{}
+	for page := 1; len(news) < q.MaxItems; page++ {
+		params.Set("page", strconv.Itoa(page))
+		result, err := fetchGuardianPage(ctx, "https://content.guardianapis.com/search?"+params.Encode())
+		if err != nil {
+			return news, err
+		}
+		for _, r := range result.Response.Results {
+			news = append(news, NewsResult{
+				Title:       r.WebTitle,
+				URL:         r.WebURL,
+				Source:      guardianProviderName,
+				PublishedAt: parsePublishedAt(r.WebPublicationDate),
+				Description: r.Fields.TrailText,
+				Author:      r.Fields.Byline,
+				SourceName:  "The Guardian",
+			})
+			if len(news) >= q.MaxItems {
+				break
+			}
+		}
+		if page >= result.Response.Pages {
+			break
+		}
+	}
+	return news, nil
+}
+
+func fetchGuardianPage(ctx context.Context, reqURL string) (GuardianResponse, error) {
+	var result GuardianResponse
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return result, err
+	}
+	resp, err := doWithRetry(ctx, req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK || result.Response.Status == "error" {
+		msg := result.Response.Message
+		if msg == "" {
+			msg = result.Message
+		}
+		if decodeErr != nil || msg == "" {
+			msg = http.StatusText(resp.StatusCode)
+		}
+		return result, &ProviderError{Provider: guardianProviderName, StatusCode: resp.StatusCode, Message: msg,
+			RetryAt: rateLimitRetryAt(resp.Header, time.Now())}
+	}
+	if decodeErr != nil {
+		return result, decodeErr
+	}
+	return result, nil
+}
@@ -0,0 +1,130 @@
+// hackernews.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// -------- Hacker News (Algolia) provider --------
+const hnProviderName = "hn"
+
+// hnMaxPageSize is the largest hitsPerPage Algolia serves.
+const hnMaxPageSize = 1000
+
+// HackerNewsProvider searches stories through the public HN Algolia API,
+// which needs no key.
+type HackerNewsProvider struct{}
+
+func (HackerNewsProvider) Name() string { return hnProviderName }
+
+func (HackerNewsProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	return fetchHackerNews(ctx, q)
+}
+
+type HNResponse struct {
+	NbPages int `json:"nbPages"`
+	Hits    []struct {
+		ObjectID  string `json:"objectID"`
+		Title     string `json:"title"`
+		URL       string `json:"url"`
+		Author    string `json:"author"`
+		CreatedAt string `json:"created_at"`
+		StoryText string `json:"story_text"`
+	} `json:"hits"`
+	Message string `json:"message"`
+}
+
+// hnItemURL is the discussion page for a story, used for Ask/Show HN posts
+// that have no external link.
+func hnItemURL(id string) string {
+	return "https://news.ycombinator.com/item?id=" + id
+}
+
+// fetchHackerNews returns the newest matching stories. The same link is
+// often submitted several times; only its most recent submission is kept.
+func fetchHackerNews(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	if q.endpoint() == EndpointTopHeadlines {
+		return nil, &ProviderError{Provider: hnProviderName, StatusCode: http.StatusBadRequest,
+			Message: "top-headlines queries are not supported"}
+	}
+	since := q.windowMidnight()
+	params := url.Values{}
+	params.Set("query", q.Query)
+	params.Set("tags", "story")
+	filters := "created_at_i>" + strconv.FormatInt(since.Unix(), 10)
+	if until := q.fetchUntil(); !until.IsZero() {
+		filters += ",created_at_i<" + strconv.FormatInt(until.Unix(), 10)
+	}
+	params.Set("numericFilters", filters)
+	pageSize := min(q.MaxItems, hnMaxPageSize)
+	params.Set("hitsPerPage", strconv.Itoa(pageSize))
+
+	news := []NewsResult{}
+	seen := map[string]bool{}
+	for page := 0; len(news) < q.MaxItems; page++ {
+		params.Set("page", strconv.Itoa(page))
+		result, err := fetchHackerNewsPage(ctx, "https://hn.algolia.com/api/v1/search_by_date?"+params.Encode())
+		if err != nil {
+			return news, err
+		}
+		for _, h := range result.Hits {
+			link := h.URL
+			if link == "" {
+				link = hnItemURL(h.ObjectID)
+			}
+			if h.Title == "" || seen[link] {
+				continue
+			}
+			seen[link] = true
+			news = append(news, NewsResult{
+				Title:       h.Title,
+				URL:         link,
+				Source:      hnProviderName,
+				PublishedAt: parsePublishedAt(h.CreatedAt),
+				Description: h.StoryText,
+				Author:      h.Author,
+				SourceName:  "Hacker News",
+			})
+			if len(news) >= q.MaxItems {
+				break
+			}
+		}
+		if len(result.Hits) < pageSize || page+1 >= result.NbPages {
+			break
+		}
+	}
+	return news, nil
+}
+
+func fetchHackerNewsPage(ctx context.Context, reqURL string) (HNResponse, error) {
+	var result HNResponse
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return result, err
+	}
+	resp, err := doWithRetry(ctx, req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK {
+		msg := result.Message
+		if msg == "" {
+			msg = http.StatusText(resp.StatusCode)
+		}
+		return result, &ProviderError{Provider: hnProviderName, StatusCode: resp.StatusCode, Message: msg,
+			RetryAt: rateLimitRetryAt(resp.Header, time.Now())}
+	}
+	if decodeErr != nil {
+		return result, fmt.Errorf("%s: %w", hnProviderName, decodeErr)
+	}
+	return result, nil
+}
@@ -0,0 +1,148 @@
+// htmlreport.go
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// -------- HTML report --------
+
+// htmlTopic is one topic's section of the report. Anchor is its id in the
+// page, for the table of contents.
+type htmlTopic struct {
+	Anchor  string
+	Label   string
+	Source  string
+	Badge   string
+	Error   string
+	Results []NewsResult
+}
+
+// htmlReport is what htmlReportTemplate renders.
+type htmlReport struct {
+	Input   string
+	Started time.Time
+	Topics  []htmlTopic
+	Invalid []*InputError
+	Summary string
+	Timing  string
+	Note    string
+}
+
+// writeHTMLOutput writes the html format: a single page with no external
+// assets, a table of contents, a sortable table per topic and the run's
+// stats at the bottom. html/template escapes everything taken from the
+// input or the providers.
+func writeHTMLOutput(w io.Writer, out fileOutput) error {
+	report := htmlReport{Input: filepath.Base(out.input.path), Started: out.started, Invalid: out.input.invalid,
+		Summary: out.stats.summary(), Timing: out.stats.timing(), Note: out.stats.budgetNote()}
+	for i, q := range out.input.topics {
+		r := out.results[i]
+		t := htmlTopic{Anchor: fmt.Sprintf("topic-%d", i+1), Label: topicSummary(q), Results: r.Results}
+		if q.UserID != "" {
+			t.Label = q.UserID + ": " + t.Label
+		}
+		switch {
+		case r.Err != nil:
+			t.Error, t.Badge = r.Err.Error(), "error"
+		case fromCache(r.Source):
+			t.Source, t.Badge = r.Source, "cache"
+		default:
+			t.Source, t.Badge = r.Source, "api"
+		}
+		report.Topics = append(report.Topics, t)
+	}
+	return htmlReportTemplate.Execute(w, report)
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"datetime": func(t time.Time) string { return t.Format("2006-01-02 15:04") },
+	"iso":      func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>News for {{.Input}}, {{datetime .Started}}</title>
+<style>
+body{font:15px/1.45 system-ui,sans-serif;margin:2em auto;max-width:60em;padding:0 1em;color:#222}
+h2{margin-top:2em;font-size:1.15em}
+table{border-collapse:collapse;width:100%}
+th,td{text-align:left;padding:.3em .5em;border-bottom:1px solid #ddd;vertical-align:top}
+th{cursor:pointer;user-select:none;background:#f4f4f4}
+th[aria-sort=ascending]::after{content:" \25B2"}
+th[aria-sort=descending]::after{content:" \25BC"}
+td.when{white-space:nowrap}
+.badge{display:inline-block;font-size:.75em;padding:.1em .5em;border-radius:.8em;color:#fff;vertical-align:middle}
+.api{background:#2b7a3d}.cache{background:#336699}.error{background:#b03a2e}
+.err{border-left:4px solid #b03a2e;padding:.3em .8em;background:#fbeeed}
+footer{margin-top:3em;border-top:1px solid #ddd;padding-top:.5em;color:#555;font-size:.9em}
+</style>
+</head>
+<body>
+<h1>News for {{.Input}}, {{datetime .Started}}</h1>
+<nav><ol>
+{{- range .Topics}}
+<li><a href="#{{.Anchor}}">{{.Label}}</a> <span class="badge {{.Badge}}">{{or .Source "failed"}}</span></li>
+{{- end}}
+</ol></nav>
+{{range .Topics}}
+<section id="{{.Anchor}}">
+<h2>{{.Label}} <span class="badge {{.Badge}}">{{or .Source "failed"}}</span></h2>
+{{- if .Error}}
+<p class="err">Error: {{.Error}}</p>
+{{- else if not .Results}}
+<p><em>No results found</em></p>
+{{- else}}
+<table>
+<thead><tr><th>Title</th><th>Source</th><th>Published</th></tr></thead>
+<tbody>
+{{- range .Results}}
+<tr><td><a href="{{.URL}}">{{.Title}}</a></td><td>{{or .SourceName .Source}}</td><td class="when" data-sort="{{if not .PublishedAt.IsZero}}{{iso .PublishedAt}}{{end}}">{{if not .PublishedAt.IsZero}}{{datetime .PublishedAt}}{{end}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+</section>
+{{- end}}
+{{- if .Invalid}}
+<section id="skipped">
+<h2>Skipped input lines</h2>
+<ul>
+{{- range .Invalid}}
+<li>{{.Where}}: {{.Err}}: <code>{{.Text}}</code></li>
+{{- end}}
+</ul>
+</section>
+{{- end}}
+<footer>
+<p>{{.Summary}}<br>{{.Timing}}{{if .Note}}<br>{{.Note}}{{end}}</p>
+<p>Run started {{datetime .Started}}.</p>
+</footer>
+<script>
+document.querySelectorAll("th").forEach(function (th) {
+  th.addEventListener("click", function () {
+    var table = th.closest("table"), col = th.cellIndex;
+    var desc = th.getAttribute("aria-sort") === "ascending";
+    table.querySelectorAll("th").forEach(function (h) { h.removeAttribute("aria-sort"); });
+    th.setAttribute("aria-sort", desc ? "descending" : "ascending");
+    var body = table.tBodies[0], rows = Array.prototype.slice.call(body.rows);
+    var key = function (row) {
+      var cell = row.cells[col];
+      return cell.dataset.sort !== undefined ? cell.dataset.sort : cell.textContent.toLowerCase();
+    };
+    rows.sort(function (a, b) {
+      var x = key(a), y = key(b);
+      return (x < y ? -1 : x > y ? 1 : 0) * (desc ? -1 : 1);
+    });
+    rows.forEach(function (row) { body.appendChild(row); });
+  });
+});
+</script>
+</body>
+</html>
+`))
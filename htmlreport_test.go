@@ -0,0 +1,97 @@
+// htmlreport_test.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestHTMLReport(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeHTMLOutput(&buf, sampleOutput()); err != nil {
+		t.Fatal(err)
+	}
+	page := buf.String()
+	for _, want := range []string{
+		`<title>News for user1, 2024-05-12 14:03</title>`,
+		`<li><a href="#topic-1">&#34;golang&#34;, 7 days, 2 items</a> <span class="badge api">API</span></li>`,
+		`<li><a href="#topic-2">&#34;rust&#34;, 3 days, 5 items</a> <span class="badge cache">DB</span></li>`,
+		`<li><a href="#topic-3">&#34;zig&#34;, 1 days, 5 items</a> <span class="badge error">failed</span></li>`,
+		`<section id="topic-1">`, `<section id="topic-2">`, `<section id="topic-3">`,
+		`<tr><td><a href="https://go.dev/blog/go1.23">Go 1.23 released</a></td><td>The Go Blog</td><td class="when" data-sort="2024-05-12T12:03:07Z">2024-05-12 12:03</td></tr>`,
+		`<p><em>No results found</em></p>`,
+		`<p class="err">Error: newsapi: rate limited</p>`,
+		`<li>line 4: days must be at least 1, got 0: <code>haskell,0,5</code></li>`,
+		`<p>3 topics: 1 from cache, 1 from API, 1 failed<br>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("report doesn't have %s", want)
+		}
+	}
+	// self-contained: no stylesheets, scripts or images fetched from
+	// elsewhere
+	for _, external := range []string{"<link", " src=", "@import", "url("} {
+		if strings.Contains(page, external) {
+			t.Errorf("report refers to an external asset: %s", external)
+		}
+	}
+}
+
+func TestHTMLReportEscapesHostileInput(t *testing.T) {
+	out := sampleOutput()
+	out.input.path = `Inputs/<b>user1</b>`
+	out.input.topics[0].Query = `"><script>alert("topic")</script>`
+	out.results[0].Results[0] = NewsResult{Title: `<script>alert("title")</script>`, URL: `javascript:alert("url")`,
+		SourceName: `<img src=x onerror=alert("source")>`}
+	out.results[0].Results[1].URL = `https://example.com/" onmouseover="alert('attr')`
+	out.results[2].Err = errors.New(`<iframe src="https://evil.example"></iframe>`)
+	out.input.invalid[0].Text = `</code><script>alert("line")</script>`
+
+	var buf bytes.Buffer
+	if err := writeHTMLOutput(&buf, out); err != nil {
+		t.Fatal(err)
+	}
+	page := buf.String()
+	if n := strings.Count(page, "<script"); n != 1 {
+		t.Errorf("got %d script tags, want only the report's own", n)
+	}
+	for _, injected := range []string{"<b>", "<img", "<iframe", `javascript:`, `" onmouseover=`} {
+		if strings.Contains(page, injected) {
+			t.Errorf("report has %s unescaped", injected)
+		}
+	}
+	for _, want := range []string{
+		`&lt;script&gt;alert(&#34;title&#34;)&lt;/script&gt;`,
+		`href="#ZgotmplZ"`,
+		`&lt;img src=x onerror=alert(&#34;source&#34;)&gt;`,
+		`&lt;iframe src=&#34;https://evil.example&#34;&gt;&lt;/iframe&gt;`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("report doesn't have %s", want)
+		}
+	}
+}
+
+func TestHTMLReportOfALargeRun(t *testing.T) {
+	const topics, perTopic = 500, 10
+	out := fileOutput{input: inputFile{path: "big"}}
+	for i := range topics {
+		out.input.topics = append(out.input.topics, NewsQuery{Query: fmt.Sprintf("topic %d", i), Days: 7, MaxItems: perTopic})
+		out.results = append(out.results, TaskResult{Source: "API", Results: stubArticles(perTopic)})
+	}
+	var buf bytes.Buffer
+	if err := writeHTMLOutput(&buf, out); err != nil {
+		t.Fatal(err)
+	}
+	// a row per result and a few lines per topic on top of a fixed
+	// overhead, nothing repeated per topic
+	if perResult := buf.Len() / (topics * perTopic); perResult > 300 {
+		t.Errorf("the report is %d bytes, %d per result", buf.Len(), perResult)
+	}
+	if n := strings.Count(buf.String(), "<style>"); n != 1 {
+		t.Errorf("got %d style sheets, want 1", n)
+	}
+}
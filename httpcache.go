@@ -0,0 +1,104 @@
+// httpcache.go
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// -------- HTTP response cache --------
+
+// httpCacheNoValidatorTTL caps how long a response without ETag or
+// Last-Modified is reused, since it can't be revalidated cheaply.
+const httpCacheNoValidatorTTL = 5 * time.Minute
+
+// HTTPCacheEntry is a stored response body keyed by request URL. Credentials
+// travel in headers, never in the URL, so keys are safe to store.
+type HTTPCacheEntry struct {
+	// URL is sized for MySQL key length limits (768 utf8mb4 characters);
+	// longer URLs aren't cached there.
+	URL          string `gorm:"primaryKey;size:768"`
+	Body         []byte
+	ETag         string `gorm:"not null;default:''"`
+	LastModified string `gorm:"not null;default:''"`
+	ExpiresAt    time.Time
+}
+
+func (e HTTPCacheEntry) hasValidators() bool {
+	return e.ETag != "" || e.LastModified != ""
+}
+
+// HTTPCache reuses responses for MaxAge and revalidates them afterwards
+// with If-None-Match/If-Modified-Since. A nil *HTTPCache caches nothing.
+type HTTPCache struct {
+	db     *gorm.DB
+	MaxAge time.Duration
+}
+
+// httpCache is the cache fetchNewsAPI consults; main sets it up when
+// -http-cache-max-age is given.
+var httpCache *HTTPCache
+
+func newHTTPCache(db *gorm.DB, maxAge time.Duration) *HTTPCache {
+	return &HTTPCache{db: db, MaxAge: maxAge}
+}
+
+func (c *HTTPCache) lookup(url string) (HTTPCacheEntry, bool) {
+	var e HTTPCacheEntry
+	if c == nil {
+		return e, false
+	}
+	ok := c.db.Where("url = ?", url).Limit(1).Find(&e).RowsAffected > 0
+	return e, ok
+}
+
+// Fresh returns the stored body for url if it may be used without asking
+// the server.
+func (c *HTTPCache) Fresh(url string) ([]byte, bool) {
+	e, ok := c.lookup(url)
+	if !ok || time.Now().After(e.ExpiresAt) {
+		return nil, false
+	}
+	return e.Body, true
+}
+
+// Prepare adds conditional headers to req from a stale entry for url and
+// returns that entry, so a 304 can be answered from it.
+func (c *HTTPCache) Prepare(req *http.Request, url string) (HTTPCacheEntry, bool) {
+	e, ok := c.lookup(url)
+	if !ok || !e.hasValidators() {
+		return e, false
+	}
+	if e.ETag != "" {
+		req.Header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		req.Header.Set("If-Modified-Since", e.LastModified)
+	}
+	return e, true
+}
+
+// Store saves body as the response for url. Responses without validators
+// expire after httpCacheNoValidatorTTL at most.
+func (c *HTTPCache) Store(url string, h http.Header, body []byte) {
+	if c == nil {
+		return
+	}
+	e := HTTPCacheEntry{URL: url, Body: body, ETag: h.Get("ETag"), LastModified: h.Get("Last-Modified")}
+	ttl := c.MaxAge
+	if !e.hasValidators() {
+		ttl = min(ttl, httpCacheNoValidatorTTL)
+	}
+	e.ExpiresAt = time.Now().Add(ttl)
+	c.db.Save(&e)
+}
+
+// Revalidated extends e after the server answered 304 Not Modified.
+func (c *HTTPCache) Revalidated(e HTTPCacheEntry, h http.Header) {
+	if etag := h.Get("ETag"); etag != "" {
+		e.ETag = etag
+	}
+	c.Store(e.URL, http.Header{"Etag": {e.ETag}, "Last-Modified": {e.LastModified}}, e.Body)
+}
@@ -0,0 +1,93 @@
+// httpclient.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// -------- HTTP client --------
+
+// defaultUserAgent identifies the tool to every provider. Reddit in
+// particular throttles generic agents much harder.
+const defaultUserAgent = "newscli/1.0 (Go_Headlines news fetcher)"
+
+// minHTTPTimeout is the shortest per-attempt timeout accepted; anything less
+// fails before most TLS handshakes complete.
+const minHTTPTimeout = time.Second
+
+// HTTPConfig holds the settings the shared client is built from.
+type HTTPConfig struct {
+	Timeout time.Duration
+	// Proxy is an explicit proxy URL; when empty HTTP_PROXY, HTTPS_PROXY and
+	// NO_PROXY are honoured.
+	Proxy     string
+	UserAgent string
+	// RecordDir saves every response there; ReplayDir serves responses
+	// from there instead of the network. See fixtures.go.
+	RecordDir string
+	ReplayDir string
+}
+
+// httpClient is shared by every provider so connections to the same API are
+// pooled across workers. Requests carry the task's context, which aborts them
+// - dial, TLS handshake and body read alike - once the topic's deadline
+// passes; Timeout only bounds a single attempt. main rebuilds it from flags.
+var httpClient, _ = newHTTPClient(HTTPConfig{Timeout: 10 * time.Second})
+
+func newHTTPClient(cfg HTTPConfig) (*http.Client, error) {
+	if cfg.Timeout < minHTTPTimeout {
+		return nil, fmt.Errorf("timeout %s is too short: use at least %s, e.g. 10s or 1m", cfg.Timeout, minHTTPTimeout)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 8 // one per worker
+	transport.TLSHandshakeTimeout = min(5*time.Second, cfg.Timeout)
+	transport.ResponseHeaderTimeout = cfg.Timeout
+	transport.Proxy = http.ProxyFromEnvironment
+	if cfg.Proxy != "" {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q: want e.g. http://proxy.example.com:8080", cfg.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	var base http.RoundTripper = transport
+	switch {
+	case cfg.RecordDir != "" && cfg.ReplayDir != "":
+		return nil, fmt.Errorf("record and replay can't be used together")
+	case cfg.RecordDir != "":
+		if err := os.MkdirAll(cfg.RecordDir, 0o755); err != nil {
+			return nil, err
+		}
+		base = recordTransport{base: transport, dir: cfg.RecordDir}
+	case cfg.ReplayDir != "":
+		if _, err := os.Stat(cfg.ReplayDir); err != nil {
+			return nil, err
+		}
+		base = replayTransport{dir: cfg.ReplayDir}
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = defaultUserAgent
+	}
+	return &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: userAgentTransport{base: base, userAgent: cfg.UserAgent},
+	}, nil
+}
+
+// userAgentTransport sets the User-Agent on requests that don't carry one.
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.base.RoundTrip(req)
+}
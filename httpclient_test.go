@@ -0,0 +1,135 @@
+// httpclient_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// slowAPI answers nothing until the request is canceled, or a minute has
+// passed.
+func slowAPI(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-r.Context().Done():
+	case <-time.After(time.Minute):
+	}
+}
+
+func TestCancelInterruptsFetch(t *testing.T) {
+	stubAPI(t, slowAPI)
+	useNewsAPIKeys(t, "test-key")
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := fetchNewsAPI(ctx, testQuery("go"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("the fetch returned %s after being canceled", took)
+	}
+}
+
+func TestDeadlineInterruptsFetch(t *testing.T) {
+	stubAPI(t, slowAPI)
+	useNewsAPIKeys(t, "test-key")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := fetchNewsAPI(ctx, testQuery("go"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("the fetch returned %s after its deadline", took)
+	}
+}
+
+func TestHTTPClientProxyAndUserAgent(t *testing.T) {
+	for _, tc := range []struct {
+		name, userAgent, want string
+	}{
+		{"default agent", "", defaultUserAgent},
+		{"custom agent", "my-bot/2.0 (+https://example.com/bot)", "my-bot/2.0 (+https://example.com/bot)"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got *http.Request
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r
+				w.Write([]byte("ok"))
+			}))
+			defer proxy.Close()
+			client, err := newHTTPClient(HTTPConfig{Timeout: 5 * time.Second, Proxy: proxy.URL, UserAgent: tc.userAgent})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Get("http://news.example.com/v2/everything?q=go")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got == nil {
+				t.Fatal("the request didn't go through the proxy")
+			}
+			// a proxy is sent the whole URL
+			if got.URL.String() != "http://news.example.com/v2/everything?q=go" || got.Host != "news.example.com" {
+				t.Errorf("the proxy got %s for host %s", got.URL, got.Host)
+			}
+			if ua := got.Header.Get("User-Agent"); ua != tc.want {
+				t.Errorf("User-Agent %q, want %q", ua, tc.want)
+			}
+		})
+	}
+}
+
+func TestHTTPClientKeepsRequestUserAgent(t *testing.T) {
+	var ua string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { ua = r.UserAgent() }))
+	defer srv.Close()
+	client, err := newHTTPClient(HTTPConfig{Timeout: 5 * time.Second, UserAgent: "configured"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("User-Agent", "provider-specific")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if ua != "provider-specific" {
+		t.Errorf("User-Agent %q, want the request's own", ua)
+	}
+}
+
+func TestHTTPClientConfigErrors(t *testing.T) {
+	for _, cfg := range []HTTPConfig{
+		{Timeout: 100 * time.Millisecond},
+		{Timeout: 5 * time.Second, Proxy: "not a url"},
+		{Timeout: 5 * time.Second, Proxy: "proxy.example.com:8080"},
+	} {
+		if _, err := newHTTPClient(cfg); err == nil {
+			t.Errorf("%+v was accepted", cfg)
+		}
+	}
+}
+
+func TestHTTPClientTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(slowAPI))
+	defer srv.Close()
+	client, err := newHTTPClient(HTTPConfig{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Fatal("a server that never answers didn't time out")
+	}
+	if took := time.Since(start); took > 3*time.Second {
+		t.Errorf("timed out after %s, want about 1s", took)
+	}
+}
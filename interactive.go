@@ -0,0 +1,241 @@
+// interactive.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// -------- Interactive prompt --------
+
+// interactiveInput is the run input recorded for topics added at the
+// prompt.
+const interactiveInput = "(interactive)"
+
+const promptHelp = `commands:
+  add <topic> [days [maxItems]]  fetch a topic now and print its results; an input line such as
+                                 add golang,7,10,lang=en works too
+  list                           show the topics of the input files and the ones added
+  run                            process the input files again
+  save                           append the topics added since the last save to the input file
+  help                           show this list
+  exit                           quit`
+
+// prompt is the state of an interactive session: the inputs of the last
+// pass and the topics added since, as input lines.
+type prompt struct {
+	ctx      context.Context
+	db       *gorm.DB
+	tasks    chan<- Task
+	cfg      RunConfig
+	defaults NewsQuery
+	w        io.Writer
+
+	inputs  []inputFile
+	added   []string
+	unsaved int
+}
+
+// runInteractive runs a pass over the input files and then reads commands
+// from stdin until exit, end of input or a signal. See promptHelp.
+func runInteractive(ctx context.Context, db *gorm.DB, tasks chan<- Task, cfg RunConfig, defaults NewsQuery) error {
+	p := &prompt{ctx: ctx, db: db, tasks: tasks, cfg: cfg, defaults: defaults, w: os.Stdout}
+	if err := p.run(); err != nil {
+		return err
+	}
+	fmt.Fprintln(p.w, "Type help for the commands.")
+	next := lineReader(ctx, os.Stdin)
+	for {
+		fmt.Fprint(p.w, "newscli> ")
+		line, err := next()
+		if err != nil {
+			fmt.Fprintln(p.w)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		name, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+		rest = strings.TrimSpace(rest)
+		switch strings.ToLower(name) {
+		case "":
+		case "add":
+			err = p.add(rest)
+		case "list":
+			p.list()
+		case "run":
+			err = p.run()
+		case "save":
+			err = p.save()
+		case "help", "?":
+			fmt.Fprintln(p.w, promptHelp)
+		case "exit", "quit":
+			if p.unsaved > 0 {
+				fmt.Fprintf(p.w, "%d added topics were not saved\n", p.unsaved)
+			}
+			fmt.Fprintln(p.w, "Exiting program")
+			return nil
+		default:
+			err = fmt.Errorf("unknown command %q; type help for the commands", name)
+		}
+		if err != nil {
+			fmt.Fprintln(p.w, "Error:", err)
+		}
+	}
+}
+
+// run processes the input files again.
+func (p *prompt) run() error {
+	inputs, _, err := runPassOver(p.ctx, p.db, p.tasks, p.cfg, p.defaults)
+	if inputs != nil {
+		p.inputs = inputs
+	}
+	return err
+}
+
+// add fetches the topic of an add command and prints its results.
+func (p *prompt) add(args string) error {
+	line, err := p.addLine(args)
+	if err != nil {
+		return err
+	}
+	q, err := parseTopicLine(line, p.defaults)
+	var warning *TopicWarning
+	if errors.As(err, &warning) {
+		fmt.Fprintln(p.w, "Warning:", warning)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(p.w)
+	stats, _ := runPass(p.ctx, p.db, p.tasks, interactiveInput, []NewsQuery{q}, p.cfg.TaskTimeout, p.w)
+	fmt.Fprintln(p.w, stats.summary())
+	p.added = append(p.added, line)
+	p.unsaved++
+	return nil
+}
+
+// addLine turns the arguments of add into an input line. Arguments with a
+// comma already are one; otherwise they are the topic's words followed by
+// up to two numbers, days and maxItems, which default to -days and -max.
+func (p *prompt) addLine(args string) (string, error) {
+	if args == "" {
+		return "", fmt.Errorf("add needs a topic, e.g. add golang 7 10")
+	}
+	if strings.Contains(args, ",") {
+		return args, nil
+	}
+	words := strings.Fields(args)
+	nums := []int{p.cfg.Days, p.cfg.MaxItems}
+	var given []int
+	for len(words) > 1 && len(given) < 2 {
+		n, err := strconv.Atoi(words[len(words)-1])
+		if err != nil {
+			break
+		}
+		given = append([]int{n}, given...)
+		words = words[:len(words)-1]
+	}
+	copy(nums, given)
+	return fmt.Sprintf("%s,%d,%d", strings.Join(words, " "), nums[0], nums[1]), nil
+}
+
+// list prints the topics of the last pass's inputs and the added ones.
+func (p *prompt) list() {
+	for _, in := range p.inputs {
+		fmt.Fprintf(p.w, "%s (%d topics):\n", in.path, len(in.topics))
+		for _, q := range in.topics {
+			fmt.Fprintln(p.w, "  "+topicSummary(q))
+		}
+	}
+	if len(p.added) > 0 {
+		fmt.Fprintf(p.w, "added (%d, %d not saved):\n", len(p.added), p.unsaved)
+		for _, line := range p.added {
+			fmt.Fprintln(p.w, "  "+line)
+		}
+	}
+}
+
+// topicSummary is the one-line form of q that list shows.
+func topicSummary(q NewsQuery) string {
+	s := topicLabel(q)
+	if q.endpoint() != EndpointTopHeadlines {
+		s += fmt.Sprintf(", %d days", q.Days)
+	}
+	s += fmt.Sprintf(", %d items", q.MaxItems)
+	if q.Provider != "" {
+		s += ", via " + q.Provider
+	}
+	return s
+}
+
+// save appends the topics added since the last save to the input file,
+// which must be a single text file.
+func (p *prompt) save() error {
+	if p.unsaved == 0 {
+		return fmt.Errorf("no topics added since the last save")
+	}
+	path, err := p.saveTarget()
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if !endsInNewline(path) {
+		fmt.Fprintln(file)
+	}
+	pending := p.added[len(p.added)-p.unsaved:]
+	for _, line := range pending {
+		fmt.Fprintln(file, line)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.w, "Saved %d topics to %s\n", len(pending), path)
+	p.unsaved = 0
+	return nil
+}
+
+// saveTarget is the file save appends to.
+func (p *prompt) saveTarget() (string, error) {
+	if p.cfg.Topics != nil {
+		return "", fmt.Errorf("the topics come from the config file %s; add them to its topics list instead", p.cfg.Inputs[0])
+	}
+	paths, _ := expandInputs(p.cfg.Inputs)
+	if len(paths) != 1 {
+		return "", fmt.Errorf("save needs a single input file, not %d", len(paths))
+	}
+	if inputFormat(paths[0], p.cfg.Read.Format) != formatText {
+		return "", fmt.Errorf("save only appends to text input files")
+	}
+	return paths[0], nil
+}
+
+// endsInNewline reports whether the file at path is empty or ends with a
+// newline, so lines appended to it start on a line of their own.
+func endsInNewline(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return true
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return true
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return true
+	}
+	return last[0] == '\n'
+}
@@ -0,0 +1,143 @@
+// jsoninput.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// -------- JSON input files --------
+
+// readJSONTopics reads a JSON input file: an array of TopicSpec objects
+// such as
+//
+//	[{"topic": "golang", "days": 7, "maxItems": 10, "language": "en", "provider": "newsapi"}]
+//
+// topic and maxItems are required, and so is days unless endpoint is
+// "top-headlines" or from gives a range of dates instead. Like a bad line
+// in a text file, an element that fails validation is returned as an
+// InputError naming its index from 0 and the offending field, and left
+// out; a file that isn't a JSON array fails as a whole. name is only used
+// in messages.
+func readJSONTopics(r io.Reader, name string, defaults NewsQuery) ([]NewsQuery, []*InputError, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var elements []json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", name, jsonFileError(data, err))
+	}
+	var topics []NewsQuery
+	var invalid []*InputError
+	for i, raw := range elements {
+		q, err := parseJSONTopic(raw, defaults)
+		var warning *TopicWarning
+		if errors.As(err, &warning) {
+			fmt.Fprintf(console, "Warning: element %d of %s: %v\n", i, name, warning)
+			err = nil
+		}
+		if err != nil {
+			var text bytes.Buffer
+			if json.Compact(&text, raw) != nil {
+				text.Write(raw)
+			}
+			invalid = append(invalid, &InputError{Where: fmt.Sprintf("element %d", i), Text: text.String(), Err: err})
+			continue
+		}
+		topics = append(topics, q)
+	}
+	return topics, invalid, nil
+}
+
+// parseJSONTopic validates one element of a JSON input file and turns it
+// into its NewsQuery. Errors name the field at fault.
+func parseJSONTopic(raw json.RawMessage, defaults NewsQuery) (NewsQuery, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+		return NewsQuery{}, fmt.Errorf("want an object, got %s", jsonKind(raw))
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var spec TopicSpec
+	if err := dec.Decode(&spec); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return NewsQuery{}, fmt.Errorf("field %q: want %s, got %s", typeErr.Field, jsonTypeName(typeErr.Type), typeErr.Value)
+		}
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return NewsQuery{}, fmt.Errorf("unknown field %s", field)
+		}
+		return NewsQuery{}, err
+	}
+
+	topHeadlines := spec.Endpoint == EndpointTopHeadlines
+	switch {
+	case fields["topic"] == nil:
+		return NewsQuery{}, fmt.Errorf("field %q: required", "topic")
+	case fields["maxItems"] == nil:
+		return NewsQuery{}, fmt.Errorf("field %q: required", "maxItems")
+	case topHeadlines && fields["days"] != nil:
+		return NewsQuery{}, fmt.Errorf("field %q: not used by the %s endpoint", "days", EndpointTopHeadlines)
+	case !topHeadlines && fields["days"] == nil && fields["from"] == nil:
+		return NewsQuery{}, fmt.Errorf("field %q: required unless endpoint is %q or from is given", "days", EndpointTopHeadlines)
+	}
+	q, err := spec.Query(defaults)
+	var specErr *SpecError
+	if errors.As(err, &specErr) {
+		return NewsQuery{}, fmt.Errorf("field %q: %v", specErr.Field, specErr.Err)
+	}
+	return q, err
+}
+
+// jsonFileError adds the line and column of a syntax error to err.
+func jsonFileError(data []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field == "" {
+			return fmt.Errorf("want an array of topics, got %s", typeErr.Value)
+		}
+		return err
+	}
+	before := data[:min(int(syntaxErr.Offset), len(data))]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Errorf("line %d, column %d: %w", line, col, err)
+}
+
+// jsonTypeName names the JSON form of a TopicSpec field type t.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int:
+		return "a whole number"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice:
+		return "a list of " + jsonTypeName(t.Elem()) + "s"
+	}
+	return t.Kind().String()
+}
+
+// jsonKind names the type of a JSON value for error messages.
+func jsonKind(raw json.RawMessage) string {
+	switch t := bytes.TrimSpace(raw); {
+	case len(t) == 0:
+		return "nothing"
+	case t[0] == '[':
+		return "array"
+	case t[0] == '"':
+		return "string"
+	case t[0] == 't' || t[0] == 'f':
+		return "bool"
+	case t[0] == 'n':
+		return "null"
+	default:
+		return "number"
+	}
+}
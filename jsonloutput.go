@@ -0,0 +1,174 @@
+// jsonloutput.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// -------- JSON Lines output --------
+
+// formatJSONL is the streaming output format.
+const formatJSONL = "jsonl"
+
+// jsonlExplode makes jsonl write a line per result instead of per topic;
+// set from -explode.
+var jsonlExplode bool
+
+// JSONLTopic is the line of a topic's answer: a JSONTopic, with its input
+// file. With -explode it is only written for topics that failed or found
+// nothing.
+type JSONLTopic struct {
+	Type          string `json:"type"`
+	SchemaVersion int    `json:"schemaVersion"`
+	Input         string `json:"input"`
+	JSONTopic
+}
+
+// JSONLResult is the line of one result with -explode. FetchedFrom is its
+// topic's source, "API" or "DB"; Source is still the provider.
+type JSONLResult struct {
+	Type          string `json:"type"`
+	SchemaVersion int    `json:"schemaVersion"`
+	Input         string `json:"input"`
+	Query         string `json:"query"`
+	FetchedFrom   string `json:"fetchedFrom"`
+	NewsResult
+}
+
+// JSONLSummary is the last line of a stream.
+type JSONLSummary struct {
+	Type          string    `json:"type"`
+	SchemaVersion int       `json:"schemaVersion"`
+	Run           JSONRun   `json:"run"`
+	Invalid       []JSONBad `json:"invalid"`
+}
+
+// jsonlLines are the lines of q's answer r.
+func jsonlLines(input string, q NewsQuery, r TaskResult) []any {
+	if !jsonlExplode || r.Err != nil || len(r.Results) == 0 {
+		return []any{JSONLTopic{"topic", jsonSchemaVersion, input, jsonTopic(q, r)}}
+	}
+	lines := make([]any, 0, len(r.Results))
+	for _, a := range r.Results {
+		lines = append(lines, JSONLResult{"result", jsonSchemaVersion, input, q.Query, r.Source, a})
+	}
+	return lines
+}
+
+func jsonlSummary(out fileOutput) JSONLSummary {
+	return JSONLSummary{"summary", jsonSchemaVersion, jsonRun(out), jsonInvalid(out.input.invalid)}
+}
+
+// writeJSONLOutput writes the jsonl format in one go, from results that
+// are all in; runInputs streams it instead with a jsonlStream.
+func writeJSONLOutput(w io.Writer, out fileOutput) error {
+	s := &jsonlStream{w: w}
+	for i, q := range out.input.topics {
+		s.write(jsonlLines(out.input.path, q, out.results[i]))
+	}
+	s.write([]any{jsonlSummary(out)})
+	return s.err
+}
+
+// jsonlStream writes lines as topics complete, safe for concurrent use.
+// Each call is a single unbuffered Write, so lines reach the file at
+// once, and an interrupted run leaves only whole lines behind. The first
+// error stops it and is kept in err.
+type jsonlStream struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+func (s *jsonlStream) write(lines []any) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	var err error
+	for _, line := range lines {
+		if err = enc.Encode(line); err != nil {
+			break
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	if err != nil {
+		s.err = err
+		return
+	}
+	_, s.err = s.w.Write(buf.Bytes())
+}
+
+// jsonlFile is an input's output file, open while its topics stream in.
+type jsonlFile struct {
+	path, latest string
+	file         *os.File
+	stream       *jsonlStream
+}
+
+// createJSONLFile creates the output file of input for the pass started
+// at start, as writeOutputFile would name it.
+func createJSONLFile(cfg RunConfig, input string, start time.Time, used map[string]bool) (*jsonlFile, error) {
+	path, latest := outputPath(cfg, input, start, used)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("creating the output directory: %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("writing output file: %w", err)
+	}
+	return &jsonlFile{path: path, latest: latest, file: file, stream: &jsonlStream{w: file}}, nil
+}
+
+// finish writes the summary line and closes f, then updates the latest
+// link as writeOutputFile does.
+func (f *jsonlFile) finish(cfg RunConfig, out fileOutput) error {
+	f.stream.write([]any{jsonlSummary(out)})
+	err := f.stream.err
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing output file: %w", err)
+	}
+	fmt.Fprintf(consoleErr, "Execution completed. Results stored in %s\n", f.path)
+	return finishOutputFile(cfg, f.path, f.latest)
+}
+
+// streamAnswered returns the fetchAll callback of the jsonl format: when
+// the task at index i of runInputs' coalesced list completes, it writes
+// the lines of every topic of inputs the task answers to the input's
+// stream in files and to stdout, either of which may be nil.
+func streamAnswered(inputs []inputFile, index map[NewsQuery]int, files []*jsonlFile, stdout *jsonlStream) func(int, TaskResult) {
+	type answer struct {
+		input int
+		q     NewsQuery
+	}
+	answers := map[int][]answer{}
+	for k, in := range inputs {
+		for _, q := range in.topics {
+			i := index[coalesceKey(q)]
+			answers[i] = append(answers[i], answer{k, q})
+		}
+	}
+	return func(i int, r TaskResult) {
+		for _, a := range answers[i] {
+			lines := jsonlLines(inputs[a.input].path, a.q, narrowResult(r, a.q))
+			if f := files[a.input]; f != nil {
+				f.stream.write(lines)
+			}
+			if stdout != nil {
+				stdout.write(lines)
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// keys.go
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// -------- NewsAPI key rotation --------
+
+// APIKeyState persists when a key hit its quota so a restart doesn't retry
+// a key that is known to be exhausted. Keys are stored as hashes only.
+type APIKeyState struct {
+	KeyHash        string `gorm:"primaryKey"`
+	ExhaustedUntil time.Time
+}
+
+// KeyRing hands out API keys round-robin, skipping keys that are cooling
+// down after a rate-limit response.
+type KeyRing struct {
+	mu        sync.Mutex
+	db        *gorm.DB
+	keys      []string
+	next      int
+	exhausted map[string]time.Time
+	cooldown  time.Duration
+}
+
+// defaultKeyCooldown is how long a rate-limited key rests, unless
+// -key-cooldown says otherwise.
+const defaultKeyCooldown = 12 * time.Hour
+
+// newsAPIKeys is the ring fetchNewsAPI draws from; main sets it up once the
+// DB is open.
+var newsAPIKeys *KeyRing
+
+// loadNewsAPIKeys collects keys from NEWSAPI_KEYS (comma-separated), the
+// file named by NEWSAPI_KEYS_FILE (one per line, # comments) and
+// NEWSAPI_KEY, dropping duplicates.
+func loadNewsAPIKeys() ([]string, error) {
+	var keys []string
+	keys = append(keys, strings.Split(os.Getenv("NEWSAPI_KEYS"), ",")...)
+	if path := os.Getenv("NEWSAPI_KEYS_FILE"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading NEWSAPI_KEYS_FILE: %w", err)
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); !strings.HasPrefix(line, "#") {
+				keys = append(keys, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading NEWSAPI_KEYS_FILE: %w", err)
+		}
+	}
+	keys = append(keys, os.Getenv("NEWSAPI_KEY"))
+
+	var out []string
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if k = strings.TrimSpace(k); k != "" && !seen[k] {
+			seen[k] = true
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newKeyRing loads the persisted exhaustion state for keys from db. With a
+// nil db, as when fetchNewsAPI runs before setup, nothing is loaded or
+// persisted.
+func newKeyRing(db *gorm.DB, keys []string, cooldown time.Duration) *KeyRing {
+	r := &KeyRing{db: db, keys: keys, exhausted: map[string]time.Time{}, cooldown: cooldown}
+	if db == nil {
+		return r
+	}
+	for _, k := range keys {
+		var state APIKeyState
+		if db.Where("key_hash = ?", hashKey(k)).Limit(1).Find(&state).RowsAffected > 0 &&
+			time.Now().Before(state.ExhaustedUntil) {
+			r.exhausted[k] = state.ExhaustedUntil
+		}
+	}
+	return r
+}
+
+// Pick returns the next usable key and charges one call to it against
+// newsAPIQuota. When every key is cooling down the error says how many there
+// are and when the first one frees up; when the rest are over today's budget
+// it wraps ErrQuotaReached, and ErrQuotaUnchecked when a call can't be
+// charged at all.
+func (r *KeyRing) Pick() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.keys) == 0 {
+		return "", fmt.Errorf("NEWSAPI_KEY not set")
+	}
+	now := time.Now()
+	var soonest time.Time
+	overBudget := 0
+	for range r.keys {
+		k := r.keys[r.next]
+		r.next = (r.next + 1) % len(r.keys)
+		until, cooling := r.exhausted[k]
+		if !cooling || now.After(until) {
+			delete(r.exhausted, k)
+			if newsAPIQuota != nil {
+				ok, err := newsAPIQuota.Take(k)
+				if err != nil {
+					return "", fmt.Errorf("newsapi: %w: %v", ErrQuotaUnchecked, err)
+				}
+				if !ok {
+					overBudget++
+					continue
+				}
+			}
+			return k, nil
+		}
+		if soonest.IsZero() || until.Before(soonest) {
+			soonest = until
+		}
+	}
+	if overBudget > 0 {
+		return "", fmt.Errorf("newsapi: %w (%d calls per key, resets at midnight %s)",
+			ErrQuotaReached, newsAPIQuota.MaxCalls, newsAPIQuota.Location)
+	}
+	return "", r.exhaustedError(soonest)
+}
+
+// exhaustedError is the error of a ring whose keys are all rate limited,
+// the first of them free again at soonest.
+func (r *KeyRing) exhaustedError(soonest time.Time) error {
+	return &NewsAPIError{
+		StatusCode: http.StatusTooManyRequests,
+		Code:       "apiKeyExhausted",
+		Message:    fmt.Sprintf("all %d API keys are rate limited", len(r.keys)),
+		RetryAt:    soonest,
+	}
+}
+
+// AllExhausted is the error Pick returns once every key is rate limited,
+// for a caller that has seen each of them refused without Pick noticing,
+// as when their cooldowns are over already.
+func (r *KeyRing) AllExhausted() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var soonest time.Time
+	for _, until := range r.exhausted {
+		if soonest.IsZero() || until.Before(soonest) {
+			soonest = until
+		}
+	}
+	return r.exhaustedError(soonest)
+}
+
+// Len is the number of keys in the ring.
+func (r *KeyRing) Len() int { return len(r.keys) }
+
+// Exhaust puts key on cooldown and records it in the DB.
+func (r *KeyRing) Exhaust(key string) {
+	until := time.Now().Add(r.cooldown)
+	r.mu.Lock()
+	r.exhausted[key] = until
+	r.mu.Unlock()
+	if r.db != nil {
+		r.db.Save(&APIKeyState{KeyHash: hashKey(key), ExhaustedUntil: until})
+	}
+}
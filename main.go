@@ -1,341 +1,2475 @@
-// main.go
-package main
-
-import (
-	"bufio"
-	"context"
-	"encoding/json"
-	"fmt"
-	"log"
-	"net/http"
-	"os"
-	"path/filepath"
-	"strconv"
-	"strings"
-	"sync"
-	"time"
-
-	"gorm.io/driver/sqlite"
-	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
-)
-
-// -------- Data structures --------
-type NewsResult struct {
-	Title  string `json:"title"`
-	URL    string `json:"url"`
-	Source string `json:"source"`
-}
-
-type CachedSearch struct {
-	ID        uint `gorm:"primaryKey"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	DeletedAt gorm.DeletedAt `gorm:"index"`
-
-	Query    string
-	Days     int
-	MaxItems int
-	Title    string
-	URL      string
-	Created  time.Time
-}
-
-type NewsAPIResponse struct {
-	Status       string `json:"status"`
-	TotalResults int    `json:"totalResults"`
-	Articles     []struct {
-		Title string `json:"title"`
-		URL   string `json:"url"`
-	} `json:"articles"`
-}
-
-// -------- Task structures --------
-type Task struct {
-	Query    string
-	Days     int
-	MaxItems int
-	Resp     chan TaskResult
-	Ctx      context.Context
-}
-
-type TaskResult struct {
-	Results []NewsResult
-	Source  string
-	Err     error
-}
-
-// -------- DB helpers --------
-func openDB(path string) (*gorm.DB, error) {
-	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Silent),
-	})
-	if err != nil {
-		return nil, err
-	}
-	if err := db.AutoMigrate(&CachedSearch{}); err != nil {
-		return nil, err
-	}
-	return db, nil
-}
-
-func fetchNewsAPI(query string, days, maxItems int) ([]NewsResult, error) {
-	apiKey := os.Getenv("NEWSAPI_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("NEWSAPI_KEY not set")
-	}
-	fromDate := time.Now().AddDate(0, 0, -days+1).Format("2006-01-02")
-	url := fmt.Sprintf("https://newsapi.org/v2/everything?q=%s&from=%s&pageSize=%d&apiKey=%s", query, fromDate, maxItems, apiKey)
-
-	client := http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var result NewsAPIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
-	}
-
-	news := []NewsResult{}
-	for _, a := range result.Articles {
-		news = append(news, NewsResult{Title: a.Title, URL: a.URL, Source: "API"})
-		if len(news) >= maxItems {
-			break
-		}
-	}
-	return news, nil
-}
-
-func getCachedResults(db *gorm.DB, query string, days, maxItems int) []NewsResult {
-	var cached []CachedSearch
-	db.Where("query = ? AND days >= ? AND max_items >= ?", query, days, maxItems).Order("created desc").Find(&cached)
-	results := []NewsResult{}
-	for _, c := range cached {
-		results = append(results, NewsResult{Title: c.Title, URL: c.URL, Source: "DB"})
-		if len(results) >= maxItems {
-			break
-		}
-	}
-	return results
-}
-
-func getMaxCachedParams(db *gorm.DB, query string) (int, int) {
-	var cached CachedSearch
-	tx := db.Where("query = ?", query).Order("days desc, max_items desc").First(&cached)
-	if tx.Error != nil || tx.RowsAffected == 0 {
-		return 0, 0
-	}
-	return cached.Days, cached.MaxItems
-}
-
-func storeFetched(db *gorm.DB, query string, days, maxItems int, results []NewsResult) {
-	for _, r := range results {
-		db.Create(&CachedSearch{
-			Query:    query,
-			Days:     days,
-			MaxItems: maxItems,
-			Title:    r.Title,
-			URL:      r.URL,
-			Created:  time.Now(),
-		})
-	}
-}
-
-// -------- Worker pool --------
-func startWorkerPool(db *gorm.DB, workers int, tasks <-chan Task, wg *sync.WaitGroup) {
-	for i := 0; i < workers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for t := range tasks {
-				select {
-				case <-t.Ctx.Done():
-					t.Resp <- TaskResult{Results: nil, Source: "", Err: fmt.Errorf("request canceled")}
-					continue
-				default:
-				}
-
-				maxDaysCached, maxItemsCached := getMaxCachedParams(db, t.Query)
-				var final []NewsResult
-				var src string
-
-				if maxDaysCached >= t.Days && maxItemsCached >= t.MaxItems {
-					final = getCachedResults(db, t.Query, t.Days, t.MaxItems)
-					src = "DB"
-				} else {
-					fetched, err := fetchNewsAPI(t.Query, t.Days, t.MaxItems)
-					if err != nil {
-						final = getCachedResults(db, t.Query, t.Days, t.MaxItems)
-						if len(final) > 0 {
-							src = "DB"
-						} else {
-							t.Resp <- TaskResult{Results: nil, Source: "", Err: err}
-							continue
-						}
-					} else {
-						storeFetched(db, t.Query, t.Days, t.MaxItems, fetched)
-						final = getCachedResults(db, t.Query, t.Days, t.MaxItems)
-						src = "API"
-					}
-				}
-				t.Resp <- TaskResult{Results: final, Source: src, Err: nil}
-			}
-		}()
-	}
-}
-
-// -------- CLI helpers --------
-func readUsersFile(filename string) ([]struct {
-	Topic    string
-	Days     int
-	MaxItems int
-}, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	var topics []struct {
-		Topic    string
-		Days     int
-		MaxItems int
-	}
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
-		parts := strings.Split(line, ",")
-		if len(parts) != 3 {
-			fmt.Println("Skipping invalid line in input file:", line)
-			continue
-		}
-		days, _ := strconv.Atoi(strings.TrimSpace(parts[1]))
-		maxItems, _ := strconv.Atoi(strings.TrimSpace(parts[2]))
-		topics = append(topics, struct {
-			Topic    string
-			Days     int
-			MaxItems int
-		}{Topic: strings.TrimSpace(parts[0]), Days: days, MaxItems: maxItems})
-	}
-	return topics, scanner.Err()
-}
-
-func runCLI(tasks chan<- Task, inputFileName string) {
-	// Input path
-	inputFile := filepath.Join("Inputs(Sampel Testcases)", inputFileName)
-
-	// Ensure Outputs folder exists
-	os.MkdirAll("Outputs", os.ModePerm)
-
-	reader := bufio.NewReader(os.Stdin)
-
-	for {
-		userTopics, err := readUsersFile(inputFile)
-		if err != nil {
-			fmt.Println("Error reading input file:", err)
-			return
-		}
-
-		resultsMap := make(map[string]TaskResult)
-		var mu sync.Mutex
-		var wgLocal sync.WaitGroup
-
-		for _, ut := range userTopics {
-			wgLocal.Add(1)
-			go func(u struct {
-				Topic          string
-				Days, MaxItems int
-			}) {
-				defer wgLocal.Done()
-				respCh := make(chan TaskResult, 1)
-				ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-				defer cancel()
-
-				task := Task{
-					Query:    u.Topic,
-					Days:     u.Days,
-					MaxItems: u.MaxItems,
-					Resp:     respCh,
-					Ctx:      ctx,
-				}
-
-				select {
-				case tasks <- task:
-				case <-ctx.Done():
-					respCh <- TaskResult{Results: nil, Source: "", Err: fmt.Errorf("timeout submitting task")}
-				}
-
-				res := <-respCh
-				mu.Lock()
-				resultsMap[u.Topic] = res
-				mu.Unlock()
-			}(ut)
-		}
-
-		wgLocal.Wait()
-
-		// Output file automatically named after input file in Outputs folder
-		baseName := strings.TrimSuffix(inputFileName, ".txt")
-		outFile := filepath.Join("Outputs", fmt.Sprintf("Outputs_%s.txt", baseName))
-		file, err := os.Create(outFile)
-		if err != nil {
-			fmt.Println("Error writing output file:", err)
-			return
-		}
-		w := bufio.NewWriter(file)
-
-		for _, u := range userTopics {
-			r := resultsMap[u.Topic]
-			if r.Err != nil {
-				w.WriteString(fmt.Sprintf("Results for \"%s\" (error: %v)\n\n", u.Topic, r.Err))
-				continue
-			}
-			w.WriteString(fmt.Sprintf("Results for \"%s\" (Fetched from: %s):\n", u.Topic, r.Source))
-			if len(r.Results) == 0 {
-				w.WriteString("- No results found\n\n")
-			} else {
-				for _, res := range r.Results {
-					w.WriteString(fmt.Sprintf("- %s (%s)\n", res.Title, res.URL))
-				}
-				w.WriteString("\n")
-			}
-		}
-
-		w.Flush()
-		file.Close()
-
-		fmt.Printf("Execution completed. Results stored in %s\n", outFile)
-		fmt.Print("Press Enter to run again, or type 'exit' to quit: ")
-		input, _ := reader.ReadString('\n')
-		if strings.TrimSpace(strings.ToLower(input)) == "exit" {
-			fmt.Println("Exiting program")
-			return
-		}
-	}
-}
-
-// -------- main --------
-func main() {
-	// Change this variable to run a different input file
-	inputFile := "user10.txt"
-
-	db, err := openDB("news_cache.db")
-	if err != nil {
-		log.Fatalf("failed to open db: %v", err)
-	}
-
-	taskQueue := make(chan Task, 1000)
-	var workersWg sync.WaitGroup
-	startWorkerPool(db, 8, taskQueue, &workersWg)
-
-	runCLI(taskQueue, inputFile)
-
-	close(taskQueue)
-	workersWg.Wait()
-}
+// main.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// -------- Data structures --------
+type NewsResult struct {
+	Title  string `json:"title"`
+	URL    string `json:"url"`
+	Source string `json:"source"`
+	// PublishedAt is zero when the provider gave no usable timestamp.
+	PublishedAt time.Time `json:"publishedAt,omitzero"`
+	Description string    `json:"description,omitempty"`
+	Author      string    `json:"author,omitempty"`
+	// SourceName is the publisher, e.g. "Reuters"; Source says where the
+	// result was served from.
+	SourceName string `json:"sourceName,omitempty"`
+}
+
+type CachedSearch struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	// The query-led indexes serve cacheScope's lookups: getCachedResults
+	// orders by created, getMaxCachedParams by days and max_items.
+	// AutoMigrate adds them to existing databases.
+	Query    string `gorm:"index:idx_cached_searches_query_created,priority:1;index:idx_cached_searches_query_params,priority:1"`
+	Days     int    `gorm:"index:idx_cached_searches_query_params,priority:2"`
+	MaxItems int    `gorm:"index:idx_cached_searches_query_params,priority:3"`
+	Endpoint string `gorm:"not null;default:everything"`
+	Country  string `gorm:"not null;default:''"`
+	Category string `gorm:"not null;default:''"`
+	Language string `gorm:"not null;default:''"`
+	SortBy   string `gorm:"not null;default:''"`
+	// Domains and ExcludeDomains hold the normalized comma-separated
+	// host filters the rows were fetched with. They and Feeds are sized so
+	// MySQL doesn't cap them at its default VARCHAR(191).
+	Domains        string `gorm:"not null;default:'';size:2048"`
+	ExcludeDomains string `gorm:"not null;default:'';size:2048"`
+	SearchIn       string `gorm:"not null;default:''"`
+	Feeds          string `gorm:"not null;default:'';size:2048"`
+	// Provider is the Name() of the Provider that fetched the row.
+	Provider string `gorm:"not null;default:newsapi"`
+	// Title and URL are unbounded (LONGTEXT on MySQL), so MySQL's unique
+	// index is on a hash of the key; see migrateCacheEntryIndex.
+	Title       string
+	URL         string
+	Description string
+	Author      string
+	SourceName  string
+	// PublishedAt is NULL for rows cached before it was recorded and for
+	// articles without a parseable date.
+	PublishedAt *time.Time
+	Created     time.Time `gorm:"index:idx_cached_searches_query_created,priority:2"`
+	// Position is the article's rank within the fetch that stored it.
+	Position int
+	// LastAccessed is when the row's query was last served or stored, for
+	// evictCache; NULL on rows from before it was tracked.
+	LastAccessed *time.Time
+	// RunID is the RunStats row of the run that last fetched the article;
+	// NULL for rows stored outside a run or before runs were recorded.
+	RunID *uint `gorm:"index"`
+	// UserID is the user whose topic last fetched the article. Results
+	// are shared: lookups never filter on it.
+	UserID string `gorm:"not null;default:global;size:64"`
+}
+
+func (c CachedSearch) toResult() NewsResult {
+	r := NewsResult{
+		Title:       c.Title,
+		URL:         c.URL,
+		Source:      c.Provider,
+		Description: c.Description,
+		Author:      c.Author,
+		SourceName:  c.SourceName,
+	}
+	if c.PublishedAt != nil {
+		r.PublishedAt = *c.PublishedAt
+	}
+	return r
+}
+
+// -------- Task structures --------
+const (
+	EndpointEverything   = "everything"
+	EndpointTopHeadlines = "top-headlines"
+)
+
+// NewsQuery describes a single search against the news provider.
+// Top-headlines queries are scoped by Country/Category and ignore Days.
+type NewsQuery struct {
+	Query    string
+	Days     int
+	MaxItems int
+	Endpoint string
+	Country  string
+	Category string
+	Language string
+	SortBy   string
+	// Domains and ExcludeDomains are comma-separated host lists, normalized
+	// by parseDomains.
+	Domains        string
+	ExcludeDomains string
+	// SearchIn limits matching to some of title, description and content;
+	// empty means all of them.
+	SearchIn string
+	// Provider pins the topic to one provider; empty uses the run's default.
+	Provider string
+	// Feeds is the semicolon-separated feed URL list for the rss provider.
+	Feeds string
+	// MaxAge overrides cacheMaxAge for this topic when non-zero.
+	MaxAge time.Duration
+	// Timeout replaces the run's -timeout for this topic when non-zero.
+	Timeout time.Duration
+	// From, when set, makes the window an absolute range of dates instead
+	// of the last Days days: from the midnight From is at, to the one To
+	// is at, or now when To is zero. Days then counts back to From, for
+	// providers that only take a number of days.
+	From, To time.Time
+	// Until, when set, asks the provider only for articles published
+	// before it, for a delta fetch; see deltaUntil. Providers found by
+	// supportsUntil honour it, the others fetch the whole window.
+	Until time.Time
+	// Refresh skips the cache lookup and replaces the topic's cached rows
+	// with a new fetch.
+	Refresh bool
+	// Priority orders the topic among those waiting for a worker.
+	Priority Priority
+	// UserID is whose subscription the topic is, from an optional `user|`
+	// prefix; empty is globalUser. It attributes history, not results.
+	UserID string
+}
+
+// globalUser owns topics without a user prefix.
+const globalUser = "global"
+
+func (q NewsQuery) user() string {
+	return cmp.Or(q.UserID, globalUser)
+}
+
+func (q NewsQuery) endpoint() string {
+	if q.Endpoint == "" {
+		return EndpointEverything
+	}
+	return q.Endpoint
+}
+
+// windowStart is the midnight that opens q's days window, matching the
+// from date sent to the providers, or the zero time for top-headlines.
+func (q NewsQuery) windowStart() time.Time {
+	if q.endpoint() == EndpointTopHeadlines || q.Days <= 0 {
+		return time.Time{}
+	}
+	if q.ranged() {
+		return q.From
+	}
+	return q.windowMidnight()
+}
+
+// windowFrom is the day providers are asked for articles from: Days-1 days
+// ago, or the start of an absolute range.
+func (q NewsQuery) windowFrom() time.Time {
+	if q.ranged() {
+		return q.From
+	}
+	return time.Now().AddDate(0, 0, -q.Days+1)
+}
+
+// windowMidnight is the local midnight of the day windowFrom falls on,
+// the lower bound for providers that take a time rather than a date.
+func (q NewsQuery) windowMidnight() time.Time {
+	y, m, d := q.windowFrom().In(time.Local).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+}
+
+// ranged reports whether q's window is an absolute range of dates.
+func (q NewsQuery) ranged() bool {
+	return !q.From.IsZero()
+}
+
+// fetchUntil is when a provider fetch for q should stop: the earlier of
+// the end of its range and the Until of a delta fetch, or the zero time
+// for now.
+func (q NewsQuery) fetchUntil() time.Time {
+	if q.To.IsZero() || !q.Until.IsZero() && q.Until.Before(q.To) {
+		return q.Until
+	}
+	return q.To
+}
+
+// cacheMaxAge is how long cached rows count as fresh; set from
+// -cache-max-age. Zero keeps them fresh forever.
+var cacheMaxAge = 6 * time.Hour
+
+// cacheCutoff is the creation time before which q's cached rows are stale,
+// or the zero time if they never are.
+func (q NewsQuery) cacheCutoff() time.Time {
+	maxAge := cacheMaxAge
+	if q.MaxAge != 0 {
+		maxAge = q.MaxAge
+	}
+	if maxAge <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-maxAge)
+}
+
+type Task struct {
+	NewsQuery
+	Resp chan TaskResult
+	Ctx  context.Context
+	// seq, from nextTaskSeq, orders the task among those of its priority.
+	seq uint64
+	// queued is when the task was handed to the pool, and deadline when
+	// its caller gives up on it, zero for none. The worker sets deadline
+	// from Ctx, before the task shares a fetch whose context has none.
+	queued, deadline time.Time
+}
+
+type TaskResult struct {
+	Results []NewsResult
+	Source  string
+	Err     error
+	// BudgetLimited is set when -api-budget kept the task off the API.
+	BudgetLimited bool
+	// Elapsed is the time the task took in its worker, and APICalls the
+	// provider requests it sent. Topics coalesced into one task share them.
+	Elapsed  time.Duration
+	APICalls int64
+	// Waited is the time the task was queued before a worker took it.
+	Waited time.Duration
+}
+
+// -------- DB helpers --------
+// openDB opens and migrates the cache; dsn is an SQLite path or a Postgres
+// URL, see dialector.
+func openDB(dsn string) (*gorm.DB, error) {
+	dial, err := dialector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(dial, &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if isMemoryDB(dsn) {
+		// shared-cache connections lock whole tables and don't wait on
+		// each other, so the in-memory cache uses a single connection
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+	if err := migrateSchema(db); err != nil {
+		return nil, err
+	}
+	if isSQLite(db) {
+		if err := ensureFTS(db); err != nil {
+			return nil, fmt.Errorf("setting up full-text search: %w", err)
+		}
+	}
+	return db, nil
+}
+
+// cacheEntryColumns identify one cached article: the cache key cacheScope
+// matches on, the provider, and the URL. storeFetched upserts on them.
+var cacheEntryColumns = []string{"query", "endpoint", "country", "category", "language", "sort_by",
+	"domains", "exclude_domains", "search_in", "feeds", "provider", "url"}
+
+const cacheEntryIndex = "idx_cached_searches_entry"
+
+// migrateCacheEntryIndex adds the unique index behind storeFetched's upsert.
+// Databases from before it can hold the same article many times, so all but
+// the newest copy are dropped first.
+func migrateCacheEntryIndex(db *gorm.DB) error {
+	if db.Migrator().HasIndex(&CachedSearch{}, cacheEntryIndex) {
+		return nil
+	}
+	cols := strings.Join(cacheEntryColumns, ", ")
+	return db.Transaction(func(tx *gorm.DB) error {
+		// the derived table lets MySQL read the table it deletes from
+		if err := tx.Exec("DELETE FROM cached_searches WHERE id NOT IN (SELECT id FROM (SELECT MAX(id) AS id FROM cached_searches GROUP BY " + cols + ") AS keep)").Error; err != nil {
+			return err
+		}
+		if isMySQL(tx) {
+			// MySQL can't index TEXT columns like url in full, and the key
+			// columns together exceed its index length anyway, so the unique
+			// index covers a stored hash of them instead. ON DUPLICATE KEY
+			// UPDATE then fires on it as on any unique index.
+			return tx.Exec("ALTER TABLE cached_searches ADD COLUMN entry_hash BINARY(32) " +
+				"AS (UNHEX(SHA2(CONCAT_WS(CHAR(31), " + cols + "), 256))) STORED, " +
+				"ADD UNIQUE INDEX " + cacheEntryIndex + " (entry_hash)").Error
+		}
+		return tx.Exec("CREATE UNIQUE INDEX " + cacheEntryIndex + " ON cached_searches (" + cols + ")").Error
+	})
+}
+
+// migrateQueryKeys rewrites topics cached before queryKey existed into their
+// current form. Where that makes two rows the same article, the row already
+// in the new form is kept. A change to queryKey needs a new migration that
+// runs this again.
+func migrateQueryKeys(db *gorm.DB) error {
+	var queries []string
+	if err := db.Unscoped().Model(&CachedSearch{}).Distinct().Pluck("query", &queries).Error; err != nil {
+		return err
+	}
+	var join []string
+	for _, c := range cacheEntryColumns {
+		if c != "query" {
+			join = append(join, "n."+c+" = o."+c)
+		}
+	}
+	// the derived table lets MySQL read the table it deletes from
+	dupes := "DELETE FROM cached_searches WHERE id IN (SELECT id FROM (SELECT o.id FROM cached_searches o " +
+		"JOIN cached_searches n ON n.query = ? AND " + strings.Join(join, " AND ") + " WHERE o.query = ?) AS dup)"
+	for _, old := range queries {
+		key := queryKey(old)
+		if key == old {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(dupes, key, old).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Model(&CachedSearch{}).Where("query = ?", old).Update("query", key).Error
+		})
+		if err != nil {
+			return fmt.Errorf("normalizing cached topic %q: %w", old, err)
+		}
+	}
+	return nil
+}
+
+// cacheScope restricts a lookup to rows cached for the same query and
+// endpoint, so top-headlines rows never satisfy an everything search.
+func cacheScope(db *gorm.DB, q NewsQuery) *gorm.DB {
+	scope := db.Where("query = ? AND endpoint = ? AND country = ? AND category = ? AND language = ? AND sort_by = ?",
+		q.cacheQuery(), q.endpoint(), q.Country, q.Category, q.Language, q.SortBy).
+		Where("domains = ? AND exclude_domains = ? AND search_in = ? AND feeds = ?", q.Domains, q.ExcludeDomains, q.SearchIn, q.Feeds)
+	if providers := q.providerFilter(); len(providers) > 0 {
+		// a fan-out topic is satisfied by rows from any of its providers
+		scope = scope.Where("provider IN ?", providers)
+	}
+	return scope
+}
+
+// strictProviders, set by -strict-provider, are the providers whose rows
+// may answer topics without a provider prefix; empty allows any.
+var strictProviders []string
+
+// providerFilter is the providers whose cached rows may answer q: those of
+// its prefix, else strictProviders.
+func (q NewsQuery) providerFilter() []string {
+	if q.Provider != "" {
+		return providerNames(q.Provider)
+	}
+	return strictProviders
+}
+
+// providerNames lists the providers of a -provider value or topic prefix,
+// e.g. "guardian,newsapi" or "hn+reddit", as stored in CachedSearch.Provider.
+func providerNames(spec string) []string {
+	var names []string
+	for _, n := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '+' }) {
+		names = append(names, cmp.Or(strings.ToLower(strings.TrimSpace(n)), newsAPIProviderName))
+	}
+	return names
+}
+
+// newestPerURL selects scope's rows keeping only the most recently cached
+// row for each URL, ordered newest article first. Articles without a
+// publication date are dated by when they were cached, and Position breaks
+// ties.
+func newestPerURL(db *gorm.DB, scope *gorm.DB) *gorm.DB {
+	ranked := scope.Model(&CachedSearch{}).
+		Select("id, ROW_NUMBER() OVER (PARTITION BY url ORDER BY " + sqlInstant(db, "created") + " DESC, id DESC) AS rn")
+	return db.Model(&CachedSearch{}).
+		Where("id IN (?)", db.Table("(?) AS ranked", ranked).Select("id").Where("rn = 1")).
+		Order(sqlInstant(db, "COALESCE(published_at, created)") + " DESC, position")
+}
+
+// cachedResults converts rows from newestPerURL into at most q.MaxItems
+// results with distinct URLs, and marks q as used.
+func cachedResults(db *gorm.DB, q NewsQuery, cached []CachedSearch) []NewsResult {
+	results := make([]NewsResult, 0, len(cached))
+	for _, c := range cached {
+		results = append(results, c.toResult())
+	}
+	// the query only sees exact URLs; this also folds http/https, www. and
+	// tracking-parameter variants of the same link
+	results = dedupeResults(results)
+	if len(results) > q.MaxItems {
+		results = results[:q.MaxItems]
+	}
+	if len(results) > 0 {
+		touchQuery(db, q)
+	}
+	return results
+}
+
+// getCachedResults returns q's cached articles published within its days
+// window or date range, newest first, skipping rows created before since unless it is
+// zero.
+func getCachedResults(db *gorm.DB, q NewsQuery, since time.Time) []NewsResult {
+	var cached []CachedSearch
+	scope := cacheScope(db, q)
+	if !since.IsZero() {
+		scope = scope.Where("created >= ?", since)
+	}
+	if start := q.windowStart(); !start.IsZero() {
+		scope = scope.Where(sqlInstant(db, "COALESCE(published_at, created)")+" >= "+sqlInstant(db, "?"), start)
+	}
+	if !q.To.IsZero() {
+		scope = scope.Where(sqlInstant(db, "COALESCE(published_at, created)")+" < "+sqlInstant(db, "?"), q.To)
+	}
+	err := withBusyRetry(func() error {
+		return newestPerURL(db, scope).Find(&cached).Error
+	})
+	if err != nil {
+		if db.Statement.Context.Err() == nil {
+			// a task out of time just finds nothing
+			log.Printf("reading cache for %s: %v", topicLabel(q), err)
+		}
+		return nil
+	}
+	return cachedResults(db, q, cached)
+}
+
+// getAnyCachedResults returns up to q.MaxItems distinct cached articles for
+// q from fetches of any window, size or age, newest first.
+func getAnyCachedResults(db *gorm.DB, q NewsQuery) ([]NewsResult, error) {
+	var cached []CachedSearch
+	if err := newestPerURL(db, cacheScope(db, q)).Find(&cached).Error; err != nil {
+		return nil, err
+	}
+	return cachedResults(db, q, cached), nil
+}
+
+// getMaxCachedParams reports the widest days window and item count among
+// q's fresh cached rows.
+func getMaxCachedParams(db *gorm.DB, q NewsQuery) (int, int) {
+	var cached CachedSearch
+	scope := cacheScope(db, q)
+	if cutoff := q.cacheCutoff(); !cutoff.IsZero() {
+		scope = scope.Where("created >= ?", cutoff)
+	}
+	tx := scope.Order("days desc, max_items desc").First(&cached)
+	if tx.Error != nil || tx.RowsAffected == 0 {
+		return 0, 0
+	}
+	return cached.Days, cached.MaxItems
+}
+
+// cacheUpsert makes an insert of CachedSearch rows overwrite the stored
+// copy of the same article, undeleting it if need be.
+func cacheUpsert() clause.OnConflict {
+	conflict := clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"updated_at", "deleted_at", "days", "max_items",
+		"title", "description", "author", "source_name", "published_at", "created", "position", "last_accessed", "run_id", "user_id"})}
+	for _, c := range cacheEntryColumns {
+		conflict.Columns = append(conflict.Columns, clause.Column{Name: c})
+	}
+	return conflict
+}
+
+// storeFetched caches one fetch. All rows share a timestamp and keep their
+// provider rank in Position, which orders articles published together.
+// Articles already cached for q are updated in place rather than added again.
+// The fetch is written in one transaction, so on error none of it is kept.
+// The rows record the run of db's context, see withRun.
+func storeFetched(db *gorm.DB, q NewsQuery, results []NewsResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+	rows := cacheRows(q, results, runOf(db.Statement.Context))
+	err := withBusyRetry(func() error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			return insertCacheRows(tx, rows)
+		})
+	})
+	if err == nil {
+		cacheStored(q, rows)
+	}
+	return err
+}
+
+// cacheRows are the CachedSearch rows storing results fetched for q by the
+// task of run, 0 for none.
+func cacheRows(q NewsQuery, results []NewsResult, run uint) []CachedSearch {
+	now := time.Now()
+	days := q.Days
+	if q.ranged() {
+		// rows of a past date range don't cover the days before now
+		days = 0
+	}
+	var runID *uint
+	if run != 0 {
+		runID = &run
+	}
+	rows := make([]CachedSearch, len(results))
+	for i, r := range results {
+		var published *time.Time
+		if !r.PublishedAt.IsZero() {
+			published = &r.PublishedAt
+		}
+		rows[i] = CachedSearch{
+			Query:          q.cacheQuery(),
+			Days:           days,
+			MaxItems:       q.MaxItems,
+			Endpoint:       q.endpoint(),
+			Country:        q.Country,
+			Category:       q.Category,
+			Language:       q.Language,
+			SortBy:         q.SortBy,
+			Domains:        q.Domains,
+			ExcludeDomains: q.ExcludeDomains,
+			SearchIn:       q.SearchIn,
+			Feeds:          q.Feeds,
+			Provider:       r.Source,
+			Title:          r.Title,
+			URL:            r.URL,
+			Description:    r.Description,
+			Author:         r.Author,
+			SourceName:     r.SourceName,
+			PublishedAt:    published,
+			Created:        now,
+			Position:       i,
+			LastAccessed:   &now,
+			RunID:          runID,
+			UserID:         q.user(),
+		}
+	}
+	return rows
+}
+
+// insertCacheRows upserts rows in tx, without retrying or touching the
+// counters and caches: the caller does that once its transaction commits.
+func insertCacheRows(tx *gorm.DB, rows []CachedSearch) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	return tx.Clauses(cacheUpsert()).CreateInBatches(rows, storeBatchSize).Error
+}
+
+// cacheStored counts rows, committed to the cache for q, and drops the
+// copies of q's results the memory cache and Redis hold.
+func cacheStored(q NewsQuery, rows []CachedSearch) {
+	rowsWritten.Add(int64(len(rows)))
+	invalidateQuery(context.Background(), q.cacheQuery())
+}
+
+// invalidateQuery drops the results of the topics cached under query from
+// the tiers in front of the SQL cache, once its rows change.
+func invalidateQuery(ctx context.Context, query string) {
+	memoryCache.Invalidate(query)
+	redisCache.Invalidate(ctx, query)
+}
+
+// storeBatchSize keeps each INSERT well under SQLite's bound-variable limit.
+const storeBatchSize = 100
+
+// replaceFetched stores a fetch in place of every row cached for q, so
+// forced refreshes don't pile up duplicates.
+func replaceFetched(db *gorm.DB, q NewsQuery, results []NewsResult) error {
+	rows := cacheRows(q, results, runOf(db.Statement.Context))
+	err := withBusyRetry(func() error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := cacheScope(tx, q).Unscoped().Delete(&CachedSearch{}).Error; err != nil {
+				return err
+			}
+			return insertCacheRows(tx, rows)
+		})
+	})
+	if err == nil {
+		cacheStored(q, rows)
+	}
+	return err
+}
+
+// missReason says why processTask fetches q rather than serving it from
+// the cache, for -verbose; covered is whether the cached fetch parameters
+// span q.
+func missReason(q NewsQuery, covered bool) string {
+	switch {
+	case q.Refresh:
+		return "-refresh"
+	case !covered:
+		return "not cached for this window and size"
+	}
+	return "too few cached rows are fresh enough"
+}
+
+// deltaUntil decides whether a cache miss needs only the older part of its
+// window: when fresh rows fetched for a shorter window are cached, it
+// returns the oldest of their publication times, which the fetch can stop
+// at. maxDaysCached is from getMaxCachedParams.
+func deltaUntil(db *gorm.DB, q NewsQuery, maxDaysCached int) (time.Time, bool) {
+	start := q.windowStart()
+	if q.Refresh || q.ranged() || start.IsZero() || maxDaysCached <= 0 || maxDaysCached >= q.Days {
+		return time.Time{}, false
+	}
+	scope := cacheScope(db, q).Where("published_at IS NOT NULL")
+	if cutoff := q.cacheCutoff(); !cutoff.IsZero() {
+		scope = scope.Where("created >= ?", cutoff)
+	}
+	var oldest CachedSearch
+	tx := scope.Order(sqlInstant(db, "published_at")).Limit(1).Find(&oldest)
+	if tx.Error != nil || tx.RowsAffected == 0 || !oldest.PublishedAt.After(start) {
+		return time.Time{}, false
+	}
+	return *oldest.PublishedAt, true
+}
+
+// -------- Worker pool --------
+func startWorkerPool(db *gorm.DB, provider Provider, workers int, tasks <-chan Task, wg *sync.WaitGroup) {
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tasks {
+				start := time.Now()
+				t.deadline, _ = t.Ctx.Deadline()
+				var calls *atomic.Int64
+				t.Ctx, calls = withCallCounter(t.Ctx)
+				reporter.taskStarted(t.NewsQuery)
+				r, shared := inFlight.do(t.Ctx, t.NewsQuery, func(ctx context.Context) (r TaskResult) {
+					t := t
+					t.Ctx = ctx
+					return processTask(db, provider, t)
+				})
+				reporter.taskEnded(t.NewsQuery)
+				if shared {
+					verbosef("%s: shared the result of the same topic's task in flight", topicLabel(t.NewsQuery))
+				}
+				r.Elapsed, r.APICalls = time.Since(start), calls.Load()
+				if !t.queued.IsZero() {
+					r.Waited = start.Sub(t.queued)
+				}
+				searchLog.Record(t.NewsQuery, runOf(t.Ctx), r, r.Elapsed, r.APICalls)
+				select {
+				case t.Resp <- r:
+				default:
+					// Resp holds one result; one already there was sent by
+					// someone else, and this worker must not hang on it
+					debugf("%s: result dropped, the task was answered already", topicLabel(t.NewsQuery))
+				}
+			}
+		}()
+	}
+}
+
+// A PanicError is the error of a task whose processing panicked: the
+// panic's value, and the stack it was raised on, cut to maxPanicStack
+// bytes. The worker goes on with the next task.
+type PanicError struct {
+	Value any
+	Stack string
+}
+
+func (e *PanicError) Error() string { return fmt.Sprintf("panic: %v", e.Value) }
+
+// maxPanicStack bounds the stack a PanicError keeps and the log shows.
+const maxPanicStack = 4 << 10
+
+// recoverTask, deferred around processing the task for q, turns a panic
+// into a PanicError result in r and logs it with its stack.
+func recoverTask(q NewsQuery, r *TaskResult) {
+	if v := recover(); v != nil {
+		*r = TaskResult{Err: newPanicError("processing "+topicLabel(q), v)}
+	}
+}
+
+// newPanicError is the PanicError of the recovered value v, which it logs
+// with its stack as the panic of what.
+func newPanicError(what string, v any) *PanicError {
+	stack := debug.Stack()
+	if len(stack) > maxPanicStack {
+		stack = append(stack[:maxPanicStack], "\n..."...)
+	}
+	log.Printf("panic %s: %v\n%s", what, v, stack)
+	return &PanicError{Value: v, Stack: string(stack)}
+}
+
+// processTask serves a task from the cache when fresh rows already cover the
+// request, otherwise fetches from the provider, falling back to the cache when the
+// fetch fails with a retryable error.
+func processTask(db *gorm.DB, provider Provider, t Task) TaskResult {
+	select {
+	case <-t.Ctx.Done():
+		return TaskResult{Results: nil, Source: "", Err: fmt.Errorf("request canceled")}
+	default:
+	}
+	// cache queries and writes end with the task too, a write in a
+	// transaction rolled back whole
+	db = db.WithContext(t.Ctx)
+
+	if t.Provider != "" {
+		p, err := newProvider(t.Provider)
+		if err != nil {
+			return TaskResult{Results: nil, Source: "", Err: err}
+		}
+		provider = p
+	}
+
+	cutoff := t.cacheCutoff()
+	if !offlineMode && !t.Refresh {
+		if res, ok := memoryCache.Get(t.NewsQuery); ok {
+			verbosef("%s: served from the memory cache", topicLabel(t.NewsQuery))
+			// the rows are in use this pass, though not read
+			touchQuery(db, t.NewsQuery)
+			return res
+		}
+		if cached, ok := redisCache.Get(t.Ctx, t.NewsQuery); ok {
+			verbosef("%s: served from Redis", topicLabel(t.NewsQuery))
+			touchQuery(db, t.NewsQuery)
+			res := TaskResult{Results: cached, Source: "Redis", Err: nil}
+			memoryCache.Put(t.NewsQuery, res)
+			return res
+		}
+	}
+	maxDaysCached, maxItemsCached := getMaxCachedParams(db, t.NewsQuery)
+	// the stored fetch parameters only hint at a hit: enough of the cached
+	// articles must also fall inside the days window. A date range is
+	// judged by the publication dates alone.
+	covered := t.ranged() || maxDaysCached >= t.Days && maxItemsCached >= t.MaxItems
+	if offlineMode {
+		return offlineResult(db, t.NewsQuery, covered)
+	}
+	if !t.Refresh && covered {
+		if cached := getCachedResults(db, t.NewsQuery, cutoff); len(cached) >= t.MaxItems {
+			verbosef("%s: served from the cache, %d rows fresh enough", topicLabel(t.NewsQuery), len(cached))
+			redisCache.Set(t.Ctx, t.NewsQuery, cached)
+			res := TaskResult{Results: cached, Source: "DB", Err: nil}
+			memoryCache.Put(t.NewsQuery, res)
+			return res
+		}
+	}
+	if err := t.Ctx.Err(); err != nil {
+		// the cache lookups took the task's time; don't spend budget on it
+		return TaskResult{Err: err}
+	}
+	// the fetch stops short of the deadline by reserve, leaving the time to
+	// serve whatever is cached instead
+	fetchCtx, reserve := t.Ctx, cacheReserve(t)
+	if reserve > 0 {
+		if left := time.Until(t.deadline); left < 2*reserve {
+			if res, ok := deadlineFallback(db, t.NewsQuery, fmt.Sprintf("%s left, too little to fetch", roundDuration(left))); ok {
+				return res
+			}
+			// nothing to fall back to: the fetch may as well use it all
+			reserve = 0
+		} else {
+			var cancel context.CancelFunc
+			fetchCtx, cancel = context.WithDeadline(t.Ctx, t.deadline.Add(-reserve))
+			defer cancel()
+		}
+	}
+	if !apiBudget.Take() {
+		if final := getCachedResults(db, t.NewsQuery, time.Time{}); len(final) > 0 {
+			return TaskResult{Results: final, Source: "DB (api budget exhausted)", BudgetLimited: true}
+		}
+		return TaskResult{Err: fmt.Errorf("api budget exhausted (-api-budget %d) and nothing cached", apiBudget.Limit),
+			BudgetLimited: true}
+	}
+
+	fetchQuery := t.NewsQuery
+	if until, ok := deltaUntil(db, t.NewsQuery, maxDaysCached); !ok {
+		verbosef("%s: %s, full fetch", topicLabel(t.NewsQuery), missReason(t.NewsQuery, covered))
+	} else if !supportsUntil(provider) {
+		verbosef("%s: cached articles cover back to %s, but %s can't fetch only the older ones; full fetch",
+			topicLabel(t.NewsQuery), until.Format(time.RFC3339), provider.Name())
+	} else {
+		fetchQuery.Until = until
+		verbosef("%s: delta fetch from %s to %s, the rest is cached", topicLabel(t.NewsQuery),
+			t.windowStart().Format(time.RFC3339), until.Format(time.RFC3339))
+	}
+	fetched, err := func() ([]NewsResult, error) {
+		if err := fetchSlots.acquire(fetchCtx, t.NewsQuery); err != nil {
+			return nil, err
+		}
+		// a provider that panics still frees its slot
+		defer fetchSlots.release()
+		return provider.Fetch(fetchCtx, fetchQuery)
+	}()
+	if ctxErr := t.Ctx.Err(); ctxErr != nil {
+		// nobody is waiting for the result any more, so nothing is cached
+		// for it either
+		return TaskResult{Err: cmp.Or(err, ctxErr)}
+	}
+	if fetchCtx.Err() != nil && len(fetched) == 0 {
+		if res, ok := deadlineFallback(db, t.NewsQuery, "fetch cut short"); ok {
+			return res
+		}
+		// as good as timed out, and retried as such
+		return TaskResult{Err: &TimeoutError{After: roundDuration(t.deadline.Sub(t.queued)), Err: cmp.Or(err, fetchCtx.Err())}}
+	}
+	switch {
+	case err == nil:
+		src := "API"
+		if names := contributors(fetched); len(names) > 1 {
+			src = strings.Join(names, "+")
+		} else if len(names) == 1 && names[0] != provider.Name() {
+			// a fallback in the chain served the topic
+			src = "API (" + names[0] + ")"
+		}
+		store := storeFetched
+		if t.Refresh {
+			store = replaceFetched
+			src += " (forced)"
+		}
+		if err := store(db, t.NewsQuery, fetched); err != nil {
+			// still show what was fetched, it just won't be cached
+			log.Printf("caching %s: %v", topicLabel(t.NewsQuery), err)
+			return TaskResult{Results: fetched, Source: src, Err: nil}
+		}
+		if !fetchQuery.Until.IsZero() {
+			// the cached rows and the delta now cover the whole window
+			err := cacheScope(db, t.NewsQuery).Model(&CachedSearch{}).Where("days < ?", t.Days).Update("days", t.Days).Error
+			if err != nil {
+				log.Printf("caching %s: %v", topicLabel(t.NewsQuery), err)
+			}
+		}
+		if err := evictCache(db); err != nil {
+			log.Printf("evicting cached topics: %v", err)
+		}
+		final := getCachedResults(db, t.NewsQuery, cutoff)
+		redisCache.Set(t.Ctx, t.NewsQuery, final)
+		// repeats of the topic are cache hits from here on
+		memoryCache.Put(t.NewsQuery, TaskResult{Results: final, Source: "DB"})
+		return TaskResult{Results: final, Source: src, Err: nil}
+	case len(fetched) > 0:
+		// keep the pages we did get, but record the smaller item count so
+		// the next run tries to fetch the rest
+		partial := t.NewsQuery
+		partial.MaxItems = len(fetched)
+		if err := storeFetched(db, partial, fetched); err != nil {
+			log.Printf("caching %s: %v", topicLabel(t.NewsQuery), err)
+			return TaskResult{Results: fetched, Source: "API", Err: nil}
+		}
+		if err := evictCache(db); err != nil {
+			log.Printf("evicting cached topics: %v", err)
+		}
+		return TaskResult{Results: getCachedResults(db, partial, cutoff), Source: "API", Err: nil}
+	case !isRetryable(err):
+		return TaskResult{Results: nil, Source: "", Err: err}
+	}
+
+	// any age will do now, but say so when the rows are past their max age
+	if final := getCachedResults(db, t.NewsQuery, time.Time{}); len(final) > 0 {
+		verbosef("%s: fetch failed (%v), falling back to %d cached rows", topicLabel(t.NewsQuery), err, len(final))
+		src := "DB"
+		switch {
+		case errors.Is(err, ErrQuotaReached):
+			src = "DB (daily API budget reached)"
+		case errors.Is(err, ErrQuotaUnchecked):
+			src = "DB (daily API budget unchecked)"
+		case isRateLimited(err):
+			src = "DB (rate limited)"
+		case !cutoff.IsZero() && len(getCachedResults(db, t.NewsQuery, cutoff)) < len(final):
+			src = "DB (stale)"
+		}
+		return TaskResult{Results: final, Source: src, Err: nil}
+	}
+	return TaskResult{Results: nil, Source: "", Err: err}
+}
+
+// deadlineSource is the Source of the results of a task its deadline kept
+// from fetching, served from the cache instead.
+const deadlineSource = "DB (deadline exceeded, served stale cache)"
+
+// cacheReserve is how long before t's deadline its fetch is cut short, so
+// that the cache can serve it instead in time: a tenth of the time t was
+// given, up to a second. It is 0 for a task without a deadline.
+func cacheReserve(t Task) time.Duration {
+	if t.deadline.IsZero() || t.queued.IsZero() {
+		return 0
+	}
+	return min(t.deadline.Sub(t.queued)/10, time.Second)
+}
+
+// deadlineFallback serves q from its cached rows of any age, as a failed
+// fetch falls back to, once why its deadline rules out a fetch; ok is false
+// when nothing is cached.
+func deadlineFallback(db *gorm.DB, q NewsQuery, why string) (res TaskResult, ok bool) {
+	results := getCachedResults(db, q, time.Time{})
+	if len(results) == 0 {
+		return TaskResult{}, false
+	}
+	verbosef("%s: %s, serving %d cached rows", topicLabel(q), why, len(results))
+	return TaskResult{Results: results, Source: deadlineSource}, true
+}
+
+// offlineMode serves every topic from the cache without touching the
+// network; set from -offline.
+var offlineMode bool
+
+// offlineResult serves q from whatever is cached. covered says whether fresh
+// rows cover the request; otherwise the source notes that the results may
+// be incomplete or out of date.
+func offlineResult(db *gorm.DB, q NewsQuery, covered bool) TaskResult {
+	if covered {
+		if results := getCachedResults(db, q, q.cacheCutoff()); len(results) >= q.MaxItems {
+			return TaskResult{Results: results, Source: "DB"}
+		}
+	}
+	results, err := getAnyCachedResults(db, q)
+	if err != nil {
+		log.Printf("reading cache for %s: %v", topicLabel(q), err)
+		return TaskResult{Err: fmt.Errorf("offline, and reading the cache failed: %w", err)}
+	}
+	if len(results) == 0 {
+		return TaskResult{Err: fmt.Errorf("offline and nothing cached")}
+	}
+	src := "DB, cache may be stale"
+	if len(results) < q.MaxItems {
+		src = fmt.Sprintf("DB, %d/%d items, cache may be stale", len(results), q.MaxItems)
+	}
+	return TaskResult{Results: results, Source: src}
+}
+
+// -------- CLI helpers --------
+// newsAPILanguages are the language codes accepted by NewsAPI's everything
+// endpoint.
+var newsAPILanguages = map[string]bool{
+	"ar": true, "de": true, "en": true, "es": true, "fr": true, "he": true, "it": true,
+	"nl": true, "no": true, "pt": true, "ru": true, "sv": true, "ud": true, "zh": true,
+}
+
+// maxTaskTimeout is the longest timeout a topic may set for itself;
+// -max-timeout sets it.
+var maxTaskTimeout = 5 * time.Minute
+
+// maxItemsCap is the most articles a topic may ask for; -max-items-cap
+// sets it.
+var maxItemsCap = 100
+
+func validateLanguage(lang string) error {
+	if lang != "" && !newsAPILanguages[lang] {
+		return fmt.Errorf("unsupported language code %q", lang)
+	}
+	return nil
+}
+
+// newsAPISortOrders are the sortBy values accepted by the everything endpoint.
+var newsAPISortOrders = []string{"relevancy", "popularity", "publishedAt"}
+
+// parseSortBy matches s case-insensitively against the supported orders,
+// warning and falling back to relevancy for anything else.
+func parseSortBy(s string) string {
+	if s == "" {
+		return ""
+	}
+	for _, o := range newsAPISortOrders {
+		if strings.EqualFold(s, o) {
+			return o
+		}
+	}
+	fmt.Fprintf(console, "Warning: unknown sortBy %q, using relevancy\n", s)
+	return "relevancy"
+}
+
+// parseDomains splits a semicolon- or comma-separated host list into the
+// domains to restrict to and, for hosts prefixed with "-", the domains to
+// exclude. Both results are lowercased, sorted and comma-joined so the same
+// filter always produces the same cache key.
+func parseDomains(list string) (domains, exclude string) {
+	var inc, exc []string
+	for _, d := range strings.FieldsFunc(list, func(r rune) bool { return r == ';' || r == ',' }) {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if strings.HasPrefix(d, "-") {
+			if d = strings.TrimSpace(d[1:]); d != "" {
+				exc = append(exc, d)
+			}
+		} else if d != "" {
+			inc = append(inc, d)
+		}
+	}
+	sort.Strings(inc)
+	sort.Strings(exc)
+	return strings.Join(inc, ","), strings.Join(exc, ",")
+}
+
+// parseSearchIn splits a "topic!fields" suffix such as "rust!title" or
+// "rust!title;description" into the topic and NewsAPI's comma-separated
+// searchIn value. Topics without a suffix are returned unchanged.
+func parseSearchIn(topic string) (string, string, error) {
+	i := strings.LastIndex(topic, "!")
+	if i < 0 || i < strings.LastIndex(topic, "\"") {
+		return topic, "", nil
+	}
+	fields := strings.FieldsFunc(topic[i+1:], func(r rune) bool { return r == ';' || r == '+' })
+	if len(fields) == 0 {
+		return "", "", fmt.Errorf("empty searchIn suffix")
+	}
+	searchIn, err := searchInFields(fields)
+	if err != nil {
+		return "", "", err
+	}
+	return topic[:i], searchIn, nil
+}
+
+// searchInFields checks a list of searchIn fields and returns them as
+// NewsAPI's sorted, comma-separated searchIn value.
+func searchInFields(list []string) (string, error) {
+	var fields []string
+	for _, f := range list {
+		f = strings.ToLower(strings.TrimSpace(f))
+		switch f {
+		case "title", "description", "content":
+			fields = append(fields, f)
+		default:
+			return "", fmt.Errorf("unknown searchIn field %q", f)
+		}
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ","), nil
+}
+
+// TopicSpec is one topic as an input file gives it, before the run's
+// defaults are applied. The text and JSON input parsers both produce it,
+// and Query turns it into the NewsQuery the rest of the run works with, so
+// nothing past the parsers cares which format a topic came from.
+type TopicSpec struct {
+	// User is whose subscription the topic is; empty is globalUser.
+	User  string `json:"user,omitempty"`
+	Topic string `json:"topic"`
+	// Days is ignored by the top-headlines endpoint.
+	Days     int    `json:"days,omitempty"`
+	MaxItems int    `json:"maxItems"`
+	Endpoint string `json:"endpoint,omitempty"`
+	// Language, SortBy and the domain lists only apply to the everything
+	// endpoint, and fall back to the run's defaults when empty.
+	Language       string   `json:"language,omitempty"`
+	SortBy         string   `json:"sortBy,omitempty"`
+	Domains        []string `json:"domains,omitempty"`
+	ExcludeDomains []string `json:"excludeDomains,omitempty"`
+	SearchIn       []string `json:"searchIn,omitempty"`
+	Country        string   `json:"country,omitempty"`
+	Category       string   `json:"category,omitempty"`
+	Provider       string   `json:"provider,omitempty"`
+	// Feeds, when set, send the topic to the rss provider.
+	Feeds []string `json:"feeds,omitempty"`
+	// From and To are an absolute range of dates, YYYY-MM-DD, to search
+	// instead of the last Days days; To defaults to today.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// MaxAge and Timeout are Go durations such as "30m".
+	MaxAge  string `json:"maxAge,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+	Refresh bool   `json:"refresh,omitempty"`
+	// Priority is high, normal or low.
+	Priority string `json:"priority,omitempty"`
+}
+
+// A SpecError is a TopicSpec field that Query rejected. Field is the
+// field's JSON name.
+type SpecError struct {
+	Field string
+	Err   error
+}
+
+func (e *SpecError) Error() string { return e.Err.Error() }
+
+func (e *SpecError) Unwrap() error { return e.Err }
+
+// Query validates s and fills in what it leaves out from defaults. A
+// setting it had to adjust is reported by returning the query together
+// with a *TopicWarning.
+func (s TopicSpec) Query(defaults NewsQuery) (NewsQuery, error) {
+	q := NewsQuery{Query: normalizeTopic(s.Topic), Endpoint: EndpointEverything, MaxItems: s.MaxItems,
+		Refresh: s.Refresh || defaults.Refresh, UserID: s.User}
+	if q.Query == "" {
+		return NewsQuery{}, &SpecError{"topic", errors.New("topic is empty")}
+	}
+	if s.MaxItems < 1 || s.MaxItems > maxItemsCap {
+		return NewsQuery{}, &SpecError{"maxItems", fmt.Errorf("maxItems must be between 1 and %d, got %d", maxItemsCap, s.MaxItems)}
+	}
+	if s.Provider != "" {
+		q.Provider = strings.ToLower(strings.TrimSpace(s.Provider))
+		if _, err := newProvider(q.Provider); err != nil {
+			return NewsQuery{}, &SpecError{"provider", err}
+		}
+	}
+	if len(s.Feeds) > 0 {
+		q.Provider, q.Feeds = rssProviderName, strings.Join(s.Feeds, ";")
+	}
+	if s.MaxAge != "" {
+		maxAge, err := time.ParseDuration(s.MaxAge)
+		if err == nil && maxAge <= 0 {
+			err = fmt.Errorf("maxage must be positive")
+		}
+		if err != nil {
+			return NewsQuery{}, &SpecError{"maxAge", err}
+		}
+		q.MaxAge = maxAge
+	}
+	var warning error
+	if s.Timeout != "" {
+		timeout, err := time.ParseDuration(s.Timeout)
+		if err == nil && timeout <= 0 {
+			err = fmt.Errorf("timeout must be positive")
+		}
+		if err != nil {
+			return NewsQuery{}, &SpecError{"timeout", err}
+		}
+		if timeout > maxTaskTimeout {
+			warning = &TopicWarning{[]string{fmt.Sprintf("timeout %s is above -max-timeout, using %s", timeout, maxTaskTimeout)}}
+			timeout = maxTaskTimeout
+		}
+		q.Timeout = timeout
+	}
+	if s.Priority != "" {
+		priority, err := parsePriority(s.Priority)
+		if err != nil {
+			return NewsQuery{}, &SpecError{"priority", err}
+		}
+		q.Priority = priority
+	}
+	switch s.Endpoint {
+	case "", EndpointEverything:
+	case EndpointTopHeadlines:
+		q.Endpoint = EndpointTopHeadlines
+		q.Country, q.Category = strings.ToLower(s.Country), strings.ToLower(s.Category)
+		return q, warning
+	default:
+		return NewsQuery{}, &SpecError{"endpoint", fmt.Errorf("unknown endpoint %q, want %s or %s",
+			s.Endpoint, EndpointEverything, EndpointTopHeadlines)}
+	}
+	switch {
+	case s.From != "" || s.To != "":
+		if s.Days != 0 {
+			return NewsQuery{}, &SpecError{"days", fmt.Errorf("days can't be combined with from and to")}
+		}
+		if err := q.setRange(s.From, s.To); err != nil {
+			return NewsQuery{}, err
+		}
+	case s.Days < 1:
+		return NewsQuery{}, &SpecError{"days", fmt.Errorf("days must be at least 1, got %d", s.Days)}
+	default:
+		q.Days = s.Days
+	}
+	if len(s.SearchIn) > 0 {
+		searchIn, err := searchInFields(s.SearchIn)
+		if err != nil {
+			return NewsQuery{}, &SpecError{"searchIn", err}
+		}
+		q.SearchIn = searchIn
+	}
+	q.Language = cmp.Or(strings.ToLower(s.Language), defaults.Language)
+	if err := validateLanguage(q.Language); err != nil {
+		return NewsQuery{}, &SpecError{"language", err}
+	}
+	q.SortBy = defaults.SortBy
+	if s.SortBy != "" {
+		q.SortBy = parseSortBy(s.SortBy)
+	}
+	q.Domains, q.ExcludeDomains = defaults.Domains, defaults.ExcludeDomains
+	if s.Domains != nil || s.ExcludeDomains != nil {
+		q.Domains, _ = parseDomains(strings.Join(s.Domains, ","))
+		q.ExcludeDomains, _ = parseDomains(strings.Join(s.ExcludeDomains, ","))
+	}
+	return q, warning
+}
+
+// Spec is q in the TopicSpec form, with defaults filled in: Query's
+// inverse, up to normalization.
+func (q NewsQuery) Spec() TopicSpec {
+	s := TopicSpec{User: q.UserID, Topic: q.Query, Days: q.Days, MaxItems: q.MaxItems, Endpoint: q.endpoint(),
+		Language: q.Language, SortBy: q.SortBy, Domains: splitList(q.Domains, ","),
+		ExcludeDomains: splitList(q.ExcludeDomains, ","), SearchIn: splitList(q.SearchIn, ","), Country: q.Country,
+		Category: q.Category, Provider: q.Provider, Feeds: splitList(q.Feeds, ";"), Refresh: q.Refresh}
+	if q.ranged() {
+		s.Days, s.From = 0, q.From.Format(time.DateOnly)
+		if !q.To.IsZero() {
+			s.To = q.To.AddDate(0, 0, -1).Format(time.DateOnly)
+		}
+	}
+	if q.MaxAge != 0 {
+		s.MaxAge = q.MaxAge.String()
+	}
+	if q.Timeout != 0 {
+		s.Timeout = q.Timeout.String()
+	}
+	if q.Priority != PriorityNormal {
+		s.Priority = q.Priority.String()
+	}
+	return s
+}
+
+// splitList splits a sep-separated list, empty for "".
+func splitList(s, sep string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, sep)
+}
+
+// setRange makes q's window the dates from through to, both YYYY-MM-DD
+// and to defaulting to today. Ranges reaching into the future or ending
+// before they start are rejected.
+func (q *NewsQuery) setRange(from, to string) error {
+	if from == "" {
+		return &SpecError{"from", fmt.Errorf("to needs a from date")}
+	}
+	start, err := time.ParseInLocation(time.DateOnly, from, time.Local)
+	if err != nil {
+		return &SpecError{"from", fmt.Errorf("from %q is not a YYYY-MM-DD date", from)}
+	}
+	y, m, d := time.Now().Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	end := today
+	if to != "" {
+		if end, err = time.ParseInLocation(time.DateOnly, to, time.Local); err != nil {
+			return &SpecError{"to", fmt.Errorf("to %q is not a YYYY-MM-DD date", to)}
+		}
+	}
+	switch {
+	case start.After(today):
+		return &SpecError{"from", fmt.Errorf("from %s is in the future", from)}
+	case end.After(today):
+		return &SpecError{"to", fmt.Errorf("to %s is in the future", to)}
+	case start.After(end):
+		return &SpecError{"from", fmt.Errorf("from %s is after to %s", from, to)}
+	}
+	q.From = start
+	if end.Before(today) {
+		q.To = end.AddDate(0, 0, 1)
+	}
+	// whole days back to the start, counting today, as Days would
+	q.Days = int(today.Sub(start).Hours()/24+0.5) + 1
+	return nil
+}
+
+// Input file formats: formatText is one topic per line as described at
+// readUsersFile, formatJSON an array of TopicSpec objects.
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// inputFormat is the format of the input file at path: format when it is
+// set, else JSON for a .json file and text for anything else.
+func inputFormat(path, format string) string {
+	if format != "" {
+		return format
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return formatJSON
+	}
+	return formatText
+}
+
+// readUsersFile parses the input file filename, in the format opts give
+// or the one its name suggests (see inputFormat). In the text format each
+// line is one topic, its fields separated by commas as in a CSV file.
+// Regular lines are
+// "topic,days,maxItems[,language[,sortBy[,domains]]]", where domains is a
+// semicolon-separated host list with "-" marking hosts to exclude. The topic
+// may start with a provider prefix like "hn:golang" and may end in a
+// searchIn suffix like "rust!title" (see parseSearchIn). A line
+// with an empty days field is a top-headlines request:
+// "topic,,maxItems[,country[,category]]". Any field after the first three
+// may instead be "rss=<url>;<url>", which sends the topic to the rss provider
+// with those feeds. Fields left out fall back to the values in defaults.
+// A first line starting "topic,days" is a header and skipped, as is the
+// first line whatever it holds when opts.Header is set.
+// The JSON format is described at readJSONTopics.
+//
+// Topics that fail validation are left out of the result and returned as
+// the list of InputErrors instead; the error is for a file that can't be
+// read at all.
+func readUsersFile(filename string, opts InputOptions, defaults NewsQuery) ([]NewsQuery, []*InputError, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	if inputFormat(filename, opts.Format) == formatJSON {
+		return readJSONTopics(file, filename, defaults)
+	}
+	return readTopics(file, opts.Header, defaults)
+}
+
+// InputOptions say how to read input files.
+type InputOptions struct {
+	// Format is formatText or formatJSON; empty picks it by file extension.
+	Format string
+	// Header says a text file's first line is a header row even when it
+	// doesn't start with topic,days.
+	Header bool
+}
+
+// An InputError is a topic of an input file that failed validation.
+type InputError struct {
+	// Where is "line N" in a text file and "element N" in a JSON one.
+	Where string
+	// Text is the offending line or element.
+	Text string
+	Err  error
+}
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("%s: %v: %s", e.Where, e.Err, e.Text)
+}
+
+func (e *InputError) Unwrap() error { return e.Err }
+
+// reportInputErrors prints the topics of input that failed validation.
+func reportInputErrors(w io.Writer, input string, invalid []*InputError) {
+	if len(invalid) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Skipping invalid topics in %s (%d):\n", input, len(invalid))
+	for _, e := range invalid {
+		fmt.Fprintf(w, "  %v\n", e)
+	}
+}
+
+// readTopics reads topics in the text input file format from r; header
+// is as for InputOptions.
+func readTopics(r io.Reader, header bool, defaults NewsQuery) ([]NewsQuery, []*InputError, error) {
+	var topics []NewsQuery
+	var invalid []*InputError
+	parse := func(line string) (NewsQuery, error) { return parseTopicLine(line, defaults) }
+	err := scanTopics(r, header, parse, func(q NewsQuery) { topics = append(topics, q) },
+		func(e *InputError) { invalid = append(invalid, e) })
+	return topics, invalid, err
+}
+
+// scanTopics parses r line by line with parse, calling topic for each valid
+// line as soon as it has been read and invalid for each line parse
+// rejects. The first non-empty line is skipped when header is set or it
+// looks like a header row (see isHeaderLine).
+func scanTopics(r io.Reader, header bool, parse func(line string) (NewsQuery, error),
+	topic func(NewsQuery), invalid func(*InputError)) error {
+	scanner := bufio.NewScanner(r)
+	first := true
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if first {
+			first = false
+			if header || isHeaderLine(line) {
+				continue
+			}
+		}
+		q, err := parse(line)
+		var warning *TopicWarning
+		if errors.As(err, &warning) {
+			fmt.Fprintf(console, "Warning: line %d: %v: %s\n", n, warning, line)
+			err = nil
+		}
+		if err != nil {
+			invalid(&InputError{Where: fmt.Sprintf("line %d", n), Text: line, Err: err})
+			continue
+		}
+		topic(q)
+	}
+	return scanner.Err()
+}
+
+// isHeaderLine reports whether line is a CSV header row naming the
+// columns, such as "topic,days,maxItems,language".
+func isHeaderLine(line string) bool {
+	fields, err := splitFields(line)
+	return err == nil && len(fields) >= 2 && strings.EqualFold(fields[0], "topic") && strings.EqualFold(fields[1], "days")
+}
+
+// errFieldCount is parseTopicLine's error for a line with too few or too
+// many fields.
+var errFieldCount = errors.New("wrong number of fields")
+
+// parseTopicLine parses one non-empty line in the input file format
+// described at readUsersFile.
+// A line with option keys parseTopicLine doesn't know is still parsed:
+// the query is returned together with a *TopicWarning naming them.
+func parseTopicLine(line string, defaults NewsQuery) (NewsQuery, error) {
+	spec, warnings, err := parseTopicSpec(line)
+	if err != nil {
+		return NewsQuery{}, err
+	}
+	q, err := spec.Query(defaults)
+	var warning *TopicWarning
+	if errors.As(err, &warning) {
+		warnings, err = append(warnings, warning.Msgs...), nil
+	}
+	if err == nil && len(warnings) > 0 {
+		err = &TopicWarning{warnings}
+	}
+	return q, err
+}
+
+// A TopicWarning lists what parseTopicLine ignored in a line it could
+// otherwise use.
+type TopicWarning struct {
+	Msgs []string
+}
+
+func (w *TopicWarning) Error() string { return strings.Join(w.Msgs, "; ") }
+
+// parseTopicSpec splits one input line into the fields of a TopicSpec,
+// returning warnings for the option keys it ignored.
+func parseTopicSpec(line string) (TopicSpec, []string, error) {
+	parts, err := splitFields(line)
+	if err != nil {
+		return TopicSpec{}, nil, err
+	}
+	var spec TopicSpec
+	var options []string
+	positional := make([]string, 0, len(parts))
+	for i, p := range parts {
+		switch {
+		case i >= 3 && strings.HasPrefix(strings.ToLower(p), "rss="):
+			spec.Feeds = append(spec.Feeds, splitFeeds(p[len("rss="):])...)
+			continue
+		case i >= 3 && strings.Contains(p, "="):
+			options = append(options, p)
+			continue
+		}
+		positional = append(positional, p)
+	}
+	parts = positional
+	// an empty days field asks for top-headlines, unless from= and to=
+	// give the window instead
+	topHeadlines := len(parts) >= 3 && parts[1] == "" && !hasRangeOption(options)
+	maxFields := 6
+	if topHeadlines {
+		maxFields = 5
+	}
+	if len(parts) < 3 || len(parts) > maxFields {
+		return TopicSpec{}, nil, fmt.Errorf("%w: got %d, want 3 to %d", errFieldCount, len(parts), maxFields)
+	}
+	user, topic := parseUserPrefix(parts[0])
+	topic, spec.Refresh = strings.CutPrefix(topic, "!")
+	spec.Provider, topic = parseProviderPrefix(strings.TrimSpace(topic))
+	spec.User = user
+	if spec.MaxItems, err = strconv.Atoi(parts[2]); err != nil {
+		return TopicSpec{}, nil, fmt.Errorf("maxItems %q is not a whole number", parts[2])
+	}
+	if topHeadlines {
+		spec.Endpoint = EndpointTopHeadlines
+		if len(parts) > 3 {
+			spec.Country = parts[3]
+		}
+		if len(parts) > 4 {
+			spec.Category = parts[4]
+		}
+	} else {
+		var searchIn string
+		if topic, searchIn, err = parseSearchIn(topic); err != nil {
+			return TopicSpec{}, nil, err
+		}
+		if searchIn != "" {
+			spec.SearchIn = strings.Split(searchIn, ",")
+		}
+		if parts[1] != "" {
+			if spec.Days, err = strconv.Atoi(parts[1]); err != nil {
+				return TopicSpec{}, nil, fmt.Errorf("days %q is not a whole number", parts[1])
+			}
+		}
+		if len(parts) > 3 {
+			spec.Language = parts[3]
+		}
+		if len(parts) > 4 {
+			spec.SortBy = parts[4]
+		}
+		if len(parts) > 5 && parts[5] != "" {
+			domains, exclude := parseDomains(parts[5])
+			spec.Domains, spec.ExcludeDomains = strings.Split(domains, ","), strings.Split(exclude, ",")
+		}
+	}
+	spec.Topic = topic
+	var warnings []string
+	for _, field := range options {
+		w, err := spec.setOptions(field)
+		if err != nil {
+			return TopicSpec{}, nil, err
+		}
+		warnings = append(warnings, w...)
+	}
+	return spec, warnings, nil
+}
+
+// hasRangeOption reports whether the option fields of a line set from or
+// to.
+func hasRangeOption(options []string) bool {
+	for _, field := range options {
+		for _, opt := range strings.Split(field, ";") {
+			key, _, _ := strings.Cut(opt, "=")
+			if key = strings.ToLower(strings.TrimSpace(key)); key == "from" || key == "to" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setOptions applies an option field of an input line, such as
+// "lang=en;provider=hn;maxage=2h", over what the positional fields set.
+// Keys are case-insensitive: lang (or language), provider, sortby,
+// domains, which may be repeated and excludes a host written with a
+// leading "-", maxage, timeout, priority, and from and to, which replace
+// the days field with a range of dates. Unknown keys are returned as
+// warnings.
+func (s *TopicSpec) setOptions(field string) (warnings []string, err error) {
+	var domains []string
+	for _, opt := range strings.Split(field, ";") {
+		if opt = strings.TrimSpace(opt); opt == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(opt, "=")
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("option %q: want key=value", opt)
+		}
+		switch key {
+		case "lang", "language":
+			s.Language = value
+		case "provider":
+			s.Provider = value
+		case "sortby":
+			s.SortBy = value
+		case "domains":
+			domains = append(domains, value)
+		case "maxage":
+			s.MaxAge = value
+		case "timeout":
+			s.Timeout = value
+		case "priority":
+			s.Priority = value
+		case "from":
+			s.From = value
+		case "to":
+			s.To = value
+		default:
+			warnings = append(warnings, fmt.Sprintf("unknown option %q", key))
+		}
+	}
+	if domains != nil {
+		include, exclude := parseDomains(strings.Join(domains, ","))
+		s.Domains, s.ExcludeDomains = strings.Split(include, ","), strings.Split(exclude, ",")
+	}
+	return warnings, nil
+}
+
+// parseUserPrefix splits a `user|` prefix off the first field of an input
+// line. User IDs are letters, digits and . _ - @; anything else before a |
+// is part of the topic.
+func parseUserPrefix(field string) (user, rest string) {
+	user, rest, ok := strings.Cut(field, "|")
+	user = strings.TrimSpace(user)
+	if !ok || user == "" || len(user) > 64 || strings.TrimSpace(rest) == "" {
+		return "", field
+	}
+	for _, r := range user {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("._-@", r) {
+			return "", field
+		}
+	}
+	return user, strings.TrimSpace(rest)
+}
+
+// splitFields splits an input line into its trimmed fields as a CSV
+// record: a field wholly in double quotes may hold commas, with "" for a
+// quote character, and loses its quotes, so "inflation, interest rates"
+// is one topic. A line that isn't valid CSV because of quotes inside a
+// field, such as `"climate change" AND policy,7,10`, is split by
+// splitQuoted instead.
+func splitFields(line string) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(line))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	fields, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if errors.Is(err, csv.ErrBareQuote) || errors.Is(err, csv.ErrQuote) {
+		return splitQuoted(line)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range fields {
+		fields[i] = strings.TrimSpace(f)
+	}
+	return fields, nil
+}
+
+// splitQuoted splits an input line on commas that are not inside double
+// quotes and trims each field. Quotes are kept, so a topic such as
+// `"climate change" AND policy` reaches NewsAPI as an exact-phrase query.
+func splitQuoted(line string) ([]string, error) {
+	var fields []string
+	var cur strings.Builder
+	inQuote := false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			cur.WriteRune(r)
+		case r == ',' && !inQuote:
+			fields = append(fields, strings.TrimSpace(cur.String()))
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	return append(fields, strings.TrimSpace(cur.String())), nil
+}
+
+// normalizeTopic trims a topic, collapses runs of internal whitespace and
+// NFC-normalizes it, so multi-word topics like "machine   learning" map to a
+// single query. Case, quotes and AND/OR/NOT operators are left as written;
+// this is the form shown in the output and sent to providers.
+func normalizeTopic(topic string) string {
+	return strings.Join(strings.Fields(norm.NFC.String(topic)), " ")
+}
+
+// queryKey is the form of a topic the cache stores and matches on:
+// normalizeTopic's, lowercased so "Bitcoin" and "bitcoin" share cached
+// results. The AND, OR and NOT operators stay uppercase, since to NewsAPI
+// "cats AND dogs" is a different search from "cats and dogs".
+func queryKey(topic string) string {
+	words := strings.Fields(normalizeTopic(topic))
+	for i, w := range words {
+		switch w {
+		case "AND", "OR", "NOT":
+		default:
+			words[i] = strings.ToLower(w)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// cacheQuery is q's topic as the cache stores it.
+func (q NewsQuery) cacheQuery() string {
+	return queryKey(q.Query)
+}
+
+// topicLabel is the quoted topic shown in section headers, annotated with
+// the country/category scope for top-headlines queries, the dates of an
+// absolute range and any searchIn restriction.
+func topicLabel(q NewsQuery) string {
+	label := "\"" + q.Query + "\""
+	if q.endpoint() == EndpointTopHeadlines {
+		label += fmt.Sprintf(" [top-headlines %s/%s]", q.Country, q.Category)
+	}
+	if q.ranged() {
+		label += " [" + rangeLabel(q) + "]"
+	}
+	if q.SearchIn != "" {
+		label += " [in " + q.SearchIn + "]"
+	}
+	return label
+}
+
+// rangeLabel is the date range of a ranged q, such as
+// "2024-03-01 to 2024-03-15", its last day inclusive.
+func rangeLabel(q NewsQuery) string {
+	to := "today"
+	if !q.To.IsZero() {
+		to = q.To.AddDate(0, 0, -1).Format(time.DateOnly)
+	}
+	return q.From.Format(time.DateOnly) + " to " + to
+}
+
+// resultMeta renders the publisher and publication date after a result line,
+// leaving out whichever is unknown (e.g. for rows cached before they were
+// recorded).
+func resultMeta(r NewsResult, showProvider bool) string {
+	var meta []string
+	if showProvider && r.Source != "" {
+		meta = append(meta, "via "+r.Source)
+	}
+	if r.SourceName != "" {
+		meta = append(meta, r.SourceName)
+	}
+	if !r.PublishedAt.IsZero() {
+		meta = append(meta, r.PublishedAt.Format("2006-01-02 15:04"))
+	}
+	if len(meta) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(meta, ", ") + "]"
+}
+
+// defaultInputFile is the sample topics file used when neither -input nor
+// NEWSCLI_INPUT is set.
+var defaultInputFile = filepath.Join("Inputs(Sampel Testcases)", "user10.txt")
+
+// RunConfig is where runCLI reads topics from and writes results to.
+type RunConfig struct {
+	// Inputs are topics files or glob patterns matching them.
+	Inputs []string
+	// Read says how to read them.
+	Read InputOptions
+	// Strict stops the run when any topic fails validation, rather than
+	// skipping those.
+	Strict bool
+	// Topics, when set, are the input lines, read from the config file
+	// named by the only entry of Inputs instead of a topics file.
+	Topics []string
+	// OutputDir and OutputName are the templates naming each input file's
+	// output file; see outputPath.
+	OutputDir, OutputName string
+	// TaskTimeout bounds each topic, from queueing it to its result.
+	TaskTimeout time.Duration
+	// Once stops after the first pass instead of offering to run again.
+	Once bool
+	// Watch runs a pass each time an input file changes instead of when
+	// the user presses Enter, polling them every WatchInterval.
+	Watch         bool
+	WatchInterval time.Duration
+	// Interactive reads commands at a prompt after the first pass; see
+	// runInteractive. Days and MaxItems are for the topics added there
+	// without them.
+	Interactive    bool
+	Days, MaxItems int
+	// Format is the output format, a key of outputFormats. Template names
+	// the -template it is formatTemplate for.
+	Format, Template string
+	// Stdout prints each topic's section to stdout as soon as it completes
+	// instead of writing the output files, or as well with Tee. Messages
+	// then go to stderr; see console.
+	Stdout, Tee bool
+	// Timestamp adds the pass's start time to output file names, so each
+	// pass keeps its own, and keeps a latest link to the newest; -overwrite
+	// turns it off. KeepDays, if positive, deletes timestamped files older
+	// than that after each write.
+	Timestamp bool
+	KeepDays  int
+	// SplitByTopic writes each topic to a file of its own, and the input
+	// file's output file lists them; see writeTopicFiles.
+	SplitByTopic bool
+	// SummaryJSON, if set, is where each pass writes its SummaryJSON.
+	// FailFast gives up on a pass's remaining topics once one fails.
+	SummaryJSON string
+	FailFast    bool
+	// RetryPasses is how many times a pass retries its topics that failed
+	// with transient errors; see retryFailed.
+	RetryPasses int
+}
+
+// runCLI processes the input files, again each time the user presses
+// Enter. A file that can't be read is reported and skipped; runCLI fails
+// only if none can be read or an output file can't be written.
+func runCLI(ctx context.Context, db *gorm.DB, tasks chan<- Task, cfg RunConfig, defaults NewsQuery) error {
+	if cfg.Watch {
+		reporter.showTopics(true)
+		return watchInputs(ctx, db, tasks, cfg, defaults)
+	}
+	if cfg.Interactive {
+		return runInteractive(ctx, db, tasks, cfg, defaults)
+	}
+	reporter.showTopics(true)
+
+	next := lineReader(ctx, os.Stdin)
+
+	for {
+		_, report, err := runPassOver(ctx, db, tasks, cfg, defaults)
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		if cfg.Once {
+			return failedTopics(report.stats)
+		}
+		fmt.Fprint(console, "Press Enter to run again, or type 'exit' to quit: ")
+		input, err := next()
+		if ctx.Err() != nil {
+			fmt.Fprintln(console)
+			return context.Cause(ctx)
+		}
+		if strings.TrimSpace(strings.ToLower(input)) == "exit" || err != nil {
+			// end of input counts as exit, or a closed stdin would loop
+			fmt.Fprintln(console, "Exiting program")
+			return failedTopics(report.stats)
+		}
+	}
+}
+
+// runPassOver reads the input files and runs one pass over them, printing
+// its summary, and writes the -summary-json document. It returns the
+// inputs it read and what the pass did.
+func runPassOver(ctx context.Context, db *gorm.DB, tasks chan<- Task, cfg RunConfig, defaults NewsQuery) ([]inputFile, passReport, error) {
+	started := time.Now()
+	inputs, report, err := runInputsOver(ctx, db, tasks, cfg, defaults)
+	if cfg.SummaryJSON != "" {
+		if serr := writeSummaryJSON(cfg.SummaryJSON, started, report, err); serr != nil {
+			fmt.Fprintln(consoleErr, "Warning:", serr)
+		}
+	}
+	return inputs, report, err
+}
+
+func runInputsOver(ctx context.Context, db *gorm.DB, tasks chan<- Task, cfg RunConfig, defaults NewsQuery) ([]inputFile, passReport, error) {
+	inputs, err := readInputs(cfg, defaults)
+	if err != nil {
+		return nil, passReport{}, err
+	}
+	report, err := runInputs(ctx, db, tasks, cfg, inputs)
+	if err != nil {
+		return inputs, report, err
+	}
+	if len(inputs) > 1 {
+		fmt.Fprintf(console, "Total over %d input files: ", len(inputs))
+	}
+	printPassSummary(console, report.stats)
+	return inputs, report, nil
+}
+
+// inputFile is one input file of a pass, its topics and the ones that
+// failed validation.
+type inputFile struct {
+	path    string
+	topics  []NewsQuery
+	invalid []*InputError
+}
+
+// readInputs reads the topics of every input file, expanding glob
+// patterns anew each pass so files added since are picked up. Files that
+// can't be read are reported and left out.
+func readInputs(cfg RunConfig, defaults NewsQuery) ([]inputFile, error) {
+	var inputs []inputFile
+	var errs []error
+	if cfg.Topics != nil {
+		topics, invalid, err := readTopics(strings.NewReader(strings.Join(cfg.Topics, "\n")), false, defaults)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, inputFile{cfg.Inputs[0], topics, invalid})
+	} else {
+		var paths []string
+		paths, errs = expandInputs(cfg.Inputs)
+		for _, path := range paths {
+			topics, invalid, err := readUsersFile(path, cfg.Read, defaults)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			inputs = append(inputs, inputFile{path, topics, invalid})
+		}
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("reading input file: %w", errors.Join(errs...))
+	}
+	for _, err := range errs {
+		fmt.Fprintln(consoleErr, "Skipping input file:", err)
+	}
+	invalid := 0
+	for _, in := range inputs {
+		reportInputErrors(consoleErr, in.path, in.invalid)
+		invalid += len(in.invalid)
+	}
+	if cfg.Strict && invalid > 0 {
+		return nil, fmt.Errorf("%d invalid topics in the input; stopping because of -strict", invalid)
+	}
+	return inputs, nil
+}
+
+// expandInputs turns the -input values into file paths, in order and
+// without repeats; an entry with *, ? or [ in it is a glob pattern.
+func expandInputs(patterns []string) ([]string, []error) {
+	var paths []string
+	var errs []error
+	seen := map[string]bool{}
+	for _, p := range patterns {
+		matches := []string{p}
+		if strings.ContainsAny(p, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(p); err != nil || len(matches) == 0 {
+				errs = append(errs, fmt.Errorf("%s: no files match", p))
+				continue
+			}
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				paths = append(paths, m)
+			}
+		}
+	}
+	return paths, errs
+}
+
+// runInputs fetches the topics of all inputs as one run, then writes one
+// output file per input and prints each file's summary. Topics that
+// differ only in days, maxItems, timeout, user or the case and spacing of
+// the topic, in one file or across several, are coalesced into one task
+// for the largest window, count and timeout, and each is answered from
+// its results (see narrowResult).
+func runInputs(ctx context.Context, db *gorm.DB, tasks chan<- Task, cfg RunConfig, inputs []inputFile) (passReport, error) {
+	var unique []NewsQuery
+	index := map[NewsQuery]int{}
+	for _, in := range inputs {
+		for _, q := range in.topics {
+			key := coalesceKey(q)
+			i, ok := index[key]
+			if !ok {
+				index[key] = len(unique)
+				unique = append(unique, q)
+				continue
+			}
+			if q.endpoint() != EndpointTopHeadlines {
+				unique[i].Days = max(unique[i].Days, q.Days)
+			}
+			unique[i].MaxItems = max(unique[i].MaxItems, q.MaxItems)
+			// the longest timeout wins, the run's for topics without one
+			unique[i].Timeout = max(cmp.Or(unique[i].Timeout, cfg.TaskTimeout), cmp.Or(q.Timeout, cfg.TaskTimeout))
+			unique[i].Priority = max(unique[i].Priority, q.Priority)
+		}
+	}
+	stats, callsBefore := startRun(db, strings.Join(cfg.Inputs, ","))
+	ctx = withRun(ctx, stats.ID)
+	var report passReport
+	var runTopics []RunTopic
+	var errs []error
+	used := map[string]bool{}
+	writeFiles := !cfg.Stdout || cfg.Tee
+	// jsonl files are streamed like stdout, unless split into files per topic
+	streamFiles := cfg.Format == formatJSONL && writeFiles && !cfg.SplitByTopic
+	var onDone func(int, TaskResult)
+	var streams []*jsonlFile
+	var stdout *jsonlStream
+	switch {
+	case cfg.Stdout && cfg.Format == formatText:
+		onDone = printAnswered(inputs, index)
+	case cfg.Format == formatJSONL:
+		if cfg.Stdout {
+			stdout = &jsonlStream{w: os.Stdout}
+		}
+		streams = make([]*jsonlFile, len(inputs))
+		if streamFiles {
+			for k, in := range inputs {
+				f, err := createJSONLFile(cfg, in.path, stats.StartedAt, used)
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				streams[k] = f
+			}
+		}
+		onDone = streamAnswered(inputs, index, streams, stdout)
+	}
+	fetchCtx := ctx
+	if cfg.FailFast {
+		var cancel context.CancelCauseFunc
+		fetchCtx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		onDone = failFast(unique, cancel, onDone)
+	}
+	fetched := fetchAll(fetchCtx, tasks, unique, cfg.TaskTimeout, onDone)
+	retryFailed(fetchCtx, tasks, unique, fetched, cfg.RetryPasses, cfg.TaskTimeout, onDone)
+	stats.measure(callsBefore)
+	stats.interrupted = ctx.Err() != nil
+
+	for k, in := range inputs {
+		results := make([]TaskResult, len(in.topics))
+		for i, q := range in.topics {
+			results[i] = narrowResult(fetched[index[coalesceKey(q)]], q)
+		}
+		out := fileOutput{input: in, results: results, stats: stats.run(RunStats{ID: stats.ID}), started: stats.StartedAt}
+		topics := tallyResults(in.topics, results, &out.stats)
+		for i, r := range results {
+			if r.Err != nil {
+				report.failed = append(report.failed, failedTopic{in.path, in.topics[i], r.Err})
+			}
+		}
+		switch {
+		case cfg.Format == formatJSONL:
+			// the topics were streamed as they completed
+			if stdout != nil {
+				stdout.write([]any{jsonlSummary(out)})
+			}
+			if streamFiles {
+				f := streams[k]
+				if f == nil {
+					continue // creating its file failed
+				}
+				if err := f.finish(cfg, out); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				report.outputs = append(report.outputs, f.path)
+			}
+		case cfg.Stdout && cfg.Format != formatText:
+			// text is streamed too; other formats are whole documents
+			if err := outputFormats[cfg.Format].write(os.Stdout, out); err != nil {
+				errs = append(errs, fmt.Errorf("writing to stdout: %w", err))
+			}
+		}
+		if writeFiles && !streamFiles {
+			paths, err := writeOutputFile(cfg, out, used)
+			report.outputs = append(report.outputs, paths...)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		fileStats := out.stats
+		runTopics = append(runTopics, topics...)
+		stats.add(fileStats)
+		if len(inputs) > 1 {
+			fmt.Fprintln(console, "  "+fileStats.summary())
+		}
+	}
+	if stdout != nil && stdout.err != nil {
+		errs = append(errs, fmt.Errorf("writing to stdout: %w", stdout.err))
+	}
+	if saved := stats.Topics - len(unique); saved > 0 {
+		fmt.Fprintf(console, "Coalesced %d duplicate topics into the %d fetched, saving up to %d API calls\n", saved, len(unique), saved)
+	}
+	finishRun(db, &stats, callsBefore, runTopics)
+	report.stats = stats
+	return report, errors.Join(errs...)
+}
+
+// failFast wraps the fetchAll callback onDone of runInputs for -fail-fast:
+// the first topic of topics to fail cancels the rest with cancel, the
+// cause naming that topic.
+func failFast(topics []NewsQuery, cancel context.CancelCauseFunc, onDone func(int, TaskResult)) func(int, TaskResult) {
+	return func(i int, r TaskResult) {
+		if r.Err != nil {
+			cancel(fmt.Errorf("skipped: -fail-fast after %s failed", topicLabel(topics[i])))
+		}
+		if onDone != nil {
+			onDone(i, r)
+		}
+	}
+}
+
+// writeOutputFile writes out to its output file in cfg.Format, and then
+// updates the latest link and deletes old files as cfg says. It returns
+// the paths written.
+func writeOutputFile(cfg RunConfig, out fileOutput, used map[string]bool) ([]string, error) {
+	if cfg.SplitByTopic {
+		return writeTopicFiles(cfg, out, used)
+	}
+	if write := outputFormats[cfg.Format].writeFiles; write != nil {
+		paths, err := write(cfg, out)
+		if len(paths) > 0 {
+			fmt.Fprintf(consoleErr, "Execution completed. Results stored in %d %s files in %s\n", len(paths), cfg.Format,
+				filepath.Dir(paths[0]))
+		}
+		return paths, err
+	}
+	outFile, latest := outputPath(cfg, out.input.path, out.started, used)
+	if err := os.MkdirAll(filepath.Dir(outFile), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("creating the output directory: %w", err)
+	}
+	err := writeFileAtomic(outFile, func(w io.Writer) error {
+		return outputFormats[cfg.Format].write(w, out)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("writing output file: %w", err)
+	}
+	fmt.Fprintf(consoleErr, "Execution completed. Results stored in %s\n", outFile)
+	return []string{outFile}, finishOutputFile(cfg, outFile, latest)
+}
+
+// finishOutputFile updates the latest link to the output file just
+// written and deletes old files as cfg says.
+func finishOutputFile(cfg RunConfig, outFile, latest string) error {
+	var errs []error
+	if latest != "" {
+		if err := updateLatest(outFile, latest); err != nil {
+			errs = append(errs, err)
+		}
+		if cfg.KeepDays > 0 {
+			deleted, err := pruneRuns(latest, cfg.KeepDays)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("deleting old output files: %w", err))
+			}
+			if deleted > 0 {
+				fmt.Fprintf(console, "Deleted %d output files older than %d days\n", deleted, cfg.KeepDays)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// printAnswered returns the fetchAll callback of -stdout: when the task at
+// index i of runInputs' coalesced list completes, it prints the section of
+// every topic of inputs the task answers to stdout, in input order. A
+// callback's sections are written in one go under a lock, so those of
+// tasks completing together never interleave.
+func printAnswered(inputs []inputFile, index map[NewsQuery]int) func(int, TaskResult) {
+	answers := map[int][]NewsQuery{}
+	for _, in := range inputs {
+		for _, q := range in.topics {
+			i := index[coalesceKey(q)]
+			answers[i] = append(answers[i], q)
+		}
+	}
+	var mu sync.Mutex
+	return func(i int, r TaskResult) {
+		var section bytes.Buffer
+		for _, q := range answers[i] {
+			writeResult(&section, q, narrowResult(r, q))
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		os.Stdout.Write(section.Bytes())
+	}
+}
+
+// coalesceKey is what topics answered by one task have in common: all of
+// the query but its window, size, user, timeout and priority, with the
+// topic in the form the cache matches on.
+func coalesceKey(q NewsQuery) NewsQuery {
+	q.Query = q.cacheQuery()
+	q.Days, q.MaxItems, q.UserID, q.Timeout, q.Priority = 0, 0, "", 0, PriorityNormal
+	return q
+}
+
+// narrowResult cuts r, fetched for the coalesced task q is part of, down
+// to what q asked for: the articles published in q's window, at most
+// q.MaxItems of them. Articles without a date are kept.
+func narrowResult(r TaskResult, q NewsQuery) TaskResult {
+	if len(r.Results) == 0 {
+		return r
+	}
+	since := q.windowStart()
+	results := make([]NewsResult, 0, min(len(r.Results), q.MaxItems))
+	for _, a := range r.Results {
+		if len(results) == q.MaxItems {
+			break
+		}
+		if !a.PublishedAt.IsZero() && (a.PublishedAt.Before(since) || !q.To.IsZero() && !a.PublishedAt.Before(q.To)) {
+			continue
+		}
+		results = append(results, a)
+	}
+	r.Results = results
+	return r
+}
+
+// runPass fetches topics through the worker pool, each within timeout,
+// writes their results to w and records the pass as a run of input. The
+// results are indexed like topics.
+func runPass(ctx context.Context, db *gorm.DB, tasks chan<- Task, input string, topics []NewsQuery, timeout time.Duration, w io.Writer) (RunStats, []TaskResult) {
+	stats, callsBefore := startRun(db, input)
+	ctx = withRun(ctx, stats.ID)
+	results := fetchAll(ctx, tasks, topics, timeout, nil)
+	stats.interrupted = ctx.Err() != nil
+	runTopics := writeResults(w, topics, results, &stats)
+	if note := stats.budgetNote(); note != "" {
+		fmt.Fprintln(w, note)
+	}
+	finishRun(db, &stats, callsBefore, runTopics)
+	return stats, results
+}
+
+// fetchAll fetches topics concurrently through the worker pool, each
+// within timeout, until ctx is canceled. The results are indexed like
+// topics, so the same topic listed twice, or for two users, keeps a
+// result of its own. onDone, if not nil, is called with each result as it
+// completes, possibly from several goroutines at once.
+func fetchAll(ctx context.Context, tasks chan<- Task, topics []NewsQuery, timeout time.Duration, onDone func(int, TaskResult)) []TaskResult {
+	results := make([]TaskResult, len(topics))
+	defer reporter.startProgress(len(topics))()
+	var wgLocal sync.WaitGroup
+	for i, ut := range topics {
+		wgLocal.Add(1)
+		seq := nextTaskSeq()
+		go func(i int, u NewsQuery) {
+			defer wgLocal.Done()
+			results[i] = submitTask(ctx, tasks, u, seq, timeout)
+			reporter.topicDone(u, results[i])
+			if onDone != nil {
+				onDone(i, results[i])
+			}
+		}(i, ut)
+	}
+	wgLocal.Wait()
+	return results
+}
+
+// writeResults writes the results of topics, grouped by user when there is
+// more than one, and tallies them into stats. It returns them as topics of
+// stats' run.
+func writeResults(w io.Writer, topics []NewsQuery, results []TaskResult, stats *RunStats) []RunTopic {
+	runTopics := tallyResults(topics, results, stats)
+	writeSections(w, topics, results)
+	return runTopics
+}
+
+// tallyResults tallies the results of topics into stats and returns them
+// as topics of stats' run, in writeSections' order.
+func tallyResults(topics []NewsQuery, results []TaskResult, stats *RunStats) []RunTopic {
+	runTopics := make([]RunTopic, 0, len(topics))
+	for _, i := range byUser(topics) {
+		stats.tally(results[i])
+		runTopics = append(runTopics, newRunTopic(stats.ID, topics[i], results[i]))
+	}
+	return runTopics
+}
+
+// writeSections writes the section of each topic, grouped by user when
+// there is more than one.
+func writeSections(w io.Writer, topics []NewsQuery, results []TaskResult) {
+	grouped, section := len(topicUsers(topics)) > 1, ""
+	for _, i := range byUser(topics) {
+		u := topics[i]
+		if grouped && u.user() != section {
+			section = u.user()
+			fmt.Fprintf(w, "==== User: %s ====\n\n", section)
+		}
+		writeResult(w, u, results[i])
+	}
+}
+
+// runStream is runPass for topics read from r as they arrive: each line is
+// queued as soon as it is read, and each result is written to w as soon as
+// it is in, so results come in the order topics complete. The run ends at
+// EOF, once every queued topic has been answered.
+func runStream(ctx context.Context, db *gorm.DB, tasks chan<- Task, input string, r io.Reader, parse func(line string) (NewsQuery, error),
+	timeout time.Duration, w io.Writer) (RunStats, error) {
+	stats, callsBefore := startRun(db, input)
+	ctx = withRun(ctx, stats.ID)
+	type answered struct {
+		q NewsQuery
+		r TaskResult
+	}
+	done := make(chan answered)
+	var runTopics []RunTopic
+	printed := make(chan struct{})
+	go func() {
+		defer close(printed)
+		for a := range done {
+			stats.tally(a.r)
+			runTopics = append(runTopics, newRunTopic(stats.ID, a.q, a.r))
+			writeResult(w, a.q, a.r)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	err := scanTopics(untilDone(ctx, r), false, parse, func(q NewsQuery) {
+		wg.Add(1)
+		seq := nextTaskSeq()
+		go func() {
+			defer wg.Done()
+			done <- answered{q, submitTask(ctx, tasks, q, seq, timeout)}
+		}()
+	}, func(e *InputError) { reportInputErrors(consoleErr, input, []*InputError{e}) })
+	wg.Wait()
+	close(done)
+	<-printed
+	stats.interrupted = ctx.Err() != nil
+	if note := stats.budgetNote(); note != "" {
+		fmt.Fprintln(w, note)
+	}
+	finishRun(db, &stats, callsBefore, runTopics)
+	return stats, err
+}
+
+// startRun begins recording a run of input. It returns the provider
+// request count so far, for finishRun.
+func startRun(db *gorm.DB, input string) (RunStats, int64) {
+	apiBudget.Reset()
+	started := time.Now()
+	passStart.Store(&started)
+	evictedQueries.Store(0)
+	stats := RunStats{StartedAt: started, InputFile: input, rowsBefore: rowsWritten.Load()}
+	if err := db.Create(&stats).Error; err != nil {
+		log.Printf("recording run: %v", err)
+	}
+	return stats, apiCalls.Load()
+}
+
+// finishRun records the end of the run startRun began, and purges old
+// soft-deleted rows.
+func finishRun(db *gorm.DB, stats *RunStats, callsBefore int64, topics []RunTopic) {
+	stats.measure(callsBefore)
+	finished := time.Now()
+	stats.FinishedAt = &finished
+	if err := saveRun(db, stats, topics); err != nil {
+		log.Printf("recording run: %v", err)
+	}
+	purgeAfterPass(db)
+}
+
+// submitTask queues one topic and waits for its result, giving up once
+// timeout has passed or parent is canceled. Once parent is canceled no
+// task is queued, and the worker of one already queued gets shutdownGrace
+// to answer. The error of a task canceled with a cause is that cause. seq
+// is from nextTaskSeq.
+func submitTask(parent context.Context, tasks chan<- Task, q NewsQuery, seq uint64, timeout time.Duration) TaskResult {
+	respCh := make(chan TaskResult, 1)
+	timeout = cmp.Or(q.Timeout, timeout)
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	task := Task{
+		NewsQuery: q,
+		Resp:      respCh,
+		Ctx:       ctx,
+		seq:       seq,
+		queued:    time.Now(),
+	}
+
+	if parent.Err() != nil {
+		// shutting down, or -fail-fast gave up: hand out no more tasks
+		return TaskResult{Err: context.Cause(parent)}
+	}
+	select {
+	case tasks <- task:
+	case <-ctx.Done():
+		respCh <- TaskResult{Results: nil, Source: "", Err: fmt.Errorf("timed out after %s waiting for a worker", timeout)}
+	}
+	var res TaskResult
+	select {
+	case res = <-respCh:
+	case <-parent.Done():
+		select {
+		case res = <-respCh:
+		case <-time.After(shutdownGrace):
+			res = TaskResult{Err: fmt.Errorf("%w: the worker was still busy %s later", context.Cause(parent), shutdownGrace)}
+		}
+	}
+	switch {
+	case res.Err == nil:
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		res.Err = &TimeoutError{After: timeout, Err: res.Err}
+	case ctx.Err() != nil && context.Cause(parent) != context.Canceled:
+		res.Err = context.Cause(parent)
+	}
+	return res
+}
+
+// A TimeoutError is the error of a task whose deadline passed, whatever
+// the provider made of the cancellation.
+type TimeoutError struct {
+	After time.Duration
+	Err   error
+}
+
+func (e *TimeoutError) Error() string {
+	if strings.HasPrefix(e.Err.Error(), "timed out") {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("timed out after %s", e.After)
+}
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// writeResult writes one topic's section of the results.
+func writeResult(w io.Writer, u NewsQuery, r TaskResult) {
+	label := topicLabel(u)
+	if r.Err != nil {
+		fmt.Fprintf(w, "Results for %s (error: %v)\n\n", label, r.Err)
+		return
+	}
+	fmt.Fprintf(w, "Results for %s (Fetched from: %s):\n", label, r.Source)
+	if len(r.Results) == 0 {
+		if u.Domains != "" || u.ExcludeDomains != "" {
+			fmt.Fprintf(w, "- No results found matching the domain filter (domains: %q, excludeDomains: %q)\n\n",
+				u.Domains, u.ExcludeDomains)
+		} else {
+			fmt.Fprint(w, "- No results found\n\n")
+		}
+		return
+	}
+	mixed := mixedProviders(r.Results)
+	for _, res := range r.Results {
+		fmt.Fprintf(w, "- %s (%s)%s\n", res.Title, res.URL, resultMeta(res, mixed))
+	}
+	fmt.Fprint(w, "\n")
+}
+
+// printPassSummary writes the end-of-pass summary lines.
+func printPassSummary(w io.Writer, stats RunStats) {
+	fmt.Fprintln(w, stats.summary())
+	fmt.Fprintln(w, stats.timing())
+	if note := stats.budgetNote(); note != "" {
+		fmt.Fprintln(w, note)
+	}
+	if n := evictedQueries.Load(); n > 0 {
+		fmt.Fprintf(w, "Evicted %d least recently used topics from the cache to stay under its size limit\n", n)
+	}
+}
+
+// purgeAfterPass removes rows soft-deleted longer than purgeDeletedAfter
+// ago. Ctrl-C while it runs stops it after the current batch rather than
+// killing the process in the middle of a write.
+func purgeAfterPass(db *gorm.DB) {
+	if purgeDeletedAfter <= 0 {
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	n, err := purgeSoftDeleted(ctx, db, time.Now().Add(-purgeDeletedAfter))
+	if n > 0 {
+		log.Printf("purged %d soft-deleted cache rows older than %s", n, purgeDeletedAfter)
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Printf("purging soft-deleted cache rows: %v", err)
+	}
+}
+
+// mixedProviders reports whether results came from more than one provider,
+// in which case the output names each result's.
+func mixedProviders(results []NewsResult) bool {
+	for _, r := range results[1:] {
+		if r.Source != results[0].Source {
+			return true
+		}
+	}
+	return false
+}
+
+// topicUsers lists the users of topics in order of first appearance.
+func topicUsers(topics []NewsQuery) []string {
+	var users []string
+	seen := map[string]bool{}
+	for _, t := range topics {
+		if !seen[t.user()] {
+			seen[t.user()] = true
+			users = append(users, t.user())
+		}
+	}
+	return users
+}
+
+// byUser orders the indexes of topics so each user's topics come together,
+// users in order of first appearance and topics as listed.
+func byUser(topics []NewsQuery) []int {
+	var order []int
+	for _, user := range topicUsers(topics) {
+		for i, t := range topics {
+			if t.user() == user {
+				order = append(order, i)
+			}
+		}
+	}
+	return order
+}
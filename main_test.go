@@ -0,0 +1,1135 @@
+// main_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	// the per-topic lines and summaries would only clutter the test output
+	reporter.out, reporter.level = io.Discard, levelQuiet
+	// retried requests shouldn't slow the tests down
+	retryPolicy.BaseDelay, retryPolicy.MaxDelay = time.Millisecond, 10*time.Millisecond
+	os.Exit(m.Run())
+}
+
+// captureLog collects what is logged for the rest of the test.
+func captureLog(t testing.TB) *bytes.Buffer {
+	var buf bytes.Buffer
+	saved := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(saved) })
+	return &buf
+}
+
+// captureConsole sends what the console shows at the normal level to the
+// returned buffer for the rest of the test.
+func captureConsole(t testing.TB) *bytes.Buffer {
+	var buf bytes.Buffer
+	reporter.mu.Lock()
+	reporter.out, reporter.level = &buf, levelNormal
+	reporter.mu.Unlock()
+	t.Cleanup(func() {
+		reporter.mu.Lock()
+		reporter.out, reporter.level = io.Discard, levelQuiet
+		reporter.mu.Unlock()
+	})
+	return &buf
+}
+
+// stubAPI sends every provider request to handler instead of the network
+// for the rest of the test. Requests keep their path, query and headers.
+func stubAPI(t testing.TB, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	to, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := httpClient
+	httpClient = &http.Client{Transport: redirectTransport{to: to, base: srv.Client().Transport}}
+	t.Cleanup(func() { httpClient = saved })
+	return srv
+}
+
+// redirectTransport sends requests to the host of to.
+type redirectTransport struct {
+	to   *url.URL
+	base http.RoundTripper
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.to.Scheme, t.to.Host
+	return t.base.RoundTrip(req)
+}
+
+// useNewsAPIKeys gives fetchNewsAPI keys for the rest of the test.
+func useNewsAPIKeys(t testing.TB, keys ...string) {
+	saved := newsAPIKeys
+	newsAPIKeys = newKeyRing(nil, keys, time.Hour)
+	t.Cleanup(func() { newsAPIKeys = saved })
+}
+
+// openTestDB opens a migrated SQLite cache of the test's own; the
+// in-memory one is shared by every connection in the process.
+func openTestDB(t testing.TB) *gorm.DB {
+	t.Helper()
+	db, err := openDB(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatalf("opening the cache: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// startTestPool starts workers fetching from provider, stopped when the
+// test ends.
+func startTestPool(t testing.TB, db *gorm.DB, provider Provider, workers int) chan<- Task {
+	t.Helper()
+	f := &FetchFlags{Workers: workers, QueueSize: 100}
+	tasks, stop := f.startPool(db, provider)
+	t.Cleanup(stop)
+	return tasks
+}
+
+// testQuery is a one-week topic of five articles.
+func testQuery(topic string) NewsQuery {
+	return NewsQuery{Query: topic, Days: 7, MaxItems: 5}
+}
+
+// stubProvider answers every topic with its canned articles, counting the
+// calls, and fails them with err when set.
+type stubProvider struct {
+	news  []NewsResult
+	err   error
+	calls atomic.Int64
+}
+
+func (*stubProvider) Name() string { return "stub" }
+
+func (p *stubProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	p.calls.Add(1)
+	countAPICall(ctx)
+	if p.err != nil {
+		return nil, p.err
+	}
+	news := make([]NewsResult, 0, len(p.news))
+	for _, r := range p.news {
+		r.Source = p.Name()
+		news = append(news, r)
+	}
+	return news, nil
+}
+
+// slowProvider is a stubProvider whose fetches take delay, or until their
+// context is done.
+type slowProvider struct {
+	*stubProvider
+	delay time.Duration
+}
+
+func (p *slowProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	select {
+	case <-time.After(p.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return p.stubProvider.Fetch(ctx, q)
+}
+
+// stubArticles are n articles published in the last n hours.
+func stubArticles(n int) []NewsResult {
+	news := make([]NewsResult, n)
+	for i := range news {
+		news[i] = NewsResult{Title: fmt.Sprintf("Story %d", i+1), URL: fmt.Sprintf("https://example.com/%d", i+1),
+			PublishedAt: time.Now().Add(-time.Duration(i+1) * time.Hour).Truncate(time.Second)}
+	}
+	return news
+}
+
+// panicProvider is the fake provider, except that it panics when fetching
+// the topic on, as a provider tripping over a response would.
+type panicProvider struct {
+	FakeProvider
+	on string
+}
+
+func (p panicProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	if q.Query == p.on {
+		panic("panicProvider: " + q.Query)
+	}
+	return p.FakeProvider.Fetch(ctx, q)
+}
+
+func TestWorkerRecoversFromPanic(t *testing.T) {
+	db := openTestDB(t)
+	// a single worker has to survive the panic to serve the other topics
+	tasks := startTestPool(t, db, panicProvider{on: "bad"}, 1)
+	var stats RunStats
+	for _, topic := range []string{"before", "bad", "after"} {
+		r := submitTask(context.Background(), tasks, testQuery(topic), nextTaskSeq(), 5*time.Second)
+		stats.tally(r)
+		var panicked *PanicError
+		switch {
+		case topic == "bad":
+			if !errors.As(r.Err, &panicked) {
+				t.Fatalf("%s: got error %v, want a PanicError", topic, r.Err)
+			}
+			if panicked.Stack == "" || len(panicked.Stack) > maxPanicStack+len("\n...") {
+				t.Errorf("%s: stack of %d bytes, want 1 to %d", topic, len(panicked.Stack), maxPanicStack)
+			}
+		case r.Err != nil:
+			t.Fatalf("%s: %v", topic, r.Err)
+		case len(r.Results) == 0:
+			t.Fatalf("%s: no results", topic)
+		}
+	}
+	if stats.Topics != 3 || stats.Failed != 1 || stats.Panicked != 1 {
+		t.Errorf("got %d topics, %d failed, %d panicked; want 3, 1, 1", stats.Topics, stats.Failed, stats.Panicked)
+	}
+}
+
+func TestMultiProviderRecoversFromPanic(t *testing.T) {
+	q := testQuery("bad")
+	multi := MultiProvider{Providers: []Provider{panicProvider{on: "bad"}, FakeProvider{}}}
+	news, err := multi.Fetch(context.Background(), q)
+	if err != nil || len(news) == 0 {
+		t.Fatalf("with one provider left: got %d results and error %v, want results", len(news), err)
+	}
+
+	multi.Providers = multi.Providers[:1]
+	_, err = multi.Fetch(context.Background(), q)
+	var panicked *PanicError
+	if !errors.As(err, &panicked) {
+		t.Fatalf("with no provider left: got error %v, want a PanicError", err)
+	}
+}
+
+func TestFlightRecoversFromPanic(t *testing.T) {
+	g := &flightGroup{calls: make(map[string]*flight)}
+	q := testQuery("bad")
+	r, _ := g.do(context.Background(), q, func(context.Context) TaskResult { panic("boom") })
+	var panicked *PanicError
+	if !errors.As(r.Err, &panicked) || panicked.Value != "boom" {
+		t.Fatalf("got error %v, want the PanicError of boom", r.Err)
+	}
+	// the failed call must not be left in flight for the next task
+	r, shared := g.do(context.Background(), q, func(context.Context) TaskResult { return TaskResult{Source: "API"} })
+	if shared || r.Err != nil || r.Source != "API" {
+		t.Fatalf("after the panic: got %+v, shared %v; want a call of its own", r, shared)
+	}
+}
+
+func TestConcurrentMissesShareOneFetch(t *testing.T) {
+	db := openTestDB(t)
+	stub := &stubProvider{news: stubArticles(5)}
+	tasks := startTestPool(t, db, &slowProvider{stub, 100 * time.Millisecond}, 16)
+
+	const users = 50
+	results := make([]TaskResult, users)
+	var wg sync.WaitGroup
+	for i := range users {
+		wg.Go(func() {
+			q := testQuery("bitcoin")
+			q.UserID = fmt.Sprintf("user%d", i)
+			results[i] = submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second)
+		})
+	}
+	wg.Wait()
+	if n := stub.calls.Load(); n != 1 {
+		t.Errorf("the provider was called %d times, want once", n)
+	}
+	for i, r := range results {
+		if r.Err != nil || len(r.Results) != 5 {
+			t.Errorf("user%d: got %d results, error %v; want the shared 5", i, len(r.Results), r.Err)
+		}
+	}
+	if n := countRows(t, db, testQuery("bitcoin")); n != 5 {
+		t.Errorf("got %d cached rows, want one write of 5", n)
+	}
+}
+
+func TestFlightOutlivesCanceledWaiters(t *testing.T) {
+	g := &flightGroup{calls: make(map[string]*flight)}
+	q := testQuery("bitcoin")
+	release := make(chan struct{})
+	callCtx := make(chan context.Context, 1)
+	fn := func(ctx context.Context) TaskResult {
+		callCtx <- ctx
+		<-release
+		return TaskResult{Source: "API", Results: stubArticles(1)}
+	}
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	firstDone := make(chan TaskResult)
+	go func() {
+		r, _ := g.do(first, q, fn)
+		firstDone <- r
+	}()
+	ctx := <-callCtx
+	secondDone := make(chan TaskResult)
+	go func() {
+		r, shared := g.do(context.Background(), q, func(context.Context) TaskResult {
+			t.Error("a second call started")
+			return TaskResult{}
+		})
+		if !shared {
+			t.Error("the second task didn't share the call")
+		}
+		secondDone <- r
+	}()
+	// let the second task join before the first gives up
+	for {
+		g.mu.Lock()
+		waiters := g.calls[flightKey(q)].waiters
+		g.mu.Unlock()
+		if waiters == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancelFirst()
+	if r := <-firstDone; !errors.Is(r.Err, context.Canceled) {
+		t.Errorf("the canceled task got %+v, want its own cancellation", r)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("the shared call was canceled while a task still waits for it")
+	}
+	close(release)
+	if r := <-secondDone; r.Err != nil || r.Source != "API" || len(r.Results) != 1 {
+		t.Errorf("the remaining task got %+v, want the call's result", r)
+	}
+}
+
+func TestFlightCanceledWithItsLastWaiter(t *testing.T) {
+	g := &flightGroup{calls: make(map[string]*flight)}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	callDone := make(chan struct{})
+	r, _ := g.do(ctx, testQuery("bitcoin"), func(ctx context.Context) TaskResult {
+		defer close(callDone)
+		<-ctx.Done()
+		return TaskResult{Err: ctx.Err()}
+	})
+	if !errors.Is(r.Err, context.Canceled) {
+		t.Errorf("got %+v, want the cancellation", r)
+	}
+	select {
+	case <-callDone:
+	case <-time.After(time.Second):
+		t.Error("the call outlived every task waiting for it")
+	}
+}
+
+func TestQuerySyntaxRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		line, query, key string
+	}{
+		{`"climate change" AND policy NOT opinion,7,10`, `"climate change" AND policy NOT opinion`, `"climate change" AND policy NOT opinion`},
+		// quotes around the whole field only protect its commas
+		{`"a, b, and c",3,5`, `a, b, and c`, `a, b, and c`},
+		{`(Rust OR Go) AND ("memory safety" OR gc) NOT "C++",3,5`, `(Rust OR Go) AND ("memory safety" OR gc) NOT "C++"`,
+			`(rust OR go) AND ("memory safety" OR gc) NOT "c++"`},
+		{`  "Machine   Learning"   AND  jobs ,3,5`, `"Machine Learning" AND jobs`, `"machine learning" AND jobs`},
+		{`+bitcoin -scam "price  drop",3,5`, `+bitcoin -scam "price drop"`, `+bitcoin -scam "price drop"`},
+	} {
+		t.Run(tc.line, func(t *testing.T) {
+			q, err := parseTopicLine(tc.line, NewsQuery{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.Query != tc.query {
+				t.Errorf("parsed %q, want %q", q.Query, tc.query)
+			}
+			if key := q.cacheQuery(); key != tc.key {
+				t.Errorf("cached as %q, want %q", key, tc.key)
+			}
+			var sent string
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				sent = r.URL.Query().Get("q")
+				w.Write([]byte(`{"status":"ok","articles":[]}`))
+			})
+			useNewsAPIKeys(t, "test-key")
+			if _, err := (NewsAPIProvider{}).Fetch(context.Background(), q); err != nil {
+				t.Fatal(err)
+			}
+			if sent != tc.query {
+				t.Errorf("sent %q, want %q", sent, tc.query)
+			}
+		})
+	}
+}
+
+func TestUnterminatedQuote(t *testing.T) {
+	if _, err := parseTopicLine(`"climate change,7,10`, NewsQuery{}); err == nil {
+		t.Error("parsed a line with an unterminated quote")
+	}
+}
+
+func TestReadTopicsWithOptions(t *testing.T) {
+	shown := captureConsole(t)
+	input := strings.Join([]string{
+		"golang,7,10",
+		"golang,7,10,lang=de;provider=hn;maxage=2h",
+		"rust,3,5,en,popularity",
+		"rust,3,5,en,popularity,sortby=relevancy;domains=a.com;domains=-b.com",
+		"zig,7,5,colour=blue",
+		"zig,7,5,maxage=soon",
+		"zig,7,5,lang",
+	}, "\n")
+	topics, invalid, err := readTopics(strings.NewReader(input), false, NewsQuery{Language: "en"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 5 {
+		t.Fatalf("got %d topics, want 5: %+v", len(topics), topics)
+	}
+	for i, want := range []NewsQuery{
+		{Query: "golang", Days: 7, MaxItems: 10, Language: "en"},
+		{Query: "golang", Days: 7, MaxItems: 10, Language: "de", Provider: hnProviderName, MaxAge: 2 * time.Hour},
+		{Query: "rust", Days: 3, MaxItems: 5, Language: "en", SortBy: "popularity"},
+		{Query: "rust", Days: 3, MaxItems: 5, Language: "en", SortBy: "relevancy", Domains: "a.com", ExcludeDomains: "b.com"},
+		{Query: "zig", Days: 7, MaxItems: 5, Language: "en"},
+	} {
+		want.Endpoint = EndpointEverything
+		if got := topics[i]; got != want {
+			t.Errorf("line %d: got %+v, want %+v", i+1, got, want)
+		}
+	}
+	if want := `Warning: line 5: unknown option "colour"`; !strings.Contains(shown.String(), want) {
+		t.Errorf("console %q doesn't have %q", shown, want)
+	}
+	if len(invalid) != 2 || invalid[0].Where != "line 6" || invalid[1].Where != "line 7" {
+		t.Errorf("got invalid lines %v, want lines 6 and 7", invalid)
+	}
+}
+
+func TestTopicLineValidation(t *testing.T) {
+	keep(t, &maxItemsCap)
+	maxItemsCap = 50
+	for _, tc := range []struct{ line, want string }{
+		{",7,10", "topic is empty"},
+		{"  ,7,10", "topic is empty"},
+		{"golang,0,10", "days must be at least 1, got 0"},
+		{"golang,-3,10", "days must be at least 1, got -3"},
+		{"golang,week,10", `days "week" is not a whole number`},
+		{"golang,7,0", "maxItems must be between 1 and 50, got 0"},
+		{"golang,7,51", "maxItems must be between 1 and 50, got 51"},
+		{"golang,7,ten", `maxItems "ten" is not a whole number`},
+		{"golang,7", "wrong number of fields: got 2, want 3 to 6"},
+		{"golang,7,10,en,relevancy,a.com,extra", "wrong number of fields: got 7, want 3 to 6"},
+		{"golang,7,10,lang=xx", `unsupported language code "xx"`},
+	} {
+		_, err := parseTopicLine(tc.line, NewsQuery{})
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%q: got error %v, want %q", tc.line, err, tc.want)
+		}
+	}
+	if _, err := parseTopicLine("golang,7,50", NewsQuery{}); err != nil {
+		t.Errorf("maxItems at the cap: %v", err)
+	}
+}
+
+func TestInvalidLinesReported(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "topics.txt")
+	if err := os.WriteFile(input, []byte("golang,7,5\nrust,0,5\n\nzig,7,ten\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fetch := func(flags ...string) error {
+		t.Helper()
+		args := append([]string{"fetch", "-provider", "fake", "-db", ":memory:", "-input", input, "-once",
+			"-overwrite", "-output-dir", dir}, flags...)
+		_, err := runCommand(t, args...)
+		return err
+	}
+
+	err := fetch("-strict")
+	if err == nil || !strings.Contains(err.Error(), "2 invalid topics") {
+		t.Fatalf("-strict: got error %v, want the run stopped for 2 invalid topics", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "Outputs_*")); len(files) != 0 {
+		t.Errorf("-strict wrote %v", files)
+	}
+
+	shown := captureConsole(t)
+	if err := fetch(); err != nil {
+		t.Fatal(err)
+	}
+	report := []string{
+		"Skipping invalid topics in " + input + " (2):\n",
+		"  line 2: days must be at least 1, got 0: rust,0,5\n",
+		`  line 4: maxItems "ten" is not a whole number: zig,7,ten` + "\n",
+	}
+	out := readOutput(t, dir)
+	for _, want := range append(report, "golang") {
+		if !strings.Contains(out, want) {
+			t.Errorf("output doesn't have %q:\n%s", want, out)
+		}
+	}
+	for _, want := range report {
+		if !strings.Contains(shown.String(), want) {
+			t.Errorf("console doesn't have %q:\n%s", want, shown)
+		}
+	}
+}
+
+func TestDuplicateTopicsAreCoalesced(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Now()
+	var news []NewsResult
+	for i, age := range []time.Duration{time.Hour, 2 * time.Hour, 3 * time.Hour, 5 * 24 * time.Hour, 5*24*time.Hour + time.Hour} {
+		news = append(news, NewsResult{Title: fmt.Sprintf("Story %d", i), URL: fmt.Sprintf("https://example.com/%d", i),
+			PublishedAt: now.Add(-age)})
+	}
+	stub := &recordingProvider{stubProvider: &stubProvider{news: news}}
+	tasks := startTestPool(t, db, stub, 2)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "user1")
+	if err := os.WriteFile(input, []byte("golang,7,5\nGolang,3,10\n golang ,7,2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	topics, invalid, err := readUsersFile(input, InputOptions{}, NewsQuery{})
+	if err != nil || len(invalid) > 0 {
+		t.Fatal(err, invalid)
+	}
+
+	shown := captureConsole(t)
+	cfg := RunConfig{Inputs: []string{input}, OutputDir: dir, OutputName: defaultOutputName, Format: formatJSON,
+		TaskTimeout: 5 * time.Second}
+	if _, err := runInputs(context.Background(), db, tasks, cfg, []inputFile{{path: input, topics: topics}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.queries) != 1 || stub.queries[0].Days != 7 || stub.queries[0].MaxItems != 10 {
+		t.Fatalf("got fetches %+v, want one for 7 days and 10 items", stub.queries)
+	}
+	if want := "Coalesced 2 duplicate topics into the 1 fetched, saving up to 2 API calls"; !strings.Contains(shown.String(), want) {
+		t.Errorf("console %q doesn't have %q", shown, want)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "Outputs_user1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc JSONOutput
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Topics) != 3 {
+		t.Fatalf("got %d sections, want one per line", len(doc.Topics))
+	}
+	// each line gets what it asked for out of the one fetch
+	for i, want := range []struct{ days, results int }{{7, 5}, {3, 3}, {7, 2}} {
+		if got := doc.Topics[i]; got.Params.Days != want.days || len(got.Results) != want.results {
+			t.Errorf("line %d: got %d days and %d results, want %d and %d", i+1, got.Params.Days, len(got.Results),
+				want.days, want.results)
+		}
+	}
+}
+
+func TestTopicOptionsKeepCacheEntriesApart(t *testing.T) {
+	db := openTestDB(t)
+	stub := &stubProvider{news: stubArticles(5)}
+	tasks := startTestPool(t, db, stub, 1)
+
+	for _, tc := range []struct{ line, source string }{
+		{"golang,7,5", "API"},
+		{"golang,7,5,lang=de", "API"},
+		{"golang,7,5,de", "DB"},
+		{"golang,7,5,sortby=popularity", "API"},
+		{"golang,7,5,en", "DB"},
+	} {
+		q, err := parseTopicLine(tc.line, NewsQuery{Language: "en", SortBy: "publishedAt"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second); r.Err != nil || r.Source != tc.source {
+			t.Errorf("%s: got source %q, error %v; want %s", tc.line, r.Source, r.Err, tc.source)
+		}
+	}
+	if n := stub.calls.Load(); n != 3 {
+		t.Errorf("the provider was called %d times, want 3", n)
+	}
+}
+
+func TestWorkerWithStubProvider(t *testing.T) {
+	db := openTestDB(t)
+	stub := &stubProvider{news: stubArticles(3)}
+	tasks := startTestPool(t, db, stub, 2)
+
+	q := testQuery("golang")
+	q.MaxItems = 3
+	r := submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second)
+	if r.Err != nil || r.Source != "API" || len(r.Results) != 3 {
+		t.Fatalf("first fetch: got %d results from %q, error %v; want 3 from the API", len(r.Results), r.Source, r.Err)
+	}
+	if r.APICalls != 1 {
+		t.Errorf("first fetch: %d API calls counted, want 1", r.APICalls)
+	}
+	r = submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second)
+	if r.Err != nil || r.Source != "DB" || len(r.Results) != 3 {
+		t.Fatalf("second fetch: got %d results from %q, error %v; want 3 from the cache", len(r.Results), r.Source, r.Err)
+	}
+	if n := stub.calls.Load(); n != 1 {
+		t.Errorf("the provider was called %d times, want once", n)
+	}
+}
+
+func TestCanceledTaskFreesItsWorker(t *testing.T) {
+	db := openTestDB(t)
+	p := &busyProvider{&recordingProvider{stubProvider: &stubProvider{news: stubArticles(5)}}, make(chan struct{}), make(chan struct{})}
+	tasks := startTestPool(t, db, p, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		// the fetch of busy never ends on its own
+		<-p.started
+		cancel()
+	}()
+	start := time.Now()
+	if r := submitTask(ctx, tasks, testQuery("busy"), nextTaskSeq(), time.Minute); !errors.Is(r.Err, context.Canceled) {
+		t.Errorf("busy: got %+v, want the cancellation", r)
+	}
+	// the one worker is free for the next topic at once
+	if r := submitTask(context.Background(), tasks, testQuery("golang"), nextTaskSeq(), time.Minute); r.Err != nil || r.Source != "API" {
+		t.Errorf("golang: got %+v, want it fetched", r)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("the worker took %s to get to the next topic", took)
+	}
+	var rows int64
+	if err := db.Model(&CachedSearch{}).Where("query = ?", "busy").Count(&rows).Error; err != nil || rows != 0 {
+		t.Errorf("got %d rows cached for the canceled topic, error %v; want none", rows, err)
+	}
+}
+
+func TestWorkerDoesntBlockOnAnsweredTask(t *testing.T) {
+	db := openTestDB(t)
+	tasks := startTestPool(t, db, &stubProvider{news: stubArticles(5)}, 1)
+	// a task whose Resp already holds its one result
+	resp := make(chan TaskResult, 1)
+	resp <- TaskResult{Err: errors.New("answered elsewhere")}
+	tasks <- Task{NewsQuery: testQuery("rust"), Resp: resp, Ctx: context.Background(), seq: nextTaskSeq()}
+	r := submitTask(context.Background(), tasks, testQuery("golang"), nextTaskSeq(), 5*time.Second)
+	if r.Err != nil {
+		t.Errorf("the worker got stuck on the answered task: %v", r.Err)
+	}
+	if r := <-resp; r.Err == nil || r.Err.Error() != "answered elsewhere" {
+		t.Errorf("the first answer was replaced by %+v", r)
+	}
+}
+
+// ageCachedRows makes q's cached rows look cached age ago.
+func ageCachedRows(t testing.TB, db *gorm.DB, q NewsQuery, age time.Duration) {
+	t.Helper()
+	if err := cacheScope(db, q).Model(&CachedSearch{}).Update("created", time.Now().Add(-age)).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		age     time.Duration
+		maxAge  time.Duration
+		fails   bool
+		source  string
+		fetches int64
+	}{
+		{"fresh", time.Hour, 0, false, "DB", 0},
+		{"stale", 7 * time.Hour, 0, false, "API", 1},
+		{"stale, fetch fails", 7 * time.Hour, 0, true, "DB (stale)", 1},
+		{"topic max age", 7 * time.Hour, 24 * time.Hour, false, "DB", 0},
+		{"topic max age passed", 25 * time.Hour, 24 * time.Hour, false, "API", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := openTestDB(t)
+			stub := &stubProvider{news: stubArticles(5)}
+			if tc.fails {
+				stub.err = &ProviderError{Provider: "stub", StatusCode: http.StatusServiceUnavailable, Message: "down"}
+			}
+			q := testQuery("golang")
+			q.MaxAge = tc.maxAge
+			if err := storeFetched(db, q, stubArticles(5)); err != nil {
+				t.Fatal(err)
+			}
+			ageCachedRows(t, db, q, tc.age)
+			tasks := startTestPool(t, db, stub, 1)
+			r := submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second)
+			if r.Err != nil || r.Source != tc.source || len(r.Results) != 5 {
+				t.Errorf("got %d results from %q, error %v; want 5 from %q", len(r.Results), r.Source, r.Err, tc.source)
+			}
+			if n := stub.calls.Load(); n != tc.fetches {
+				t.Errorf("%d fetches, want %d", n, tc.fetches)
+			}
+			if tc.source == "API" {
+				// the refetched rows are fresh again
+				if got := getCachedResults(db, q, q.cacheCutoff()); len(got) != 5 {
+					t.Errorf("%d fresh rows after the refetch, want 5", len(got))
+				}
+			}
+		})
+	}
+}
+
+// countRows counts q's cached rows, deleted ones included when unscoped.
+func countRows(t testing.TB, db *gorm.DB, q NewsQuery) int64 {
+	t.Helper()
+	var n int64
+	if err := cacheScope(db, q).Model(&CachedSearch{}).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestRefetchDoesNotDuplicateRows(t *testing.T) {
+	db := openTestDB(t)
+	tasks := startTestPool(t, db, &stubProvider{news: stubArticles(5)}, 1)
+	q := testQuery("golang")
+	q.Refresh = true
+	for i := 1; i <= 2; i++ {
+		if r := submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second); r.Err != nil || r.Source != "API (forced)" {
+			t.Fatalf("fetch %d: got %+v", i, r)
+		}
+		if n := countRows(t, db, q); n != 5 {
+			t.Errorf("after fetch %d: %d rows cached, want 5", i, n)
+		}
+	}
+	// storing the same articles again updates them in place
+	news := stubArticles(5)
+	for i := range news {
+		news[i].Source = "stub"
+	}
+	if err := storeFetched(db, testQuery("golang"), news); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, q); n != 5 {
+		t.Errorf("after storing again: %d rows cached, want 5", n)
+	}
+}
+
+func TestMigrateCacheEntryIndexDedupes(t *testing.T) {
+	db := openTestDB(t)
+	// a cache from before the index could hold an article many times
+	if err := db.Migrator().DropIndex(&CachedSearch{}, cacheEntryIndex); err != nil {
+		t.Fatal(err)
+	}
+	q := testQuery("golang")
+	for i := range 3 {
+		if err := db.Create(&CachedSearch{Query: "golang", Days: 7, MaxItems: 5, Endpoint: EndpointEverything,
+			Provider: newsAPIProviderName, Title: fmt.Sprintf("Copy %d", i), URL: "https://example.com/1",
+			Created: time.Now()}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countRows(t, db, q); n != 3 {
+		t.Fatalf("seeded %d rows, want 3", n)
+	}
+	if err := migrateCacheEntryIndex(db); err != nil {
+		t.Fatal(err)
+	}
+	var kept []CachedSearch
+	cacheScope(db, q).Find(&kept)
+	if len(kept) != 1 || kept[0].Title != "Copy 2" {
+		t.Errorf("kept %v, want the newest copy alone", kept)
+	}
+	if !db.Migrator().HasIndex(&CachedSearch{}, cacheEntryIndex) {
+		t.Error("the unique index wasn't created")
+	}
+}
+
+func TestCachedResultsByPublicationDate(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Now()
+	noon := func(daysAgo int) time.Time {
+		y, m, d := now.AddDate(0, 0, -daysAgo).Date()
+		return time.Date(y, m, d, 12, 0, 0, 0, time.Local)
+	}
+	ages := []time.Time{now.Add(-time.Minute), noon(1), noon(3), noon(10), noon(40)}
+	var news []NewsResult
+	for i, published := range ages {
+		news = append(news, NewsResult{Title: fmt.Sprintf("Story %d", i), URL: fmt.Sprintf("https://example.com/%d", i),
+			Source: newsAPIProviderName, PublishedAt: published})
+	}
+	// one article without a date is dated by when it was cached
+	news = append(news, NewsResult{Title: "Undated", URL: "https://example.com/undated", Source: newsAPIProviderName})
+	month := NewsQuery{Query: "golang", Days: 60, MaxItems: 10}
+	if err := storeFetched(db, month, news); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		days int
+		want int
+	}{{1, 2}, {2, 3}, {7, 4}, {30, 5}, {60, 6}} {
+		q := NewsQuery{Query: "golang", Days: tc.days, MaxItems: 10}
+		if got := getCachedResults(db, q, time.Time{}); len(got) != tc.want {
+			t.Errorf("%d days: got %d cached results, want %d", tc.days, len(got), tc.want)
+		}
+	}
+
+	// the rows of a wider fetch cover a narrower window, but too few of
+	// them fall inside it to be a hit
+	stub := &stubProvider{news: stubArticles(5)}
+	tasks := startTestPool(t, db, stub, 1)
+	q := NewsQuery{Query: "golang", Days: 2, MaxItems: 3}
+	if r := submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second); r.Err != nil || r.Source != "DB" {
+		t.Errorf("3 of 2 days: got %+v, want a cache hit", r)
+	}
+	q.MaxItems = 5
+	if r := submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second); r.Err != nil || r.Source != "API" {
+		t.Errorf("5 of 2 days: got %q, error %v, want a fetch", r.Source, r.Err)
+	}
+	if n := stub.calls.Load(); n != 1 {
+		t.Errorf("%d fetches, want 1", n)
+	}
+}
+
+// BenchmarkStoreFetched stores a few thousand articles per op the way
+// storeFetched does, in batches in one transaction, and the way it used
+// to, a row and a commit at a time.
+func BenchmarkStoreFetched(b *testing.B) {
+	const n = 2000
+	q := NewsQuery{Query: "golang", Days: 7, MaxItems: n}
+	news := stubArticles(n)
+	for i := range news {
+		news[i].Source = newsAPIProviderName
+	}
+	b.Run("batched", func(b *testing.B) {
+		db := openTestDB(b)
+		for b.Loop() {
+			if err := storeFetched(db, q, news); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("row by row", func(b *testing.B) {
+		db := openTestDB(b)
+		for b.Loop() {
+			for _, row := range cacheRows(q, news, 0) {
+				if err := db.Clauses(cacheUpsert()).Create(&row).Error; err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+// seedTopics caches n articles for each of topics topics, named "topic 1"
+// on, as storeFetched would.
+func seedTopics(tb testing.TB, db *gorm.DB, topics, n int) {
+	tb.Helper()
+	news := stubArticles(n)
+	for i := range news {
+		news[i].Source = newsAPIProviderName
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i := 1; i <= topics; i++ {
+			q := NewsQuery{Query: fmt.Sprintf("topic %d", i), Days: 7, MaxItems: n}
+			if err := insertCacheRows(tx, cacheRows(q, news, 0)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// BenchmarkCacheLookup times a topic's lookups in a cache of 100,000 rows,
+// with the query-led indexes and without any.
+func BenchmarkCacheLookup(b *testing.B) {
+	for _, indexed := range []bool{true, false} {
+		b.Run(map[bool]string{true: "indexed", false: "no indexes"}[indexed], func(b *testing.B) {
+			db := openTestDB(b)
+			seedTopics(b, db, 2000, 50)
+			if !indexed {
+				for _, idx := range []string{"idx_cached_searches_query_created", "idx_cached_searches_query_params", cacheEntryIndex} {
+					if err := db.Migrator().DropIndex(&CachedSearch{}, idx); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			q := NewsQuery{Query: "topic 1234", Days: 7, MaxItems: 50}
+			for b.Loop() {
+				if days, _ := getMaxCachedParams(db, q); days != 7 {
+					b.Fatalf("cached for %d days, want 7", days)
+				}
+				if got := getCachedResults(db, q, q.cacheCutoff()); len(got) != 50 {
+					b.Fatalf("got %d results, want 50", len(got))
+				}
+			}
+		})
+	}
+}
+
+func TestSoftDeletedRowsAreExcluded(t *testing.T) {
+	db := openTestDB(t)
+	seedTopics(t, db, 3, 5)
+	q := NewsQuery{Query: "topic 2", Days: 7, MaxItems: 5}
+	if err := cacheScope(db, q).Where("position < ?", 2).Delete(&CachedSearch{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := getCachedResults(db, q, time.Time{}); len(got) != 3 {
+		t.Errorf("got %d results, want the 3 rows not deleted", len(got))
+	}
+	if err := cacheScope(db, q).Delete(&CachedSearch{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := getCachedResults(db, q, time.Time{}); len(got) != 0 {
+		t.Errorf("got %d results of a deleted topic", len(got))
+	}
+	if days, items := getMaxCachedParams(db, q); days != 0 || items != 0 {
+		t.Errorf("a deleted topic looks cached for %d days and %d items", days, items)
+	}
+	if got, _ := getAnyCachedResults(db, q); len(got) != 0 {
+		t.Errorf("got %d results of a deleted topic in any window", len(got))
+	}
+	other := NewsQuery{Query: "topic 3", Days: 7, MaxItems: 5}
+	if got := getCachedResults(db, other, time.Time{}); len(got) != 5 {
+		t.Errorf("another topic: got %d results, want 5", len(got))
+	}
+}
+
+func TestNormalizeTopic(t *testing.T) {
+	for _, tc := range []struct{ in, topic, key string }{
+		{"bitcoin", "bitcoin", "bitcoin"},
+		{"  Bitcoin ", "Bitcoin", "bitcoin"},
+		{"BITCOIN\tprice\n", "BITCOIN price", "bitcoin price"},
+		{"climate    change", "climate change", "climate change"},
+		{"cats AND dogs", "cats AND dogs", "cats AND dogs"},
+		{"Cats and NOT Dogs", "Cats and NOT Dogs", "cats and NOT dogs"},
+		{"Café", "Café", "café"},
+		// a decomposed é composes to the same key
+		{"Cafe\u0301", "Café", "café"},
+		{"MÜNCHEN", "MÜNCHEN", "münchen"},
+		{"東京  オリンピック", "東京 オリンピック", "東京 オリンピック"},
+		{" \t ", "", ""},
+	} {
+		if got := normalizeTopic(tc.in); got != tc.topic {
+			t.Errorf("normalizeTopic(%q) = %q, want %q", tc.in, got, tc.topic)
+		}
+		if got := queryKey(tc.in); got != tc.key {
+			t.Errorf("queryKey(%q) = %q, want %q", tc.in, got, tc.key)
+		}
+	}
+}
+
+func TestTopicSpellingsShareCache(t *testing.T) {
+	db := openTestDB(t)
+	provider := &stubProvider{news: stubArticles(5)}
+	tasks := startTestPool(t, db, provider, 1)
+	for _, topic := range []string{"Bitcoin", "bitcoin", " bitcoin ", "BITCOIN"} {
+		if r := submitTask(context.Background(), tasks, testQuery(topic), nextTaskSeq(), 5*time.Second); r.Err != nil {
+			t.Fatalf("%q: %v", topic, r.Err)
+		}
+	}
+	if n := provider.calls.Load(); n != 1 {
+		t.Errorf("%d provider calls, want 1 for every spelling", n)
+	}
+}
+
+func TestMigrateQueryKeys(t *testing.T) {
+	db := openTestDB(t)
+	for _, row := range []struct{ query, url string }{
+		{"  Bitcoin ", "https://example.com/1"},
+		{"  Bitcoin ", "https://example.com/2"},
+		{"bitcoin", "https://example.com/2"}, // already in the new form, and kept
+		{"Rust  Lang", "https://example.com/3"},
+	} {
+		if err := db.Create(&CachedSearch{Query: row.query, Days: 7, MaxItems: 5, Endpoint: EndpointEverything,
+			Provider: newsAPIProviderName, Title: row.query, URL: row.url, Created: time.Now()}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := migrateQueryKeys(db); err != nil {
+		t.Fatal(err)
+	}
+	var rows []CachedSearch
+	if err := db.Order("url").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range rows {
+		got = append(got, r.Query+" "+r.URL+" "+r.Title)
+	}
+	want := []string{
+		"bitcoin https://example.com/1   Bitcoin ",
+		"bitcoin https://example.com/2 bitcoin",
+		"rust lang https://example.com/3 Rust  Lang",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got rows\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestCachedResultsAreDistinct(t *testing.T) {
+	db := openTestDB(t)
+	// duplicates as caches from before the unique index hold them
+	if err := db.Migrator().DropIndex(&CachedSearch{}, cacheEntryIndex); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Truncate(time.Second)
+	add := func(article int, url, title string, age time.Duration) {
+		t.Helper()
+		published := now.Add(-time.Duration(article) * time.Hour)
+		if err := db.Create(&CachedSearch{Query: "golang", Days: 7, MaxItems: 5, Endpoint: EndpointEverything,
+			Provider: newsAPIProviderName, Title: title, URL: url, PublishedAt: &published, Created: now.Add(-age)}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= 6; i++ {
+		url := fmt.Sprintf("https://example.com/%d", i)
+		add(i, url, fmt.Sprintf("Story %d", i), time.Hour)
+		if i <= 2 {
+			// fetched again since, the newest copy wins
+			add(i, url, fmt.Sprintf("Old story %d", i), 2*time.Hour)
+			add(i, url, fmt.Sprintf("Older story %d", i), 3*time.Hour)
+		}
+	}
+	// the same link as story 3, spelled differently, is folded into it
+	add(3, "http://www.example.com/3?utm_source=feed", "Story 3 again", 30*time.Minute)
+
+	got := getCachedResults(db, testQuery("golang"), time.Time{})
+	var titles []string
+	urls := map[string]bool{}
+	for _, r := range got {
+		titles = append(titles, r.Title)
+		if urls[r.URL] {
+			t.Errorf("%s listed twice", r.URL)
+		}
+		urls[r.URL] = true
+	}
+	want := []string{"Story 1", "Story 2", "Story 3", "Story 4", "Story 5"}
+	if fmt.Sprint(titles) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", titles, want)
+	}
+}
+
+// recordingProvider is a provider delta fetches don't know, which records
+// the queries it is asked.
+type recordingProvider struct {
+	*stubProvider
+	mu      sync.Mutex
+	queries []NewsQuery
+}
+
+func (p *recordingProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	p.mu.Lock()
+	p.queries = append(p.queries, q)
+	p.mu.Unlock()
+	return p.stubProvider.Fetch(ctx, q)
+}
+
+// cacheFiveDays caches five days' worth of golang from provider, the
+// oldest article five hours old, and returns that article's publication
+// time.
+func cacheFiveDays(t *testing.T, db *gorm.DB, provider string) time.Time {
+	t.Helper()
+	news := stubArticles(5)
+	for i := range news {
+		news[i].Source = provider
+	}
+	q := testQuery("golang")
+	q.Days = 5
+	if err := storeFetched(db, q, news); err != nil {
+		t.Fatal(err)
+	}
+	return news[4].PublishedAt
+}
+
+func TestDeltaFetch(t *testing.T) {
+	db := openTestDB(t)
+	oldest := cacheFiveDays(t, db, newsAPIProviderName)
+	var requests []url.Values
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Query())
+		fmt.Fprint(w, newsAPIArticles(10, 5, 5))
+	})
+	useNewsAPIKeys(t, "test-key")
+	keep(t, &debugLogging)
+	debugLogging = true
+	logged := captureLog(t)
+	tasks := startTestPool(t, db, NewsAPIProvider{}, 1)
+
+	q := testQuery("golang")
+	r := submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second)
+	if r.Err != nil || r.Source != "API" {
+		t.Fatalf("got %+v, want a fetch", r)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(requests))
+	}
+	// only the part of the week before the cached articles is asked for
+	wantFrom, wantTo := q.windowFrom().Format("2006-01-02"), oldest.UTC().Format("2006-01-02T15:04:05")
+	if from, to := requests[0].Get("from"), requests[0].Get("to"); from != wantFrom || to != wantTo {
+		t.Errorf("asked for %s to %s, want %s to %s", from, to, wantFrom, wantTo)
+	}
+	if !strings.Contains(logged.String(), "delta fetch from") {
+		t.Errorf("the delta fetch wasn't logged:\n%s", logged)
+	}
+
+	// the cached and fetched rows now cover the week
+	if r := submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second); r.Err != nil || r.Source != "DB" {
+		t.Errorf("second search: got %q, %v; want it cached", r.Source, r.Err)
+	}
+	if len(requests) != 1 {
+		t.Errorf("%d requests, want the week served from the cache", len(requests))
+	}
+	if !strings.Contains(logged.String(), "served from the cache") {
+		t.Errorf("the cache hit wasn't logged:\n%s", logged)
+	}
+}
+
+func TestDeltaFetchWithoutUntilSupport(t *testing.T) {
+	db := openTestDB(t)
+	cacheFiveDays(t, db, "stub")
+	keep(t, &debugLogging)
+	debugLogging = true
+	logged := captureLog(t)
+	// the same articles as cached, with the rest of the week
+	provider := &recordingProvider{stubProvider: &stubProvider{news: stubArticles(8)}}
+	tasks := startTestPool(t, db, provider, 1)
+
+	q := testQuery("golang")
+	q.MaxItems = 8
+	if r := submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second); r.Err != nil || len(r.Results) != 8 {
+		t.Fatalf("got %d results, %v; want 8", len(r.Results), r.Err)
+	}
+	if len(provider.queries) != 1 || !provider.queries[0].Until.IsZero() || provider.queries[0].Days != 7 {
+		t.Fatalf("asked %+v, want one full week", provider.queries)
+	}
+	if !strings.Contains(logged.String(), "can't fetch only the older ones; full fetch") {
+		t.Errorf("the full fetch wasn't logged:\n%s", logged)
+	}
+	// refetched articles replace their rows
+	var n int64
+	db.Model(&CachedSearch{}).Count(&n)
+	if n != 8 {
+		t.Errorf("%d rows cached, want 8", n)
+	}
+}
@@ -0,0 +1,106 @@
+// memcache.go
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -------- In-process result cache --------
+
+// resultsKey covers every parameter that selects q's cached results.
+func (q NewsQuery) resultsKey() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%d|%d|%d|%d", q.cacheQuery(), q.endpoint(), q.Country, q.Category,
+		q.Language, q.SortBy, q.Domains, q.ExcludeDomains, q.SearchIn, q.Feeds, strings.Join(q.providerFilter(), "+"),
+		q.Days, q.MaxItems, q.From.Unix(), q.To.Unix())
+}
+
+// MemoryCache remembers the results served for the most recently used
+// topics, so a topic repeated in the input file is looked up in the cache
+// DB once per process. Entries are dropped once they are older than
+// cacheMaxAge or the topic's rows change. A nil *MemoryCache caches
+// nothing.
+type MemoryCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	query   string
+	result  TaskResult
+	created time.Time
+}
+
+// memoryCache is the cache processTask consults first; main sizes it from
+// -memory-cache-size.
+var memoryCache *MemoryCache
+
+func newMemoryCache(size int) *MemoryCache {
+	return &MemoryCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Get returns the result last served for q.
+func (c *MemoryCache) Get(q NewsQuery) (TaskResult, bool) {
+	if c == nil {
+		return TaskResult{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[q.resultsKey()]
+	if !ok {
+		return TaskResult{}, false
+	}
+	e := el.Value.(*memoryEntry)
+	if cacheMaxAge > 0 && time.Since(e.created) > cacheMaxAge {
+		c.remove(el)
+		return TaskResult{}, false
+	}
+	c.order.MoveToFront(el)
+	return e.result, true
+}
+
+// Put remembers r as q's result, dropping the least recently used entry
+// when the cache is full.
+func (c *MemoryCache) Put(q NewsQuery, r TaskResult) {
+	if c == nil || len(r.Results) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := q.resultsKey()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.order.PushFront(&memoryEntry{key: key, query: q.cacheQuery(), result: r, created: time.Now()})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Invalidate forgets every entry for query, as queryKey gives it, whatever
+// its parameters.
+func (c *MemoryCache) Invalidate(query string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*memoryEntry).query == query {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+func (c *MemoryCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*memoryEntry).key)
+}
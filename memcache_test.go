@@ -0,0 +1,157 @@
+// memcache_test.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestMemoryCache(t *testing.T) {
+	c := newMemoryCache(2)
+	served := func(topic string) TaskResult {
+		return TaskResult{Results: stubArticles(1), Source: "DB " + topic}
+	}
+	week, month := testQuery("golang"), testQuery("golang")
+	month.Days = 30
+	c.Put(week, served("week"))
+	c.Put(month, served("month"))
+	if r, ok := c.Get(week); !ok || r.Source != "DB week" {
+		t.Fatalf("got %+v, %v for the week", r, ok)
+	}
+	// the month is now least recently used
+	c.Put(testQuery("rust"), served("rust"))
+	if _, ok := c.Get(month); ok {
+		t.Error("the least recently used entry wasn't dropped")
+	}
+	if _, ok := c.Get(week); !ok {
+		t.Error("the week was dropped")
+	}
+
+	c = newMemoryCache(3)
+	for _, q := range []NewsQuery{week, month, testQuery("rust")} {
+		c.Put(q, served(q.Query))
+	}
+	c.Invalidate(queryKey("golang"))
+	for _, q := range []NewsQuery{week, month} {
+		if _, ok := c.Get(q); ok {
+			t.Errorf("%d days of golang still cached after invalidating it", q.Days)
+		}
+	}
+	if _, ok := c.Get(testQuery("rust")); !ok {
+		t.Error("invalidating golang dropped rust")
+	}
+
+	keep(t, &cacheMaxAge)
+	cacheMaxAge = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := c.Get(testQuery("rust")); ok {
+		t.Error("an entry older than cacheMaxAge was served")
+	}
+
+	var nilCache *MemoryCache
+	nilCache.Put(week, served("week"))
+	if _, ok := nilCache.Get(week); ok {
+		t.Error("a nil cache served a result")
+	}
+}
+
+func TestMemoryCacheConcurrentUse(t *testing.T) {
+	c := newMemoryCache(8)
+	var wg sync.WaitGroup
+	for i := range 32 {
+		wg.Go(func() {
+			q := testQuery(fmt.Sprintf("topic %d", i%10))
+			for range 100 {
+				c.Put(q, TaskResult{Results: stubArticles(1)})
+				c.Get(q)
+				c.Invalidate(q.cacheQuery())
+			}
+		})
+	}
+	wg.Wait()
+	if n := c.order.Len(); n > 8 || n != len(c.entries) {
+		t.Errorf("%d entries in the list and %d in the map, want the same, at most 8", n, len(c.entries))
+	}
+}
+
+// countQueries counts the SELECTs db runs from now on.
+func countQueries(tb testing.TB, db *gorm.DB) *atomic.Int64 {
+	tb.Helper()
+	var n atomic.Int64
+	count := func(*gorm.DB) { n.Add(1) }
+	if err := db.Callback().Query().After("gorm:query").Register("test:count", count); err != nil {
+		tb.Fatal(err)
+	}
+	if err := db.Callback().Row().After("gorm:row").Register("test:count", count); err != nil {
+		tb.Fatal(err)
+	}
+	return &n
+}
+
+// repeatedTopics is an input file of 500 lines naming 20 topics, each
+// cached already.
+func repeatedTopics(tb testing.TB, db *gorm.DB) []NewsQuery {
+	seedTopics(tb, db, 20, 5)
+	queries := make([]NewsQuery, 500)
+	for i := range queries {
+		queries[i] = testQuery(fmt.Sprintf("topic %d", i%20+1))
+	}
+	return queries
+}
+
+func TestMemoryCacheSavesDBReads(t *testing.T) {
+	reads := map[bool]int64{}
+	for _, cached := range []bool{false, true} {
+		// a subtest each, so the first pool is stopped before the second starts
+		t.Run(map[bool]string{false: "no memory cache", true: "memory cache"}[cached], func(t *testing.T) {
+			db := openTestDB(t)
+			queries := repeatedTopics(t, db)
+			keep(t, &memoryCache)
+			memoryCache = nil
+			if cached {
+				memoryCache = newMemoryCache(100)
+			}
+			tasks := startTestPool(t, db, &stubProvider{err: fmt.Errorf("the cache should serve every topic")}, 8)
+			n := countQueries(t, db)
+			stats, _ := runPass(context.Background(), db, tasks, "topics.txt", queries, 5*time.Second, io.Discard)
+			if stats.Hits != 500 {
+				t.Fatalf("%d of 500 topics from the cache", stats.Hits)
+			}
+			reads[cached] = n.Load()
+		})
+	}
+	// every line reads the DB without the memory cache, each topic about once with it
+	if reads[false] < 2*500 || reads[true] > reads[false]/10 {
+		t.Errorf("%d DB reads with the memory cache, %d without; want a tenth at most", reads[true], reads[false])
+	}
+}
+
+// BenchmarkRepeatedTopics times a pass over 500 lines of 20 cached topics
+// with and without the memory cache, reporting the DB reads each took.
+func BenchmarkRepeatedTopics(b *testing.B) {
+	for _, cached := range []bool{false, true} {
+		b.Run(map[bool]string{false: "no memory cache", true: "memory cache"}[cached], func(b *testing.B) {
+			db := openTestDB(b)
+			queries := repeatedTopics(b, db)
+			keep(b, &memoryCache)
+			tasks := startTestPool(b, db, &stubProvider{news: stubArticles(5)}, 8)
+			n := countQueries(b, db)
+			for b.Loop() {
+				// each pass starts without the previous one's results
+				memoryCache = nil
+				if cached {
+					memoryCache = newMemoryCache(100)
+				}
+				runPass(context.Background(), db, tasks, "topics.txt", queries, 5*time.Second, io.Discard)
+			}
+			b.ReportMetric(float64(n.Load())/float64(b.N), "db-reads/op")
+		})
+	}
+}
@@ -0,0 +1,111 @@
+// migrate.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// -------- Schema migrations --------
+
+// SchemaMigration records one applied migration. The highest Version is
+// the schema version of the cache.
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+type migration struct {
+	name string
+	run  func(tx *gorm.DB) error
+}
+
+// migrations bring a cache from any earlier schema up to the current one;
+// migration i+1 is schema version i+1. Append only: never reorder or edit
+// one that has shipped. Each must be idempotent, since caches created
+// before versions were recorded start from version 0 with some of the
+// changes already in place, and the AutoMigrate in the first one creates
+// tables with today's columns.
+var migrations = []migration{
+	{"create the cache tables", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&CachedSearch{}, &APIKeyState{}, &APIUsage{}, &HTTPCacheEntry{}, &RunStats{})
+	}},
+	{"add a unique index on cached articles", migrateCacheEntryIndex},
+	{"normalize cached topics to their query key", migrateQueryKeys},
+	{"record run history", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&RunStats{}, &RunTopic{}, &CachedSearch{})
+	}},
+	{"add the search log", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&SearchLog{})
+	}},
+	{"attribute cached rows and history to users", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&CachedSearch{}, &RunTopic{}, &SearchLog{})
+	}},
+	{"count the cache rows each run writes", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&RunStats{})
+	}},
+}
+
+// schemaVersion is the version the migrations above lead to.
+var schemaVersion = len(migrations)
+
+// cacheSchemaVersion reports the schema version of db, 0 if no migration
+// has been recorded.
+func cacheSchemaVersion(db *gorm.DB) (int, error) {
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return 0, nil
+	}
+	var version int
+	err := db.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
+
+// migrateSchema applies the migrations db hasn't had yet, each in its own
+// transaction together with its schema_migrations row. A cache from a
+// newer build is refused rather than used with a schema this one doesn't
+// know.
+func migrateSchema(db *gorm.DB) error {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+	from, err := cacheSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if from > schemaVersion {
+		return fmt.Errorf("the cache has schema version %d but this newscli only knows up to %d; upgrade newscli or use another -db", from, schemaVersion)
+	}
+	fresh := from == 0 && !db.Migrator().HasTable(&CachedSearch{})
+	for i, m := range migrations {
+		version := i + 1
+		if version <= from {
+			continue
+		}
+		err := withBusyRetry(func() error {
+			return db.Transaction(func(tx *gorm.DB) error {
+				// another process may have applied it since from was read
+				var done int64
+				if err := tx.Model(&SchemaMigration{}).Where("version = ?", version).Count(&done).Error; err != nil || done > 0 {
+					return err
+				}
+				if err := m.run(tx); err != nil {
+					return err
+				}
+				return tx.Create(&SchemaMigration{Version: version, Name: m.name, AppliedAt: time.Now()}).Error
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("migrating the cache to schema version %d (%s): %w", version, m.name, err)
+		}
+	}
+	if from < schemaVersion && !fresh {
+		log.Printf("migrated the cache from schema version %d to %d", from, schemaVersion)
+	} else {
+		debugf("cache schema version %d", schemaVersion)
+	}
+	return nil
+}
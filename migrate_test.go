@@ -0,0 +1,121 @@
+// migrate_test.go
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// copyFixture copies a cache file checked into the repository to a
+// directory of the test's own, so migrating it changes nothing in the tree.
+func copyFixture(t *testing.T, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := copyFile(name, path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// distinctArticles counts the articles the cache at path holds per query
+// key, before any migration: the rows left once the migrations have
+// normalized the topics and dropped the copies.
+func distinctArticles(t *testing.T, path string) int {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}()
+	var rows []struct{ Query, URL string }
+	if err := db.Table("cached_searches").Select("query, url").Scan(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, r := range rows {
+		seen[queryKey(r.Query)+"\x00"+r.URL] = true
+	}
+	return len(seen)
+}
+
+func TestMigrateOriginalSchema(t *testing.T) {
+	// the caches the first releases wrote: news_cache.db with the original
+	// CachedSearch columns, cache.db from a build that also had a limit one
+	for _, fixture := range []string{"news_cache.db", "cache.db"} {
+		t.Run(fixture, func(t *testing.T) {
+			path := copyFixture(t, fixture)
+			want := distinctArticles(t, path)
+			logged := captureLog(t)
+			db, done := openCache(t, path)
+			defer done()
+			if msg := fmt.Sprintf("migrated the cache from schema version 0 to %d", schemaVersion); !strings.Contains(logged.String(), msg) {
+				t.Errorf("logged %q, want %q", logged.String(), msg)
+			}
+
+			if v, err := cacheSchemaVersion(db); err != nil || v != schemaVersion {
+				t.Errorf("got schema version %d, %v; want %d", v, err, schemaVersion)
+			}
+			var applied []SchemaMigration
+			db.Order("version").Find(&applied)
+			if len(applied) != len(migrations) {
+				t.Errorf("%d migrations recorded, want %d", len(applied), len(migrations))
+			}
+			for i, m := range applied {
+				if m.Version != i+1 || m.Name != migrations[i].name {
+					t.Errorf("migration %d recorded as %d %q, want %q", i+1, m.Version, m.Name, migrations[i].name)
+				}
+			}
+			var rows []CachedSearch
+			if err := db.Find(&rows).Error; err != nil {
+				t.Fatalf("reading the migrated rows: %v", err)
+			}
+			if len(rows) != want {
+				t.Errorf("%d rows after migrating, want the %d distinct articles", len(rows), want)
+			}
+			for _, r := range rows {
+				if r.Query != queryKey(r.Query) || r.Endpoint != EndpointEverything || r.Provider != newsAPIProviderName {
+					t.Errorf("row %d: query %q, endpoint %q, provider %q", r.ID, r.Query, r.Endpoint, r.Provider)
+					break
+				}
+			}
+			// the old rows still serve their topics
+			q := NewsQuery{Query: "Technology", Days: 2, MaxItems: 2}
+			if got, err := getAnyCachedResults(db, q); err != nil || len(got) != 2 {
+				t.Errorf("got %d cached Technology articles, %v; want 2", len(got), err)
+			}
+
+			// opening it again has nothing left to do
+			done()
+			db, done = openCache(t, path)
+			var n int64
+			db.Model(&SchemaMigration{}).Count(&n)
+			if n != int64(len(migrations)) {
+				t.Errorf("%d migrations recorded after reopening, want %d", n, len(migrations))
+			}
+		})
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	db, done := openCache(t, path)
+	if err := db.Create(&SchemaMigration{Version: schemaVersion + 1, Name: "from the future", AppliedAt: time.Now()}).Error; err != nil {
+		t.Fatal(err)
+	}
+	done()
+	_, err := openDB(path)
+	if err == nil || !strings.Contains(err.Error(), "upgrade newscli or use another -db") {
+		t.Errorf("got %v, want the newer schema refused", err)
+	}
+}
@@ -0,0 +1,233 @@
+// newsapi.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// -------- NewsAPI provider --------
+const newsAPIProviderName = "newsapi"
+
+// NewsAPIProvider fetches from newsapi.org with the keys configured for
+// newsAPIKeys (NEWSAPI_KEY, NEWSAPI_KEYS or NEWSAPI_KEYS_FILE).
+type NewsAPIProvider struct{}
+
+func (NewsAPIProvider) Name() string { return newsAPIProviderName }
+
+func (NewsAPIProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return fetchNewsAPI(ctx, q)
+}
+
+type NewsAPIResponse struct {
+	Status       string `json:"status"`
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+	TotalResults int    `json:"totalResults"`
+	Articles     []struct {
+		Source struct {
+			Name string `json:"name"`
+		} `json:"source"`
+		Author      string `json:"author"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		URL         string `json:"url"`
+		PublishedAt string `json:"publishedAt"`
+	} `json:"articles"`
+}
+
+// newsAPIMaxPageSize is the largest pageSize NewsAPI accepts.
+const newsAPIMaxPageSize = 100
+
+// fetchNewsAPI pages through results until q.MaxItems articles are collected
+// or the provider runs out. On error the articles gathered so far are returned
+// alongside it. Each page is sent with the next key from the ring, so the
+// daily budget is spread across keys. A rate-limited request is retried after
+// a short Retry-After that fits in ctx's deadline; otherwise the key is
+// rested and the next key tried, each key once per page at most.
+func fetchNewsAPI(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	keys := newsAPIKeys
+	if keys == nil {
+		list, err := loadNewsAPIKeys()
+		if err != nil {
+			return nil, err
+		}
+		keys = newKeyRing(nil, list, defaultKeyCooldown)
+	}
+	params := url.Values{}
+	if q.Query != "" {
+		params.Set("q", q.Query)
+	}
+	if q.endpoint() == EndpointTopHeadlines {
+		// top-headlines has no date window, only country/category scoping
+		if q.Country != "" {
+			params.Set("country", q.Country)
+		}
+		if q.Category != "" {
+			params.Set("category", q.Category)
+		}
+	} else {
+		params.Set("from", q.windowFrom().Format("2006-01-02"))
+		if until := q.fetchUntil(); !until.IsZero() {
+			params.Set("to", until.UTC().Format("2006-01-02T15:04:05"))
+		}
+		if q.Language != "" {
+			params.Set("language", q.Language)
+		}
+		if q.SortBy != "" {
+			params.Set("sortBy", q.SortBy)
+		}
+		if q.Domains != "" {
+			params.Set("domains", q.Domains)
+		}
+		if q.ExcludeDomains != "" {
+			params.Set("excludeDomains", q.ExcludeDomains)
+		}
+		if q.SearchIn != "" {
+			params.Set("searchIn", q.SearchIn)
+		}
+	}
+	pageSize := min(q.MaxItems, newsAPIMaxPageSize)
+	params.Set("pageSize", strconv.Itoa(pageSize))
+
+	news := []NewsResult{}
+	waits, rotations := 0, 0
+	for page := 1; len(news) < q.MaxItems; page++ {
+		params.Set("page", strconv.Itoa(page))
+		reqURL := "https://newsapi.org/v2/" + q.endpoint() + "?" + params.Encode()
+		var result NewsAPIResponse
+		if body, ok := httpCache.Fresh(reqURL); ok && json.Unmarshal(body, &result) == nil {
+			// served locally, so no key is picked and no quota spent
+			debugf("newsapi: page %d of %q from the HTTP cache", page, q.Query)
+		} else {
+			apiKey, err := keys.Pick()
+			if err != nil {
+				return news, err
+			}
+			result, err = fetchNewsAPIPage(ctx, reqURL, apiKey)
+			if err != nil && isRateLimited(err) {
+				if at := retryAtOf(err); !at.IsZero() && waits < maxRateLimitWaits && waitForRetry(ctx, at) {
+					waits++
+					page--
+					continue
+				}
+				// this key is spent; retry the same page with the next one,
+				// unless every key has been refused it
+				keys.Exhaust(apiKey)
+				if rotations++; rotations >= keys.Len() {
+					return news, keys.AllExhausted()
+				}
+				page--
+				continue
+			}
+			if err != nil {
+				return news, err
+			}
+			rotations = 0
+		}
+		for _, a := range result.Articles {
+			news = append(news, NewsResult{
+				Title:       a.Title,
+				URL:         a.URL,
+				Source:      newsAPIProviderName,
+				PublishedAt: parsePublishedAt(a.PublishedAt),
+				Description: a.Description,
+				Author:      a.Author,
+				SourceName:  a.Source.Name,
+			})
+			if len(news) >= q.MaxItems {
+				break
+			}
+		}
+		if len(result.Articles) < pageSize || page*pageSize >= result.TotalResults {
+			break
+		}
+	}
+	return news, nil
+}
+
+// fetchNewsAPIPage requests reqURL with apiKey in the X-Api-Key header,
+// keeping the key out of URLs that are logged or cached. A stale httpCache
+// entry is revalidated and reused on 304 Not Modified.
+func fetchNewsAPIPage(ctx context.Context, reqURL, apiKey string) (NewsAPIResponse, error) {
+	var result NewsAPIResponse
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("X-Api-Key", apiKey)
+	cached, revalidating := httpCache.Prepare(req, reqURL)
+	resp, err := doWithRetry(ctx, req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	if revalidating && resp.StatusCode == http.StatusNotModified {
+		httpCache.Revalidated(cached, resp.Header)
+		return result, json.Unmarshal(cached.Body, &result)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return result, err
+	}
+	decodeErr := json.Unmarshal(body, &result)
+	if resp.StatusCode != http.StatusOK || result.Status == "error" {
+		apiErr := &NewsAPIError{StatusCode: resp.StatusCode, Code: result.Code, Message: result.Message,
+			RetryAt: rateLimitRetryAt(resp.Header, time.Now())}
+		if decodeErr != nil || apiErr.Code == "" {
+			apiErr.Code = "http" + strconv.Itoa(resp.StatusCode)
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		return result, apiErr
+	}
+	if decodeErr != nil {
+		return result, decodeErr
+	}
+	httpCache.Store(reqURL, resp.Header, body)
+	return result, nil
+}
+
+// NewsAPIError is an error reported by NewsAPI itself, e.g. a bad key or a
+// rate limit, as opposed to a transport failure.
+type NewsAPIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	// RetryAt is when NewsAPI asked us to come back, if it did.
+	RetryAt time.Time
+}
+
+func (e *NewsAPIError) Error() string {
+	msg := fmt.Sprintf("newsapi: %s: %s", e.Code, e.Message)
+	if !e.RetryAt.IsZero() {
+		msg += " (retry after " + e.RetryAt.Local().Format("15:04:05") + ")"
+	}
+	return msg
+}
+
+func (e *NewsAPIError) Is(target error) bool {
+	return target == ErrRateLimited &&
+		(e.Code == "rateLimited" || e.Code == "apiKeyExhausted" || e.StatusCode == http.StatusTooManyRequests)
+}
+
+func (e *NewsAPIError) retryAt() time.Time { return e.RetryAt }
+
+// Retryable reports whether the same request may succeed later. Key and
+// parameter problems never will, so they should not be masked by the cache.
+func (e *NewsAPIError) Retryable() bool {
+	switch e.Code {
+	case "rateLimited", "apiKeyExhausted", "unexpectedError", "maximumResultsReached":
+		return true
+	}
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
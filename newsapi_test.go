@@ -0,0 +1,219 @@
+// newsapi_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newsAPIArticles is a NewsAPI response body of n articles, numbered from
+// first.
+func newsAPIArticles(first, n, total int) string {
+	var arts []string
+	for i := first; i < first+n; i++ {
+		arts = append(arts, fmt.Sprintf(`{"source":{"name":"Wire"},"title":"Story %d","url":"https://example.com/%d",`+
+			`"publishedAt":"2024-05-01T10:00:00Z"}`, i, i))
+	}
+	return fmt.Sprintf(`{"status":"ok","totalResults":%d,"articles":[%s]}`, total, strings.Join(arts, ","))
+}
+
+func TestNewsAPIEncodesQuery(t *testing.T) {
+	for _, topic := range []string{
+		"artificial intelligence & robotics",
+		"c++ #news",
+		"50% off? a=b",
+		"café Zürich",
+		"東京 ニュース",
+		`"exact phrase" +must -not`,
+	} {
+		t.Run(topic, func(t *testing.T) {
+			var got string
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				got = r.URL.Query().Get("q")
+				fmt.Fprint(w, newsAPIArticles(1, 2, 2))
+			})
+			useNewsAPIKeys(t, "test-key")
+			news, err := NewsAPIProvider{}.Fetch(context.Background(), NewsQuery{Query: topic, Days: 3, MaxItems: 5})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != topic {
+				t.Errorf("the server got q=%q, want %q", got, topic)
+			}
+			if len(news) != 2 {
+				t.Errorf("got %d results, want 2", len(news))
+			}
+		})
+	}
+}
+
+func TestTopicLineWithSpecialCharacters(t *testing.T) {
+	q, err := parseTopicLine("artificial intelligence & robotics,3,5", NewsQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Query != "artificial intelligence & robotics" || q.Days != 3 || q.MaxItems != 5 {
+		t.Errorf("got %+v", q)
+	}
+}
+
+func TestNewsAPIPaginates(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		maxItems, have int
+		wantPages      []string
+		want           int
+	}{
+		{"final short page", 250, 230, []string{"1", "2", "3"}, 230},
+		{"stops at maxItems", 150, 1000, []string{"1", "2"}, 150},
+		{"one page", 40, 1000, []string{"1"}, 40},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var pages []string
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				page, size := r.URL.Query().Get("page"), r.URL.Query().Get("pageSize")
+				pages = append(pages, page)
+				var n, p int
+				fmt.Sscan(page, &p)
+				fmt.Sscan(size, &n)
+				first := (p-1)*n + 1
+				fmt.Fprint(w, newsAPIArticles(first, max(min(n, tc.have-first+1), 0), tc.have))
+			})
+			useNewsAPIKeys(t, "test-key")
+			news, err := NewsAPIProvider{}.Fetch(context.Background(), NewsQuery{Query: "go", Days: 7, MaxItems: tc.maxItems})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(news) != tc.want {
+				t.Errorf("got %d results, want %d", len(news), tc.want)
+			}
+			if strings.Join(pages, ",") != strings.Join(tc.wantPages, ",") {
+				t.Errorf("requested pages %v, want %v", pages, tc.wantPages)
+			}
+			seen := map[string]bool{}
+			for _, r := range news {
+				if seen[r.URL] {
+					t.Fatalf("%s returned twice", r.URL)
+				}
+				seen[r.URL] = true
+			}
+		})
+	}
+}
+
+func TestNewsAPIErrorBodies(t *testing.T) {
+	for _, tc := range []struct {
+		status      int
+		body        string
+		want        string
+		retryable   bool
+		rateLimited bool
+	}{
+		{http.StatusUnauthorized, `{"status":"error","code":"apiKeyInvalid","message":"Your API key is invalid or incorrect."}`,
+			"newsapi: apiKeyInvalid: Your API key is invalid or incorrect.", false, false},
+		{http.StatusUpgradeRequired, `{"status":"error","code":"parameterInvalid","message":"You are trying to request results too far in the past."}`,
+			"newsapi: parameterInvalid: You are trying to request results too far in the past.", false, false},
+		{http.StatusTooManyRequests, `{"status":"error","code":"rateLimited","message":"You have made too many requests recently."}`,
+			"newsapi: rateLimited: You have made too many requests recently.", true, true},
+		{http.StatusUnauthorized, `<html>Unauthorized</html>`, "newsapi: http401: Unauthorized", false, false},
+	} {
+		t.Run(fmt.Sprint(tc.status), func(t *testing.T) {
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			})
+			_, err := fetchNewsAPIPage(context.Background(), "https://newsapi.org/v2/everything?q=go", "test-key")
+			var apiErr *NewsAPIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got error %v, want a NewsAPIError", err)
+			}
+			if err.Error() != tc.want {
+				t.Errorf("got %q, want %q", err, tc.want)
+			}
+			if apiErr.StatusCode != tc.status {
+				t.Errorf("status %d, want %d", apiErr.StatusCode, tc.status)
+			}
+			if apiErr.Retryable() != tc.retryable {
+				t.Errorf("Retryable() = %v, want %v", apiErr.Retryable(), tc.retryable)
+			}
+			if errors.Is(err, ErrRateLimited) != tc.rateLimited {
+				t.Errorf("errors.Is(err, ErrRateLimited) = %v, want %v", !tc.rateLimited, tc.rateLimited)
+			}
+		})
+	}
+}
+
+func TestNewsAPIErrorIsReported(t *testing.T) {
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"status":"error","code":"apiKeyInvalid","message":"Your API key is invalid or incorrect."}`)
+	})
+	useNewsAPIKeys(t, "bad-key")
+	db := openTestDB(t)
+	q := NewsQuery{Query: "go", Days: 7, MaxItems: 5}
+	// rows cached earlier must not hide a key that will never work
+	if err := storeFetched(db, q, []NewsResult{{Title: "Old", URL: "https://example.com/old", Source: newsAPIProviderName}}); err != nil {
+		t.Fatal(err)
+	}
+	q.Refresh = true
+	tasks := startTestPool(t, db, NewsAPIProvider{}, 1)
+	r := submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second)
+	if r.Err == nil || !strings.Contains(r.Err.Error(), "apiKeyInvalid") {
+		t.Fatalf("got %+v, want the apiKeyInvalid error", r)
+	}
+}
+
+func TestNewsAPISearchIn(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want []string
+	}{
+		{"rust,3,5", nil},
+		{"rust!title,3,5", []string{"title"}},
+		{"rust!title;description,3,5", []string{"description,title"}},
+	} {
+		t.Run(tc.line, func(t *testing.T) {
+			var got []string
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				got = r.URL.Query()["searchIn"]
+				fmt.Fprint(w, newsAPIArticles(1, 1, 1))
+			})
+			useNewsAPIKeys(t, "test-key")
+			q, err := parseTopicLine(tc.line, NewsQuery{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.Query != "rust" {
+				t.Errorf("topic %q, want rust", q.Query)
+			}
+			if _, err := (NewsAPIProvider{}).Fetch(context.Background(), q); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+				t.Errorf("searchIn=%q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSearchInIsCachedApart(t *testing.T) {
+	db := openTestDB(t)
+	title := NewsQuery{Query: "rust", Days: 7, MaxItems: 5, SearchIn: "title"}
+	if err := storeFetched(db, title, []NewsResult{{Title: "Rust 2.0", URL: "https://example.com/rust",
+		Source: newsAPIProviderName, PublishedAt: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := getCachedResults(db, title, time.Time{}); len(got) != 1 {
+		t.Errorf("title search: got %d cached results, want 1", len(got))
+	}
+	full := title
+	full.SearchIn = ""
+	if got := getCachedResults(db, full, time.Time{}); len(got) != 0 {
+		t.Errorf("full search: got %d cached results of the title search, want none", len(got))
+	}
+}
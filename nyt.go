@@ -0,0 +1,155 @@
+// nyt.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// -------- New York Times Article Search provider --------
+const nytProviderName = "nyt"
+
+const (
+	// nytPageSize is fixed by the API.
+	nytPageSize = 10
+	// nytMaxPage is the last page the API will serve for a query.
+	nytMaxPage = 100
+	// nytPageDelay keeps paging under the API's per-minute request limit.
+	nytPageDelay = 12 * time.Second
+)
+
+// NYTProvider queries the NYT Article Search API using the NYT_KEY
+// environment variable.
+type NYTProvider struct{}
+
+func (NYTProvider) Name() string { return nytProviderName }
+
+func (NYTProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	return fetchNYT(ctx, q)
+}
+
+type NYTResponse struct {
+	Status   string `json:"status"`
+	Response struct {
+		Docs []struct {
+			WebURL   string `json:"web_url"`
+			Abstract string `json:"abstract"`
+			PubDate  string `json:"pub_date"`
+			Source   string `json:"source"`
+			Headline struct {
+				Main string `json:"main"`
+			} `json:"headline"`
+			Byline struct {
+				Original string `json:"original"`
+			} `json:"byline"`
+		} `json:"docs"`
+		Meta struct {
+			Hits int `json:"hits"`
+		} `json:"meta"`
+	} `json:"response"`
+	// Fault is the API gateway's error shape (bad key, rate limit).
+	Fault struct {
+		FaultString string `json:"faultstring"`
+	} `json:"fault"`
+	Errors []string `json:"errors"`
+}
+
+// fetchNYT walks the fixed-size pages until q.MaxItems docs are collected,
+// pausing between pages so a multi-page query doesn't trip the rate limit.
+// The pause ends early, returning what was fetched, if ctx is done.
+func fetchNYT(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	apiKey := os.Getenv("NYT_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("NYT_KEY not set")
+	}
+	if q.endpoint() == EndpointTopHeadlines {
+		return nil, &ProviderError{Provider: nytProviderName, StatusCode: http.StatusBadRequest,
+			Message: "top-headlines queries are not supported"}
+	}
+	now := time.Now()
+	params := url.Values{}
+	params.Set("q", q.Query)
+	params.Set("begin_date", q.windowFrom().Format("20060102"))
+	end := now
+	if until := q.fetchUntil(); !until.IsZero() {
+		// whole days only; the overlap is deduplicated when stored
+		end = until
+	}
+	params.Set("end_date", end.Format("20060102"))
+	if q.SortBy == "publishedAt" {
+		params.Set("sort", "newest")
+	} else {
+		params.Set("sort", "relevance")
+	}
+	params.Set("api-key", apiKey)
+
+	news := []NewsResult{}
+	for page := 0; len(news) < q.MaxItems && page < nytMaxPage; page++ {
+		if page > 0 {
+			select {
+			case <-ctx.Done():
+				return news, ctx.Err()
+			case <-time.After(nytPageDelay):
+			}
+		}
+		params.Set("page", strconv.Itoa(page))
+		result, err := fetchNYTPage(ctx, "https://api.nytimes.com/svc/search/v2/articlesearch.json?"+params.Encode())
+		if err != nil {
+			return news, err
+		}
+		for _, d := range result.Response.Docs {
+			news = append(news, NewsResult{
+				Title:       d.Headline.Main,
+				URL:         d.WebURL,
+				Source:      nytProviderName,
+				PublishedAt: parsePublishedAt(d.PubDate),
+				Description: d.Abstract,
+				Author:      d.Byline.Original,
+				SourceName:  d.Source,
+			})
+			if len(news) >= q.MaxItems {
+				break
+			}
+		}
+		if len(result.Response.Docs) < nytPageSize || (page+1)*nytPageSize >= result.Response.Meta.Hits {
+			break
+		}
+	}
+	return news, nil
+}
+
+func fetchNYTPage(ctx context.Context, reqURL string) (NYTResponse, error) {
+	var result NYTResponse
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return result, err
+	}
+	resp, err := doWithRetry(ctx, req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK || result.Status == "ERROR" {
+		msg := result.Fault.FaultString
+		if msg == "" && len(result.Errors) > 0 {
+			msg = result.Errors[0]
+		}
+		if msg == "" {
+			msg = http.StatusText(resp.StatusCode)
+		}
+		return result, &ProviderError{Provider: nytProviderName, StatusCode: resp.StatusCode, Message: msg,
+			RetryAt: rateLimitRetryAt(resp.Header, time.Now())}
+	}
+	if decodeErr != nil {
+		return result, decodeErr
+	}
+	return result, nil
+}
@@ -0,0 +1,517 @@
+// outputs.go
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -------- Output files --------
+
+// outputFormat is a -format: how an output file is written and the
+// extension {format} stands for in its name. A format with writeFiles
+// instead writes files of its own naming, and returns their paths.
+type outputFormat struct {
+	ext        string
+	write      func(w io.Writer, out fileOutput) error
+	writeFiles func(cfg RunConfig, out fileOutput) ([]string, error)
+}
+
+// outputFormats are the -format values. formatText and formatJSON are
+// the input format names too.
+var outputFormats = map[string]outputFormat{
+	formatText:  {"txt", writeTextOutput, nil},
+	formatJSON:  {"json", writeJSONOutput, nil},
+	"csv":       {"csv", writeCSVOutput, nil},
+	"md":        {"md", writeMarkdownOutput, nil},
+	"html":      {"html", writeHTMLOutput, nil},
+	formatJSONL: {"jsonl", writeJSONLOutput, nil},
+	"rss":       {feedExt, nil, writeFeedFiles},
+	"atom":      {feedExt, nil, writeFeedFiles},
+	// set by -template rather than -format
+	formatTemplate: {"txt", writeTemplateOutput, nil},
+}
+
+// formatTemplate is the output format of -template.
+const formatTemplate = "template"
+
+// outputFormatNames lists the -format values for messages.
+func outputFormatNames() string {
+	var names []string
+	for _, name := range slices.Sorted(maps.Keys(outputFormats)) {
+		if name != formatTemplate {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// fileOutput is what goes into one output file: the topics of an input
+// file, their results and the stats tallied over them.
+type fileOutput struct {
+	input   inputFile
+	results []TaskResult
+	stats   RunStats
+	started time.Time
+}
+
+// writeTextOutput writes the text format: the input's invalid lines, a
+// section per topic as the console shows them, and the summary.
+func writeTextOutput(w io.Writer, out fileOutput) error {
+	if len(out.input.invalid) > 0 {
+		reportInputErrors(w, out.input.path, out.input.invalid)
+		fmt.Fprintln(w)
+	}
+	writeSections(w, out.input.topics, out.results)
+	writeTextSummary(w, out)
+	return nil
+}
+
+// writeTextSummary writes the summary ending a text output file: the
+// topics' counts, the run's timing and the time each topic took.
+func writeTextSummary(w io.Writer, out fileOutput) {
+	fmt.Fprintln(w, "==== Summary ====")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, out.stats.summary())
+	fmt.Fprintln(w, out.stats.timing())
+	if note := out.stats.budgetNote(); note != "" {
+		fmt.Fprintln(w, note)
+	}
+	if len(out.input.topics) > 0 {
+		fmt.Fprintln(w, "Time per topic:")
+	}
+	for i, q := range out.input.topics {
+		label := topicSummary(q)
+		if q.UserID != "" {
+			label = q.UserID + ": " + label
+		}
+		fmt.Fprintf(w, "  %8s  %s\n", roundDuration(out.results[i].Elapsed), label)
+	}
+}
+
+// jsonSchemaVersion is the schemaVersion of JSONOutput. It goes up when a
+// field is removed, renamed or changes meaning; new fields can be added
+// without it.
+const jsonSchemaVersion = 1
+
+// JSONOutput is the document -format json writes for each input file.
+type JSONOutput struct {
+	SchemaVersion int         `json:"schemaVersion"`
+	Run           JSONRun     `json:"run"`
+	Invalid       []JSONBad   `json:"invalid"`
+	Topics        []JSONTopic `json:"topics"`
+}
+
+// JSONRun describes the pass. FromCache counts stale cache hits as well.
+type JSONRun struct {
+	ID        uint      `json:"id,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	Input     string    `json:"input"`
+	Topics    int       `json:"topics"`
+	FromCache int       `json:"fromCache"`
+	FromAPI   int       `json:"fromAPI"`
+	Failed    int       `json:"failed"`
+	// Panicked counts the failed topics whose processing panicked.
+	Panicked int `json:"panicked"`
+	// ElapsedMs, APICalls and RowsWritten are the whole run's, when its
+	// results were in.
+	ElapsedMs   int64 `json:"elapsedMs"`
+	APICalls    int64 `json:"apiCalls"`
+	RowsWritten int64 `json:"rowsWritten"`
+	// Interrupted is set when a signal cut the run short.
+	Interrupted bool `json:"interrupted,omitempty"`
+}
+
+// JSONBad is an input line or element that failed validation.
+type JSONBad struct {
+	Where string `json:"where"`
+	Text  string `json:"text"`
+	Error string `json:"error"`
+}
+
+// JSONTopic is one topic's answer. Params are in the JSON input form, so
+// a topic can be fed back as input; Source is where the results came
+// from, "API", "DB" or "Redis", possibly annotated, and is left out when
+// Error is set. ElapsedMs and APICalls are those of the task that
+// fetched it, see TaskResult.
+type JSONTopic struct {
+	Query     string       `json:"query"`
+	Params    TopicSpec    `json:"params"`
+	Source    string       `json:"source,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	ElapsedMs int64        `json:"elapsedMs"`
+	APICalls  int64        `json:"apiCalls"`
+	Results   []NewsResult `json:"results"`
+}
+
+// writeJSONOutput writes out as an indented JSONOutput, topics in input
+// order.
+func writeJSONOutput(w io.Writer, out fileOutput) error {
+	doc := JSONOutput{SchemaVersion: jsonSchemaVersion, Run: jsonRun(out), Invalid: jsonInvalid(out.input.invalid),
+		Topics: []JSONTopic{}}
+	for i, q := range out.input.topics {
+		doc.Topics = append(doc.Topics, jsonTopic(q, out.results[i]))
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+func jsonRun(out fileOutput) JSONRun {
+	return JSONRun{ID: out.stats.ID, StartedAt: out.started, Input: out.input.path, Topics: out.stats.Topics,
+		FromCache: out.stats.Hits + out.stats.StaleHits, FromAPI: out.stats.Misses, Failed: out.stats.Failed,
+		Panicked: out.stats.Panicked, ElapsedMs: out.stats.elapsed.Milliseconds(), APICalls: out.stats.APICalls,
+		RowsWritten: out.stats.RowsWritten, Interrupted: out.stats.interrupted}
+}
+
+func jsonInvalid(invalid []*InputError) []JSONBad {
+	bad := []JSONBad{}
+	for _, e := range invalid {
+		bad = append(bad, JSONBad{Where: e.Where, Text: e.Text, Error: e.Err.Error()})
+	}
+	return bad
+}
+
+func jsonTopic(q NewsQuery, r TaskResult) JSONTopic {
+	t := JSONTopic{Query: q.Query, Params: q.Spec(), ElapsedMs: r.Elapsed.Milliseconds(), APICalls: r.APICalls,
+		Results: []NewsResult{}}
+	if r.Err != nil {
+		t.Error = r.Err.Error()
+	} else {
+		t.Source = r.Source
+	}
+	if r.Results != nil {
+		t.Results = r.Results
+	}
+	return t
+}
+
+// csvHeader names the columns of the csv format. source is where a
+// topic's results came from, provider the provider of each result, and
+// elapsed_ms and api_calls are the topic's task's; see csvSummary for
+// rows_written.
+var csvHeader = []string{"run_timestamp", "topic", "days", "max_items", "source", "title", "url", "published_at",
+	"provider", "error", "elapsed_ms", "api_calls", "rows_written"}
+
+// csvSummary is the source of the csv format's last row, which has the
+// run's totals: its topics counted in the title, as the console says, and
+// the run's elapsed_ms, api_calls and rows_written.
+const csvSummary = "summary"
+
+// csvBOM starts csv files with a UTF-8 byte order mark, which Excel needs
+// to read them as UTF-8; set from -csv-bom.
+var csvBOM bool
+
+// writeCSVOutput writes the csv format: a header and a row per result,
+// topics in input order, then the csvSummary row. A topic that failed has
+// one row with its error, and one without results a row with an empty
+// title.
+func writeCSVOutput(w io.Writer, out fileOutput) error {
+	if csvBOM {
+		io.WriteString(w, "\uFEFF")
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	stamp := out.started.Format(time.RFC3339)
+	for i, q := range out.input.topics {
+		r := out.results[i]
+		days := ""
+		if q.endpoint() != EndpointTopHeadlines {
+			days = strconv.Itoa(q.Days)
+		}
+		row := []string{stamp, q.Query, days, strconv.Itoa(q.MaxItems), r.Source}
+		timing := []string{strconv.FormatInt(r.Elapsed.Milliseconds(), 10), strconv.FormatInt(r.APICalls, 10), ""}
+		if r.Err != nil {
+			cw.Write(slices.Concat(row[:4], []string{"", "", "", "", "", r.Err.Error()}, timing))
+			continue
+		}
+		if len(r.Results) == 0 {
+			cw.Write(slices.Concat(row, []string{"", "", "", "", ""}, timing))
+		}
+		for _, a := range r.Results {
+			published := ""
+			if !a.PublishedAt.IsZero() {
+				published = a.PublishedAt.Format(time.RFC3339)
+			}
+			cw.Write(slices.Concat(row, []string{a.Title, a.URL, published, a.Source, ""}, timing))
+		}
+	}
+	cw.Write([]string{stamp, "", "", "", csvSummary, out.stats.summary(), "", "", "", "",
+		strconv.FormatInt(out.stats.elapsed.Milliseconds(), 10), strconv.FormatInt(out.stats.APICalls, 10),
+		strconv.FormatInt(out.stats.RowsWritten, 10)})
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeMarkdownOutput writes the md format: a title with the run's date,
+// a heading per topic with its parameters and source, and a link per
+// result. Errors are quoted, and invalid input lines listed at the end.
+func writeMarkdownOutput(w io.Writer, out fileOutput) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# News for %s, %s\n", mdEscape(filepath.Base(out.input.path)),
+		out.started.Format("2006-01-02 15:04"))
+	for i, q := range out.input.topics {
+		r := out.results[i]
+		heading := topicSummary(q)
+		if q.UserID != "" {
+			heading = q.UserID + ": " + heading
+		}
+		if r.Err == nil {
+			heading += " (from " + r.Source + ")"
+		}
+		fmt.Fprintf(bw, "\n## %s\n\n", mdEscape(heading))
+		switch {
+		case r.Err != nil:
+			fmt.Fprintf(bw, "> Error: %s\n", mdEscape(r.Err.Error()))
+		case len(r.Results) == 0:
+			fmt.Fprintln(bw, "_No results found_")
+		}
+		for _, a := range r.Results {
+			line := fmt.Sprintf("- [%s](%s)", mdEscape(a.Title), mdURL(a.URL))
+			var meta []string
+			if source := cmp.Or(a.SourceName, a.Source); source != "" {
+				meta = append(meta, mdEscape(source))
+			}
+			if !a.PublishedAt.IsZero() {
+				meta = append(meta, a.PublishedAt.Format("2006-01-02 15:04"))
+			}
+			if len(meta) > 0 {
+				line += " — " + strings.Join(meta, ", ")
+			}
+			fmt.Fprintln(bw, line)
+		}
+	}
+	if len(out.input.invalid) > 0 {
+		fmt.Fprintf(bw, "\n## Skipped input lines\n\n")
+		for _, e := range out.input.invalid {
+			fmt.Fprintf(bw, "- %s: %s: `%s`\n", e.Where, mdEscape(e.Err.Error()), strings.ReplaceAll(e.Text, "`", "'"))
+		}
+	}
+	fmt.Fprintf(bw, "\n## Summary\n\n%s  \n%s\n", mdEscape(out.stats.summary()), mdEscape(out.stats.timing()))
+	if note := out.stats.budgetNote(); note != "" {
+		fmt.Fprintf(bw, "\n_%s_\n", mdEscape(note))
+	}
+	return bw.Flush()
+}
+
+// mdEscaper backslash-escapes the characters that could make text start
+// or end a link, emphasis, code span, table cell or HTML tag, and folds
+// line breaks. Parentheses only matter after a ], which is escaped.
+var mdEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"|", `\|`, "<", `\<`, ">", `\>`, "#", `\#`, "~", `\~`, "\n", " ", "\r", "")
+
+func mdEscape(s string) string {
+	return mdEscaper.Replace(s)
+}
+
+// mdURL keeps a link destination from ending early at a parenthesis or
+// space.
+func mdURL(u string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E").Replace(u)
+}
+
+// writeFileAtomic writes path through write by way of a temporary file
+// beside it, renamed over path once complete, so readers never see a
+// partial file.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	w := bufio.NewWriter(file)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Chmod(0o644)
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// defaultOutputName is the -output-name template: Outputs_user10.txt for
+// user10.txt.
+const defaultOutputName = "Outputs_{input}.{format}"
+
+// templateVars are what the tokens of an output template stand for: the
+// input file, when the pass started and the output format's extension.
+type templateVars struct {
+	input string
+	start time.Time
+	ext   string
+}
+
+// outputTokens are the tokens output templates may use, with what they
+// stand for.
+var outputTokens = map[string]func(v templateVars) string{
+	// the input file's name without its directory and extension
+	"input": func(v templateVars) string {
+		return strings.TrimSuffix(filepath.Base(v.input), filepath.Ext(v.input))
+	},
+	"date":   func(v templateVars) string { return v.start.Format("2006-01-02") },
+	"time":   func(v templateVars) string { return v.start.Format("150405") },
+	"format": func(v templateVars) string { return v.ext },
+}
+
+// expandTemplate replaces the {token}s of tmpl with their values for v. It
+// fails on an unknown token or an unmatched brace.
+func expandTemplate(tmpl string, v templateVars) (string, error) {
+	var b strings.Builder
+	for rest := tmpl; rest != ""; {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			b.WriteString(rest)
+			break
+		}
+		b.WriteString(rest[:open])
+		end := strings.IndexByte(rest[open:], '}')
+		if rest[open] == '}' || end < 0 {
+			return "", fmt.Errorf("%q: unmatched brace", tmpl)
+		}
+		name := rest[open+1 : open+end]
+		token, ok := outputTokens[name]
+		if !ok {
+			return "", fmt.Errorf("%q: unknown token {%s}; want one of {%s}", tmpl, name,
+				strings.Join(slices.Sorted(maps.Keys(outputTokens)), "}, {"))
+		}
+		b.WriteString(token(v))
+		rest = rest[open+end+1:]
+	}
+	return b.String(), nil
+}
+
+// checkOutputTemplates validates the -output-dir and -output-name
+// templates before anything is fetched. Besides the tokens, the name must
+// stay inside the directory: no absolute path and no .. element.
+func checkOutputTemplates(dir, name string) error {
+	v := templateVars{"input.txt", time.Now(), "txt"}
+	if _, err := expandTemplate(dir, v); err != nil {
+		return fmt.Errorf("-output-dir %w", err)
+	}
+	expanded, err := expandTemplate(name, v)
+	if err != nil {
+		return fmt.Errorf("-output-name %w", err)
+	}
+	switch {
+	case strings.TrimSpace(expanded) == "" || strings.HasSuffix(expanded, "/"):
+		return fmt.Errorf("-output-name %q: doesn't name a file", name)
+	case filepath.IsAbs(expanded):
+		return fmt.Errorf("-output-name %q: must be relative to -output-dir", name)
+	case slices.Contains(strings.Split(filepath.ToSlash(expanded), "/"), ".."):
+		return fmt.Errorf("-output-name %q: must not leave -output-dir with ..", name)
+	}
+	return nil
+}
+
+// runStampLayout is the pass start time in the names of timestamped
+// output files: Outputs_user10_2024-05-12T14-03-07.txt.
+const runStampLayout = "2006-01-02T15-04-05"
+
+// latestSuffix marks the link to, or copy of, an input's newest output
+// file: Outputs_user10_latest.txt.
+const latestSuffix = "_latest"
+
+// outputPath names the output file of input for the pass started at
+// start, from cfg's templates, which checkOutputTemplates has accepted.
+// With cfg.Timestamp, a name without a {time} of its own gets
+// _<runStampLayout> before its extension, latest is the name of the
+// input's latest link, and a file already on disk is never reused. A path
+// another input of the pass already has, or with cfg.Timestamp one that
+// exists, gets a -2, -3... suffix.
+func outputPath(cfg RunConfig, input string, start time.Time, used map[string]bool) (path, latest string) {
+	v := templateVars{input, start, outputFormats[cfg.Format].ext}
+	dir, _ := expandTemplate(cfg.OutputDir, v)
+	name, _ := expandTemplate(cfg.OutputName, v)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if cfg.Timestamp && !strings.Contains(cfg.OutputName, "{time}") {
+		latest = filepath.Join(dir, base+latestSuffix+ext)
+		base += "_" + start.Format(runStampLayout)
+	}
+	path = filepath.Join(dir, base+ext)
+	for n := 2; used[path] || cfg.Timestamp && fileExists(path); n++ {
+		path = filepath.Join(dir, fmt.Sprintf("%s-%d%s", base, n, ext))
+	}
+	used[path] = true
+	return path, latest
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// updateLatest points latest at path, the output file just written, as a
+// symlink beside it, or as a copy where symlinks can't be made. Either is
+// put in place with a rename, so readers never see it missing.
+func updateLatest(path, latest string) error {
+	tmp := latest + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(filepath.Base(path), tmp); err != nil {
+		debugf("can't symlink %s, copying it instead: %v", latest, err)
+		if err := copyFile(path, tmp); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("updating %s: %w", latest, err)
+		}
+	}
+	if err := os.Rename(tmp, latest); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("updating %s: %w", latest, err)
+	}
+	return nil
+}
+
+// pruneRuns deletes the timestamped output files of the input whose latest
+// link is latest that were started more than keepDays days ago, and
+// returns how many it deleted. Files whose names don't carry a stamp are
+// left alone.
+func pruneRuns(latest string, keepDays int) (int, error) {
+	dir, name := filepath.Split(latest)
+	ext := filepath.Ext(name)
+	prefix := strings.TrimSuffix(name, latestSuffix+ext) + "_"
+	if name == topicLatest+ext {
+		prefix = "" // a directory of -split-by-topic, its files named by the stamp alone
+	}
+	entries, err := os.ReadDir(cmp.Or(dir, "."))
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().AddDate(0, 0, -keepDays)
+	deleted := 0
+	var errs []error
+	for _, e := range entries {
+		stamp, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || e.IsDir() || !strings.HasSuffix(stamp, ext) || len(stamp) < len(runStampLayout) {
+			continue
+		}
+		started, err := time.ParseInLocation(runStampLayout, stamp[:len(runStampLayout)], time.Local)
+		if err != nil || !started.Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, errors.Join(errs...)
+}
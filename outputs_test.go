@@ -0,0 +1,316 @@
+// outputs_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares got with testdata/name, or with -update writes it
+// there.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v; run go test -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output doesn't match %s; got\n%s", path, got)
+	}
+}
+
+// sampleOutput is an output file's worth of results that covers what the
+// formats render: a topic answered by the API, one from the cache without
+// results, a failed one and an invalid input line.
+func sampleOutput() fileOutput {
+	started := time.Date(2024, 5, 12, 14, 3, 7, 0, time.UTC)
+	return fileOutput{
+		input: inputFile{
+			path: "Inputs/user1",
+			topics: []NewsQuery{
+				{Query: "golang", Days: 7, MaxItems: 2, Language: "en", SortBy: "publishedAt"},
+				{Query: "rust", Days: 3, MaxItems: 5},
+				{Query: "zig", Days: 1, MaxItems: 5},
+			},
+			invalid: []*InputError{{Where: "line 4", Text: "haskell,0,5", Err: errors.New("days must be at least 1, got 0")}},
+		},
+		results: []TaskResult{
+			{Source: "API", Elapsed: 420 * time.Millisecond, APICalls: 1, Results: []NewsResult{
+				{Title: "Go 1.23 released", URL: "https://go.dev/blog/go1.23", Source: "newsapi",
+					PublishedAt: started.Add(-2 * time.Hour), SourceName: "The Go Blog", Author: "The Go Team"},
+				{Title: "Range over func, explained", URL: "https://example.com/range-func", Source: "newsapi",
+					PublishedAt: started.Add(-26 * time.Hour), Description: "Iterators, at last."},
+			}},
+			{Source: "DB", Elapsed: 3 * time.Millisecond},
+			{Err: errors.New("newsapi: rate limited"), Elapsed: 1500 * time.Millisecond, APICalls: 3},
+		},
+		stats: RunStats{ID: 12, Topics: 3, Hits: 1, Misses: 1, Failed: 1, APICalls: 4, RowsWritten: 2,
+			elapsed: 1600 * time.Millisecond},
+		started: started,
+	}
+}
+
+// render writes sampleOutput in format.
+func render(t *testing.T, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := outputFormats[format].write(&buf, sampleOutput()); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// fetchInto runs a fetch of topics with the fake provider, the input file
+// user1 and its results in dir.
+func fetchInto(t *testing.T, dir, topics string, flags ...string) {
+	t.Helper()
+	input := filepath.Join(dir, "user1")
+	if err := os.WriteFile(input, []byte(topics), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	args := append([]string{"fetch", "-provider", "fake", "-db", filepath.Join(dir, "cache.db"), "-input", input,
+		"-once", "-output-dir", dir}, flags...)
+	if _, err := runCommand(t, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimestampedOutputs(t *testing.T) {
+	dir := t.TempDir()
+	runFiles := func() []string {
+		files, _ := filepath.Glob(filepath.Join(dir, "Outputs_user1_2*.txt"))
+		return files
+	}
+
+	fetchInto(t, dir, "golang,7,3\n")
+	first := runFiles()
+	if len(first) != 1 {
+		t.Fatalf("first run wrote %v, want one timestamped file", first)
+	}
+	// the second run may start within the same second; it gets a -2 then
+	fetchInto(t, dir, "rust,7,3\n")
+	files := runFiles()
+	if len(files) != 2 || !slices.Contains(files, first[0]) {
+		t.Fatalf("second run left %v, want the first run's file and one more", files)
+	}
+	second := files[0]
+	if second == first[0] {
+		second = files[1]
+	}
+
+	latest := filepath.Join(dir, "Outputs_user1_latest.txt")
+	if target, err := os.Readlink(latest); err != nil || target != filepath.Base(second) {
+		t.Errorf("latest links to %q, error %v; want %q", target, err, filepath.Base(second))
+	}
+	got, err := os.ReadFile(latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) || !strings.Contains(string(got), "rust") {
+		t.Errorf("latest has\n%s\nwant the second run's\n%s", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Outputs_user1.txt")); !os.IsNotExist(err) {
+		t.Errorf("an untimestamped file was written: %v", err)
+	}
+}
+
+func TestOverwriteOutputs(t *testing.T) {
+	dir := t.TempDir()
+	fetchInto(t, dir, "golang,7,3\n", "-overwrite")
+	fetchInto(t, dir, "rust,7,3\n", "-overwrite")
+	files, _ := filepath.Glob(filepath.Join(dir, "Outputs_*"))
+	if want := []string{filepath.Join(dir, "Outputs_user1.txt")}; !slices.Equal(files, want) {
+		t.Fatalf("got %v, want %v", files, want)
+	}
+	if out := readOutput(t, dir); !strings.Contains(out, "rust") || strings.Contains(out, "golang") {
+		t.Errorf("the second run didn't replace the first's results:\n%s", out)
+	}
+}
+
+func TestPruneRuns(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	name := func(age time.Duration, suffix string) string {
+		return "Outputs_user1_" + now.Add(-age).Format(runStampLayout) + suffix + ".txt"
+	}
+	old, older := name(3*24*time.Hour, ""), name(10*24*time.Hour, "-2")
+	recent := name(time.Hour, "")
+	for _, f := range []string{old, older, recent, "Outputs_user1.txt", "Outputs_user2_2001-01-01T00-00-00.txt", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := pruneRuns(filepath.Join(dir, "Outputs_user1_latest.txt"), 2)
+	if err != nil || deleted != 2 {
+		t.Fatalf("deleted %d files, error %v; want 2", deleted, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	var left []string
+	for _, e := range entries {
+		left = append(left, e.Name())
+	}
+	want := []string{"Outputs_user1.txt", recent, "Outputs_user2_2001-01-01T00-00-00.txt", "notes.txt"}
+	slices.Sort(want)
+	if !slices.Equal(left, want) {
+		t.Errorf("left %v, want %v", left, want)
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	got := render(t, formatJSON)
+	checkGolden(t, "output.json", got)
+
+	// what downstream tools rely on beyond the golden file's bytes
+	var doc map[string]any
+	if err := json.Unmarshal(got, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["schemaVersion"] != float64(jsonSchemaVersion) {
+		t.Errorf("got schemaVersion %v, want %d", doc["schemaVersion"], jsonSchemaVersion)
+	}
+	topics := doc["topics"].([]any)
+	if results, ok := topics[1].(map[string]any)["results"].([]any); !ok || len(results) != 0 {
+		t.Errorf("a topic without results has results %v, want []", topics[1].(map[string]any)["results"])
+	}
+	if _, ok := topics[2].(map[string]any)["source"]; ok {
+		t.Error("a failed topic has a source")
+	}
+}
+
+func TestJSONOutputWithoutTopics(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJSONOutput(&buf, fileOutput{input: inputFile{path: "empty"}}); err != nil {
+		t.Fatal(err)
+	}
+	if s := buf.String(); !strings.Contains(s, `"topics": []`) || !strings.Contains(s, `"invalid": []`) {
+		t.Errorf("empty lists aren't []:\n%s", s)
+	}
+}
+
+func TestJSONOutputFileIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	fetchInto(t, dir, "golang,7,3\n", "-overwrite", "-format", "json")
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if name := e.Name(); name != "user1" && name != "cache.db" && name != "Outputs_user1.json" {
+			t.Errorf("left %s behind", name)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "Outputs_user1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc JSONOutput
+	if err := json.Unmarshal(data, &doc); err != nil || len(doc.Topics) != 1 || len(doc.Topics[0].Results) != 3 {
+		t.Errorf("got %+v, error %v; want golang's 3 results", doc, err)
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	keep(t, &csvBOM)
+	out := sampleOutput()
+	awkward := `Commas, "quotes" and` + "\na newline"
+	out.results[0].Results = append(out.results[0].Results, NewsResult{Title: awkward, URL: "https://example.com/a,b"})
+	out.input.topics[0].MaxItems = 3
+
+	for _, bom := range []bool{false, true} {
+		csvBOM = bom
+		var buf bytes.Buffer
+		if err := writeCSVOutput(&buf, out); err != nil {
+			t.Fatal(err)
+		}
+		data, hasBOM := bytes.CutPrefix(buf.Bytes(), []byte("\uFEFF"))
+		if hasBOM != bom {
+			t.Errorf("-csv-bom %v: file starts with a BOM: %v", bom, hasBOM)
+		}
+		rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the header, golang's 3 results, a row each for rust without
+		// results and the failed zig, and the summary
+		if len(rows) != 7 {
+			t.Fatalf("got %d rows, want 7: %q", len(rows), rows)
+		}
+		if !slices.Equal(rows[0], csvHeader) {
+			t.Errorf("got header %q", rows[0])
+		}
+		col := func(row []string, name string) string { return row[slices.Index(csvHeader, name)] }
+		var titles []string
+		for _, row := range rows[1:4] {
+			titles = append(titles, col(row, "title"))
+		}
+		if want := []string{"Go 1.23 released", "Range over func, explained", awkward}; !slices.Equal(titles, want) {
+			t.Errorf("got titles %q, want %q", titles, want)
+		}
+		if got := col(rows[3], "url"); got != "https://example.com/a,b" {
+			t.Errorf("got url %q", got)
+		}
+		if got := rows[4]; col(got, "topic") != "rust" || col(got, "source") != "DB" || col(got, "title") != "" {
+			t.Errorf("got %q for rust, want a row without a result", got)
+		}
+		if got := rows[5]; col(got, "topic") != "zig" || col(got, "error") != "newsapi: rate limited" || col(got, "source") != "" {
+			t.Errorf("got %q for zig, want a row with its error", got)
+		}
+		if got := rows[6]; col(got, "source") != csvSummary || col(got, "rows_written") != "2" {
+			t.Errorf("got summary row %q", got)
+		}
+		for _, row := range rows[1:] {
+			if col(row, "run_timestamp") != "2024-05-12T14:03:07Z" {
+				t.Errorf("row %q has the wrong run_timestamp", row)
+			}
+		}
+	}
+}
+
+func TestMarkdownOutput(t *testing.T) {
+	out := sampleOutput()
+	out.results[0].Results[1].Title = "[RFC] snake_case | pipes, *stars* and `ticks`"
+	out.results[0].Results[1].URL = "https://en.wikipedia.org/wiki/Go_(programming_language)"
+	var buf bytes.Buffer
+	if err := writeMarkdownOutput(&buf, out); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "output.md", buf.Bytes())
+}
+
+func TestMarkdownEscaping(t *testing.T) {
+	for in, want := range map[string]string{
+		"[link](x)":     `\[link\](x)`,
+		"snake_case":    `snake\_case`,
+		"a | b":         `a \| b`,
+		"<b>#1</b>":     `\<b\>\#1\</b\>`,
+		"two\r\nlines":  "two lines",
+		`back\slash *x`: `back\\slash \*x`,
+	} {
+		if got := mdEscape(in); got != want {
+			t.Errorf("mdEscape(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := mdURL("https://example.com/a (b)"); got != "https://example.com/a%20%28b%29" {
+		t.Errorf("mdURL: got %q", got)
+	}
+}
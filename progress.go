@@ -0,0 +1,209 @@
+// progress.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -------- Progress --------
+
+// progressInterval is how often a pass's progress is reported where the
+// console isn't a terminal; on one the progress line is redrawn every
+// second, and whenever a topic starts or completes.
+const progressInterval = 5 * time.Second
+
+// progress is the state of a pass over input files that the console shows
+// while it runs: the topics done of total, how they were served, and the
+// topics the workers are processing. The consoleReporter holds it, and it
+// is guarded by the reporter's lock.
+type progress struct {
+	started                    time.Time
+	total, done                int
+	fromAPI, fromCache, failed int
+	inFlight                   []string
+	drawn                      bool
+	stop                       chan struct{}
+	log                        io.Writer
+}
+
+// line is the progress line, at most width columns wide.
+func (p *progress) line(width int) string {
+	msg := fmt.Sprintf("%d/%d done", p.done, p.total)
+	if n := len(p.inFlight); n > 0 {
+		shown := p.inFlight[:min(n, 3)]
+		msg += fmt.Sprintf(", %d in flight (%s", n, strings.Join(shown, ", "))
+		if n > len(shown) {
+			msg += fmt.Sprintf(", +%d", n-len(shown))
+		}
+		msg += ")"
+	}
+	msg += fmt.Sprintf(" · %d from API, %d from cache, %d failed · %s", p.fromAPI, p.fromCache, p.failed,
+		time.Since(p.started).Truncate(time.Second))
+	if r := []rune(msg); len(r) > width-1 {
+		msg = string(r[:max(width-2, 0)]) + "…"
+	}
+	return msg
+}
+
+// terminalWidth is the width the progress line is cut to: $COLUMNS, else
+// 80.
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return 80
+}
+
+// setProgress is the Set of -no-progress.
+func setProgress(s string) error {
+	off, err := strconv.ParseBool(s)
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+	reporter.noProgress = off
+	return err
+}
+
+// startProgress shows the progress of a pass of total topics, until the
+// returned function is called. On a terminal it is one line kept below
+// the other messages, which are written above it, log's included;
+// elsewhere a line such as "42/200 done" every progressInterval. Nothing
+// is shown with -quiet or -no-progress, outside passes over input files,
+// or when a pass is shown already.
+func (c *consoleReporter) startProgress(total int) (stop func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prog != nil || c.noProgress || !c.topics || c.level < levelNormal {
+		return func() {}
+	}
+	p := &progress{started: time.Now(), total: total, stop: make(chan struct{})}
+	c.prog = p
+	interval := progressInterval
+	if c.live {
+		interval = time.Second
+		// log writes to stderr, most likely the same terminal
+		p.log = log.Writer()
+		log.SetOutput(progressLog{c, p.log})
+		c.drawProgress()
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				c.mu.Lock()
+				if c.live {
+					c.drawProgress()
+				} else {
+					fmt.Fprintf(c.out, "%d/%d done, %d in flight, %s\n", p.done, p.total, len(p.inFlight),
+						time.Since(p.started).Truncate(time.Second))
+				}
+				c.mu.Unlock()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(p.stop)
+		<-done
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.clearProgress()
+		if p.log != nil {
+			log.SetOutput(p.log)
+		}
+		c.prog = nil
+	}
+}
+
+// taskStarted and taskEnded track the topics a worker is processing, for
+// the progress line.
+func (c *consoleReporter) taskStarted(q NewsQuery) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prog == nil {
+		return
+	}
+	c.prog.inFlight = append(c.prog.inFlight, q.Query)
+	c.drawProgress()
+}
+
+func (c *consoleReporter) taskEnded(q NewsQuery) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prog == nil {
+		return
+	}
+	if i := slices.Index(c.prog.inFlight, q.Query); i >= 0 {
+		c.prog.inFlight = slices.Delete(c.prog.inFlight, i, i+1)
+	}
+	c.drawProgress()
+}
+
+// countProgress counts a completed topic; c.mu is held.
+func (c *consoleReporter) countProgress(r TaskResult) {
+	if c.prog == nil {
+		return
+	}
+	c.prog.done++
+	switch {
+	case r.Err != nil:
+		c.prog.failed++
+	case fromCache(strings.TrimSuffix(r.Source, retryNote)):
+		c.prog.fromCache++
+	default:
+		c.prog.fromAPI++
+	}
+	c.drawProgress()
+}
+
+// drawProgress redraws the progress line, if there is one; c.mu is held.
+func (c *consoleReporter) drawProgress() {
+	if c.prog == nil || !c.live {
+		return
+	}
+	fmt.Fprint(c.out, "\r\x1b[K"+c.prog.line(terminalWidth()))
+	c.prog.drawn = true
+}
+
+// clearProgress erases the progress line, if drawn; c.mu is held.
+func (c *consoleReporter) clearProgress() {
+	if c.prog == nil || !c.prog.drawn {
+		return
+	}
+	fmt.Fprint(c.out, "\r\x1b[K")
+	c.prog.drawn = false
+}
+
+// write writes p to the console above the progress line; c.mu is held.
+func (c *consoleReporter) write(p []byte) (int, error) {
+	c.clearProgress()
+	n, err := c.out.Write(p)
+	c.drawProgress()
+	return n, err
+}
+
+// progressLog is log's output while the progress line is shown.
+type progressLog struct {
+	c   *consoleReporter
+	out io.Writer
+}
+
+func (w progressLog) Write(p []byte) (int, error) {
+	w.c.mu.Lock()
+	defer w.c.mu.Unlock()
+	w.c.clearProgress()
+	n, err := w.out.Write(p)
+	w.c.drawProgress()
+	return n, err
+}
@@ -0,0 +1,396 @@
+// provider.go
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -------- Providers --------
+
+// Provider is a news source the worker pool can fetch from. Fetch may return
+// partial results together with an error; every result's Source must be set
+// to Name() so cached rows record where they came from.
+type Provider interface {
+	Name() string
+	Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error)
+}
+
+// providers is the registry of providers, in the order -provider's help
+// lists them; an empty name is newsapi.
+var providers = []struct {
+	name string
+	new  func() Provider
+}{
+	{newsAPIProviderName, func() Provider { return NewsAPIProvider{} }},
+	{guardianProviderName, func() Provider { return GuardianProvider{} }},
+	{gnewsProviderName, func() Provider { return GNewsProvider{} }},
+	{bingProviderName, func() Provider { return BingProvider{} }},
+	{nytProviderName, func() Provider { return NYTProvider{} }},
+	{hnProviderName, func() Provider { return HackerNewsProvider{} }},
+	{redditProviderName, func() Provider { return RedditProvider{IncludeSelfPosts: redditIncludeSelfPosts} }},
+	{rssProviderName, func() Provider { return RSSProvider{} }},
+	{fakeProviderName, func() Provider { return fakeProvider }},
+}
+
+// registeredProviders lists the registered provider names for help text,
+// e.g. "newsapi, guardian or fake".
+func registeredProviders() string {
+	var names []string
+	for _, p := range providers {
+		names = append(names, p.name)
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// newProvider returns the provider registered under name. A comma-separated
+// list (e.g. "newsapi,gnews,rss") is tried in order, see ProviderChain, and
+// names joined with "+" (e.g. "newsapi+guardian") fan out to all of them,
+// see MultiProvider.
+func newProvider(name string) (Provider, error) {
+	if strings.Contains(name, ",") {
+		var chain ProviderChain
+		for _, n := range strings.Split(name, ",") {
+			p, err := newProvider(strings.TrimSpace(n))
+			if err != nil {
+				return nil, err
+			}
+			chain.Providers = append(chain.Providers, p)
+		}
+		return chain, nil
+	}
+	if strings.Contains(name, "+") {
+		var multi MultiProvider
+		for _, n := range strings.Split(name, "+") {
+			p, err := newProvider(strings.TrimSpace(n))
+			if err != nil {
+				return nil, err
+			}
+			multi.Providers = append(multi.Providers, p)
+		}
+		return multi, nil
+	}
+	name = cmp.Or(strings.ToLower(name), newsAPIProviderName)
+	for _, p := range providers {
+		if p.name == name {
+			return p.new(), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown provider %q, want %s", name, registeredProviders())
+}
+
+// parseProviderPrefix splits a "provider:topic" input such as "hn:golang".
+// Anything before the colon that isn't a known provider stays part of the
+// topic.
+func parseProviderPrefix(topic string) (provider, rest string) {
+	name, rest, ok := strings.Cut(topic, ":")
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !ok || name == "" {
+		return "", topic
+	}
+	if _, err := newProvider(name); err != nil {
+		return "", topic
+	}
+	return name, rest
+}
+
+// supportsUntil reports whether p limits its results to NewsQuery.Until,
+// so a delta fetch can ask it for the uncovered part of a window only.
+func supportsUntil(p Provider) bool {
+	switch p := p.(type) {
+	case NewsAPIProvider, GuardianProvider, GNewsProvider, NYTProvider, HackerNewsProvider, FakeProvider:
+		return true
+	case ProviderChain:
+		return all(p.Providers, supportsUntil)
+	case MultiProvider:
+		return all(p.Providers, supportsUntil)
+	}
+	return false
+}
+
+func all(providers []Provider, ok func(Provider) bool) bool {
+	for _, p := range providers {
+		if !ok(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// ProviderError is an HTTP-level error reported by a provider's API, for
+// providers whose error bodies carry no finer-grained code.
+type ProviderError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+	// RetryAt is when the provider asked us to come back, if it did.
+	RetryAt time.Time
+}
+
+func (e *ProviderError) Error() string {
+	msg := fmt.Sprintf("%s: http %d: %s", e.Provider, e.StatusCode, e.Message)
+	if !e.RetryAt.IsZero() {
+		msg += " (retry after " + e.RetryAt.Local().Format("15:04:05") + ")"
+	}
+	return msg
+}
+
+func (e *ProviderError) Is(target error) bool {
+	return target == ErrRateLimited && e.StatusCode == http.StatusTooManyRequests
+}
+
+func (e *ProviderError) retryAt() time.Time { return e.RetryAt }
+
+// Retryable reports whether the same request may succeed later; only rate
+// limits and server-side failures qualify.
+func (e *ProviderError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// ProviderChain tries its providers in priority order, moving on to the next
+// one when a provider fails or finds nothing. Network errors get one retry
+// before the chain moves on; auth, quota and other provider errors move on
+// immediately since repeating the request won't help.
+type ProviderChain struct {
+	Providers []Provider
+}
+
+func (c ProviderChain) Name() string {
+	names := make([]string, len(c.Providers))
+	for i, p := range c.Providers {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (c ProviderChain) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	var errs []error
+	for _, p := range c.Providers {
+		news, err := p.Fetch(ctx, q)
+		if err != nil && errorClass(err) == errClassNetwork && ctx.Err() == nil {
+			news, err = p.Fetch(ctx, q)
+		}
+		if err == nil && len(news) > 0 {
+			return news, nil
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if len(errs) == 0 {
+		return []NewsResult{}, nil // every provider answered, none had results
+	}
+	return nil, errors.Join(errs...)
+}
+
+const (
+	errClassOther = iota
+	errClassAuth
+	errClassRateLimit
+	errClassNetwork
+)
+
+// errorClass buckets a fetch error for ProviderChain.
+func errorClass(err error) int {
+	var newsAPIErr *NewsAPIError
+	var providerErr *ProviderError
+	switch {
+	case isRateLimited(err):
+		return errClassRateLimit
+	case errors.As(err, &newsAPIErr):
+		if strings.HasPrefix(newsAPIErr.Code, "apiKey") {
+			return errClassAuth
+		}
+		return errClassOther
+	case errors.As(err, &providerErr):
+		if providerErr.StatusCode == http.StatusUnauthorized || providerErr.StatusCode == http.StatusForbidden {
+			return errClassAuth
+		}
+		return errClassOther
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return errClassOther
+	}
+	var netErr net.Error
+	var urlErr *url.Error
+	if errors.As(err, &netErr) || errors.As(err, &urlErr) {
+		return errClassNetwork
+	}
+	return errClassOther
+}
+
+// MultiProvider queries all its providers concurrently and merges their
+// results, dropping duplicate links and keeping the newest articles first.
+// It only fails if every provider does.
+type MultiProvider struct {
+	Providers []Provider
+}
+
+func (p MultiProvider) Name() string {
+	names := make([]string, len(p.Providers))
+	for i, sub := range p.Providers {
+		names[i] = sub.Name()
+	}
+	return strings.Join(names, "+")
+}
+
+func (p MultiProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	type fetchResult struct {
+		news []NewsResult
+		err  error
+	}
+	results := make([]fetchResult, len(p.Providers))
+	var wg sync.WaitGroup
+	for i, sub := range p.Providers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			news, err := safeFetch(ctx, sub, q)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", sub.Name(), err)
+			}
+			results[i] = fetchResult{news, err}
+		}()
+	}
+	wg.Wait()
+
+	var merged []NewsResult
+	var errs []error
+	for _, r := range results {
+		merged = append(merged, r.news...)
+		if r.err != nil {
+			errs = append(errs, r.err)
+		}
+	}
+	merged = dedupeResults(merged)
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].PublishedAt.After(merged[j].PublishedAt)
+	})
+	if len(merged) > q.MaxItems {
+		merged = merged[:q.MaxItems]
+	}
+	if len(errs) == len(p.Providers) {
+		return merged, errors.Join(errs...)
+	}
+	return merged, nil
+}
+
+// safeFetch is p.Fetch for a goroutine of its own, where a panic would
+// take the process down: a panic fails the fetch with a PanicError
+// instead, which is then the error of p.
+func safeFetch(ctx context.Context, p Provider, q NewsQuery) (news []NewsResult, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			news, err = nil, newPanicError(fmt.Sprintf("fetching %s from %s", topicLabel(q), p.Name()), v)
+		}
+	}()
+	return p.Fetch(ctx, q)
+}
+
+// dedupeResults drops results whose canonical URL was already seen,
+// keeping the first occurrence.
+func dedupeResults(news []NewsResult) []NewsResult {
+	seen := make(map[string]bool, len(news))
+	out := news[:0:0]
+	for _, r := range news {
+		key := canonicalURL(r.URL)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, r)
+	}
+	return out
+}
+
+// canonicalURL normalizes a link for duplicate detection: scheme and host
+// are lowercased, "www." and fragments are dropped, as are utm_* tracking
+// parameters and a trailing slash.
+func canonicalURL(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" {
+		return strings.TrimSpace(raw)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme == "http" {
+		u.Scheme = "https"
+	}
+	u.Host = strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	u.Fragment = ""
+	query := u.Query()
+	for k := range query {
+		if strings.HasPrefix(strings.ToLower(k), "utm_") {
+			query.Del(k)
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u.String()
+}
+
+// contributors lists the distinct providers that results came from, in
+// order of first appearance.
+func contributors(news []NewsResult) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, r := range news {
+		if !seen[r.Source] {
+			seen[r.Source] = true
+			names = append(names, r.Source)
+		}
+	}
+	return names
+}
+
+// isRateLimited reports whether err is a provider telling us to slow down
+// or that the day's quota is used up.
+func isRateLimited(err error) bool {
+	return errors.Is(err, ErrRateLimited)
+}
+
+// isRetryable treats anything that isn't a definitive provider error
+// (network failures, timeouts, a missing local key) as transient. A joined
+// error from several providers is retryable if any part of it is.
+func isRetryable(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			if isRetryable(e) {
+				return true
+			}
+		}
+		return false
+	}
+	var apiErr interface{ Retryable() bool }
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	return true
+}
+
+// parsePublishedAt parses a provider timestamp, returning the zero time
+// for missing or malformed values rather than failing the whole fetch.
+func parsePublishedAt(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{
+		time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02",
+		// RSS pubDate variants
+		time.RFC1123Z, time.RFC1123, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST",
+		"2 Jan 2006 15:04:05 -0700", time.RFC822Z, time.RFC822,
+	} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,133 @@
+// provider_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProviderChainFailureModes(t *testing.T) {
+	network := &url.Error{Op: "Get", URL: "https://api.example.com", Err: errors.New("connection refused")}
+	for _, tc := range []struct {
+		name string
+		err  error
+		// calls is how often the failing provider is asked
+		calls int64
+	}{
+		{"auth", &ProviderError{Provider: "stub", StatusCode: http.StatusUnauthorized, Message: "bad key"}, 1},
+		{"newsapi key", &NewsAPIError{StatusCode: http.StatusUnauthorized, Code: "apiKeyInvalid", Message: "bad key"}, 1},
+		{"rate limit", &ProviderError{Provider: "stub", StatusCode: http.StatusTooManyRequests, Message: "slow down"}, 1},
+		{"quota", ErrQuotaReached, 1},
+		{"server", &ProviderError{Provider: "stub", StatusCode: http.StatusBadGateway, Message: "bad gateway"}, 1},
+		{"network", network, 2},
+		{"no results", nil, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			failing, next := &stubProvider{err: tc.err}, &stubProvider{news: stubArticles(2)}
+			news, err := ProviderChain{Providers: []Provider{failing, next}}.Fetch(context.Background(), testQuery("go"))
+			if err != nil || len(news) != 2 {
+				t.Fatalf("got %d results and error %v, want the next provider's 2", len(news), err)
+			}
+			if n := failing.calls.Load(); n != tc.calls {
+				t.Errorf("the failing provider was asked %d times, want %d", n, tc.calls)
+			}
+		})
+	}
+}
+
+func TestProviderChainAllFail(t *testing.T) {
+	first := &stubProvider{err: &ProviderError{Provider: "stub", StatusCode: http.StatusUnauthorized, Message: "bad key"}}
+	second := &stubProvider{err: &ProviderError{Provider: "stub", StatusCode: http.StatusTooManyRequests, Message: "slow down"}}
+	_, err := ProviderChain{Providers: []Provider{first, second}}.Fetch(context.Background(), testQuery("go"))
+	if err == nil || !strings.Contains(err.Error(), "bad key") || !strings.Contains(err.Error(), "slow down") {
+		t.Fatalf("got %v, want both providers' errors", err)
+	}
+	if !errors.Is(err, ErrRateLimited) {
+		t.Error("the joined error doesn't wrap the rate limit")
+	}
+
+	empty := ProviderChain{Providers: []Provider{&stubProvider{}, &stubProvider{}}}
+	news, err := empty.Fetch(context.Background(), testQuery("go"))
+	if err != nil || news == nil || len(news) != 0 {
+		t.Errorf("every provider empty: got %v, %v; want no results and no error", news, err)
+	}
+}
+
+func TestProviderChainStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	first, second := &stubProvider{err: context.Canceled}, &stubProvider{news: stubArticles(1)}
+	if _, err := (ProviderChain{Providers: []Provider{first, second}}).Fetch(ctx, testQuery("go")); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if second.calls.Load() != 0 {
+		t.Error("the chain went on after the context was canceled")
+	}
+}
+
+func TestProviderChainFromFlag(t *testing.T) {
+	p, err := newProvider("newsapi, gnews,rss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, ok := p.(ProviderChain)
+	if !ok || chain.Name() != "newsapi,gnews,rss" {
+		t.Fatalf("got %T %s, want the chain newsapi,gnews,rss", p, p.Name())
+	}
+	if _, err := newProvider("newsapi,nope"); err == nil {
+		t.Error("a chain with an unknown provider was accepted")
+	}
+}
+
+func TestStrictProviderIgnoresOtherProvidersRows(t *testing.T) {
+	db := openTestDB(t)
+	q := testQuery("golang")
+	news := stubArticles(5)
+	for i := range news {
+		news[i].Title, news[i].Source = "NewsAPI "+news[i].Title, newsAPIProviderName
+	}
+	if err := storeFetched(db, q, news); err != nil {
+		t.Fatal(err)
+	}
+	keep(t, &strictProviders)
+
+	// any provider's rows do without strict matching
+	if got := getCachedResults(db, q, q.cacheCutoff()); len(got) != 5 {
+		t.Fatalf("%d cached rows without strict matching, want 5", len(got))
+	}
+	guardianOnly := q
+	guardianOnly.Provider = guardianProviderName
+	strictProviders = providerNames(guardianProviderName)
+	for name, q := range map[string]NewsQuery{"-strict-provider": q, "a guardian: topic": guardianOnly} {
+		if got := getCachedResults(db, q, q.cacheCutoff()); len(got) != 0 {
+			t.Errorf("%s: got %d NewsAPI rows for a Guardian-only search", name, len(got))
+		}
+		if days, items := getMaxCachedParams(db, q); days != 0 || items != 0 {
+			t.Errorf("%s: NewsAPI rows cover %d days of %d items", name, days, items)
+		}
+	}
+
+	// the worker fetches instead, and serves the new rows from then on
+	strictProviders = providerNames("stub")
+	provider := &stubProvider{news: stubArticles(5)}
+	tasks := startTestPool(t, db, provider, 1)
+	for _, want := range []string{"API", "DB"} {
+		r := submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second)
+		if r.Err != nil || r.Source != want || len(r.Results) != 5 {
+			t.Fatalf("got %d results from %q, error %v; want 5 from %s", len(r.Results), r.Source, r.Err, want)
+		}
+		for _, res := range r.Results {
+			if strings.HasPrefix(res.Title, "NewsAPI") {
+				t.Errorf("%s: served the NewsAPI row %q", want, res.Title)
+			}
+		}
+	}
+	if n := provider.calls.Load(); n != 1 {
+		t.Errorf("%d provider calls, want 1", n)
+	}
+}
@@ -0,0 +1,168 @@
+// quota.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// -------- NewsAPI daily quota --------
+
+// ErrQuotaReached is returned, wrapped, once every NewsAPI key has used up
+// the -max-api-calls budget for the day. No request is sent.
+var ErrQuotaReached = errors.New("daily API call budget reached")
+
+// ErrQuotaUnchecked is returned, wrapped, when a call couldn't be charged
+// to its key's budget, the cache being busy or gone. No request is sent,
+// since it would go uncounted.
+var ErrQuotaUnchecked = errors.New("daily API call budget couldn't be checked")
+
+// APIUsage counts the NewsAPI requests sent with one key on one day. Day is
+// a 2006-01-02 date in the quota timezone; keys are stored as hashes only.
+type APIUsage struct {
+	Day     string `gorm:"primaryKey"`
+	KeyHash string `gorm:"primaryKey"`
+	Calls   int    `gorm:"not null;default:0"`
+}
+
+// Quota enforces a per-key daily request budget. A zero MaxCalls only
+// counts requests without limiting them.
+type Quota struct {
+	mu       sync.Mutex
+	db       *gorm.DB
+	MaxCalls int
+	// Location decides when a day ends; NewsAPI resets at midnight UTC.
+	Location *time.Location
+}
+
+// newsAPIQuota is the budget KeyRing.Pick charges; main sets it up once the
+// DB is open.
+var newsAPIQuota *Quota
+
+func newQuota(db *gorm.DB, maxCalls int, loc *time.Location) *Quota {
+	return &Quota{db: db, MaxCalls: maxCalls, Location: loc}
+}
+
+func (q *Quota) today() string {
+	return time.Now().In(q.Location).Format("2006-01-02")
+}
+
+// used is the number of calls made with the key hashed as keyHash on day.
+func (q *Quota) used(day, keyHash string) int {
+	var u APIUsage
+	q.db.Where("day = ? AND key_hash = ?", day, keyHash).Limit(1).Find(&u)
+	return u.Calls
+}
+
+// Take charges one call to key, or reports false without charging when the
+// key's budget for today is spent. A busy SQLite cache is waited for; if
+// the count still can't be written, the error is returned and the call
+// must not be made.
+func (q *Quota) Take(key string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	day, h := q.today(), hashKey(key)
+	upsert := clause.OnConflict{
+		Columns:   []clause.Column{{Name: "day"}, {Name: "key_hash"}},
+		DoUpdates: clause.Assignments(map[string]any{"calls": gorm.Expr("api_usages.calls + 1")}),
+	}
+	switch {
+	case q.MaxCalls > 0 && isMySQL(q.db):
+		// ON DUPLICATE KEY UPDATE takes no WHERE; leaving calls unchanged
+		// reports no affected rows just the same
+		upsert.DoUpdates = clause.Assignments(map[string]any{
+			"calls": gorm.Expr("IF(api_usages.calls < ?, api_usages.calls + 1, api_usages.calls)", q.MaxCalls)})
+	case q.MaxCalls > 0:
+		// checked in the upsert itself so processes sharing a Postgres cache
+		// can't overspend between a read and the write
+		upsert.Where = clause.Where{Exprs: []clause.Expression{gorm.Expr("api_usages.calls < ?", q.MaxCalls)}}
+	}
+	var charged bool
+	err := withBusyRetry(func() error {
+		tx := q.db.Clauses(upsert).Create(&APIUsage{Day: day, KeyHash: h, Calls: 1})
+		charged = tx.RowsAffected > 0
+		return tx.Error
+	})
+	if err != nil {
+		return false, err
+	}
+	return charged, nil
+}
+
+// Report writes today's usage and remaining budget for each key.
+func (q *Quota) Report(w io.Writer, keys []string) {
+	day := q.today()
+	fmt.Fprintf(w, "NewsAPI usage for %s (%s):\n", day, q.Location)
+	if len(keys) == 0 {
+		fmt.Fprintln(w, "  no keys configured")
+	}
+	total := 0
+	for i, k := range keys {
+		calls := q.used(day, hashKey(k))
+		total += calls
+		line := fmt.Sprintf("  key %d (%s): %d calls", i+1, hashKey(k)[:8], calls)
+		if q.MaxCalls > 0 {
+			line += fmt.Sprintf(", %d of %d remaining", max(q.MaxCalls-calls, 0), q.MaxCalls)
+		}
+		fmt.Fprintln(w, line)
+	}
+	if len(keys) > 1 {
+		fmt.Fprintf(w, "  total: %d calls\n", total)
+	}
+	if q.MaxCalls == 0 {
+		fmt.Fprintln(w, "  no budget set (use -max-api-calls)")
+	}
+	q.reportUsers(w)
+}
+
+// reportUsers writes today's provider requests per user from the search
+// log. These cover every provider, not only the NewsAPI keys above.
+func (q *Quota) reportUsers(w io.Writer) {
+	y, m, d := time.Now().In(q.Location).Date()
+	start := time.Date(y, m, d, 0, 0, 0, 0, q.Location)
+	var users []struct {
+		UserID   string
+		APICalls int64
+	}
+	q.db.Model(&SearchLog{}).Select("user_id, SUM(api_calls) AS api_calls").
+		Where(sqlInstant(q.db, "searched_at")+" >= "+sqlInstant(q.db, "?"), start).
+		Group("user_id").Having("SUM(api_calls) > 0").Order("user_id").Scan(&users)
+	if len(users) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "API calls by user today (all providers):")
+	for _, u := range users {
+		fmt.Fprintf(w, "  %s: %d calls\n", u.UserID, u.APICalls)
+	}
+}
+
+// -------- Per-run API budget --------
+
+// RunBudget caps how many cache misses one pass over the input file may
+// send to the providers; the rest are served from the cache. Workers share
+// it, so the counter is atomic. A nil *RunBudget is unlimited.
+type RunBudget struct {
+	Limit int
+	used  atomic.Int64
+}
+
+// apiBudget is set from -api-budget and reset before every pass.
+var apiBudget *RunBudget
+
+// Take reserves one fetch, reporting false once the budget is spent.
+func (b *RunBudget) Take() bool {
+	return b == nil || b.used.Add(1) <= int64(b.Limit)
+}
+
+func (b *RunBudget) Reset() {
+	if b != nil {
+		b.used.Store(0)
+	}
+}
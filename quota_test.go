@@ -0,0 +1,101 @@
+// quota_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunBudget(t *testing.T) {
+	db := openTestDB(t)
+	apiBudget = &RunBudget{Limit: 3}
+	t.Cleanup(func() { apiBudget = nil })
+	stub := &stubProvider{news: stubArticles(5)}
+	tasks := startTestPool(t, db, stub, 4)
+
+	// one topic has a few rows cached already, too few to be a hit
+	cached := testQuery("topic 20")
+	cached.MaxItems = 2
+	if err := storeFetched(db, cached, stubArticles(2)); err != nil {
+		t.Fatal(err)
+	}
+	var topics []NewsQuery
+	for i := 1; i <= 20; i++ {
+		topics = append(topics, testQuery(fmt.Sprintf("topic %d", i)))
+	}
+	var out bytes.Buffer
+	stats, results := runPass(context.Background(), db, tasks, "budget.txt", topics, 5*time.Second, &out)
+
+	if n := stub.calls.Load(); n != 3 {
+		t.Errorf("the provider was called %d times, want 3", n)
+	}
+	if stats.APICalls != 3 || stats.Misses != 3 || stats.budgetLimited != 17 {
+		t.Errorf("got %d API calls, %d misses, %d topics over budget; want 3, 3, 17", stats.APICalls, stats.Misses, stats.budgetLimited)
+	}
+	var served, failed int
+	for i, r := range results {
+		switch {
+		case r.BudgetLimited && r.Err != nil:
+			failed++
+		case r.BudgetLimited:
+			served++
+			if topics[i].Query != "topic 20" || r.Source != "DB (api budget exhausted)" || len(r.Results) != 2 {
+				t.Errorf("%s: got %d results from %q", topics[i].Query, len(r.Results), r.Source)
+			}
+		}
+	}
+	// topic 20 may have got one of the three calls instead
+	if served+failed != 17 || served > 1 {
+		t.Errorf("%d topics over budget served from the cache and %d failed, want 17 in all", served, failed)
+	}
+	if note := "17 of 20 topics skipped the API: -api-budget 3 exhausted"; !strings.Contains(out.String(), note) {
+		t.Errorf("the results don't say %q:\n%s", note, out.String())
+	}
+
+	// every pass gets the budget afresh
+	stats, _ = runPass(context.Background(), db, tasks, "budget.txt", topics, 5*time.Second, &out)
+	if stats.APICalls != 3 || stats.Hits != 3 {
+		t.Errorf("second pass: %d API calls and %d hits, want 3 of each", stats.APICalls, stats.Hits)
+	}
+}
+
+func TestQuotaTake(t *testing.T) {
+	q := newQuota(openTestDB(t), 2, time.UTC)
+	for i, want := range []bool{true, true, false} {
+		if ok, err := q.Take("key"); ok != want || err != nil {
+			t.Errorf("call %d: got %v, %v; want %v", i+1, ok, err, want)
+		}
+	}
+	if n := q.used(q.today(), hashKey("key")); n != 2 {
+		t.Errorf("counted %d calls, want 2", n)
+	}
+}
+
+func TestQuotaUncountedCallIsNotMade(t *testing.T) {
+	db := openTestDB(t)
+	keep(t, &newsAPIQuota)
+	newsAPIQuota = newQuota(db, 10, time.UTC)
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+	if _, err := newsAPIQuota.Take("key"); err == nil {
+		t.Fatal("Take succeeded without the cache")
+	}
+
+	var requests int
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, newsAPIArticles(1, 1, 1))
+	})
+	useNewsAPIKeys(t, "key")
+	_, err := NewsAPIProvider{}.Fetch(context.Background(), testQuery("go"))
+	if !errors.Is(err, ErrQuotaUnchecked) || requests != 0 {
+		t.Errorf("got %v after %d requests, want ErrQuotaUnchecked and none sent", err, requests)
+	}
+}
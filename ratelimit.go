@@ -0,0 +1,131 @@
+// ratelimit.go
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -------- Rate limits --------
+
+// ErrRateLimited matches (via errors.Is) every provider error caused by a
+// rate limit or an exhausted quota.
+var ErrRateLimited = errors.New("rate limited")
+
+const (
+	// maxRateLimitWait caps a Retry-After sleep for tasks without a deadline.
+	maxRateLimitWait = 30 * time.Second
+	// maxRateLimitWaits bounds how often one fetch sleeps before giving up.
+	maxRateLimitWaits = 3
+)
+
+// rateLimitRetryAt reads Retry-After (seconds or an HTTP date) or, once
+// X-RateLimit-Remaining hits zero, X-RateLimit-Reset (a unix timestamp or
+// seconds from now). It returns the zero time when the headers say nothing.
+func rateLimitRetryAt(h http.Header, now time.Time) time.Time {
+	if v := strings.TrimSpace(h.Get("Retry-After")); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return now.Add(time.Duration(secs) * time.Second)
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return t
+		}
+	}
+	remaining, err := strconv.ParseFloat(strings.TrimSpace(h.Get("X-RateLimit-Remaining")), 64)
+	if err != nil || remaining > 0 {
+		return time.Time{}
+	}
+	reset, err := strconv.ParseInt(strings.TrimSpace(h.Get("X-RateLimit-Reset")), 10, 64)
+	switch {
+	case err != nil:
+		return time.Time{}
+	case reset > 1_000_000_000: // an epoch timestamp, not a delta
+		return time.Unix(reset, 0)
+	default:
+		return now.Add(time.Duration(reset) * time.Second)
+	}
+}
+
+// retryAtOf returns when a rate-limit error says to try again, or the zero
+// time if it doesn't say.
+func retryAtOf(err error) time.Time {
+	var rl interface{ retryAt() time.Time }
+	if errors.As(err, &rl) {
+		return rl.retryAt()
+	}
+	return time.Time{}
+}
+
+// waitForRetry sleeps until at when that is before ctx's deadline (or within
+// maxRateLimitWait if there is none) and reports whether it did. Waits that
+// can't fit return false immediately so the caller can fail fast.
+func waitForRetry(ctx context.Context, at time.Time) bool {
+	wait := time.Until(at)
+	if wait <= 0 {
+		return true
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if at.After(deadline) {
+			return false
+		}
+	} else if wait > maxRateLimitWait {
+		return false
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(wait):
+		return true
+	}
+}
+
+// -------- Concurrent fetches --------
+
+// A fetchLimiter bounds how many provider fetches are in flight at once,
+// whatever the number of workers: a worker takes a slot around the fetch
+// alone, so tasks served from the cache never wait for one. A nil
+// fetchLimiter has no limit.
+type fetchLimiter chan struct{}
+
+// fetchSlots is the limiter processTask fetches under, set from
+// -max-concurrent-fetches.
+var fetchSlots fetchLimiter
+
+// newFetchLimiter returns a limiter of n slots, nil if n is 0.
+func newFetchLimiter(n int) fetchLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return make(fetchLimiter, n)
+}
+
+// acquire takes a slot, waiting until one is free or ctx is done, in which
+// case it returns ctx's error.
+func (l fetchLimiter) acquire(ctx context.Context, q NewsQuery) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l <- struct{}{}:
+		return nil
+	default:
+	}
+	verbosef("%s: waiting for one of the %d fetch slots", topicLabel(q), cap(l))
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees the slot acquire took.
+func (l fetchLimiter) release() {
+	if l != nil {
+		<-l
+	}
+}
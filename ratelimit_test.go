@@ -0,0 +1,250 @@
+// ratelimit_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitRetryAt(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name    string
+		headers map[string]string
+		want    time.Time
+	}{
+		{"none", nil, time.Time{}},
+		{"seconds", map[string]string{"Retry-After": "30"}, now.Add(30 * time.Second)},
+		{"date", map[string]string{"Retry-After": "Wed, 01 May 2024 12:02:00 GMT"}, now.Add(2 * time.Minute)},
+		{"garbage", map[string]string{"Retry-After": "soon"}, time.Time{}},
+		{"reset delta", map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "90"}, now.Add(90 * time.Second)},
+		{"reset epoch", map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": fmt.Sprint(now.Add(time.Hour).Unix())}, now.Add(time.Hour)},
+		{"calls left", map[string]string{"X-RateLimit-Remaining": "5", "X-RateLimit-Reset": "90"}, time.Time{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tc.headers {
+				h.Set(k, v)
+			}
+			if got := rateLimitRetryAt(h, now); !got.Equal(tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// rateLimitOnce answers the first request with a 429, with Retry-After
+// when retryAfter is set, and the rest with one article each. It records
+// the key each request was sent with.
+func rateLimitOnce(retryAfter string, keys *[]string) http.HandlerFunc {
+	var mu sync.Mutex
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		*keys = append(*keys, r.Header.Get("X-Api-Key"))
+		if len(*keys) == 1 {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"status":"error","code":"rateLimited","message":"You have made too many requests recently."}`)
+			return
+		}
+		fmt.Fprint(w, newsAPIArticles(1, 1, 1))
+	}
+}
+
+func TestNewsAPIWaitsForRetryAfter(t *testing.T) {
+	var keys []string
+	stubAPI(t, rateLimitOnce("1", &keys))
+	// the only key is worth waiting for
+	useNewsAPIKeys(t, "only")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	news, err := NewsAPIProvider{}.Fetch(ctx, testQuery("go"))
+	if err != nil || len(news) != 1 {
+		t.Fatalf("got %d results and error %v, want 1 result", len(news), err)
+	}
+	if waited := time.Since(start); waited < 900*time.Millisecond {
+		t.Errorf("retried after %s, want about the 1s of Retry-After", waited)
+	}
+	if len(keys) != 2 {
+		t.Errorf("sent %d requests, want 2", len(keys))
+	}
+}
+
+func TestNewsAPIRetryAfterPastDeadline(t *testing.T) {
+	var keys []string
+	stubAPI(t, rateLimitOnce("3600", &keys))
+	useNewsAPIKeys(t, "first", "second")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := (NewsAPIProvider{}).Fetch(ctx, testQuery("go")); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("waited %s for a Retry-After past the deadline", waited)
+	}
+	if len(keys) != 2 || keys[1] != "second" {
+		t.Errorf("sent with keys %v, want the second key next", keys)
+	}
+}
+
+func TestNewsAPIRateLimitWithoutRetryAfter(t *testing.T) {
+	var keys []string
+	stubAPI(t, rateLimitOnce("", &keys))
+	useNewsAPIKeys(t, "first", "second")
+	if _, err := (NewsAPIProvider{}).Fetch(context.Background(), testQuery("go")); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "first" || keys[1] != "second" {
+		t.Errorf("sent with keys %v, want first then second", keys)
+	}
+
+	// with no key left, the rate limit is the result
+	keys = nil
+	stubAPI(t, rateLimitOnce("", &keys))
+	useNewsAPIKeys(t, "only")
+	_, err := NewsAPIProvider{}.Fetch(context.Background(), testQuery("go"))
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("got %v, want a rate limit error", err)
+	}
+}
+
+func TestNewsAPIRateLimitedWithoutCooldown(t *testing.T) {
+	var requests atomic.Int64
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"status":"error","code":"rateLimited","message":"You have made too many requests recently."}`)
+	})
+	// a key whose rest is over as soon as it starts is handed straight back
+	saved := newsAPIKeys
+	newsAPIKeys = newKeyRing(nil, []string{"only"}, 0)
+	t.Cleanup(func() { newsAPIKeys = saved })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := NewsAPIProvider{}.Fetch(ctx, testQuery("go"))
+	var apiErr *NewsAPIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "apiKeyExhausted" {
+		t.Errorf("got %v, want every key exhausted", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("sent %d requests, want the one the only key was refused", n)
+	}
+}
+
+// heldProvider is a stubProvider whose fetches last until release is
+// closed, or their context is done, and which keeps the most that were in
+// flight at once.
+type heldProvider struct {
+	*stubProvider
+	release   chan struct{}
+	mu        sync.Mutex
+	now, most int
+}
+
+func (p *heldProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	p.mu.Lock()
+	p.now++
+	p.most = max(p.most, p.now)
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.now--
+		p.mu.Unlock()
+	}()
+	select {
+	case <-p.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return p.stubProvider.Fetch(ctx, q)
+}
+
+// inFlight is how many of p's fetches are under way.
+func (p *heldProvider) inFlight() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.now
+}
+
+func TestMaxConcurrentFetches(t *testing.T) {
+	keep(t, &fetchSlots)
+	fetchSlots = newFetchLimiter(2)
+	db := openTestDB(t)
+	p := &heldProvider{stubProvider: &stubProvider{news: stubArticles(5)}, release: make(chan struct{})}
+	tasks := startTestPool(t, db, p, 8)
+	submit := func(topics ...string) <-chan TaskResult {
+		results := make(chan TaskResult, len(topics))
+		for _, topic := range topics {
+			go func() {
+				results <- submitTask(context.Background(), tasks, testQuery(topic), nextTaskSeq(), 5*time.Second)
+			}()
+		}
+		return results
+	}
+
+	// cache one topic while the slots are free
+	close(p.release)
+	if r := <-submit("cached"); r.Err != nil || r.Source != "API" {
+		t.Fatalf("got %+v, want the topic fetched", r)
+	}
+	p.release = make(chan struct{})
+
+	misses := submit("golang", "rust", "zig", "haskell", "ocaml", "elixir")
+	for start := time.Now(); p.inFlight() < 2; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the misses weren't fetched")
+		}
+	}
+	// with both slots taken, and workers to spare, a cache hit is served
+	// at once
+	select {
+	case r := <-submit("cached"):
+		if r.Err != nil || r.Source != "DB" {
+			t.Errorf("got %+v, want the cached topic", r)
+		}
+	case <-time.After(time.Second):
+		t.Error("a cache hit waited for a fetch slot")
+	}
+
+	close(p.release)
+	for range 6 {
+		if r := <-misses; r.Err != nil || r.Source != "API" {
+			t.Errorf("got %+v, want the topic fetched", r)
+		}
+	}
+	if p.most != 2 {
+		t.Errorf("got %d fetches at once, want the limit, 2", p.most)
+	}
+}
+
+func TestFetchSlotWaitEndsWithTheTask(t *testing.T) {
+	l := newFetchLimiter(1)
+	if err := l.acquire(context.Background(), testQuery("golang")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(ctx, testQuery("rust")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v waiting for the taken slot, want the deadline", err)
+	}
+	l.release()
+	if err := l.acquire(context.Background(), testQuery("rust")); err != nil {
+		t.Errorf("got %v once the slot was freed", err)
+	}
+	// no limit never waits
+	var none fetchLimiter
+	if err := none.acquire(ctx, testQuery("zig")); err != nil {
+		t.Errorf("got %v without a limit", err)
+	}
+}
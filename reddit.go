@@ -0,0 +1,210 @@
+// reddit.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// -------- Reddit search provider --------
+const redditProviderName = "reddit"
+
+const (
+	// redditMaxPageSize is the largest limit the search endpoint serves.
+	redditMaxPageSize = 100
+	// redditDefaultBackoff is used when a 429 comes without a reset header.
+	redditDefaultBackoff = 60 * time.Second
+)
+
+// redditIncludeSelfPosts makes self (text) posts appear with their
+// permalink instead of being skipped. Set from the -reddit-self-posts flag.
+var redditIncludeSelfPosts bool
+
+// RedditProvider queries reddit's public JSON search without
+// authentication.
+type RedditProvider struct {
+	IncludeSelfPosts bool
+}
+
+func (RedditProvider) Name() string { return redditProviderName }
+
+func (p RedditProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	return fetchReddit(ctx, q, p.IncludeSelfPosts)
+}
+
+type RedditResponse struct {
+	Data struct {
+		After    string `json:"after"`
+		Children []struct {
+			Data struct {
+				Title      string  `json:"title"`
+				URL        string  `json:"url"`
+				Permalink  string  `json:"permalink"`
+				IsSelf     bool    `json:"is_self"`
+				CreatedUTC float64 `json:"created_utc"`
+				Author     string  `json:"author"`
+				Subreddit  string  `json:"subreddit_name_prefixed"`
+				Selftext   string  `json:"selftext"`
+			} `json:"data"`
+		} `json:"children"`
+	} `json:"data"`
+	Message string `json:"message"`
+}
+
+// redditLimiter is shared by every worker: unauthenticated clients get a
+// small per-IP budget, so once reddit reports it spent we all wait out the
+// reset window instead of hammering it.
+var redditLimiter struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// redditWait blocks until the shared back-off window has passed or ctx is
+// done.
+func redditWait(ctx context.Context) error {
+	redditLimiter.mu.Lock()
+	wait := time.Until(redditLimiter.until)
+	redditLimiter.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// redditObserve records the rate-limit headers of a response. Reddit sends
+// the remaining budget and the seconds until it resets as decimals.
+func redditObserve(resp *http.Response) {
+	remaining, errRem := strconv.ParseFloat(resp.Header.Get("X-Ratelimit-Remaining"), 64)
+	reset, errReset := strconv.ParseFloat(resp.Header.Get("X-Ratelimit-Reset"), 64)
+	var backoff time.Duration
+	switch {
+	case errReset == nil && (resp.StatusCode == http.StatusTooManyRequests || (errRem == nil && remaining < 1)):
+		backoff = time.Duration(math.Ceil(reset)) * time.Second
+	case resp.StatusCode == http.StatusTooManyRequests:
+		backoff = redditDefaultBackoff
+	default:
+		return
+	}
+	redditLimiter.mu.Lock()
+	if until := time.Now().Add(backoff); until.After(redditLimiter.until) {
+		redditLimiter.until = until
+	}
+	redditLimiter.mu.Unlock()
+}
+
+// redditTimeBucket maps a days window onto reddit's t parameter; results
+// are filtered by creation time afterwards since the buckets are coarse.
+func redditTimeBucket(days int) string {
+	switch {
+	case days <= 1:
+		return "day"
+	case days <= 7:
+		return "week"
+	case days <= 31:
+		return "month"
+	case days <= 365:
+		return "year"
+	}
+	return "all"
+}
+
+func fetchReddit(ctx context.Context, q NewsQuery, includeSelf bool) ([]NewsResult, error) {
+	if q.endpoint() == EndpointTopHeadlines {
+		return nil, &ProviderError{Provider: redditProviderName, StatusCode: http.StatusBadRequest,
+			Message: "top-headlines queries are not supported"}
+	}
+	since := q.windowMidnight()
+	params := url.Values{}
+	params.Set("q", q.Query)
+	params.Set("t", redditTimeBucket(q.Days))
+	params.Set("type", "link")
+	if q.SortBy == "publishedAt" {
+		params.Set("sort", "new")
+	} else if q.SortBy == "popularity" {
+		params.Set("sort", "top")
+	}
+	pageSize := min(q.MaxItems, redditMaxPageSize)
+	params.Set("limit", strconv.Itoa(pageSize))
+	params.Set("raw_json", "1")
+
+	news := []NewsResult{}
+	for len(news) < q.MaxItems {
+		if err := redditWait(ctx); err != nil {
+			return news, err
+		}
+		result, err := fetchRedditPage(ctx, "https://www.reddit.com/search.json?"+params.Encode())
+		if err != nil {
+			return news, err
+		}
+		for _, c := range result.Data.Children {
+			d := c.Data
+			link := d.URL
+			if d.IsSelf {
+				if !includeSelf {
+					continue
+				}
+				link = "https://www.reddit.com" + d.Permalink
+			}
+			published := time.Unix(int64(d.CreatedUTC), 0).UTC()
+			if published.Before(since) {
+				continue
+			}
+			news = append(news, NewsResult{
+				Title:       d.Title,
+				URL:         link,
+				Source:      redditProviderName,
+				PublishedAt: published,
+				Description: d.Selftext,
+				Author:      d.Author,
+				SourceName:  d.Subreddit,
+			})
+			if len(news) >= q.MaxItems {
+				break
+			}
+		}
+		if result.Data.After == "" || len(result.Data.Children) < pageSize {
+			break
+		}
+		params.Set("after", result.Data.After)
+	}
+	return news, nil
+}
+
+func fetchRedditPage(ctx context.Context, reqURL string) (RedditResponse, error) {
+	var result RedditResponse
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return result, err
+	}
+	resp, err := doWithRetry(ctx, req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+	redditObserve(resp)
+
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK {
+		msg := result.Message
+		if msg == "" {
+			msg = http.StatusText(resp.StatusCode)
+		}
+		return result, &ProviderError{Provider: redditProviderName, StatusCode: resp.StatusCode, Message: msg,
+			RetryAt: rateLimitRetryAt(resp.Header, time.Now())}
+	}
+	if decodeErr != nil {
+		return result, decodeErr
+	}
+	return result, nil
+}
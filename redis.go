@@ -0,0 +1,159 @@
+// redis.go
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// -------- Redis result cache --------
+
+// redisRetryAfter is how long a Redis outage is assumed to last before the
+// next attempt, so tasks don't each wait out a connection timeout.
+const redisRetryAfter = 30 * time.Second
+
+// RedisCache keeps the results of recently served topics in Redis in front
+// of the SQL cache, which stays the durable store. Entries expire after TTL.
+// A nil *RedisCache caches nothing, and a Redis that can't be reached only
+// logs a warning.
+type RedisCache struct {
+	client *redis.Client
+	TTL    time.Duration
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+// redisCache is the tier processTask consults; main sets it up when
+// REDIS_ADDR is set.
+var redisCache *RedisCache
+
+// newRedisCache connects to addr, either host:port or a redis:// or
+// rediss:// URL carrying a password and database number.
+func newRedisCache(addr string, ttl time.Duration) (*RedisCache, error) {
+	opts := &redis.Options{Addr: addr}
+	if strings.Contains(addr, "://") {
+		var err error
+		if opts, err = redis.ParseURL(addr); err != nil {
+			return nil, fmt.Errorf("invalid REDIS_ADDR: %w", err)
+		}
+	}
+	// a slow Redis must not hold up tasks the SQL cache can serve
+	opts.DialTimeout = 500 * time.Millisecond
+	opts.ReadTimeout = 500 * time.Millisecond
+	opts.WriteTimeout = 500 * time.Millisecond
+	opts.MaxRetries = 0
+	redis.SetLogger(redisLogger{})
+	return &RedisCache{client: redis.NewClient(opts), TTL: ttl}, nil
+}
+
+// redisLogger sends the client's own connection messages to -debug;
+// RedisCache.failed logs the warning that matters.
+type redisLogger struct{}
+
+func (redisLogger) Printf(_ context.Context, format string, v ...any) {
+	debugf("redis: "+format, v...)
+}
+
+func redisKey(q NewsQuery) string {
+	h := sha256.Sum256([]byte(q.resultsKey()))
+	return "newscli:results:" + hex.EncodeToString(h[:])
+}
+
+// redisQueryKey names the set of the result keys stored for the topics
+// whose rows are cached under query, so Invalidate can find them.
+func redisQueryKey(query string) string {
+	h := sha256.Sum256([]byte(query))
+	return "newscli:query:" + hex.EncodeToString(h[:])
+}
+
+// available reports whether Redis should be tried at all.
+func (c *RedisCache) available() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().After(c.downUntil)
+}
+
+// failed records a Redis error, warning once per outage. Errors from a
+// task's own deadline don't count.
+func (c *RedisCache) failed(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Now().After(c.downUntil) {
+		log.Printf("warning: redis unavailable, using the SQL cache only for %s: %v", redisRetryAfter, err)
+	}
+	c.downUntil = time.Now().Add(redisRetryAfter)
+}
+
+// Get returns q's results if Redis holds them.
+func (c *RedisCache) Get(ctx context.Context, q NewsQuery) ([]NewsResult, bool) {
+	if !c.available() {
+		return nil, false
+	}
+	data, err := c.client.Get(ctx, redisKey(q)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false
+	}
+	if err != nil {
+		c.failed(ctx, err)
+		return nil, false
+	}
+	var results []NewsResult
+	if err := json.Unmarshal(data, &results); err != nil || len(results) < q.MaxItems {
+		return nil, false
+	}
+	return results, true
+}
+
+// Set stores q's results for TTL.
+func (c *RedisCache) Set(ctx context.Context, q NewsQuery, results []NewsResult) {
+	if !c.available() || len(results) == 0 {
+		return
+	}
+	data, err := json.Marshal(results)
+	if err != nil {
+		return
+	}
+	key, index := redisKey(q), redisQueryKey(q.cacheQuery())
+	_, err = c.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, key, data, c.TTL)
+		p.SAdd(ctx, index, key)
+		p.Expire(ctx, index, c.TTL)
+		return nil
+	})
+	if err != nil {
+		c.failed(ctx, err)
+	}
+}
+
+// Invalidate drops the results stored for every topic whose rows are
+// cached under query, as the memory cache's are when those rows change.
+func (c *RedisCache) Invalidate(ctx context.Context, query string) {
+	if !c.available() {
+		return
+	}
+	index := redisQueryKey(query)
+	keys, err := c.client.SMembers(ctx, index).Result()
+	if err == nil {
+		err = c.client.Del(ctx, append(keys, index)...).Err()
+	}
+	if err != nil {
+		c.failed(ctx, err)
+	}
+}
@@ -0,0 +1,98 @@
+// redis_test.go
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// useRedis puts a Redis of the test's own in front of the SQL cache.
+func useRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	c, err := newRedisCache(mr.Addr(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.client.Close() })
+	keep(t, &redisCache)
+	redisCache = c
+	return mr
+}
+
+func TestRedisServesRepeatTopics(t *testing.T) {
+	mr := useRedis(t)
+	db := openTestDB(t)
+	provider := &stubProvider{news: stubArticles(5)}
+	tasks := startTestPool(t, db, provider, 1)
+	q := testQuery("golang")
+
+	for _, want := range []string{"API", "Redis", "Redis"} {
+		if r := submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second); r.Err != nil || r.Source != want || len(r.Results) != 5 {
+			t.Fatalf("got %d results from %q, error %v; want 5 from %s", len(r.Results), r.Source, r.Err, want)
+		}
+	}
+	if !mr.Exists(redisKey(q)) {
+		t.Errorf("no %s in Redis", redisKey(q))
+	}
+	// once expired, the SQL cache serves the topic and Redis is filled again
+	mr.FastForward(time.Hour)
+	if r := submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second); r.Source != "DB" {
+		t.Errorf("after the TTL: got %q, want DB", r.Source)
+	}
+	if ttl := mr.TTL(redisKey(q)); ttl != time.Hour {
+		t.Errorf("refilled entry expires in %s, want an hour", ttl)
+	}
+	if n := provider.calls.Load(); n != 1 {
+		t.Errorf("%d provider calls, want 1", n)
+	}
+}
+
+func TestRedisInvalidatedByWrites(t *testing.T) {
+	mr := useRedis(t)
+	db := openTestDB(t)
+	tasks := startTestPool(t, db, &stubProvider{news: stubArticles(5)}, 1)
+	week, month := testQuery("golang"), testQuery("golang")
+	month.Days = 30
+	// the month's articles cover the week as well
+	for _, q := range []NewsQuery{month, week} {
+		submitTask(context.Background(), tasks, q, nextTaskSeq(), 5*time.Second)
+	}
+	if !mr.Exists(redisKey(week)) || !mr.Exists(redisKey(month)) {
+		t.Fatal("the results of both searches aren't in Redis")
+	}
+	// new rows for the topic drop what was stored for every search of it
+	if err := storeFetched(db, week, stubArticles(6)); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []NewsQuery{week, month} {
+		if mr.Exists(redisKey(q)) {
+			t.Errorf("%d days: results still in Redis after the rows changed", q.Days)
+		}
+	}
+}
+
+func TestRedisDownFallsBackToSQL(t *testing.T) {
+	mr := useRedis(t)
+	db := openTestDB(t)
+	provider := &stubProvider{news: stubArticles(5)}
+	tasks := startTestPool(t, db, provider, 1)
+	logged := captureLog(t)
+
+	mr.Close()
+	for _, topic := range []string{"golang", "golang", "rust"} {
+		if r := submitTask(context.Background(), tasks, testQuery(topic), nextTaskSeq(), 5*time.Second); r.Err != nil {
+			t.Fatalf("%s: %v", topic, r.Err)
+		}
+	}
+	if n := provider.calls.Load(); n != 2 {
+		t.Errorf("%d provider calls, want 2, the repeat served by the SQL cache", n)
+	}
+	if n := strings.Count(logged.String(), "warning: redis unavailable"); n != 1 {
+		t.Errorf("warned %d times, want once for the outage:\n%s", n, logged.String())
+	}
+}
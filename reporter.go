@@ -0,0 +1,184 @@
+// reporter.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// -------- Console reporter --------
+
+// verbosity is how much the console says, set by -quiet and -verbose.
+type verbosity int
+
+const (
+	levelQuiet verbosity = iota
+	levelNormal
+	levelVerbose
+)
+
+// consoleReporter is the console of fetch: a line per topic as it
+// completes, marked in color on a terminal, and the messages of each
+// level it lets through, above the progress of the pass. Every line is
+// written whole under a lock, so workers and the goroutines waiting on
+// them can report at once.
+type consoleReporter struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level verbosity
+	color bool
+	// topics turns on the per-topic lines, for passes over input files;
+	// one-off topics and the prompt print their results instead.
+	topics bool
+	// prog is the progress of the pass in progress, if shown; live says
+	// it is drawn as a line of its own, noProgress is -no-progress.
+	prog             *progress
+	live, noProgress bool
+}
+
+// reporter is the process's console, on stdout unless -stdout moves it.
+var reporter = &consoleReporter{out: os.Stdout, level: levelNormal, color: colorTerminal(os.Stdout),
+	live: liveTerminal(os.Stdout)}
+
+// console takes the messages -quiet hides, consoleErr errors and the
+// paths written, which it shows too.
+var (
+	console    io.Writer = levelWriter{reporter, levelNormal}
+	consoleErr io.Writer = levelWriter{reporter, levelQuiet}
+)
+
+// levelWriter writes to r what r's verbosity lets through at level.
+type levelWriter struct {
+	r     *consoleReporter
+	level verbosity
+}
+
+func (w levelWriter) Write(p []byte) (int, error) {
+	w.r.mu.Lock()
+	defer w.r.mu.Unlock()
+	if w.level > w.r.level {
+		return len(p), nil
+	}
+	return w.r.write(p)
+}
+
+// setVerbosity is the Set of -quiet and -verbose, which turn on level.
+func setVerbosity(s string, level verbosity) error {
+	on, err := strconv.ParseBool(s)
+	if on {
+		reporter.setLevel(level)
+	}
+	return err
+}
+
+// setOutput moves c to f, in color if f is a terminal.
+func (c *consoleReporter) setOutput(f *os.File) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.out, c.color, c.live = f, colorTerminal(f), liveTerminal(f)
+}
+
+func (c *consoleReporter) setLevel(level verbosity) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.level = level
+}
+
+func (c *consoleReporter) showTopics(on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.topics = on
+}
+
+// colorTerminal says whether to color what goes to f: only when it is a
+// terminal, and neither NO_COLOR is set nor TERM is dumb.
+func colorTerminal(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(f)
+}
+
+// liveTerminal says whether the progress line can be drawn on f: a
+// terminal, unless TERM is dumb, that results printed to stdout don't go
+// to as well.
+func liveTerminal(f *os.File) bool {
+	return os.Getenv("TERM") != "dumb" && isTerminal(f) && (f == os.Stdout || !isTerminal(os.Stdout))
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// ANSI colors of the topic marks.
+const (
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiBlue  = "\x1b[34m"
+	ansiReset = "\x1b[0m"
+)
+
+// fromCache says whether a result's Source is the cache's, stale or not.
+func fromCache(source string) bool {
+	return strings.HasPrefix(source, "DB") || source == "Redis"
+}
+
+// topicDone reports a completed topic: a check, green when fetched from
+// the API and blue when served from the cache, or a red cross with the
+// error when it failed. Failures are shown even with -quiet, except those
+// of a run interrupted, which the signal's message covers.
+func (c *consoleReporter) topicDone(q NewsQuery, r TaskResult) {
+	if errors.Is(r.Err, errInterrupted) {
+		return
+	}
+	label := topicSummary(q)
+	if q.UserID != "" {
+		label = q.UserID + ": " + label
+	}
+	level, mark, color := levelNormal, "✓", ansiGreen
+	what := fmt.Sprintf("%d results from %s", len(r.Results), r.Source)
+	switch {
+	case r.Err != nil:
+		level, mark, color, what = levelQuiet, "✗", ansiRed, r.Err.Error()
+	case fromCache(r.Source):
+		color = ansiBlue
+	}
+	if r.Elapsed > 0 {
+		what += " in " + roundDuration(r.Elapsed).String()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.countProgress(r)
+	if !c.topics || level > c.level {
+		return
+	}
+	if c.color {
+		mark = color + mark + ansiReset
+	}
+	c.write(fmt.Appendf(nil, "%s %s: %s\n", mark, label, what))
+}
+
+// verbosef reports a decision -verbose shows, such as where a topic is
+// served from or a retry. Without -verbose, -debug still logs it.
+func verbosef(format string, args ...any) {
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+	if reporter.level < levelVerbose {
+		// log may write through the reporter, so not under its lock
+		reporter.mu.Unlock()
+		debugf(format, args...)
+		reporter.mu.Lock()
+		return
+	}
+	msg := "  " + fmt.Sprintf(format, args...)
+	if reporter.color {
+		msg = "\x1b[2m" + msg + ansiReset
+	}
+	reporter.write([]byte(msg + "\n"))
+}
@@ -0,0 +1,121 @@
+// reporter_test.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// topicResults are one topic of each kind topicDone reports.
+var topicResults = []struct {
+	q NewsQuery
+	r TaskResult
+}{
+	{testQuery("golang"), TaskResult{Source: "API", Results: stubArticles(5), Elapsed: 420 * time.Millisecond}},
+	{testQuery("rust"), TaskResult{Source: "DB", Results: stubArticles(2)}},
+	{NewsQuery{Query: "zig", Days: 7, MaxItems: 5, UserID: "alice"}, TaskResult{Err: errors.New("newsapi: rate limited")}},
+}
+
+// reportTopics has a reporter at level, in color or not, report
+// topicResults, and returns what it wrote.
+func reportTopics(level verbosity, color bool) string {
+	var buf bytes.Buffer
+	c := &consoleReporter{out: &buf, level: level, color: color, topics: true}
+	for _, tr := range topicResults {
+		c.topicDone(tr.q, tr.r)
+	}
+	return buf.String()
+}
+
+func TestReporterTopicLines(t *testing.T) {
+	plain := `✓ "golang", 7 days, 5 items: 5 results from API in 420ms
+✓ "rust", 7 days, 5 items: 2 results from DB
+✗ alice: "zig", 7 days, 5 items: newsapi: rate limited
+`
+	if got := reportTopics(levelNormal, false); got != plain {
+		t.Errorf("without color got\n%s\nwant\n%s", got, plain)
+	}
+
+	colored := "\x1b[32m✓\x1b[0m \"golang\", 7 days, 5 items: 5 results from API in 420ms\n" +
+		"\x1b[34m✓\x1b[0m \"rust\", 7 days, 5 items: 2 results from DB\n" +
+		"\x1b[31m✗\x1b[0m alice: \"zig\", 7 days, 5 items: newsapi: rate limited\n"
+	if got := reportTopics(levelNormal, true); got != colored {
+		t.Errorf("in color got %q, want %q", got, colored)
+	}
+}
+
+func TestReporterLevels(t *testing.T) {
+	for _, color := range []bool{false, true} {
+		t.Run(fmt.Sprintf("color %v", color), func(t *testing.T) {
+			// -quiet shows only the failure
+			quiet := reportTopics(levelQuiet, color)
+			if strings.Count(quiet, "\n") != 1 || !strings.Contains(quiet, "newsapi: rate limited") {
+				t.Errorf("-quiet got %q, want only zig's error", quiet)
+			}
+			if strings.Contains(quiet, "\x1b[") != color {
+				t.Errorf("-quiet got %q, color %v", quiet, color)
+			}
+
+			var buf bytes.Buffer
+			keep(t, &reporter)
+			reporter = &consoleReporter{out: &buf, level: levelNormal, color: color}
+			verbosef("golang: %d cached rows are fresh", 5)
+			if buf.Len() != 0 {
+				t.Errorf("a -verbose message shown without -verbose: %q", buf.String())
+			}
+			reporter.setLevel(levelVerbose)
+			verbosef("golang: %d cached rows are fresh", 5)
+			want := "  golang: 5 cached rows are fresh\n"
+			if color {
+				want = "\x1b[2m  golang: 5 cached rows are fresh\x1b[0m\n"
+			}
+			if buf.String() != want {
+				t.Errorf("-verbose got %q, want %q", buf.String(), want)
+			}
+		})
+	}
+}
+
+func TestReporterLevelWriters(t *testing.T) {
+	var buf bytes.Buffer
+	c := &consoleReporter{out: &buf, level: levelQuiet}
+	fmt.Fprintln(levelWriter{c, levelNormal}, "Coalesced 2 duplicate topics")
+	fmt.Fprintln(levelWriter{c, levelQuiet}, "Results stored in Outputs/user1.txt")
+	if got, want := buf.String(), "Results stored in Outputs/user1.txt\n"; got != want {
+		t.Errorf("-quiet got %q, want %q", got, want)
+	}
+	// outside a pass over input files there are no per-topic lines
+	buf.Reset()
+	c.level, c.topics = levelNormal, false
+	c.topicDone(topicResults[0].q, topicResults[0].r)
+	if buf.Len() != 0 {
+		t.Errorf("got %q outside a pass", buf.String())
+	}
+}
+
+func TestColorTerminal(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if colorTerminal(f) || liveTerminal(f) {
+		t.Error("a file counts as a terminal")
+	}
+	// NO_COLOR wins even on a terminal, where there is one to try
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	defer tty.Close()
+	t.Setenv("NO_COLOR", "1")
+	if colorTerminal(tty) {
+		t.Error("NO_COLOR didn't turn color off")
+	}
+}
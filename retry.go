@@ -0,0 +1,185 @@
+// retry.go
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// -------- HTTP retries --------
+
+// RetryPolicy controls how transient HTTP failures are retried: connection
+// errors, timeouts and 5xx responses. 4xx responses are never retried.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// retryPolicy is the policy used for provider requests, set from flags.
+var retryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 5 * time.Second}
+
+// debugLogging enables debugf output; set from the -debug flag.
+var debugLogging bool
+
+func debugf(format string, args ...any) {
+	if debugLogging {
+		log.Printf("debug: "+format, args...)
+	}
+}
+
+// backoff is the delay before retry number attempt (1-based): exponential
+// in the attempt, capped at MaxDelay, with jitter over its upper half so
+// workers retrying together spread out.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d > p.MaxDelay || d <= 0 {
+		d = p.MaxDelay
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// doWithRetry sends req with httpClient, retrying transient failures per
+// retryPolicy while ctx allows. A 5xx on the last attempt is returned as the
+// response so the caller can read the provider's error body; a transport
+// error on the last attempt is wrapped with the attempt count.
+func doWithRetry(ctx context.Context, req *http.Request) (*http.Response, error) {
+	attempts := max(retryPolicy.MaxAttempts, 1)
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		countAPICall(ctx)
+		resp, err := httpClient.Do(req.Clone(ctx))
+		switch {
+		case err == nil && resp.StatusCode < 500:
+			return resp, nil
+		case err == nil:
+			lastErr = fmt.Errorf("http %d", resp.StatusCode)
+			if attempt >= attempts {
+				return resp, nil
+			}
+			resp.Body.Close()
+		default:
+			lastErr = err
+			if ctx.Err() != nil || attempt >= attempts || !isRetryable(err) {
+				return nil, fmt.Errorf("%s %s failed after %d attempt(s): %w", req.Method, req.URL.Host, attempt, lastErr)
+			}
+		}
+
+		delay := retryPolicy.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return nil, fmt.Errorf("%s %s: no time left to retry after %d attempt(s): %w", req.Method, req.URL.Host, attempt, lastErr)
+		}
+		verbosef("%s %s attempt %d/%d failed (%v), retrying in %s", req.Method, req.URL.Host, attempt, attempts, lastErr, delay)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%s %s: %w (last error: %v)", req.Method, req.URL.Host, ctx.Err(), lastErr)
+		case <-time.After(delay):
+		}
+	}
+}
+
+// -------- Retry passes --------
+
+// retryClasses are the kinds of transient failure -retry-on can name.
+var retryClasses = []string{"timeout", "ratelimit", "server", "network"}
+
+// retryPassOn holds the retryClasses a retry pass retries; set from
+// -retry-on.
+var retryPassOn = map[string]bool{"timeout": true, "ratelimit": true, "server": true, "network": true}
+
+// setRetryOn is the Set of -retry-on, a comma-separated list of
+// retryClasses.
+func setRetryOn(s string) error {
+	on := map[string]bool{}
+	for _, class := range splitList(s, ",") {
+		class = strings.ToLower(strings.TrimSpace(class))
+		if !slices.Contains(retryClasses, class) {
+			return fmt.Errorf("unknown error class %q, want some of %s", class, strings.Join(retryClasses, ","))
+		}
+		on[class] = true
+	}
+	retryPassOn = on
+	return nil
+}
+
+// retryClass names the retryClasses err belongs to, "" for an error that
+// retrying won't help, such as a bad key, an exhausted -api-budget or a
+// panic.
+func retryClass(err error) string {
+	var timeout *TimeoutError
+	var apiErr interface{ Retryable() bool }
+	switch {
+	case errors.As(err, &timeout):
+		return "timeout"
+	case isRateLimited(err):
+		return "ratelimit"
+	case errorClass(err) == errClassNetwork:
+		return "network"
+	case errors.As(err, &apiErr) && apiErr.Retryable():
+		return "server"
+	}
+	return ""
+}
+
+// retryNote marks the Source of a result a retry pass got.
+const retryNote = " (succeeded on retry)"
+
+// retryFailed runs up to passes more passes over the topics whose results
+// failed with an error retryPassOn retries, and merges what they get into
+// results. Each pass gives a topic twice its last timeout, cut to
+// -max-timeout. A topic that succeeds has retryNote added to its Source;
+// one that still fails keeps its first error followed by its last
+// retry's. onDone, if not nil, is called with each merged result as it
+// completes, as fetchAll does. No pass starts once ctx is canceled.
+func retryFailed(ctx context.Context, tasks chan<- Task, topics []NewsQuery, results []TaskResult, passes int,
+	timeout time.Duration, onDone func(int, TaskResult)) {
+	topics = slices.Clone(topics)
+	first := make([]TaskResult, len(results))
+	last := make([]error, len(results))
+	for i, r := range results {
+		first[i], last[i] = r, r.Err
+	}
+	for pass := 1; pass <= passes && ctx.Err() == nil; pass++ {
+		var index []int
+		var retry []NewsQuery
+		for i, r := range results {
+			if r.Err == nil || !retryPassOn[retryClass(last[i])] {
+				continue
+			}
+			topics[i].Timeout = min(2*cmp.Or(topics[i].Timeout, timeout), maxTaskTimeout)
+			index, retry = append(index, i), append(retry, topics[i])
+		}
+		if len(retry) == 0 {
+			return
+		}
+		fmt.Fprintf(console, "Retrying %d topics that failed with transient errors (pass %d of %d)\n", len(retry), pass, passes)
+		var succeeded atomic.Int64
+		fetchAll(ctx, tasks, retry, timeout, func(j int, r TaskResult) {
+			i := index[j]
+			last[i] = r.Err
+			r.Elapsed += first[i].Elapsed
+			r.APICalls += first[i].APICalls
+			r.Waited += first[i].Waited
+			if r.Err == nil {
+				r.Source += retryNote
+				succeeded.Add(1)
+			} else {
+				r.Err = fmt.Errorf("%w; on retry: %w", first[i].Err, r.Err)
+			}
+			results[i] = r
+			if onDone != nil {
+				onDone(i, r)
+			}
+		})
+		fmt.Fprintf(console, "%d of %d retried topics succeeded\n", succeeded.Load(), len(retry))
+	}
+}
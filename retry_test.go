@@ -0,0 +1,158 @@
+// retry_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyProvider is a stubProvider whose first fetch of "flaky" fails with
+// a 503, whose fetches of "down" always do and whose fetches of "badkey"
+// fail with a 401. It records each topic's fetches.
+type flakyProvider struct {
+	*stubProvider
+	mu      sync.Mutex
+	fetches map[string][]NewsQuery
+}
+
+func newFlakyProvider() *flakyProvider {
+	return &flakyProvider{stubProvider: &stubProvider{news: stubArticles(5)}, fetches: map[string][]NewsQuery{}}
+}
+
+func (p *flakyProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	p.mu.Lock()
+	p.fetches[q.Query] = append(p.fetches[q.Query], q)
+	n := len(p.fetches[q.Query])
+	p.mu.Unlock()
+	switch {
+	case q.Query == "flaky" && n == 1, q.Query == "down":
+		return nil, &ProviderError{Provider: p.Name(), StatusCode: http.StatusServiceUnavailable, Message: "try later"}
+	case q.Query == "badkey":
+		return nil, &ProviderError{Provider: p.Name(), StatusCode: http.StatusUnauthorized, Message: "bad key"}
+	}
+	return p.stubProvider.Fetch(ctx, q)
+}
+
+func (p *flakyProvider) fetched(topic string) []NewsQuery {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.fetches[topic]
+}
+
+func TestRetryClass(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{&TimeoutError{After: time.Second, Err: context.DeadlineExceeded}, "timeout"},
+		{&ProviderError{Provider: "newsapi", StatusCode: http.StatusTooManyRequests}, "ratelimit"},
+		{&ProviderError{Provider: "newsapi", StatusCode: http.StatusBadGateway}, "server"},
+		{&ProviderError{Provider: "newsapi", StatusCode: http.StatusUnauthorized}, ""},
+		{&PanicError{Value: "boom"}, ""},
+		{errors.New("api budget exhausted"), ""},
+	} {
+		if got := retryClass(tc.err); got != tc.want {
+			t.Errorf("retryClass(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestRetryFailed(t *testing.T) {
+	db := openTestDB(t)
+	p := newFlakyProvider()
+	tasks := startTestPool(t, db, p, 4)
+	topics := []NewsQuery{testQuery("golang"), testQuery("flaky"), testQuery("down"), testQuery("badkey")}
+	results := fetchAll(context.Background(), tasks, topics, time.Second, nil)
+	for i, topic := range []string{"flaky", "down", "badkey"} {
+		if results[i+1].Err == nil {
+			t.Fatalf("%s: the first pass succeeded", topic)
+		}
+	}
+	var mu sync.Mutex
+	done := map[int]bool{}
+	retryFailed(context.Background(), tasks, topics, results, 2, time.Second, func(i int, r TaskResult) {
+		mu.Lock()
+		done[i] = true
+		mu.Unlock()
+	})
+
+	if r := results[0]; r.Err != nil || r.Source != "API" {
+		t.Errorf("golang: got %+v, want the first pass's result", r)
+	}
+	if r := results[1]; r.Err != nil || r.Source != "API"+retryNote || len(r.Results) != 5 {
+		t.Errorf("flaky: got %+v, want it fetched on retry", r)
+	}
+	// down is retried on both passes, and keeps its first error with its
+	// last retry's
+	if r := results[2]; r.Err == nil || strings.Count(r.Err.Error(), "try later") != 2 || !strings.Contains(r.Err.Error(), "; on retry: ") {
+		t.Errorf("down: got error %v, want the first and the retry's", r.Err)
+	}
+	if r := results[3]; r.Err == nil || strings.Contains(r.Err.Error(), "on retry") {
+		t.Errorf("badkey: got error %v, want the first, unretried", r.Err)
+	}
+	for topic, want := range map[string]int{"golang": 1, "flaky": 2, "down": 3, "badkey": 1} {
+		if n := len(p.fetched(topic)); n != want {
+			t.Errorf("%s fetched %d times, want %d", topic, n, want)
+		}
+	}
+	// each pass doubles the timeout
+	for i, q := range p.fetched("down") {
+		if want := []time.Duration{0, 2 * time.Second, 4 * time.Second}[i]; q.Timeout != want {
+			t.Errorf("down, fetch %d: timeout %s, want %s", i+1, q.Timeout, want)
+		}
+	}
+	if len(done) != 2 || !done[1] || !done[2] {
+		t.Errorf("onDone got %v, want flaky and down", done)
+	}
+}
+
+func TestRetryOn(t *testing.T) {
+	keep(t, &retryPassOn)
+	if err := setRetryOn("timeout,RateLimit"); err != nil {
+		t.Fatal(err)
+	}
+	db := openTestDB(t)
+	p := newFlakyProvider()
+	tasks := startTestPool(t, db, p, 1)
+	topics := []NewsQuery{testQuery("flaky")}
+	results := fetchAll(context.Background(), tasks, topics, time.Second, nil)
+	retryFailed(context.Background(), tasks, topics, results, 1, time.Second, nil)
+	if results[0].Err == nil || len(p.fetched("flaky")) != 1 {
+		t.Errorf("got %+v after %d fetches; want a server error left alone", results[0], len(p.fetched("flaky")))
+	}
+	if err := setRetryOn("timeout,sometimes"); err == nil || !strings.Contains(err.Error(), `"sometimes"`) {
+		t.Errorf("got %v, want an error about the unknown class", err)
+	}
+}
+
+func TestRetryPassInOutput(t *testing.T) {
+	db := openTestDB(t)
+	tasks := startTestPool(t, db, newFlakyProvider(), 2)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "user1")
+	topics := []NewsQuery{testQuery("golang"), testQuery("flaky")}
+	shown := captureConsole(t)
+	cfg := RunConfig{Inputs: []string{input}, OutputDir: dir, OutputName: defaultOutputName, Format: formatText,
+		TaskTimeout: 5 * time.Second, RetryPasses: 1}
+	report, err := runInputs(context.Background(), db, tasks, cfg, []inputFile{{path: input, topics: topics}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.stats.Failed != 0 || report.stats.Misses != 2 {
+		t.Errorf("got %+v, want both topics from the API", report.stats)
+	}
+	if out := readOutput(t, dir); !strings.Contains(out, "API"+retryNote) {
+		t.Errorf("the output doesn't note the retry:\n%s", out)
+	}
+	for _, want := range []string{"Retrying 1 topics that failed with transient errors (pass 1 of 1)", "1 of 1 retried topics succeeded"} {
+		if !strings.Contains(shown.String(), want) {
+			t.Errorf("console %q doesn't have %q", shown, want)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// rss.go
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// -------- RSS/Atom feed provider --------
+const rssProviderName = "rss"
+
+// RSSProvider reads the feeds listed in NewsQuery.Feeds and keeps the items
+// whose title or description matches the query.
+type RSSProvider struct{}
+
+func (RSSProvider) Name() string { return rssProviderName }
+
+func (RSSProvider) Fetch(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	return fetchFeeds(ctx, q)
+}
+
+// feedDocument decodes both RSS 2.0 (<rss><channel><item>) and Atom
+// (<feed><entry>); whichever shape doesn't apply is left empty.
+type feedDocument struct {
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"date"` // dc:date
+	Author      string `xml:"author"`
+	Creator     string `xml:"creator"` // dc:creator
+}
+
+type atomEntry struct {
+	Title string `xml:"title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Summary   string `xml:"summary"`
+	Content   string `xml:"content"`
+	Updated   string `xml:"updated"`
+	Published string `xml:"published"`
+	Author    struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+}
+
+func (e atomEntry) link() string {
+	for _, l := range e.Links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return l.Href
+		}
+	}
+	if len(e.Links) > 0 {
+		return e.Links[0].Href
+	}
+	return ""
+}
+
+// splitFeeds parses the semicolon-separated feed list of an input line.
+func splitFeeds(list string) []string {
+	var feeds []string
+	for _, f := range strings.Split(list, ";") {
+		if f = strings.TrimSpace(f); f != "" {
+			feeds = append(feeds, f)
+		}
+	}
+	return feeds
+}
+
+// fetchFeeds reads every feed in q.Feeds. A feed that can't be fetched or
+// parsed is skipped; an error is only returned if none of them worked.
+// Items without a date are kept, since the window can't be applied to them.
+func fetchFeeds(ctx context.Context, q NewsQuery) ([]NewsResult, error) {
+	feeds := splitFeeds(q.Feeds)
+	if len(feeds) == 0 {
+		return nil, &ProviderError{Provider: rssProviderName, StatusCode: http.StatusBadRequest,
+			Message: "no feed URLs given (use rss=<url>;<url> in the input line)"}
+	}
+	since := q.windowMidnight()
+	terms := queryTerms(q.Query)
+
+	news := []NewsResult{}
+	var errs []error
+	for _, feedURL := range feeds {
+		items, err := fetchFeed(ctx, feedURL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", feedURL, err))
+			continue
+		}
+		for _, it := range items {
+			if q.endpoint() != EndpointTopHeadlines && !it.PublishedAt.IsZero() && it.PublishedAt.Before(since) {
+				continue
+			}
+			if !terms.match(it.Title + " " + it.Description) {
+				continue
+			}
+			news = append(news, it)
+		}
+	}
+	if len(errs) == len(feeds) {
+		return nil, fmt.Errorf("rss: %w", errors.Join(errs...))
+	}
+
+	// newest first across all feeds, undated items last
+	sort.SliceStable(news, func(i, j int) bool {
+		return news[i].PublishedAt.After(news[j].PublishedAt)
+	})
+	if len(news) > q.MaxItems {
+		news = news[:q.MaxItems]
+	}
+	return news, nil
+}
+
+func fetchFeed(ctx context.Context, feedURL string) ([]NewsResult, error) {
+	base, err := url.Parse(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doWithRetry(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &ProviderError{Provider: rssProviderName, StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	}
+
+	var doc feedDocument
+	dec := xml.NewDecoder(resp.Body)
+	dec.Strict = false
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) { return input, nil }
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("malformed feed: %w", err)
+	}
+
+	// relative links are resolved against the feed's own URL
+	resolve := func(link string) string {
+		ref, err := url.Parse(strings.TrimSpace(link))
+		if err != nil {
+			return link
+		}
+		return base.ResolveReference(ref).String()
+	}
+	var items []NewsResult
+	for _, it := range doc.Channel.Items {
+		date := it.PubDate
+		if date == "" {
+			date = it.Date
+		}
+		author := it.Author
+		if author == "" {
+			author = it.Creator
+		}
+		items = append(items, NewsResult{
+			Title:       strings.TrimSpace(it.Title),
+			URL:         resolve(it.Link),
+			Source:      rssProviderName,
+			PublishedAt: parsePublishedAt(date),
+			Description: strings.TrimSpace(it.Description),
+			Author:      author,
+			SourceName:  strings.TrimSpace(doc.Channel.Title),
+		})
+	}
+	for _, e := range doc.Entries {
+		date := e.Published
+		if date == "" {
+			date = e.Updated
+		}
+		desc := e.Summary
+		if desc == "" {
+			desc = e.Content
+		}
+		items = append(items, NewsResult{
+			Title:       strings.TrimSpace(e.Title),
+			URL:         resolve(e.link()),
+			Source:      rssProviderName,
+			PublishedAt: parsePublishedAt(date),
+			Description: strings.TrimSpace(desc),
+			Author:      e.Author.Name,
+			SourceName:  strings.TrimSpace(doc.Title),
+		})
+	}
+	return items, nil
+}
+
+// queryMatcher is a simplified local version of the providers' query
+// syntax: every term (or quoted phrase) must appear, except terms after NOT
+// or prefixed with "-", which must not. AND and OR are ignored.
+type queryMatcher struct {
+	include, exclude []string
+}
+
+func queryTerms(query string) queryMatcher {
+	var m queryMatcher
+	var tokens []string
+	for i, part := range strings.Split(query, "\"") {
+		if i%2 == 1 {
+			if part = strings.TrimSpace(part); part != "" {
+				tokens = append(tokens, "\x00"+part) // marks a phrase
+			}
+			continue
+		}
+		tokens = append(tokens, strings.Fields(part)...)
+	}
+	negate := false
+	for _, tok := range tokens {
+		phrase := strings.HasPrefix(tok, "\x00")
+		tok = strings.TrimPrefix(tok, "\x00")
+		if !phrase {
+			switch tok {
+			case "AND", "OR":
+				continue
+			case "NOT":
+				negate = true
+				continue
+			}
+			if strings.HasPrefix(tok, "-") && len(tok) > 1 {
+				negate, tok = true, tok[1:]
+			}
+			tok = strings.Trim(tok, "+()")
+		}
+		if tok == "" {
+			continue
+		}
+		if negate {
+			m.exclude = append(m.exclude, strings.ToLower(tok))
+		} else {
+			m.include = append(m.include, strings.ToLower(tok))
+		}
+		negate = false
+	}
+	return m
+}
+
+func (m queryMatcher) match(text string) bool {
+	text = strings.ToLower(text)
+	for _, t := range m.include {
+		if !strings.Contains(text, t) {
+			return false
+		}
+	}
+	for _, t := range m.exclude {
+		if strings.Contains(text, t) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+// runs.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// -------- Run history --------
+
+const runsUsage = `usage:
+  newscli runs list [-n 20]`
+
+// RunTopic is how one topic of a run was served. RunID refers to the
+// RunStats row; like CachedSearch.RunID it is a plain column rather than a
+// foreign key, so pruning old runs never touches the cache.
+type RunTopic struct {
+	ID     uint   `gorm:"primaryKey"`
+	RunID  uint   `gorm:"index"`
+	UserID string `gorm:"not null;default:global;size:64"`
+	Query  string
+	// Source is where the results came from (DB, Redis, API, or a stale
+	// DB variant), empty if the topic failed.
+	Source  string
+	Results int
+	Error   string
+}
+
+func newRunTopic(runID uint, q NewsQuery, r TaskResult) RunTopic {
+	t := RunTopic{RunID: runID, UserID: q.user(), Query: topicLabel(q), Results: len(r.Results)}
+	if r.Err != nil {
+		t.Error = r.Err.Error()
+	} else {
+		t.Source = r.Source
+	}
+	return t
+}
+
+// saveRun fills in the finished run and records its topics.
+func saveRun(db *gorm.DB, s *RunStats, topics []RunTopic) error {
+	return withBusyRetry(func() error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Save(s).Error; err != nil {
+				return err
+			}
+			if len(topics) == 0 {
+				return nil
+			}
+			return tx.CreateInBatches(topics, 100).Error
+		})
+	})
+}
+
+// runRunsCommand handles `newscli runs <subcommand> [flags]`.
+func runRunsCommand(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", runsUsage)
+	}
+	switch args[0] {
+	case "list":
+		return runRunsList(args[1:], w)
+	}
+	return fmt.Errorf("unknown runs command %q\n%s", args[0], runsUsage)
+}
+
+// runRunsList prints the most recent runs, newest first.
+func runRunsList(args []string, w io.Writer) error {
+	fs, store := cacheFlagSet("runs list")
+	limit := fs.Int("n", 20, "number of runs to show")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *limit <= 0 {
+		return fmt.Errorf("runs list: -n must be positive")
+	}
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+	var runs []RunStats
+	if err := db.Order("id DESC").Limit(*limit).Find(&runs).Error; err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%6s  %-16s  %8s  %-20s %6s %5s %5s %6s %6s %9s\n",
+		"ID", "STARTED", "DURATION", "INPUT", "TOPICS", "HITS", "STALE", "MISSES", "FAILED", "API CALLS")
+	for _, r := range runs {
+		duration := "-"
+		if r.FinishedAt != nil {
+			duration = r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond).String()
+		}
+		input := r.InputFile
+		if input == "" {
+			input = "-"
+		}
+		fmt.Fprintf(w, "%6d  %-16s  %8s  %-20s %6d %5d %5d %6d %6d %9d\n",
+			r.ID, r.StartedAt.Local().Format("2006-01-02 15:04"), duration, input,
+			r.Topics, r.Hits, r.StaleHits, r.Misses, r.Failed, r.APICalls)
+	}
+	return nil
+}
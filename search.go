@@ -0,0 +1,224 @@
+// search.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// -------- Full-text search --------
+
+// cachedSearchFTS is the FTS5 index over the titles and descriptions of
+// cached_searches. It is an external-content table, so it holds only the
+// index and triggers keep it in step with the rows.
+const cachedSearchFTS = "cached_searches_fts"
+
+var ftsTriggers = map[string]string{
+	"cached_searches_fts_insert": "AFTER INSERT ON cached_searches BEGIN " +
+		"INSERT INTO cached_searches_fts(rowid, title, description) VALUES (new.id, new.title, new.description); END",
+	"cached_searches_fts_delete": "AFTER DELETE ON cached_searches BEGIN " +
+		"INSERT INTO cached_searches_fts(cached_searches_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description); END",
+	"cached_searches_fts_update": "AFTER UPDATE OF title, description ON cached_searches BEGIN " +
+		"INSERT INTO cached_searches_fts(cached_searches_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description); " +
+		"INSERT INTO cached_searches_fts(rowid, title, description) VALUES (new.id, new.title, new.description); END",
+}
+
+// ftsEnabled is set by ensureFTS when the cache has a usable FTS5 index.
+var ftsEnabled bool
+
+// ensureFTS sets up the full-text index on an SQLite cache. This isn't one
+// of the schema migrations because it depends on the binary rather than the
+// cache: FTS5 is only compiled in with -tags sqlite_fts5. A build without it
+// drops the triggers, which would fail every write, and the next build with
+// it recreates them and rebuilds the index from the rows.
+func ensureFTS(db *gorm.DB) error {
+	var compiled int
+	if err := db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&compiled).Error; err != nil {
+		return err
+	}
+	if compiled == 0 {
+		for name := range ftsTriggers {
+			if err := db.Exec("DROP TRIGGER IF EXISTS " + name).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return withBusyRetry(func() error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS " + cachedSearchFTS +
+				" USING fts5(title, description, content='cached_searches', content_rowid='id')").Error; err != nil {
+				return err
+			}
+			var existing int64
+			tx.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'cached_searches_fts_%'").Scan(&existing)
+			if existing == int64(len(ftsTriggers)) {
+				ftsEnabled = true
+				return nil
+			}
+			for name, body := range ftsTriggers {
+				if err := tx.Exec("DROP TRIGGER IF EXISTS " + name).Error; err != nil {
+					return err
+				}
+				if err := tx.Exec("CREATE TRIGGER " + name + " " + body).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Exec("INSERT INTO " + cachedSearchFTS + "(" + cachedSearchFTS + ") VALUES ('rebuild')").Error; err != nil {
+				return err
+			}
+			ftsEnabled = true
+			return nil
+		})
+	})
+}
+
+// searchHit is one cached article matching a search.
+type searchHit struct {
+	Title       string
+	URL         string
+	Query       string
+	PublishedAt *time.Time
+	Created     time.Time
+}
+
+// runSearchCommand handles `newscli search [flags] terms`. Flags may also
+// follow the terms.
+func runSearchCommand(args []string, w io.Writer) error {
+	fs, store := cacheFlagSet("search")
+	since := fs.String("since", "", "only articles published in this window (e.g. 30d, 12h) or since a date (2006-01-02)")
+	limit := fs.Int("n", 20, "most articles to show")
+	terms, err := parseFlagsAnywhere(fs, args)
+	if err != nil {
+		return err
+	}
+	for i, term := range terms {
+		if isPlainPhrase(term) {
+			terms[i] = `"` + term + `"` // search "supply chain" means the phrase
+		}
+	}
+	match := strings.TrimSpace(strings.Join(terms, " "))
+	if match == "" {
+		return fmt.Errorf("usage: newscli search [-since 30d] [-n 20] terms")
+	}
+	if *limit <= 0 {
+		return fmt.Errorf("search: -n must be positive")
+	}
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+
+	published := sqlInstant(db, "COALESCE(c.published_at, c.created)")
+	scope := db.Table("cached_searches AS c").
+		Select("c.title, c.url, c.query, c.published_at, c.created").
+		Where("c.deleted_at IS NULL")
+	if *since != "" {
+		start, err := parseSince(*since)
+		if err != nil {
+			return fmt.Errorf("invalid -since: %w", err)
+		}
+		scope = scope.Where(published+" >= "+sqlInstant(db, "?"), start)
+	}
+	if ftsEnabled {
+		scope = scope.Joins("JOIN "+cachedSearchFTS+" ON "+cachedSearchFTS+".rowid = c.id").
+			Where(cachedSearchFTS+" MATCH ?", match).
+			Order("bm25(" + cachedSearchFTS + ")")
+	} else {
+		log.Printf("warning: full-text search needs an SQLite cache and a build with FTS5 (go build -tags sqlite_fts5); matching words with LIKE instead")
+		for _, term := range likeTerms(match) {
+			pattern := "%" + term + "%"
+			scope = scope.Where("(LOWER(c.title) LIKE ? OR LOWER(c.description) LIKE ?)", pattern, pattern)
+		}
+	}
+	queryErr := func(err error) error {
+		if ftsEnabled {
+			return fmt.Errorf("search %s: %w (see the FTS5 query syntax)", match, err)
+		}
+		return err
+	}
+	rows, err := scope.Order(published + " DESC").Rows()
+	if err != nil {
+		return queryErr(err)
+	}
+	defer rows.Close()
+
+	// an article cached for several topics is listed once, with them all
+	var hits []searchHit
+	index := map[string]int{}
+	for rows.Next() {
+		var h searchHit
+		if err := db.ScanRows(rows, &h); err != nil {
+			return queryErr(err)
+		}
+		if i, ok := index[h.URL]; ok {
+			if !strings.Contains(", "+hits[i].Query+", ", ", "+h.Query+", ") {
+				hits[i].Query += ", " + h.Query
+			}
+			continue
+		}
+		if len(hits) == *limit {
+			break
+		}
+		index[h.URL] = len(hits)
+		hits = append(hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		return queryErr(err)
+	}
+	if len(hits) == 0 {
+		fmt.Fprintln(w, "no cached articles match")
+		return nil
+	}
+	for _, h := range hits {
+		date := h.Created
+		if h.PublishedAt != nil {
+			date = *h.PublishedAt
+		}
+		fmt.Fprintf(w, "%s  %s\n    %s\n    topic: %s\n", date.Local().Format("2006-01-02"), h.Title, h.URL, h.Query)
+	}
+	return nil
+}
+
+// isPlainPhrase reports whether a search argument is several words without
+// any FTS5 syntax, which is searched for as a phrase.
+func isPlainPhrase(term string) bool {
+	words := strings.Fields(term)
+	if len(words) < 2 || strings.ContainsAny(term, `"*()^:+`) {
+		return false
+	}
+	for _, w := range words {
+		if w == "AND" || w == "OR" || w == "NOT" || strings.HasPrefix(w, "NEAR") {
+			return false
+		}
+	}
+	return true
+}
+
+// likeTerms approximates an FTS query for LIKE: the lowercased words and
+// quoted phrases, without operators or prefix stars.
+func likeTerms(match string) []string {
+	var terms []string
+	for i, part := range strings.Split(match, `"`) {
+		if i%2 == 1 {
+			if p := strings.TrimSpace(part); p != "" {
+				terms = append(terms, strings.ToLower(p))
+			}
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			if word == "AND" || word == "OR" || word == "NOT" {
+				continue
+			}
+			if word = strings.Trim(word, "*()^"); word != "" {
+				terms = append(terms, strings.ToLower(word))
+			}
+		}
+	}
+	return terms
+}
@@ -0,0 +1,123 @@
+// search_test.go
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// searchCache caches a few articles for search to find, under two topics.
+func searchCache(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cache.db")
+	db, done := openCache(t, path)
+	defer done()
+	now := time.Now()
+	article := func(title, description, url string, age time.Duration) NewsResult {
+		return NewsResult{Title: title, Description: description, URL: "https://example.com/" + url,
+			Source: newsAPIProviderName, PublishedAt: now.Add(-age)}
+	}
+	for topic, news := range map[string][]NewsResult{
+		"economy": {
+			article("Supply chain woes ease", "", "woes", 24*time.Hour),
+			article("Ports reopen", "The supply chain recovers after the strike", "ports", 48*time.Hour),
+			article("Chain of supply stores closes", "", "stores", 72*time.Hour),
+			article("Semiconductor shortage lingers", "", "chips", 40*24*time.Hour),
+		},
+		"technology": {
+			article("Semiconductors get cheaper", "", "cheaper", 2*time.Hour),
+			article("Supply chain woes ease", "", "woes", 24*time.Hour),
+		},
+	} {
+		if err := storeFetched(db, testQuery(topic), news); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+// searchTitles runs search with args against the cache at path and
+// returns the titles found, as "title (topics)", sorted: the order is
+// bm25's with FTS5 and the dates' without.
+func searchTitles(t *testing.T, path string, args ...string) []string {
+	t.Helper()
+	out, err := runCommand(t, append([]string{"search", "-db", path}, args...)...)
+	if err != nil {
+		t.Fatalf("search %s: %v", strings.Join(args, " "), err)
+	}
+	var titles []string
+	lines := strings.Split(out, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "    topic: ") {
+			_, title, _ := strings.Cut(lines[i-2], "  ")
+			topics := strings.Split(strings.TrimPrefix(line, "    topic: "), ", ")
+			slices.Sort(topics)
+			titles = append(titles, title+" ("+strings.Join(topics, ", ")+")")
+		}
+	}
+	slices.Sort(titles)
+	return titles
+}
+
+// TestSearch holds with FTS5 and with the LIKE fallback alike.
+func TestSearch(t *testing.T) {
+	path := searchCache(t)
+	captureLog(t)
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		// several plain words are a phrase, in the title or the description
+		{[]string{"supply chain"}, "Ports reopen (economy), Supply chain woes ease (economy, technology)"},
+		{[]string{"semicond*"}, "Semiconductor shortage lingers (economy), Semiconductors get cheaper (technology)"},
+		{[]string{"-since", "30d", "semicond*"}, "Semiconductors get cheaper (technology)"},
+		{[]string{"supply", "-n", "1"}, "Supply chain woes ease (economy, technology)"},
+		{[]string{"hurricane"}, ""},
+	} {
+		if got := strings.Join(searchTitles(t, path, tc.args...), ", "); got != tc.want {
+			t.Errorf("search %s: got %q, want %q", strings.Join(tc.args, " "), got, tc.want)
+		}
+	}
+}
+
+func TestSearchFTSSyntax(t *testing.T) {
+	path := searchCache(t)
+	if !ftsEnabled {
+		t.Skip("built without FTS5; go test -tags sqlite_fts5")
+	}
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		// a phrase is in order, unlike separate words
+		{[]string{"chain supply"}, ""},
+		{[]string{"chain", "supply"}, "Chain of supply stores closes (economy), Ports reopen (economy), Supply chain woes ease (economy, technology)"},
+		{[]string{"semiconductor OR ports"}, "Ports reopen (economy), Semiconductor shortage lingers (economy)"},
+		{[]string{"supply NOT woes"}, "Chain of supply stores closes (economy), Ports reopen (economy)"},
+		// a prefix matches at word starts only
+		{[]string{"hain*"}, ""},
+	} {
+		if got := strings.Join(searchTitles(t, path, tc.args...), ", "); got != tc.want {
+			t.Errorf("search %s: got %q, want %q", strings.Join(tc.args, " "), got, tc.want)
+		}
+	}
+	_, err := runCommand(t, "search", "-db", path, `"unterminated`)
+	if err == nil || !strings.Contains(err.Error(), "see the FTS5 query syntax") {
+		t.Errorf("got %v, want a syntax error", err)
+	}
+}
+
+func TestSearchWarnsWithoutFTS(t *testing.T) {
+	path := searchCache(t)
+	if ftsEnabled {
+		t.Skip("built with FTS5")
+	}
+	logged := captureLog(t)
+	searchTitles(t, path, "supply")
+	if !strings.Contains(logged.String(), "matching words with LIKE instead") {
+		t.Errorf("no warning about the LIKE fallback: %q", logged.String())
+	}
+}
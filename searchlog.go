@@ -0,0 +1,271 @@
+// searchlog.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// -------- Search log --------
+
+// SearchLog is one task served by a worker: what was asked, where the
+// answer came from and how long it took. Query is the cache key form, so
+// the trends report counts "Bitcoin" and "bitcoin" as one topic.
+type SearchLog struct {
+	ID         uint      `gorm:"primaryKey"`
+	SearchedAt time.Time `gorm:"index"`
+	RunID      uint      `gorm:"index"`
+	UserID     string    `gorm:"not null;default:global;size:64;index"`
+	Query      string    `gorm:"index"`
+	Endpoint   string
+	Country    string
+	Category   string
+	Language   string
+	SearchIn   string
+	Provider   string
+	Days       int
+	MaxItems   int
+	// Source is where the results came from, empty if the task failed.
+	Source     string
+	Results    int
+	DurationMS int64
+	// APICalls counts the provider requests the task sent, retries included.
+	APICalls int64
+	Error    string
+}
+
+// searchLogBuffer is how many entries may wait for the writer before new
+// ones are dropped; searchLogBatch is how many it inserts at once.
+const (
+	searchLogBuffer = 1024
+	searchLogBatch  = 100
+)
+
+// SearchLogger writes SearchLog rows from a goroutine of its own, so
+// workers only pay for a channel send. A nil *SearchLogger logs nothing.
+type SearchLogger struct {
+	db      *gorm.DB
+	entries chan SearchLog
+	done    chan struct{}
+}
+
+// searchLog is the logger the worker pool records to; main starts it
+// before the first pass.
+var searchLog *SearchLogger
+
+func newSearchLogger(db *gorm.DB) *SearchLogger {
+	l := &SearchLogger{db: db, entries: make(chan SearchLog, searchLogBuffer), done: make(chan struct{})}
+	go l.run()
+	return l
+}
+
+// Record queues the outcome of one task of run. When the writer has fallen
+// behind the entry is dropped rather than holding up the worker.
+func (l *SearchLogger) Record(q NewsQuery, run uint, r TaskResult, took time.Duration, calls int64) {
+	if l == nil {
+		return
+	}
+	e := SearchLog{SearchedAt: time.Now(), RunID: run, UserID: q.user(), Query: q.cacheQuery(),
+		Endpoint: q.endpoint(), Country: q.Country, Category: q.Category, Language: q.Language, SearchIn: q.SearchIn,
+		Provider: q.Provider, Days: q.Days, MaxItems: q.MaxItems, Results: len(r.Results),
+		DurationMS: took.Milliseconds(), APICalls: calls}
+	if r.Err != nil {
+		e.Error = r.Err.Error()
+	} else {
+		e.Source = r.Source
+	}
+	select {
+	case l.entries <- e:
+	default:
+		debugf("search log full, dropping entry for %q", e.Query)
+	}
+}
+
+// run inserts queued entries in batches, flushing at least once a second.
+func (l *SearchLogger) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	batch := make([]SearchLog, 0, searchLogBatch)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := withBusyRetry(func() error { return l.db.CreateInBatches(batch, searchLogBatch).Error }); err != nil {
+			debugf("writing %d search log entries: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case e, ok := <-l.entries:
+			if !ok {
+				flush()
+				return
+			}
+			if batch = append(batch, e); len(batch) == searchLogBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Close writes the entries still queued. Record must not be called after.
+func (l *SearchLogger) Close() {
+	if l == nil {
+		return
+	}
+	close(l.entries)
+	<-l.done
+}
+
+// -------- trends command --------
+
+// A topic is reported as failing when at least failingMinShare of its
+// searches, and at least two of them, failed.
+const failingMinShare = 0.5
+
+// TopicTrend is one topic's searches over the report window. Recent and
+// Earlier split them at the window's midpoint.
+type TopicTrend struct {
+	Query        string    `json:"query"`
+	Searches     int       `json:"searches"`
+	Recent       int       `json:"recent"`
+	Earlier      int       `json:"earlier"`
+	Failures     int       `json:"failures"`
+	LastSearched time.Time `json:"last_searched"`
+}
+
+// TrendsReport is the output of `newscli trends`.
+type TrendsReport struct {
+	Since   time.Time    `json:"since"`
+	Days    int          `json:"days"`
+	Top     []TopicTrend `json:"top"`
+	Rising  []TopicTrend `json:"rising"`
+	Failing []TopicTrend `json:"failing"`
+}
+
+// runTrendsCommand handles `newscli trends [flags]`.
+func runTrendsCommand(args []string, w io.Writer) error {
+	fs, store := cacheFlagSet("trends")
+	days := fs.Int("days", 7, "report on searches from this many days back")
+	limit := fs.Int("n", 10, "topics to list in each section")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *days <= 0 || *limit <= 0 {
+		return fmt.Errorf("trends: -days and -n must be positive")
+	}
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	report := TrendsReport{Since: now.AddDate(0, 0, -*days), Days: *days}
+	mid := now.Add(-now.Sub(report.Since) / 2)
+
+	var rows []struct {
+		Query                      string
+		Searches, Recent, Failures int
+		// MAX() of a time column comes back as text from SQLite
+		LastSearched string
+	}
+	searched := sqlInstant(db, "searched_at")
+	err = db.Model(&SearchLog{}).
+		Select("query, COUNT(*) AS searches, "+
+			"SUM(CASE WHEN "+searched+" >= "+sqlInstant(db, "?")+" THEN 1 ELSE 0 END) AS recent, "+
+			"SUM(CASE WHEN error <> '' THEN 1 ELSE 0 END) AS failures, "+
+			"MAX(searched_at) AS last_searched", mid).
+		Where(searched+" >= "+sqlInstant(db, "?"), report.Since).
+		Group("query").Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	var all []TopicTrend
+	for _, r := range rows {
+		all = append(all, TopicTrend{Query: r.Query, Searches: r.Searches, Recent: r.Recent,
+			Earlier: r.Searches - r.Recent, Failures: r.Failures, LastSearched: parseDBTime(r.LastSearched)})
+	}
+	report.Top = rankTrends(all, *limit, func(t TopicTrend) (float64, bool) {
+		return float64(t.Searches), true
+	})
+	report.Rising = rankTrends(all, *limit, func(t TopicTrend) (float64, bool) {
+		return float64(t.Recent - t.Earlier), t.Recent > t.Earlier
+	})
+	report.Failing = rankTrends(all, *limit, func(t TopicTrend) (float64, bool) {
+		share := float64(t.Failures) / float64(t.Searches)
+		return share, t.Failures >= 2 && share >= failingMinShare
+	})
+
+	if *asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	fmt.Fprintf(w, "searches since %s (%d days)\n", report.Since.Local().Format("2006-01-02 15:04"), report.Days)
+	printTrends(w, "most searched", report.Top)
+	printTrends(w, "rising (more searches in the last half of the window)", report.Rising)
+	printTrends(w, "failing (at least half of the searches failed)", report.Failing)
+	return nil
+}
+
+// parseDBTime reads a timestamp scanned into a string: SQLite's stored
+// text, or the RFC 3339 form database/sql gives Postgres and MySQL times.
+func parseDBTime(s string) time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// rankTrends keeps the topics score accepts, highest score first, ties by
+// search count and then name, and returns the first limit of them.
+func rankTrends(all []TopicTrend, limit int, score func(TopicTrend) (float64, bool)) []TopicTrend {
+	type scored struct {
+		TopicTrend
+		score float64
+	}
+	var kept []scored
+	for _, t := range all {
+		if s, ok := score(t); ok {
+			kept = append(kept, scored{t, s})
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		if kept[i].score != kept[j].score {
+			return kept[i].score > kept[j].score
+		}
+		if kept[i].Searches != kept[j].Searches {
+			return kept[i].Searches > kept[j].Searches
+		}
+		return kept[i].Query < kept[j].Query
+	})
+	out := []TopicTrend{}
+	for _, k := range kept[:min(len(kept), limit)] {
+		out = append(out, k.TopicTrend)
+	}
+	return out
+}
+
+func printTrends(w io.Writer, title string, trends []TopicTrend) {
+	fmt.Fprintf(w, "\n%s:\n", title)
+	if len(trends) == 0 {
+		fmt.Fprintln(w, "  (none)")
+		return
+	}
+	fmt.Fprintf(w, "  %-30s %8s %7s %8s %8s  %s\n", "QUERY", "SEARCHES", "RECENT", "EARLIER", "FAILURES", "LAST SEARCHED")
+	for _, t := range trends {
+		fmt.Fprintf(w, "  %-30s %8d %7d %8d %8d  %s\n", t.Query, t.Searches, t.Recent, t.Earlier, t.Failures,
+			t.LastSearched.Local().Format("2006-01-02 15:04"))
+	}
+}
@@ -0,0 +1,124 @@
+// serve.go
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// -------- serve command --------
+
+// ServeResponse is the JSON answer to GET /news.
+type ServeResponse struct {
+	Topic   string       `json:"topic"`
+	Source  string       `json:"source,omitempty"`
+	Results []NewsResult `json:"results"`
+	Error   string       `json:"error,omitempty"`
+	// Warning names what of the topic line was ignored, such as an
+	// unknown option.
+	Warning string `json:"warning,omitempty"`
+}
+
+// runServeCommand handles `newscli serve [flags]`: an HTTP server that
+// answers GET /news?topic=<line>, where line is a topic in the input file
+// format, through the same worker pool and caches as fetch. It stops on
+// SIGINT or SIGTERM once the requests in flight have been answered.
+func runServeCommand(args []string, w io.Writer) error {
+	fs, f := fetchFlagSet("serve")
+	addr := addServeFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		usageFatal(fs, fmt.Sprintf("unexpected argument %q", fs.Arg(0)))
+	}
+	db, provider, defaults := f.setup()
+	reportSecrets()
+	tasks, stop := f.startPool(db, provider)
+	defer stop()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(rw, "ok")
+	})
+	mux.HandleFunc("GET /news", func(rw http.ResponseWriter, r *http.Request) {
+		line := r.URL.Query().Get("topic")
+		resp := ServeResponse{Topic: line, Results: []NewsResult{}}
+		q, err := parseTopicLine(line, defaults)
+		var warning *TopicWarning
+		if errors.As(err, &warning) {
+			resp.Warning, err = warning.Error(), nil
+		}
+		if err != nil {
+			resp.Error = fmt.Sprintf("invalid topic %q: %v", line, err)
+			writeJSON(rw, http.StatusBadRequest, resp)
+			return
+		}
+		resp.Topic = topicLabel(q)
+		ctx, cancel := context.WithTimeout(r.Context(), cmp.Or(q.Timeout, f.TaskTimeout))
+		defer cancel()
+		respCh := make(chan TaskResult, 1)
+		select {
+		case tasks <- Task{NewsQuery: q, Resp: respCh, Ctx: ctx, seq: nextTaskSeq()}:
+		case <-ctx.Done():
+			resp.Error = "timeout submitting task"
+			writeJSON(rw, http.StatusServiceUnavailable, resp)
+			return
+		}
+		res := <-respCh
+		if res.Err != nil {
+			resp.Error = res.Err.Error()
+			writeJSON(rw, http.StatusBadGateway, resp)
+			return
+		}
+		resp.Source = res.Source
+		if res.Results != nil {
+			resp.Results = res.Results
+		}
+		writeJSON(rw, http.StatusOK, resp)
+	})
+
+	srv := &http.Server{Addr: *addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	ctx, cancelSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancelSignals()
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.ListenAndServe() }()
+	fmt.Fprintf(w, "serving on http://%s (GET /news?topic=golang,7,10)\n", *addr)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	log.Printf("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), f.TaskTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// addServeFlags adds serve's own flags to fs.
+func addServeFlags(fs *flag.FlagSet) *string {
+	return fs.String("addr", cmp.Or(os.Getenv("NEWSCLI_ADDR"), "127.0.0.1:8080"), "address to listen on (env NEWSCLI_ADDR)")
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		debugf("writing response: %v", err)
+	}
+}
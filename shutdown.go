@@ -0,0 +1,140 @@
+// shutdown.go
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// -------- Graceful shutdown --------
+
+// errInterrupted is the cause of fetch's context once SIGINT or SIGTERM
+// arrives, and so the error of the topics it cut short.
+var errInterrupted = errors.New("run interrupted")
+
+// shutdownGrace is how long fetch waits, once interrupted, for the workers
+// to finish their current task; set from -shutdown-grace.
+var shutdownGrace = 10 * time.Second
+
+// notifyShutdown returns the context fetch runs under. The first SIGINT or
+// SIGTERM cancels it with errInterrupted: no task is handed out after
+// that, the provider requests in flight are canceled, and the pass writes
+// what it has. A second one exits at once with exitInterrupted. stop
+// restores the default handling.
+func notifyShutdown() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case s := <-sig:
+			fmt.Fprintf(consoleErr, "\nGot %s: finishing the tasks in flight and writing the results so far; press Ctrl+C again to quit at once\n", s)
+			cancel(errInterrupted)
+		case <-done:
+			return
+		}
+		select {
+		case <-sig:
+			fmt.Fprintln(os.Stderr, "Quitting without waiting")
+			os.Exit(exitInterrupted)
+		case <-done:
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sig)
+		close(done)
+		cancel(nil)
+	}
+}
+
+// shutdown stops the worker pool with stop, which waits for the workers,
+// and closes db. Once ctx is canceled the workers get shutdownGrace to
+// finish; past it fetch exits without them, leaving db open under them.
+func shutdown(ctx context.Context, db *gorm.DB, stop func()) {
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	if ctx.Err() == nil {
+		<-stopped
+	} else {
+		select {
+		case <-stopped:
+		case <-time.After(shutdownGrace):
+			fmt.Fprintf(consoleErr, "Workers still busy %s after the signal; exiting without them\n", shutdownGrace)
+			return
+		}
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		if err := sqlDB.Close(); err != nil {
+			log.Printf("closing the cache: %v", err)
+		}
+	}
+}
+
+// lineReader returns a function reading the next line of r. It returns
+// io.EOF at the end of input, and the cause of ctx once ctx is done, even
+// in the middle of a read: lines are read in the background, and the read
+// in progress then is left behind. That way a prompt gives up waiting for
+// input when the signal comes.
+func lineReader(ctx context.Context, r io.Reader) func() (string, error) {
+	lines := make(chan string)
+	var err error
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				err = context.Cause(ctx)
+				return
+			}
+		}
+		err = cmp.Or(scanner.Err(), io.EOF)
+	}()
+	return func() (string, error) {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return "", err
+			}
+			return line, nil
+		case <-ctx.Done():
+			return "", context.Cause(ctx)
+		}
+	}
+}
+
+// untilDone returns a reader of r's lines that ends, with the cause of
+// ctx, once ctx is done; see lineReader.
+func untilDone(ctx context.Context, r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	next := lineReader(ctx, r)
+	go func() {
+		for {
+			line, err := next()
+			if err == nil {
+				_, err = io.WriteString(pw, line+"\n")
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return pr
+}
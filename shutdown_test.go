@@ -0,0 +1,146 @@
+// shutdown_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNotifyShutdown(t *testing.T) {
+	ctx, stop := notifyShutdown()
+	defer stop()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGTERM didn't cancel the context")
+	}
+	if err := context.Cause(ctx); err != errInterrupted {
+		t.Errorf("got cause %v, want errInterrupted", err)
+	}
+}
+
+func TestInterruptedRunWritesResults(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "user1")
+	if err := os.WriteFile(input, []byte("golang,7,3\nrust,7,3\nzig,7,3\nhaskell,7,3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(dir, "cache.db")
+	shown := captureConsole(t)
+	done := make(chan error, 1)
+	go func() {
+		_, err := runCommand(t, "fetch", "-provider", "fake", "-fake-latency", "300ms", "-workers", "1", "-db", dbPath,
+			"-input", input, "-once", "-overwrite", "-output-dir", dir)
+		done <- err
+	}()
+
+	// interrupt once the first topic is in, the second being fetched
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		reporter.mu.Lock()
+		fetched := strings.Contains(shown.String(), "✓")
+		reporter.mu.Unlock()
+		if fetched {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("no topic was fetched")
+		}
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetch didn't stop on SIGINT")
+	}
+	if !errors.Is(err, errInterrupted) || exitStatus(err) != exitInterrupted {
+		t.Errorf("got error %v, exit status %d; want %d for the interrupt", err, exitStatus(err), exitInterrupted)
+	}
+
+	out := readOutput(t, dir)
+	if !strings.Contains(out, "1 from API, 3 failed; run interrupted") {
+		t.Errorf("the output doesn't have the first topic and the interrupt:\n%s", out)
+	}
+	if n := strings.Count(out, errInterrupted.Error()); n < 4 {
+		t.Errorf("the output notes the interrupt %d times, want on each of 3 topics and the summary:\n%s", n, out)
+	}
+	// the cache was closed cleanly, with the first topic's rows in it
+	db, err := openDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	var rows int64
+	if err := db.Model(&CachedSearch{}).Count(&rows).Error; err != nil || rows != 3 {
+		t.Errorf("got %d cached rows, error %v; want the first topic's 3", rows, err)
+	}
+}
+
+func TestShutdownGrace(t *testing.T) {
+	keep(t, &shutdownGrace)
+	shutdownGrace = 50 * time.Millisecond
+
+	t.Run("workers finish", func(t *testing.T) {
+		db := openTestDB(t)
+		stopped := false
+		shutdown(context.Background(), db, func() { stopped = true })
+		if !stopped {
+			t.Error("the pool wasn't stopped")
+		}
+		if err := db.Exec("SELECT 1").Error; err == nil {
+			t.Error("the cache is still open")
+		}
+	})
+	t.Run("workers stuck", func(t *testing.T) {
+		db := openTestDB(t)
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cancel(errInterrupted)
+		stuck := make(chan struct{})
+		defer close(stuck)
+		start := time.Now()
+		shutdown(ctx, db, func() { <-stuck })
+		if took := time.Since(start); took < shutdownGrace || took > time.Second {
+			t.Errorf("shutdown took %s, want the grace period, %s", took, shutdownGrace)
+		}
+		// the workers may still write to it
+		if err := db.Exec("SELECT 1").Error; err != nil {
+			t.Errorf("the cache was closed under the workers: %v", err)
+		}
+	})
+}
+
+func TestLineReader(t *testing.T) {
+	next := lineReader(context.Background(), strings.NewReader("one\ntwo\n"))
+	for _, want := range []string{"one", "two"} {
+		if line, err := next(); line != want || err != nil {
+			t.Errorf("got %q, %v; want %q", line, err, want)
+		}
+	}
+	if _, err := next(); err != io.EOF {
+		t.Errorf("got %v at the end, want io.EOF", err)
+	}
+
+	// a read blocked on a terminal gives up when the signal comes
+	r, w := io.Pipe()
+	defer w.Close()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	next = lineReader(ctx, r)
+	time.AfterFunc(20*time.Millisecond, func() { cancel(errInterrupted) })
+	if _, err := next(); err != errInterrupted {
+		t.Errorf("got %v, want errInterrupted", err)
+	}
+}
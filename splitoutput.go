@@ -0,0 +1,145 @@
+// splitoutput.go
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// -------- Output files per topic --------
+
+// topicLatest is the name, less its extension, of the latest link in a
+// topic's directory.
+const topicLatest = "latest"
+
+// writeTopicFiles is writeOutputFile for -split-by-topic. Each topic of
+// out goes to a file of its own in cfg.Format, in a directory named by
+// topicDirs under the output directory: golang/2024-05-12.txt, or with
+// timestamps golang/2024-05-12T08-30-00.txt beside a latest.txt link. The
+// input's own output file, always text, lists where each topic went, with
+// the invalid input lines and the stats. It returns the paths written.
+func writeTopicFiles(cfg RunConfig, out fileOutput, used map[string]bool) ([]string, error) {
+	ext := outputFormats[cfg.Format].ext
+	dir, _ := expandTemplate(cfg.OutputDir, templateVars{out.input.path, out.started, ext})
+	var errs []error
+	var written []string
+	paths := make([]string, len(out.input.topics))
+	for i, name := range topicDirs(out.input.topics) {
+		q, r := out.input.topics[i], out.results[i]
+		one := fileOutput{input: inputFile{path: out.input.path, topics: []NewsQuery{q}}, results: []TaskResult{r},
+			stats: out.stats.run(RunStats{ID: out.stats.ID}), started: out.started}
+		one.stats.tally(r)
+		path, latest := topicPath(cfg, filepath.Join(dir, name), ext, out.started, used)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			errs = append(errs, fmt.Errorf("creating the output directory: %w", err))
+			continue
+		}
+		err := writeFileAtomic(path, func(w io.Writer) error {
+			return outputFormats[cfg.Format].write(w, one)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("writing output file: %w", err))
+			continue
+		}
+		if err := finishOutputFile(cfg, path, latest); err != nil {
+			errs = append(errs, err)
+		}
+		paths[i] = path
+		written = append(written, path)
+	}
+
+	index := cfg
+	index.Format = formatText
+	indexFile, latest := outputPath(index, out.input.path, out.started, used)
+	if err := os.MkdirAll(filepath.Dir(indexFile), os.ModePerm); err != nil {
+		return written, errors.Join(append(errs, fmt.Errorf("creating the output directory: %w", err))...)
+	}
+	err := writeFileAtomic(indexFile, func(w io.Writer) error {
+		return writeTopicIndex(w, out, dir, paths)
+	})
+	if err != nil {
+		return written, errors.Join(append(errs, fmt.Errorf("writing output file: %w", err))...)
+	}
+	fmt.Fprintf(consoleErr, "Execution completed. Results stored in %d topic files in %s, listed in %s\n",
+		len(written), dir, indexFile)
+	return append(written, indexFile), errors.Join(append(errs, finishOutputFile(index, indexFile, latest))...)
+}
+
+// writeTopicIndex writes the input's output file of -split-by-topic: the
+// invalid lines, then a line per topic with its file, relative to dir, and
+// what it found. paths[i] is empty for a topic whose file wasn't written.
+func writeTopicIndex(w io.Writer, out fileOutput, dir string, paths []string) error {
+	if len(out.input.invalid) > 0 {
+		reportInputErrors(w, out.input.path, out.input.invalid)
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "Results a file per topic, in %s:\n", dir)
+	for i, q := range out.input.topics {
+		r := out.results[i]
+		label := topicSummary(q)
+		if q.UserID != "" {
+			label = q.UserID + ": " + label
+		}
+		path := "(not written)"
+		if paths[i] != "" {
+			path, _ = filepath.Rel(dir, paths[i])
+		}
+		if r.Err != nil {
+			fmt.Fprintf(w, "  %s -> %s: failed after %s: %v\n", label, path, roundDuration(r.Elapsed), r.Err)
+			continue
+		}
+		fmt.Fprintf(w, "  %s -> %s: %d results from %s in %s\n", label, path, len(r.Results), r.Source,
+			roundDuration(r.Elapsed))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, out.stats.summary())
+	fmt.Fprintln(w, out.stats.timing())
+	if note := out.stats.budgetNote(); note != "" {
+		fmt.Fprintln(w, note)
+	}
+	return nil
+}
+
+// topicDirs names the directory of each of topics: its topicSlug, unless
+// an earlier, different topic of the file already has that, when a short
+// hash of the topic's summary is added. The name thus depends only on the
+// topic and those before it, and is the same on every run.
+func topicDirs(topics []NewsQuery) []string {
+	names := make([]string, len(topics))
+	owners := map[string]string{}
+	for i, q := range topics {
+		name, owner := topicSlug(q), q.UserID+"|"+topicSummary(q)
+		if o, ok := owners[name]; ok && o != owner {
+			sum := sha1.Sum([]byte(owner))
+			name += "-" + hex.EncodeToString(sum[:3])
+		}
+		if _, ok := owners[name]; !ok {
+			owners[name] = owner
+		}
+		names[i] = name
+	}
+	return names
+}
+
+// topicPath is the path of a topic's file in its directory dir, and its
+// latest link if cfg timestamps files. Like outputPath, it never picks a
+// path taken in this pass, nor with timestamps an existing file.
+func topicPath(cfg RunConfig, dir, ext string, start time.Time, used map[string]bool) (path, latest string) {
+	base := start.Format(time.DateOnly)
+	if cfg.Timestamp {
+		base = start.Format(runStampLayout)
+		latest = filepath.Join(dir, topicLatest+"."+ext)
+	}
+	path = filepath.Join(dir, base+"."+ext)
+	for n := 2; used[path] || cfg.Timestamp && fileExists(path); n++ {
+		path = filepath.Join(dir, fmt.Sprintf("%s-%d.%s", base, n, ext))
+	}
+	used[path] = true
+	return path, latest
+}
@@ -0,0 +1,84 @@
+// splitoutput_test.go
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTopicSlug(t *testing.T) {
+	for _, tc := range []struct {
+		q    NewsQuery
+		want string
+	}{
+		{NewsQuery{Query: "c/c++"}, "c-c"},
+		{NewsQuery{Query: "what's new?"}, "what-s-new"},
+		{NewsQuery{Query: "Machine   Learning"}, "machine-learning"},
+		{NewsQuery{Query: "../../etc/passwd"}, "etc-passwd"},
+		{NewsQuery{Query: `C:\Windows\`}, "c-windows"},
+		{NewsQuery{Query: "Zürich über alles"}, "zürich-über-alles"},
+		{NewsQuery{Query: "東京 ニュース"}, "東京-ニュース"},
+		{NewsQuery{Query: "+++"}, "topic"},
+		{NewsQuery{Query: "golang", UserID: "alice@example.com"}, "alice-example-com-golang"},
+		{NewsQuery{Query: "news", Endpoint: EndpointTopHeadlines, Country: "us", Category: "tech"}, "news-top-headlines-us-tech"},
+		{NewsQuery{Query: strings.Repeat("é", 80)}, strings.Repeat("é", 50)},
+	} {
+		got := topicSlug(tc.q)
+		if got != tc.want {
+			t.Errorf("topicSlug(%q) = %q, want %q", tc.q.Query, got, tc.want)
+		}
+		if strings.ContainsAny(got, `/\:?*"<>|. `) {
+			t.Errorf("topicSlug(%q) = %q isn't a safe directory name", tc.q.Query, got)
+		}
+	}
+}
+
+func TestTopicDirsDisambiguate(t *testing.T) {
+	topics := []NewsQuery{{Query: "c/c++", Days: 7}, {Query: "what's new?", Days: 7}, {Query: "C C", Days: 7},
+		{Query: "c/c++", Days: 7}, {Query: "c-c", Days: 7}}
+	dirs := topicDirs(topics)
+	if dirs[0] != "c-c" || dirs[1] != "what-s-new" || dirs[3] != "c-c" {
+		t.Errorf("got %q; want c/c++ in c-c, twice, and what's new? in what-s-new", dirs)
+	}
+	// the later topics that clash with c/c++ get names of their own
+	if dirs[2] == dirs[4] || !strings.HasPrefix(dirs[2], "c-c-") || !strings.HasPrefix(dirs[4], "c-c-") {
+		t.Errorf("got %q; want C C and c-c each in a c-c-<hash> directory", dirs)
+	}
+	if again := topicDirs(topics); !slices.Equal(again, dirs) {
+		t.Errorf("got %q on the second run, %q on the first", again, dirs)
+	}
+}
+
+func TestSplitByTopic(t *testing.T) {
+	dir := t.TempDir()
+	fetchInto(t, dir, "c/c++,7,3\nwhat's new?,7,2\nC C,7,1\n", "-split-by-topic", "-overwrite")
+
+	dirs := topicDirs([]NewsQuery{{Query: "c/c++", Days: 7, MaxItems: 3}, {Query: "what's new?", Days: 7, MaxItems: 2},
+		{Query: "C C", Days: 7, MaxItems: 1}})
+	index := readOutput(t, dir)
+	for i, name := range dirs {
+		files, _ := filepath.Glob(filepath.Join(dir, name, "*.txt"))
+		if len(files) != 1 {
+			t.Errorf("%s has %v, want one results file", name, files)
+			continue
+		}
+		rel, _ := filepath.Rel(dir, files[0])
+		if !strings.Contains(index, " -> "+rel+": ") {
+			t.Errorf("the index doesn't list %s:\n%s", rel, index)
+		}
+		data, err := os.ReadFile(files[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// each file has its own topic's results and no other's
+		topics := []string{`"c/c++"`, `"what's new?"`, `"C C"`}
+		for j, topic := range topics {
+			if strings.Contains(string(data), topic) != (i == j) {
+				t.Errorf("%s: got\n%s\nwant only %s", files[0], data, topics[i])
+			}
+		}
+	}
+}
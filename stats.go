@@ -0,0 +1,284 @@
+// stats.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// -------- Run statistics --------
+
+// RunStats records how one pass over the input file was served. runPass
+// creates it when the pass starts and fills it in when the pass ends, so a
+// NULL FinishedAt marks a pass that was killed.
+type RunStats struct {
+	ID         uint `gorm:"primaryKey"`
+	StartedAt  time.Time
+	FinishedAt *time.Time
+	InputFile  string `gorm:"not null;default:''"`
+	Topics     int
+	// Hits were answered by fresh cached rows or Redis, StaleHits by older
+	// or partial rows after the API couldn't be used, Misses by the API.
+	Hits      int
+	StaleHits int
+	Misses    int
+	Failed    int
+	// Panicked counts the failed topics whose processing panicked; it is
+	// reported but not stored.
+	Panicked int `gorm:"-"`
+	// APICalls counts HTTP requests sent to providers, retries included.
+	APICalls int64
+	// RowsWritten counts the articles stored in the cache.
+	RowsWritten int64
+	// budgetLimited counts the topics -api-budget kept off the API; it is
+	// reported but not stored.
+	budgetLimited int
+	// elapsed is the run's wall time so far, topicTime and slowest the
+	// total and longest time a topic took in a worker, and queueWait and
+	// longestWait the total and longest time one waited for a worker; see
+	// measure and tally. rowsBefore is the rowsWritten count when the run
+	// started.
+	elapsed, topicTime, slowest, queueWait, longestWait time.Duration
+	rowsBefore                                          int64
+	// interrupted is set when a signal cut the run short; the topics it
+	// didn't get to count as failed.
+	interrupted bool
+}
+
+// apiCalls is incremented for every provider request; runPass takes the
+// per-pass difference.
+var apiCalls atomic.Int64
+
+// rowsWritten is incremented by the rows storeFetched caches, counted the
+// same way.
+var rowsWritten atomic.Int64
+
+type taskCallsKey struct{}
+
+// withCallCounter returns a context whose provider requests countAPICall
+// also adds to the returned counter, so the worker can attribute them to
+// the task's user.
+func withCallCounter(ctx context.Context) (context.Context, *atomic.Int64) {
+	calls := new(atomic.Int64)
+	return context.WithValue(ctx, taskCallsKey{}, calls), calls
+}
+
+// countAPICall records one provider request made under ctx.
+func countAPICall(ctx context.Context) {
+	apiCalls.Add(1)
+	if calls, ok := ctx.Value(taskCallsKey{}).(*atomic.Int64); ok {
+		calls.Add(1)
+	}
+}
+
+type runIDKey struct{}
+
+// withRun returns a context for the tasks of the run whose RunStats row has
+// ID id: storeFetched records it on the rows they cache, and the search log
+// on their entries, even for a task that outlives its run.
+func withRun(ctx context.Context, id uint) context.Context {
+	return context.WithValue(ctx, runIDKey{}, id)
+}
+
+// runOf is the run ID withRun gave ctx, 0 outside a run.
+func runOf(ctx context.Context) uint {
+	id, _ := ctx.Value(runIDKey{}).(uint)
+	return id
+}
+
+// tally classifies one topic's result into s.
+func (s *RunStats) tally(r TaskResult) {
+	s.Topics++
+	s.topicTime += r.Elapsed
+	s.slowest = max(s.slowest, r.Elapsed)
+	s.queueWait += r.Waited
+	s.longestWait = max(s.longestWait, r.Waited)
+	if r.BudgetLimited {
+		s.budgetLimited++
+	}
+	var panicked *PanicError
+	source := strings.TrimSuffix(r.Source, retryNote)
+	switch {
+	case errors.As(r.Err, &panicked):
+		s.Failed++
+		s.Panicked++
+	case r.Err != nil:
+		s.Failed++
+	case source == "DB", source == "Redis":
+		s.Hits++
+	case strings.HasPrefix(source, "DB"):
+		s.StaleHits++
+	default:
+		s.Misses++
+	}
+}
+
+// add adds the topic counters of o, the stats of part of the run, to s.
+func (s *RunStats) add(o RunStats) {
+	s.Topics += o.Topics
+	s.Hits += o.Hits
+	s.StaleHits += o.StaleHits
+	s.Misses += o.Misses
+	s.Failed += o.Failed
+	s.Panicked += o.Panicked
+	s.budgetLimited += o.budgetLimited
+	s.topicTime += o.topicTime
+	s.slowest = max(s.slowest, o.slowest)
+	s.queueWait += o.queueWait
+	s.longestWait = max(s.longestWait, o.longestWait)
+}
+
+// measure sets the run-wide counters of s, the stats of the run startRun
+// began when the provider request count was callsBefore: the requests
+// and cache rows since then and the time taken. It is called once the
+// results are in, for the output files, and again by finishRun.
+func (s *RunStats) measure(callsBefore int64) {
+	s.APICalls = apiCalls.Load() - callsBefore
+	s.RowsWritten = rowsWritten.Load() - s.rowsBefore
+	s.elapsed = time.Since(s.StartedAt)
+}
+
+// run copies the run-wide counters of s, set by measure, and whether it
+// was interrupted to o, the stats of part of the run, so its output file
+// can report them.
+func (s RunStats) run(o RunStats) RunStats {
+	o.APICalls, o.RowsWritten, o.elapsed, o.interrupted = s.APICalls, s.RowsWritten, s.elapsed, s.interrupted
+	return o
+}
+
+// budgetNote explains topics kept off the API by -api-budget, "" if none.
+func (s RunStats) budgetNote() string {
+	if s.budgetLimited == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d of %d topics skipped the API: -api-budget %d exhausted",
+		s.budgetLimited, s.Topics, apiBudget.Limit)
+}
+
+// summary is the one-line end-of-run message.
+func (s RunStats) summary() string {
+	msg := fmt.Sprintf("%d topics: %d from cache, %d from API, %d failed", s.Topics, s.Hits+s.StaleHits, s.Misses, s.Failed)
+	if s.Panicked > 0 {
+		msg += fmt.Sprintf(" (%d panicked)", s.Panicked)
+	}
+	if s.StaleHits > 0 {
+		msg += fmt.Sprintf(" (%d cached results possibly stale)", s.StaleHits)
+	}
+	if s.interrupted {
+		msg += "; run interrupted"
+	}
+	return msg
+}
+
+// timing is the end-of-run line of how long the run took and what it
+// sent and stored. Times are those of the monotonic clock, the per-topic
+// ones measured around each task in its worker. Topics that waited long
+// for a worker are a sign -workers is too low.
+func (s RunStats) timing() string {
+	msg := fmt.Sprintf("Took %s", roundDuration(s.elapsed))
+	if s.Topics > 0 {
+		msg += fmt.Sprintf(", %s a topic on average, the slowest %s", roundDuration(s.topicTime/time.Duration(s.Topics)),
+			roundDuration(s.slowest))
+		msg += fmt.Sprintf("; queued %s for a worker on average, the longest %s",
+			roundDuration(s.queueWait/time.Duration(s.Topics)), roundDuration(s.longestWait))
+	}
+	return msg + fmt.Sprintf("; %d API calls, %d cache rows written", s.APICalls, s.RowsWritten)
+}
+
+// roundDuration rounds d for reports: to the millisecond below a second,
+// to 10ms above.
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(10 * time.Millisecond)
+}
+
+// runCacheStats handles `newscli cache stats`: totals over every recorded
+// run and a per-query breakdown of the cache.
+func runCacheStats(args []string, w io.Writer) error {
+	fs, store := cacheFlagSet("cache stats")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+	var total struct {
+		Runs                                    int64
+		Topics, Hits, StaleHits, Misses, Failed int64
+		APICalls                                int64
+	}
+	err = db.Model(&RunStats{}).Select("COUNT(*) AS runs, COALESCE(SUM(topics), 0) AS topics, " +
+		"COALESCE(SUM(hits), 0) AS hits, COALESCE(SUM(stale_hits), 0) AS stale_hits, " +
+		"COALESCE(SUM(misses), 0) AS misses, COALESCE(SUM(failed), 0) AS failed, " +
+		"COALESCE(SUM(api_calls), 0) AS api_calls").Scan(&total).Error
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%d runs, %d topics: %d cache hits, %d stale hits, %d misses, %d failed, %d API calls\n",
+		total.Runs, total.Topics, total.Hits, total.StaleHits, total.Misses, total.Failed, total.APICalls)
+	if total.Topics > 0 {
+		fmt.Fprintf(w, "hit rate: %.0f%%\n", 100*float64(total.Hits+total.StaleHits)/float64(total.Topics))
+	}
+
+	var queries []struct {
+		Query          string
+		TotalRows      int64
+		Oldest, Newest string
+		LastAccessed   *string
+	}
+	err = db.Model(&CachedSearch{}).Select("query, COUNT(*) AS total_rows, MIN(created) AS oldest, MAX(created) AS newest, " +
+		"MAX(last_accessed) AS last_accessed").Group("query").Order("query").Scan(&queries).Error
+	if err != nil {
+		return err
+	}
+	if err := userStats(db, w); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\n%-30s %6s  %-16s  %-16s  %s\n", "QUERY", "ROWS", "OLDEST", "NEWEST", "LAST ACCESS")
+	for _, q := range queries {
+		fmt.Fprintf(w, "%-30s %6d  %-16s  %-16s  %s\n", q.Query, q.TotalRows,
+			statsTime(&q.Oldest), statsTime(&q.Newest), statsTime(q.LastAccessed))
+	}
+	return nil
+}
+
+// userStats breaks the search log down by user. Source is DB, or DB with
+// a note when stale, for cached results, as tally counts them.
+func userStats(db *gorm.DB, w io.Writer) error {
+	var users []struct {
+		UserID                                      string
+		Searches, Cached, Fetched, Failed, APICalls int64
+	}
+	err := db.Model(&SearchLog{}).Select("user_id, COUNT(*) AS searches, " +
+		"SUM(CASE WHEN error = '' AND (source LIKE 'DB%' OR source = 'Redis') THEN 1 ELSE 0 END) AS cached, " +
+		"SUM(CASE WHEN error = '' AND NOT (source LIKE 'DB%' OR source = 'Redis') THEN 1 ELSE 0 END) AS fetched, " +
+		"SUM(CASE WHEN error <> '' THEN 1 ELSE 0 END) AS failed, " +
+		"COALESCE(SUM(api_calls), 0) AS api_calls").Group("user_id").Order("user_id").Scan(&users).Error
+	if err != nil || len(users) == 0 {
+		return err
+	}
+	fmt.Fprintf(w, "\n%-20s %8s %10s %8s %6s %9s\n", "USER", "SEARCHES", "FROM CACHE", "FROM API", "FAILED", "API CALLS")
+	for _, u := range users {
+		fmt.Fprintf(w, "%-20s %8d %10d %8d %6d %9d\n", u.UserID, u.Searches, u.Cached, u.Fetched, u.Failed, u.APICalls)
+	}
+	return nil
+}
+
+// statsTime shortens a stored timestamp to minutes; NULL prints as "-".
+func statsTime(s *string) string {
+	if s == nil || *s == "" {
+		return "-"
+	}
+	return (*s)[:min(len(*s), 16)]
+}
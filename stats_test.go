@@ -0,0 +1,136 @@
+// stats_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunStatsSummary(t *testing.T) {
+	var s RunStats
+	for _, r := range []TaskResult{
+		{Source: "DB"}, {Source: "Redis"}, {Source: "DB (stale)"}, {Source: "API"},
+		{Source: "API (fake)" + retryNote}, {Err: errors.New("failed")},
+		{Err: newPanicError("processing a", "boom")},
+	} {
+		s.tally(r)
+	}
+	if s.Hits != 2 || s.StaleHits != 1 || s.Misses != 2 || s.Failed != 2 || s.Panicked != 1 {
+		t.Errorf("got %+v, want 2 hits, 1 stale, 2 misses, 2 failed of which 1 panicked", s)
+	}
+	want := "7 topics: 3 from cache, 2 from API, 2 failed (1 panicked) (1 cached results possibly stale)"
+	if got := s.summary(); got != want {
+		t.Errorf("got summary %q, want %q", got, want)
+	}
+}
+
+func TestCacheStatsCountsRuns(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "cache.db")
+	fetch := func(topics string, flags ...string) error {
+		t.Helper()
+		input := filepath.Join(dir, "topics.txt")
+		if err := os.WriteFile(input, []byte(topics), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		args := append([]string{"fetch", "-provider", "fake", "-db", dbPath, "-input", input, "-once", "-overwrite", "-output-dir", dir}, flags...)
+		_, err := runCommand(t, args...)
+		return err
+	}
+
+	// two misses; two hits and a miss; a hit and, offline, a failure
+	if err := fetch("golang,7,5\nrust,7,5\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fetch("golang,7,5\nrust,7,5\nzig,7,5\n"); err != nil {
+		t.Fatal(err)
+	}
+	var failed *FailedTopicsError
+	if err := fetch("zig,7,5\nhaskell,7,5\n", "-offline"); !errors.As(err, &failed) || failed.Failed != 1 {
+		t.Fatalf("got error %v, want haskell failed", err)
+	}
+
+	out, err := runCommand(t, "cache", "stats", "-db", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"3 runs, 7 topics: 3 cache hits, 0 stale hits, 3 misses, 1 failed, 3 API calls\n",
+		"hit rate: 43%\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("cache stats doesn't say %q:\n%s", want, out)
+		}
+	}
+	for _, topic := range []string{"golang", "rust", "zig"} {
+		if !strings.Contains(out, "\n"+topic+" ") {
+			t.Errorf("cache stats has no row for %s:\n%s", topic, out)
+		}
+	}
+	if strings.Contains(out, "haskell") {
+		t.Errorf("cache stats lists the uncached haskell:\n%s", out)
+	}
+}
+
+func TestRunTimings(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	// generous for a loaded machine, but well short of another fetch
+	const slack = 40 * time.Millisecond
+	db := openTestDB(t)
+	tasks := startTestPool(t, db, &slowProvider{&stubProvider{news: stubArticles(5)}, delay}, 1)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "user1")
+	topics := []NewsQuery{testQuery("golang"), testQuery("rust"), testQuery("zig")}
+	run := func() RunStats {
+		t.Helper()
+		cfg := RunConfig{Inputs: []string{input}, OutputDir: dir, OutputName: defaultOutputName, Format: formatText,
+			TaskTimeout: 5 * time.Second}
+		report, err := runInputs(context.Background(), db, tasks, cfg, []inputFile{{path: input, topics: topics}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report.stats
+	}
+	within := func(what string, d, lo, hi time.Duration) {
+		t.Helper()
+		if d < lo || d > hi {
+			t.Errorf("%s took %s, want %s to %s", what, d, lo, hi)
+		}
+	}
+
+	// one worker fetches the topics one after another
+	s := run()
+	if s.Topics != 3 || s.Misses != 3 || s.APICalls != 3 || s.RowsWritten != 15 {
+		t.Errorf("got %+v, want 3 topics from 3 API calls writing 15 rows", s)
+	}
+	within("the pass", s.elapsed, 3*delay, 3*delay+slack)
+	within("the slowest topic", s.slowest, delay, delay+slack)
+	within("the topics", s.topicTime, 3*delay, 3*(delay+slack))
+	within("the longest wait for the worker", s.longestWait, 2*delay, 2*delay+slack)
+	out := readOutput(t, dir)
+	// the file's timing line is from before the run was recorded, a moment
+	// earlier than s's
+	for _, want := range []string{s.summary() + "\nTook ", "; 3 API calls, 15 cache rows written\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output doesn't have %q:\n%s", want, out)
+		}
+	}
+	var shown bytes.Buffer
+	printPassSummary(&shown, s)
+	if got, want := shown.String(), s.summary()+"\n"+s.timing()+"\n"; got != want {
+		t.Errorf("console got %q, want %q", got, want)
+	}
+
+	// from the cache, without waiting on the provider
+	s = run()
+	if s.Hits != 3 || s.APICalls != 0 || s.RowsWritten != 0 {
+		t.Errorf("got %+v, want 3 cache hits and no API calls", s)
+	}
+	within("the cached pass", s.elapsed, 0, delay)
+}
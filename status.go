@@ -0,0 +1,162 @@
+// status.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// -------- Exit status --------
+
+// Exit statuses: exitPartial when some topics failed, exitFatal when all
+// of them did or the command couldn't run at all, and exitInterrupted when
+// SIGINT or SIGTERM stopped it, as shells report a process the signal
+// killed. Usage errors exit with exitFatal too, as flag does.
+const (
+	exitPartial     = 1
+	exitFatal       = 2
+	exitInterrupted = 130
+)
+
+// fatal and fatalf log a setup error and exit with exitFatal, like
+// log.Fatal but for the status cron jobs check.
+func fatal(v ...any) {
+	log.Print(v...)
+	os.Exit(exitFatal)
+}
+
+func fatalf(format string, v ...any) {
+	log.Printf(format, v...)
+	os.Exit(exitFatal)
+}
+
+// A FailedTopicsError is the result of a run that went through but had
+// topics fail; main exits with its exitStatus.
+type FailedTopicsError struct {
+	Failed, Topics int
+}
+
+func (e *FailedTopicsError) Error() string {
+	return fmt.Sprintf("%d of %d topics failed", e.Failed, e.Topics)
+}
+
+// exitStatus is exitFatal if every topic failed, else exitPartial.
+func (e *FailedTopicsError) exitStatus() int {
+	if e.Failed >= e.Topics {
+		return exitFatal
+	}
+	return exitPartial
+}
+
+// failedTopics is the error of a run with stats: a FailedTopicsError if
+// any topic failed, else nil.
+func failedTopics(stats RunStats) error {
+	if stats.Failed == 0 {
+		return nil
+	}
+	return &FailedTopicsError{Failed: stats.Failed, Topics: stats.Topics}
+}
+
+// exitStatus is the status main exits with for the error a command
+// returned.
+func exitStatus(err error) int {
+	var failed *FailedTopicsError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &failed):
+		return failed.exitStatus()
+	case errors.Is(err, errInterrupted):
+		return exitInterrupted
+	}
+	return exitFatal
+}
+
+// -------- Pass reports --------
+
+// passReport is what a pass over the input files did: its stats, the
+// topics that failed and the output files written.
+type passReport struct {
+	stats   RunStats
+	failed  []failedTopic
+	outputs []string
+}
+
+type failedTopic struct {
+	input string
+	q     NewsQuery
+	err   error
+}
+
+// SummaryJSON is the status document -summary-json writes after each
+// pass, for monitoring: what exit status the pass means, its counts, the
+// topics that failed and the output files written. Error is set, and the
+// counts are zero, when the pass couldn't run at all.
+type SummaryJSON struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	Status        string               `json:"status"`
+	ExitCode      int                  `json:"exitCode"`
+	Error         string               `json:"error,omitempty"`
+	StartedAt     time.Time            `json:"startedAt"`
+	FinishedAt    time.Time            `json:"finishedAt"`
+	Topics        int                  `json:"topics"`
+	FromCache     int                  `json:"fromCache"`
+	FromAPI       int                  `json:"fromAPI"`
+	Failed        int                  `json:"failed"`
+	Panicked      int                  `json:"panicked"`
+	APICalls      int64                `json:"apiCalls"`
+	FailedTopics  []SummaryFailedTopic `json:"failedTopics"`
+	Outputs       []string             `json:"outputs"`
+}
+
+// SummaryFailedTopic is a topic that failed, by input file and line.
+type SummaryFailedTopic struct {
+	Input string `json:"input"`
+	User  string `json:"user,omitempty"`
+	Query string `json:"query"`
+	Error string `json:"error"`
+}
+
+// summaryStatuses name the exit statuses in SummaryJSON.Status.
+var summaryStatuses = map[int]string{0: "ok", exitPartial: "partial", exitFatal: "failed", exitInterrupted: "interrupted"}
+
+// writeSummaryJSON writes the SummaryJSON of a pass that started at
+// started and ended with report and err to path.
+func writeSummaryJSON(path string, started time.Time, report passReport, err error) error {
+	if err == nil {
+		err = failedTopics(report.stats)
+	}
+	code := exitStatus(err)
+	doc := SummaryJSON{SchemaVersion: jsonSchemaVersion, Status: summaryStatuses[code], ExitCode: code,
+		StartedAt: started, FinishedAt: time.Now(), Topics: report.stats.Topics,
+		FromCache: report.stats.Hits + report.stats.StaleHits, FromAPI: report.stats.Misses, Failed: report.stats.Failed,
+		Panicked: report.stats.Panicked, APICalls: report.stats.APICalls, FailedTopics: []SummaryFailedTopic{},
+		Outputs: []string{}}
+	var failed *FailedTopicsError
+	if err != nil && !errors.As(err, &failed) {
+		doc.Error = err.Error()
+	}
+	for _, f := range report.failed {
+		doc.FailedTopics = append(doc.FailedTopics, SummaryFailedTopic{Input: f.input, User: f.q.UserID,
+			Query: f.q.Query, Error: f.err.Error()})
+	}
+	doc.Outputs = append(doc.Outputs, report.outputs...)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("writing -summary-json: %w", err)
+	}
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(doc)
+	})
+	if err != nil {
+		return fmt.Errorf("writing -summary-json: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// status_test.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useFakeCalls starts the fake provider's call count, which -fake-fail-after
+// counts against, at n for the rest of the test.
+func useFakeCalls(t *testing.T, n int64) {
+	saved := fakeCalls.Load()
+	fakeCalls.Store(n)
+	t.Cleanup(func() { fakeCalls.Store(saved) })
+}
+
+func TestExitStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{nil, 0},
+		{&FailedTopicsError{Failed: 1, Topics: 3}, exitPartial},
+		{&FailedTopicsError{Failed: 3, Topics: 3}, exitFatal},
+		{fmt.Errorf("pass: %w", &FailedTopicsError{Failed: 2, Topics: 3}), exitPartial},
+		{errInterrupted, exitInterrupted},
+		{errors.New("reading input file: no such file"), exitFatal},
+	} {
+		if got := exitStatus(tc.err); got != tc.want {
+			t.Errorf("exitStatus(%v) = %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestFetchExitStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// calls is how many fake calls count as made already
+		calls  int64
+		flags  []string
+		input  string
+		status int
+	}{
+		{"all good", 0, nil, "golang,7,3\nrust,7,3\nzig,7,3\n", 0},
+		{"some failed", 0, []string{"-fake-fail-after", "2"}, "golang,7,3\nrust,7,3\nzig,7,3\n", exitPartial},
+		{"all failed", 1, []string{"-fake-fail-after", "1"}, "golang,7,3\nrust,7,3\n", exitFatal},
+		{"no input file", 0, nil, "", exitFatal},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			useFakeCalls(t, tc.calls)
+			dir := t.TempDir()
+			input := filepath.Join(dir, "user1")
+			if tc.input != "" {
+				if err := os.WriteFile(input, []byte(tc.input), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			args := append([]string{"fetch", "-provider", "fake", "-db", filepath.Join(dir, "cache.db"), "-input", input,
+				"-once", "-overwrite", "-workers", "1", "-retry-passes", "0", "-output-dir", dir}, tc.flags...)
+			_, err := runCommand(t, args...)
+			if got := exitStatus(err); got != tc.status {
+				t.Errorf("got exit status %d (error %v), want %d", got, err, tc.status)
+			}
+		})
+	}
+}
+
+func TestSummaryJSON(t *testing.T) {
+	useFakeCalls(t, 0)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "user1")
+	if err := os.WriteFile(input, []byte("golang,7,3\nrust,7,3\nzig,7,3\nhaskell,7,3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	summary := filepath.Join(dir, "status", "summary.json")
+	// the first topic fetched succeeds, the second fails, and -fail-fast
+	// skips the rest
+	_, err := runCommand(t, "fetch", "-provider", "fake", "-db", filepath.Join(dir, "cache.db"), "-input", input,
+		"-once", "-overwrite", "-workers", "1", "-retry-passes", "0", "-output-dir", dir, "-fake-fail-after", "1",
+		"-fail-fast", "-summary-json", summary)
+	if got := exitStatus(err); got != exitPartial {
+		t.Fatalf("got exit status %d (error %v), want %d", got, err, exitPartial)
+	}
+
+	data, err := os.ReadFile(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc SummaryJSON
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Status != "partial" || doc.ExitCode != exitPartial || doc.Error != "" {
+		t.Errorf("got status %q, exit code %d, error %q; want partial", doc.Status, doc.ExitCode, doc.Error)
+	}
+	if doc.Topics != 4 || doc.FromAPI != 1 || doc.Failed != 3 || doc.FinishedAt.Before(doc.StartedAt) {
+		t.Errorf("got %+v, want 4 topics, 1 from the API and 3 failed", doc)
+	}
+	if len(doc.FailedTopics) != 3 {
+		t.Fatalf("got failed topics %+v, want rust, zig and haskell", doc.FailedTopics)
+	}
+	var first string
+	for _, f := range doc.FailedTopics {
+		if strings.Contains(f.Error, "injected failure") {
+			first = f.Query
+		}
+	}
+	for _, f := range doc.FailedTopics {
+		want := fmt.Sprintf("skipped: -fail-fast after %q failed", first)
+		if f.Query == first {
+			want = "injected failure"
+		}
+		if f.Input != input || !strings.Contains(f.Error, want) {
+			t.Errorf("got %+v, want an error about %s from %s", f, want, input)
+		}
+	}
+	if want := filepath.Join(dir, "Outputs_user1.txt"); len(doc.Outputs) != 1 || doc.Outputs[0] != want {
+		t.Errorf("got outputs %q, want %s", doc.Outputs, want)
+	}
+}
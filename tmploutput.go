@@ -0,0 +1,124 @@
+// tmploutput.go
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// -------- Template output --------
+
+// builtinTemplates are the templates -template @name selects.
+//
+//go:embed templates/*.tmpl
+var builtinTemplates embed.FS
+
+// TemplateData is what a -template template renders for each input file.
+// Run and Invalid are those of the JSON format.
+type TemplateData struct {
+	Run     JSONRun
+	Topics  []TemplateTopic
+	Invalid []JSONBad
+}
+
+// TemplateTopic is one topic of TemplateData: its JSONTopic fields, Query,
+// Params, Source, Error and Results, and the Label section headers show.
+type TemplateTopic struct {
+	JSONTopic
+	Label string
+}
+
+// outputTemplate is the template of -template, parsed before the run.
+var outputTemplate *template.Template
+
+// templateFuncs are the helpers templates may call besides text/template's
+// own.
+var templateFuncs = template.FuncMap{
+	// trunc cuts s to n characters, marking the cut with an ellipsis
+	"trunc": func(n int, s string) string {
+		if utf8.RuneCountInString(s) <= n {
+			return s
+		}
+		return string([]rune(s)[:max(n-1, 0)]) + "…"
+	},
+	// date formats t with a Go layout, "" for the zero time
+	"date": func(layout string, t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Local().Format(layout)
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"join":  func(sep string, list []string) string { return strings.Join(list, sep) },
+	"add":   func(a, b int) int { return a + b },
+}
+
+// loadOutputTemplate parses the template named by -template: a file, or
+// @name for one of builtinTemplates.
+func loadOutputTemplate(name string) (*template.Template, error) {
+	var text []byte
+	var err error
+	if builtin, ok := strings.CutPrefix(name, "@"); ok {
+		if text, err = builtinTemplates.ReadFile("templates/" + builtin + ".tmpl"); err != nil {
+			return nil, fmt.Errorf("no built-in template %q; want one of %s", name, builtinTemplateNames())
+		}
+	} else if text, err = os.ReadFile(name); err != nil {
+		return nil, err
+	}
+	t, err := template.New(name).Funcs(templateFuncs).Parse(string(text))
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func builtinTemplateNames() string {
+	entries, _ := builtinTemplates.ReadDir("templates")
+	var names []string
+	for _, e := range entries {
+		names = append(names, "@"+strings.TrimSuffix(e.Name(), path.Ext(e.Name())))
+	}
+	return strings.Join(names, ", ")
+}
+
+// writeTemplateOutput renders outputTemplate for out. A template that
+// defines "topic" is run as "header" (if defined) with the TemplateData,
+// "topic" with each TemplateTopic and "footer" (if defined); a topic that
+// fails to render is replaced by a line saying why and reported, and the
+// rest still rendered. Other templates render the TemplateData as a whole.
+func writeTemplateOutput(w io.Writer, out fileOutput) error {
+	data := TemplateData{Run: jsonRun(out), Topics: []TemplateTopic{}, Invalid: jsonInvalid(out.input.invalid)}
+	for i, q := range out.input.topics {
+		data.Topics = append(data.Topics, TemplateTopic{jsonTopic(q, out.results[i]), topicLabel(q)})
+	}
+	if outputTemplate.Lookup("topic") == nil {
+		return outputTemplate.Execute(w, data)
+	}
+	if t := outputTemplate.Lookup("header"); t != nil {
+		if err := t.Execute(w, data); err != nil {
+			return err
+		}
+	}
+	for _, t := range data.Topics {
+		var section bytes.Buffer
+		if err := outputTemplate.ExecuteTemplate(&section, "topic", t); err != nil {
+			fmt.Fprintf(consoleErr, "Warning: template: topic %s: %v\n", t.Label, err)
+			fmt.Fprintf(w, "[%s: template error: %v]\n", t.Label, err)
+			continue
+		}
+		section.WriteTo(w)
+	}
+	if t := outputTemplate.Lookup("footer"); t != nil {
+		return t.Execute(w, data)
+	}
+	return nil
+}
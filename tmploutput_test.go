@@ -0,0 +1,95 @@
+// tmploutput_test.go
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// renderTemplate renders out with the -template name.
+func renderTemplate(t *testing.T, name string, out fileOutput) string {
+	t.Helper()
+	tmpl, err := loadOutputTemplate(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keep(t, &outputTemplate)
+	outputTemplate = tmpl
+	var buf bytes.Buffer
+	if err := writeTemplateOutput(&buf, out); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestBuiltinTemplates(t *testing.T) {
+	// date shows times in the local zone
+	keep(t, &time.Local)
+	time.Local = time.UTC
+	for _, name := range strings.Split(builtinTemplateNames(), ", ") {
+		t.Run(name, func(t *testing.T) {
+			got := renderTemplate(t, name, sampleOutput())
+			checkGolden(t, "template-"+strings.TrimPrefix(name, "@")+".txt", []byte(got))
+		})
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	keep(t, &time.Local)
+	time.Local = time.UTC
+	path := filepath.Join(t.TempDir(), "funcs.tmpl")
+	text := `{{trunc 5 "headlines"}}|{{trunc 9 "headlines"}}|{{upper "go"}}|{{lower "GO"}}|` +
+		`{{date "Jan 2" .Run.StartedAt}}|{{(index .Topics 0).Label}}|{{add 1 (len .Topics)}}|` +
+		`{{join "+" (index .Topics 0).Params.Domains}}` + "\n"
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := sampleOutput()
+	out.input.topics[0].Domains = "go.dev,golang.org"
+	want := `head…|headlines|GO|go|May 12|"golang"|4|go.dev+golang.org` + "\n"
+	if got := renderTemplate(t, path, out); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTemplateTopicErrorsDontStopTheRest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "first.tmpl")
+	// rust and zig have no results for index to find
+	text := `{{define "header"}}{{.Run.Input}}` + "\n" + `{{end}}` +
+		`{{define "topic"}}{{.Query}}: {{(index .Results 0).Title}}` + "\n" + `{{end}}` +
+		`{{define "footer"}}{{.Run.Topics}} topics` + "\n" + `{{end}}`
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	shown := captureConsole(t)
+	got := renderTemplate(t, path, sampleOutput())
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != 5 || lines[0] != "Inputs/user1" || lines[1] != "golang: Go 1.23 released" ||
+		!strings.HasPrefix(lines[2], `["rust": template error: `) || !strings.HasPrefix(lines[3], `["zig": template error: `) ||
+		lines[4] != "3 topics" {
+		t.Errorf("got\n%s", got)
+	}
+	if !strings.Contains(shown.String(), `Warning: template: topic "rust":`) {
+		t.Errorf("console %q doesn't report rust's error", shown)
+	}
+}
+
+func TestLoadOutputTemplateErrors(t *testing.T) {
+	bad := filepath.Join(t.TempDir(), "bad.tmpl")
+	if err := os.WriteFile(bad, []byte("{{range .Topics}}{{.Query}}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		bad:            "unexpected EOF",
+		"@nosuch":      "no built-in template",
+		"missing.tmpl": "no such file",
+	} {
+		if _, err := loadOutputTemplate(name); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %v, want %q", name, err, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// watch.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// -------- Watch mode --------
+
+// inputState is what watchInputs compares to notice a changed input file.
+type inputState struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchInputs runs a pass over the input files and then another each time
+// one of them changes, until ctx is canceled by SIGINT or SIGTERM. Files
+// are polled every
+// cfg.WatchInterval, and a pass starts once they have stopped changing for
+// a whole interval, so an editor saving several times in a row causes one
+// pass. Glob patterns are expanded on every poll and a file is watched by
+// name, so a file that is deleted and written anew, as many editors save,
+// is picked up again. A pass running when the signal comes is cut short,
+// writing the results it has, as a fetch's is.
+func watchInputs(ctx context.Context, db *gorm.DB, tasks chan<- Task, cfg RunConfig, defaults NewsQuery) error {
+	var prev []inputFile
+	pass := func() {
+		inputs, _, err := runPassOver(ctx, db, tasks, cfg, defaults)
+		if err != nil {
+			fmt.Fprintln(consoleErr, "Watch:", err)
+		}
+		if inputs != nil {
+			if prev != nil {
+				fmt.Fprintln(console, describeChanges(prev, inputs))
+			}
+			prev = inputs
+		}
+		fmt.Fprintf(console, "Watching %s for changes (Ctrl+C to stop)\n", strings.Join(cfg.Inputs, ", "))
+	}
+	state := watchState(cfg.Inputs)
+	pass()
+
+	ticker := time.NewTicker(cfg.WatchInterval)
+	defer ticker.Stop()
+	pending := false
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(console, "Stopped watching")
+			return nil
+		case <-ticker.C:
+		}
+		if cur := watchState(cfg.Inputs); !maps.Equal(cur, state) {
+			state, pending = cur, true
+			continue
+		}
+		if pending {
+			pending = false
+			pass()
+		}
+	}
+}
+
+// watchState records the modification time and size of each file the
+// input patterns match right now.
+func watchState(patterns []string) map[string]inputState {
+	paths, _ := expandInputs(patterns)
+	state := make(map[string]inputState, len(paths))
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			state[path] = inputState{info.ModTime(), info.Size()}
+		}
+	}
+	return state
+}
+
+// describeChanges is the console line comparing the topics of a pass with
+// those of the one before, per input file.
+func describeChanges(prev, cur []inputFile) string {
+	before := map[string]map[NewsQuery]bool{}
+	for _, in := range prev {
+		before[in.path] = topicSet(in.topics)
+	}
+	var parts []string
+	for _, in := range cur {
+		old, ok := before[in.path]
+		delete(before, in.path)
+		if !ok {
+			parts = append(parts, fmt.Sprintf("%s: new file, %d topics", in.path, len(in.topics)))
+			continue
+		}
+		now := topicSet(in.topics)
+		added, removed := 0, 0
+		for q := range now {
+			if !old[q] {
+				added++
+			}
+		}
+		for q := range old {
+			if !now[q] {
+				removed++
+			}
+		}
+		parts = append(parts, fmt.Sprintf("%s: %d topics added, %d removed, %d unchanged",
+			in.path, added, removed, len(now)-added))
+	}
+	for _, path := range slices.Sorted(maps.Keys(before)) {
+		parts = append(parts, path+": gone")
+	}
+	return "Changes since the last pass: " + strings.Join(parts, "; ")
+}
+
+func topicSet(topics []NewsQuery) map[NewsQuery]bool {
+	set := make(map[NewsQuery]bool, len(topics))
+	for _, q := range topics {
+		set[q] = true
+	}
+	return set
+}